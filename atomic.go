@@ -0,0 +1,201 @@
+package speed
+
+import (
+	"math"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/performancecopilot/speed/bytewriter"
+)
+
+// The atomic fast path
+//
+// Singleton metrics of 64 bit integer and floating point types store their value in
+// a single 64 bit word, which is a word of the mapping while the metric is bound to one, and a
+// heap allocated word otherwise. Operations like CAS and Inc update the word atomically
+// while holding only the read lock of the metric, so concurrent updates do not serialize
+// on its mutex, and PCP never samples a partially written value.
+//
+// Operations that replace the word, like binding to a mapping, hold the write lock,
+// and copy the current value to the new word.
+//
+// Floating point values are stored as their IEEE 754 bits, as returned by math.Float64bits
+// and math.Float32bits, the latter in the low 32 bits of the word, which is where the
+// mapping stores a float. A word is always written whole, so PCP cannot sample a torn float.
+// Note that CAS compares floating point values by their bits, so 0 and -0 are different
+// and NaN is equal to itself.
+//
+// 64 bit atomic operations require 8 byte aligned words on 386 and arm. Words of a mapping
+// are aligned, see wordAlignment, and wordAt refuses unaligned ones, in which case the metric
+// is updated through its mutex instead. Heap allocated words are always aligned.
+
+// isAtomic returns true if values of the type are updated through the atomic fast path.
+func (m MetricType) isAtomic() bool {
+	return m == Int64Type || m == Uint64Type || m == FloatType || m == DoubleType
+}
+
+// isNumeric returns true if values of the type are numbers.
+func (m MetricType) isNumeric() bool {
+	return m != StringType
+}
+
+// bits returns the word representation of a resolved value of an atomic type.
+func (m MetricType) bits(val interface{}) uint64 {
+	switch m {
+	case Int64Type:
+		return uint64(val.(int64))
+	case FloatType:
+		return uint64(math.Float32bits(val.(float32)))
+	case DoubleType:
+		return math.Float64bits(val.(float64))
+	}
+	return val.(uint64)
+}
+
+// fromBits returns the value represented by a word for an atomic type.
+func (m MetricType) fromBits(bits uint64) interface{} {
+	switch m {
+	case Int64Type:
+		return int64(bits)
+	case FloatType:
+		return math.Float32frombits(uint32(bits))
+	case DoubleType:
+		return math.Float64frombits(bits)
+	}
+	return bits
+}
+
+// wordAt returns the word at offset in the buffer of a writer,
+// or nil if the offset is not aligned for atomic operations.
+func wordAt(writer bytewriter.Writer, offset int) *uint64 {
+	p := unsafe.Pointer(&writer.Bytes()[offset])
+	if uintptr(p)%8 != 0 {
+		return nil
+	}
+	return (*uint64)(p)
+}
+
+// newWord allocates a word holding the passed value of an atomic type.
+func newWord(t MetricType, val interface{}) *uint64 {
+	w := new(uint64)
+	atomic.StoreUint64(w, t.bits(val))
+	return w
+}
+
+// cas sets the value of the metric to new if its current value is old.
+// It only holds the read lock when the metric is on the atomic fast path.
+func (m *pcpSingletonMetric) cas(old, new interface{}) (bool, error) {
+	if !m.t.isNumeric() {
+		return false, m.errorf("compare and swap", "", "CAS is only supported for numeric metrics, not %v", m.t)
+	}
+
+	old, err := m.convert(old, "")
+	if err != nil {
+		return false, err
+	}
+
+	new, err = m.convert(new, "")
+	if err != nil {
+		return false, err
+	}
+
+	if !m.t.IsCompatible(old) || !m.t.IsCompatible(new) {
+		return false, m.errorf("compare and swap", "", "values %v(%T) and %v(%T) are incompatible with type %v", old, old, new, new, m.t)
+	}
+
+	old, new = m.t.resolve(old), m.t.resolve(new)
+
+	if !m.enabled() {
+		return false, nil
+	}
+
+	m.mutex.RLock()
+	if m.word != nil && !m.cleared {
+		swapped := atomic.CompareAndSwapUint64(m.word, m.t.bits(old), m.t.bits(new))
+		if swapped {
+			m.wrote()
+		}
+		m.mutex.RUnlock()
+		return swapped, nil
+	}
+	m.mutex.RUnlock()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// the metric can be bound to a word after the read lock is released,
+	// which value and set account for
+	if m.value() != old {
+		return false, nil
+	}
+
+	return true, m.set(new)
+}
+
+// less compares two resolved numeric values of the type.
+func (m MetricType) less(a, b interface{}) bool {
+	switch m {
+	case Int32Type:
+		return a.(int32) < b.(int32)
+	case Uint32Type:
+		return a.(uint32) < b.(uint32)
+	case Int64Type:
+		return a.(int64) < b.(int64)
+	case Uint64Type:
+		return a.(uint64) < b.(uint64)
+	case FloatType:
+		return a.(float32) < b.(float32)
+	case DoubleType:
+		return a.(float64) < b.(float64)
+	}
+	return false
+}
+
+// storeIf sets the value of the metric to val using a CAS loop,
+// as long as replace returns true for val and the current value.
+func (m *pcpSingletonMetric) storeIf(val interface{}, replace func(val, cur interface{}) bool) (bool, error) {
+	if !m.t.isNumeric() {
+		return false, m.errorf("store", "", "conditional stores are only supported for numeric metrics, not %v", m.t)
+	}
+
+	val, err := m.convert(val, "")
+	if err != nil {
+		return false, err
+	}
+
+	if !m.t.IsCompatible(val) {
+		return false, m.errorf("store", "", "value %v(%T) is incompatible with type %v", val, val, m.t)
+	}
+
+	val = m.t.resolve(val)
+
+	for {
+		// cas fails on a metric disabled while looping, which would otherwise loop forever
+		if !m.enabled() {
+			return false, nil
+		}
+
+		m.mutex.RLock()
+		cur := m.value()
+		m.mutex.RUnlock()
+
+		if !replace(val, cur) {
+			return false, nil
+		}
+
+		swapped, err := m.cas(cur, val)
+		if err != nil || swapped {
+			return swapped, err
+		}
+	}
+}
+
+// storeMax sets the value of the metric to val if it is greater than the current value.
+func (m *pcpSingletonMetric) storeMax(val interface{}) (bool, error) {
+	return m.storeIf(val, func(val, cur interface{}) bool { return m.t.less(cur, val) })
+}
+
+// storeMin sets the value of the metric to val if it is less than the current value.
+func (m *pcpSingletonMetric) storeMin(val interface{}) (bool, error) {
+	return m.storeIf(val, func(val, cur interface{}) bool { return m.t.less(val, cur) })
+}
@@ -0,0 +1,271 @@
+package speed
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+// incrementAll increments a counter n times from each of the passed number of goroutines,
+// using CAS loops for even goroutines and Inc for odd ones
+func incrementAll(c *PCPCounter, goroutines, n int, t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+
+	for g := 0; g < goroutines; g++ {
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < n; i++ {
+				if g%2 == 1 {
+					c.Up()
+					continue
+				}
+
+				for {
+					v := c.Val()
+					swapped, err := c.CAS(v, v+1)
+					if err != nil {
+						t.Errorf("cannot CAS counter, error: %v", err)
+						return
+					}
+					if swapped {
+						break
+					}
+				}
+			}
+		}(g)
+	}
+
+	wg.Wait()
+}
+
+func TestCAS(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	counter, err := NewPCPCounter(0, "cas.counter")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+	c.MustRegister(counter)
+
+	c.MustRegisterString("cas.indom[a, b]", Instances{"a": 1, "b": 2}, Int32Type, InstantSemantics, OneUnit)
+
+	incrementAll(counter, 4, 100, t)
+	if counter.Val() != 400 {
+		t.Errorf("expected counter to be 400 before start, got %v", counter.Val())
+	}
+
+	c.MustStart()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		incrementAll(counter, 8, 1000, t)
+	}()
+
+	// the counter is rebound to a new mapping while it is being incremented
+	if err = c.SetInstanceDomainDescription("cas.indom", "remapped"); err != nil {
+		t.Errorf("cannot remap, error: %v", err)
+	}
+
+	wg.Wait()
+
+	if counter.Val() != 8400 {
+		t.Errorf("expected counter to be 8400, got %v", counter.Val())
+	}
+
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "cas.counter"); err != nil || v != int64(8400) {
+		t.Errorf("expected counter to be written as 8400, got %v, error: %v", v, err)
+	}
+
+	c.MustStop()
+
+	incrementAll(counter, 4, 100, t)
+	if counter.Val() != 8800 {
+		t.Errorf("expected counter to be 8800 after stop, got %v", counter.Val())
+	}
+
+	if _, err = counter.CAS(8800, 0); err == nil {
+		t.Errorf("expected CAS to fail when decrementing a counter")
+	}
+}
+
+func TestCASTypes(t *testing.T) {
+	cases := []struct {
+		t             MetricType
+		val, old, new interface{}
+	}{
+		{Int32Type, 1, 1, 2},
+		{Uint32Type, uint32(1), uint32(1), uint32(2)},
+		{Int64Type, int64(1), int64(1), int64(-2)},
+		{Uint64Type, uint64(1), uint64(1), uint64(2)},
+		{FloatType, float32(1), float32(1), float32(2.5)},
+		{DoubleType, 1.0, 1.0, 2.5},
+	}
+
+	for _, c := range cases {
+		m, err := NewPCPSingletonMetric(c.val, "cas.metric", c.t, InstantSemantics, OneUnit)
+		if err != nil {
+			t.Fatalf("cannot create %v metric, error: %v", c.t, err)
+		}
+
+		if swapped, err := m.CAS(c.new, c.old); err != nil || swapped {
+			t.Errorf("expected CAS on %v to not swap a different value, got %v, error: %v", c.t, swapped, err)
+		}
+
+		if swapped, err := m.CAS(c.old, c.new); err != nil || !swapped {
+			t.Errorf("expected CAS on %v to swap, got %v, error: %v", c.t, swapped, err)
+		}
+
+		if v := m.Val(); v != c.t.resolve(c.new) {
+			t.Errorf("expected %v metric to be %v after CAS, got %v", c.t, c.new, v)
+		}
+	}
+
+	m, err := NewPCPSingletonMetric("a", "cas.string", StringType, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create string metric, error: %v", err)
+	}
+
+	if _, err = m.CAS("a", "b"); err == nil {
+		t.Errorf("expected CAS on a string metric to fail")
+	}
+}
+
+func TestStoreMaxMin(t *testing.T) {
+	max, err := NewPCPSingletonMetric(int64(0), "store.max", Int64Type, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	min, err := NewPCPGauge(1000, "store.min")
+	if err != nil {
+		t.Fatalf("cannot create gauge, error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(100)
+	for i := 1; i <= 100; i++ {
+		go func(i int) {
+			defer wg.Done()
+
+			if _, err := max.StoreMax(int64(i)); err != nil {
+				t.Errorf("cannot store max, error: %v", err)
+			}
+
+			if _, err := min.StoreMin(float64(i)); err != nil {
+				t.Errorf("cannot store min, error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if max.Val() != int64(100) {
+		t.Errorf("expected max to be 100, got %v", max.Val())
+	}
+
+	if min.Val() != 1 {
+		t.Errorf("expected min to be 1, got %v", min.Val())
+	}
+
+	if stored, err := max.StoreMax(int64(50)); err != nil || stored {
+		t.Errorf("expected a smaller value to not be stored as max, got %v, error: %v", stored, err)
+	}
+
+	if stored, err := min.StoreMax(5); err != nil || !stored || min.Val() != 5 {
+		t.Errorf("expected a larger value to be stored as max, got %v, error: %v", min.Val(), err)
+	}
+
+	if _, err = max.StoreMax("a"); err == nil {
+		t.Errorf("expected storing an incompatible value to fail")
+	}
+}
+
+func TestStoreIfDisabledWhileLooping(t *testing.T) {
+	m, err := NewPCPSingletonMetric(int64(0), "store.disabled", Int64Type, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	// the metric is disabled by another goroutine after the loop read the current value
+	// and before it swaps it, so every later swap fails
+	replace := func(val, cur interface{}) bool {
+		disabled := make(chan struct{})
+		go func() {
+			m.setEnabled(false)
+			close(disabled)
+		}()
+		<-disabled
+
+		return m.t.less(cur, val)
+	}
+
+	done := make(chan bool)
+	go func() {
+		stored, _ := m.storeIf(int64(10), replace)
+		done <- stored
+	}()
+
+	select {
+	case stored := <-done:
+		if stored {
+			t.Error("expected a disabled metric to not be stored")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected storing to stop once the metric is disabled")
+	}
+}
+
+func TestFloatWords(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	g, err := NewPCPGauge(0, "atomic.gauge")
+	if err != nil {
+		t.Fatalf("cannot create gauge, error: %v", err)
+	}
+	c.MustRegister(g)
+
+	f, err := NewPCPSingletonMetric(float32(1.5), "atomic.float", FloatType, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+	c.MustRegister(f)
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if g.word == nil || f.word == nil {
+		t.Fatalf("expected the floating point metrics to be bound to words of the mapping")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(8)
+	for i := 0; i < 8; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				g.MustInc(0.5)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "atomic.gauge"); err != nil || v != float64(4000) {
+		t.Errorf("expected the gauge to be written as 4000, got %v, error: %v", v, err)
+	}
+
+	f.MustSet(float32(-2.25))
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "atomic.float"); err != nil || v != float32(-2.25) {
+		t.Errorf("expected the float to be written as -2.25, got %v, error: %v", v, err)
+	}
+}
@@ -0,0 +1,96 @@
+package speed
+
+import (
+	"math"
+	"sync/atomic"
+
+	"github.com/performancecopilot/speed/bytewriter"
+)
+
+// Store ordering
+//
+// PCP reads a mapping while it is written, from another process, without any locking.
+// A reader only trusts the metadata of a mapping if both generation numbers in its header
+// are equal, and values are read whenever PCP samples them, so the writer guarantees that
+//
+//   - the second generation number is stored last, with an atomic store, when a mapping is
+//     written. Atomic stores have release semantics on weakly ordered architectures like
+//     arm64, so all components written before it are visible to a reader that sees it.
+//   - the second generation number is cleared with an atomic swap before a mapping is
+//     rewritten in place, see PCPClient.extend. A swap is a full barrier, so none of the
+//     writes that follow can become visible before readers see the mapping as being written.
+//   - numeric values are updated with a single atomic store of their 8 byte aligned word,
+//     so PCP never samples a value that is partially written, whatever the architecture.
+//     The atomic fast path, see atomic.go, stores the same words.
+//
+// String values span multiple words, and can be sampled partially written.
+
+// g2Offset is the offset of the second generation number in the header of a mapping,
+// following the tag, the version and the first generation number
+const g2Offset = 16
+
+// publishGeneration stores the second generation number of a mapping at offset,
+// after all of its components were written.
+func publishGeneration(writer bytewriter.Writer, gen int64, offset int) {
+	if w := wordAt(writer, offset); w != nil {
+		atomic.StoreUint64(w, uint64(gen))
+		return
+	}
+
+	_ = writer.MustWriteInt64(gen, offset)
+}
+
+// invalidateGeneration clears the second generation number of a mapping at offset,
+// before its components are rewritten.
+func invalidateGeneration(writer bytewriter.Writer, offset int) {
+	if w := wordAt(writer, offset); w != nil {
+		atomic.SwapUint64(w, 0)
+		return
+	}
+
+	_ = writer.MustWriteInt64(0, offset)
+}
+
+// wordBits returns the word a numeric value is stored as in a mapping,
+// which holds 32 bit values in its low 32 bits.
+func wordBits(val interface{}) (uint64, bool) {
+	switch v := val.(type) {
+	case int32:
+		return uint64(uint32(v)), true
+	case uint32:
+		return uint64(v), true
+	case int64:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	case float32:
+		return uint64(math.Float32bits(v)), true
+	case float64:
+		return math.Float64bits(v), true
+	}
+	return 0, false
+}
+
+// newvalueClosure returns an update closure for the value of a metric of type t at offset,
+// which stores numeric values with a single atomic store if the offset is aligned for it.
+func newvalueClosure(t MetricType, offset int, writer bytewriter.Writer) updateClosure {
+	update := newupdateClosure(offset, writer)
+	if !t.isNumeric() {
+		return update
+	}
+
+	w := wordAt(writer, offset)
+	if w == nil {
+		return update
+	}
+
+	return func(val interface{}) error {
+		bits, ok := wordBits(val)
+		if !ok {
+			return update(val)
+		}
+
+		atomic.StoreUint64(w, bits)
+		return nil
+	}
+}
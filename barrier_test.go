@@ -0,0 +1,74 @@
+package speed
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/performancecopilot/speed/bytewriter"
+)
+
+func TestValueClosure(t *testing.T) {
+	w := bytewriter.NewByteWriter(64)
+
+	cases := []struct {
+		t        MetricType
+		val      interface{}
+		expected uint64
+	}{
+		{Int32Type, int32(-2), uint64(math.MaxUint32 - 1)},
+		{Uint32Type, uint32(7), 7},
+		{Int64Type, int64(-1), math.MaxUint64},
+		{FloatType, float32(1.5), uint64(math.Float32bits(1.5))},
+		{DoubleType, 2.5, math.Float64bits(2.5)},
+	}
+
+	for _, c := range cases {
+		update := newvalueClosure(c.t, 8, w)
+		if err := update(c.val); err != nil {
+			t.Errorf("cannot write %v, error: %v", c.val, err)
+		}
+
+		if v := binary.LittleEndian.Uint64(w.Bytes()[8:]); v != c.expected {
+			t.Errorf("expected %v to be stored as %x, got %x", c.val, c.expected, v)
+		}
+	}
+
+	// unaligned values are written through the writer
+	update := newvalueClosure(Int64Type, 20, w)
+	if err := update(int64(5)); err != nil {
+		t.Errorf("cannot write an unaligned value, error: %v", err)
+	}
+
+	if v := binary.LittleEndian.Uint64(w.Bytes()[20:]); v != 5 {
+		t.Errorf("expected an unaligned value to be 5, got %v", v)
+	}
+}
+
+func TestGeneration(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	c.MustRegisterString("barrier.counter", int64(1), Int64Type, CounterSemantics, OneUnit)
+
+	c.MustStart()
+	defer c.MustStop()
+
+	b := c.writer.Bytes()
+	g1, g2 := binary.LittleEndian.Uint64(b[8:]), binary.LittleEndian.Uint64(b[g2Offset:])
+	if g1 == 0 || g1 != g2 {
+		t.Errorf("expected the generation numbers of a written mapping to match, got %v and %v", g1, g2)
+	}
+
+	invalidateGeneration(c.writer, g2Offset)
+	if g2 = binary.LittleEndian.Uint64(b[g2Offset:]); g2 != 0 {
+		t.Errorf("expected the second generation number to be cleared, got %v", g2)
+	}
+
+	publishGeneration(c.writer, int64(g1), g2Offset)
+	if g2 = binary.LittleEndian.Uint64(b[g2Offset:]); g2 != g1 {
+		t.Errorf("expected the second generation number to be %v, got %v", g1, g2)
+	}
+}
@@ -0,0 +1,102 @@
+package speed
+
+import "strings"
+
+// the states of a circuit breaker instrumented using InstrumentBreaker
+const (
+	BreakerClosed   = "closed"
+	BreakerHalfOpen = "half-open"
+	BreakerOpen     = "open"
+)
+
+// BreakerHooks maintain the metrics of a circuit breaker instrumented using
+// InstrumentBreaker, and are called by the breaker, usually from the callbacks
+// of a breaker library. They are safe for concurrent use.
+//
+// For example, a breaker of github.com/sony/gobreaker is instrumented using
+//
+//	hooks := speed.MustInstrumentBreaker(client, "backend.breaker")
+//	cb := gobreaker.NewCircuitBreaker(gobreaker.Settings{
+//		Name: "backend",
+//		OnStateChange: func(_ string, _, to gobreaker.State) {
+//			hooks.OnStateChange(to.String())
+//		},
+//	})
+//
+// calling OnRejected when cb.Execute returns gobreaker.ErrOpenState.
+type BreakerHooks struct {
+	client   *PCPClient
+	state    *PCPEnum
+	trips    *PCPCounter
+	rejected *PCPCounter
+}
+
+// InstrumentBreaker registers metrics for a circuit breaker with the client, all named
+// under name, and returns the hooks maintaining them. The registered metrics are
+//
+// name.state, the state of the breaker, a PCPEnum of BreakerClosed, BreakerHalfOpen
+// and BreakerOpen, starting closed
+//
+// name.trips, counting the times the breaker opened
+//
+// name.rejected, counting the calls the breaker rejected while it was open
+func InstrumentBreaker(c *PCPClient, name string) (*BreakerHooks, error) {
+	b := &BreakerHooks{client: c}
+
+	var err error
+	states := []string{BreakerClosed, BreakerHalfOpen, BreakerOpen}
+	if b.state, err = NewPCPEnum(BreakerClosed, states, name+".state", "state of the circuit breaker"); err != nil {
+		return nil, err
+	}
+
+	if b.trips, err = NewPCPCounter(0, name+".trips", "times the circuit breaker opened"); err != nil {
+		return nil, err
+	}
+
+	if b.rejected, err = NewPCPCounter(0, name+".rejected", "calls rejected by the open circuit breaker"); err != nil {
+		return nil, err
+	}
+
+	for _, m := range []Metric{b.state, b.trips, b.rejected} {
+		if err = c.Register(m); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+// MustInstrumentBreaker panics if InstrumentBreaker fails.
+func MustInstrumentBreaker(c *PCPClient, name string) *BreakerHooks {
+	b, err := InstrumentBreaker(c, name)
+	must("instrument breaker", name, c.name(), err)
+	return b
+}
+
+// OnStateChange is called when the breaker changes to the passed state, which is
+// one of BreakerClosed, BreakerHalfOpen and BreakerOpen in any case, as returned by
+// the String method of the states of most breaker libraries. Changing to
+// BreakerOpen counts a trip.
+func (b *BreakerHooks) OnStateChange(to string) {
+	to = strings.ToLower(to)
+
+	old, err := b.state.swapState(to)
+	if err != nil {
+		b.client.reportError(err)
+		return
+	}
+
+	if open, _ := b.state.Code(BreakerOpen); to == BreakerOpen && old != open {
+		b.client.reportError(b.trips.Inc(1))
+	}
+}
+
+// OnRejected is called when the breaker rejects a call.
+func (b *BreakerHooks) OnRejected() {
+	b.client.reportError(b.rejected.Inc(1))
+}
+
+// State returns the state the breaker last changed to.
+func (b *BreakerHooks) State() string {
+	return b.state.State()
+}
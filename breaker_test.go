@@ -0,0 +1,59 @@
+package speed
+
+import (
+	"testing"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestInstrumentBreaker(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	var handled []error
+	c.SetErrorHandler(func(err error) { handled = append(handled, err) })
+
+	b := MustInstrumentBreaker(c, "backend.breaker")
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if s := b.State(); s != BreakerClosed {
+		t.Errorf("expected a new breaker to be closed, got %v", s)
+	}
+
+	for _, s := range []string{"open", "open", "half-open", "OPEN", "closed", "half-open", "closed"} {
+		b.OnStateChange(s)
+	}
+	b.OnRejected()
+	b.OnRejected()
+
+	if s := b.State(); s != BreakerClosed {
+		t.Errorf("expected the breaker to be closed, got %v", s)
+	}
+
+	code, _ := b.state.Code(BreakerClosed)
+	expected := map[string]interface{}{
+		"backend.breaker.state":    code,
+		"backend.breaker.trips":    int64(2),
+		"backend.breaker.rejected": int64(2),
+	}
+
+	for name, val := range expected {
+		if v, err := mmvdump.Lookup(c.writer.Bytes(), name); err != nil || v != val {
+			t.Errorf("expected %v to be %v, got %v, error: %v", name, val, v, err)
+		}
+	}
+
+	b.OnStateChange("tripped")
+
+	if len(handled) != 1 {
+		t.Fatalf("expected changing to an unknown state to pass an error to the handler, got %v", handled)
+	}
+
+	if _, ok := handled[0].(*OpError); !ok {
+		t.Errorf("expected an *OpError, got %T", handled[0])
+	}
+}
@@ -0,0 +1,155 @@
+package speed
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PCPBucketHistogram counts values, like latencies, into buckets with bounds chosen by the
+// application, publishing the number of values in every bucket along with the count, sum,
+// minimum and maximum of all values as instances of a single metric, so a distribution is
+// exported without defining an instance domain for it.
+//
+// Every bucket holds the values larger than the bound of the previous one and not larger
+// than its own, and is named after its bounds, like PCPInt64Histogram buckets, so bounds of
+// 10, 50 and 100 milliseconds give the instances <=10ms, 10ms-50ms, 50ms-100ms and >100ms,
+// followed by the instances count, sum, min and max.
+type PCPBucketHistogram struct {
+	*pcpInstanceMetric
+	bounds    []int64  // upper bounds of the buckets, ascending
+	instances []string // names of the buckets, one more than bounds for the overflow
+}
+
+// NewPCPBucketHistogram creates a new PCPBucketHistogram counting values in unit into
+// buckets with the passed upper bounds, which must be increasing, with a last bucket for
+// the values larger than all of them. Optionally, a couple of description strings may be
+// passed as the short and long descriptions of the metric.
+//
+// Internally it creates a PCP InstanceMetric with Int64Type, InstantSemantics and unit,
+// like PCPHistogram, so the bucket counts and the count of values are published in unit
+// as well.
+func NewPCPBucketHistogram(name string, bounds []int64, unit MetricUnit, desc ...string) (*PCPBucketHistogram, error) {
+	if len(bounds) == 0 {
+		return nil, &OpError{"create metric", name, "", errors.New("no bucket bounds were passed")}
+	}
+
+	for i := 1; i < len(bounds); i++ {
+		if bounds[i] <= bounds[i-1] {
+			return nil, &OpError{"create metric", name, "", fmt.Errorf("bound %v is not larger than %v", bounds[i], bounds[i-1])}
+		}
+	}
+
+	bounds = append([]int64(nil), bounds...)
+	buckets := bucketNames(bounds, unit)
+
+	instances := append(append([]string(nil), buckets...), "count", "sum", "min", "max")
+	vals := make(Instances)
+	for _, s := range instances {
+		vals[s] = int64(0)
+	}
+
+	m, err := generateInstanceMetric(vals, name, instances, Int64Type, InstantSemantics, unit, desc...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PCPBucketHistogram{m, bounds, buckets}, nil
+}
+
+// Record counts a new value.
+func (h *PCPBucketHistogram) Record(val int64) error {
+	return h.RecordN(val, 1)
+}
+
+// MustRecord panics if Record fails.
+func (h *PCPBucketHistogram) MustRecord(val int64) {
+	must("record", h.name, h.client, h.Record(val))
+}
+
+// RecordN counts the same value n times.
+func (h *PCPBucketHistogram) RecordN(val, n int64) error {
+	if n < 0 {
+		return h.errorf("record", "", "cannot record a value %v times", n)
+	}
+
+	if !h.enabled() || n == 0 {
+		return nil
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	current := func(instance string) int64 { return h.vals[instance].val.(int64) }
+
+	count := current("count")
+	min, max := current("min"), current("max")
+	if count == 0 || val < min {
+		min = val
+	}
+	if count == 0 || val > max {
+		max = val
+	}
+
+	bucket := h.instances[bucketOf(h.bounds, val)]
+
+	updates := []struct {
+		instance string
+		val      int64
+	}{
+		{bucket, current(bucket) + n},
+		{"count", count + n},
+		{"sum", current("sum") + val*n},
+		{"min", min},
+		{"max", max},
+	}
+
+	for _, u := range updates {
+		if u.val == current(u.instance) {
+			continue
+		}
+
+		if err := h.setInstance(u.val, u.instance); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MustRecordN panics if RecordN fails.
+func (h *PCPBucketHistogram) MustRecordN(val, n int64) {
+	must("record", h.name, h.client, h.RecordN(val, n))
+}
+
+// get returns the value of an instance
+func (h *PCPBucketHistogram) get(instance string) int64 {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.vals[instance].val.(int64)
+}
+
+// Count returns the number of values recorded.
+func (h *PCPBucketHistogram) Count() int64 { return h.get("count") }
+
+// Sum returns the sum of the values recorded.
+func (h *PCPBucketHistogram) Sum() int64 { return h.get("sum") }
+
+// Min returns the smallest value recorded, or 0 if none was.
+func (h *PCPBucketHistogram) Min() int64 { return h.get("min") }
+
+// Max returns the largest value recorded, or 0 if none was.
+func (h *PCPBucketHistogram) Max() int64 { return h.get("max") }
+
+// Bounds returns the upper bounds of all buckets but the last, which is unbounded.
+func (h *PCPBucketHistogram) Bounds() []int64 {
+	return append([]int64(nil), h.bounds...)
+}
+
+// Buckets returns the buckets with the number of values counted in each, ordered by their
+// bounds. The first bucket starts at math.MinInt64 and the last ends at math.MaxInt64.
+func (h *PCPBucketHistogram) Buckets() []*HistogramBucket {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	return countedBuckets(h.bounds, h.instances, h.vals)
+}
@@ -0,0 +1,76 @@
+package speed
+
+import (
+	"math"
+	"testing"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestPCPBucketHistogram(t *testing.T) {
+	if _, err := NewPCPBucketHistogram("latency", nil, MillisecondUnit); err == nil {
+		t.Errorf("expected creating a histogram without bounds to fail")
+	}
+
+	if _, err := NewPCPBucketHistogram("latency", []int64{10, 50, 50}, MillisecondUnit); err == nil {
+		t.Errorf("expected creating a histogram with bounds that are not increasing to fail")
+	}
+
+	h, err := NewPCPBucketHistogram("latency", []int64{10, 50, 100}, MillisecondUnit, "request latency")
+	if err != nil {
+		t.Fatalf("cannot create histogram, error: %v", err)
+	}
+
+	if h.Type() != Int64Type || h.Semantics() != InstantSemantics || h.Unit() != MillisecondUnit {
+		t.Errorf("expected the histogram to be an instant int64 in milliseconds, got %v %v %v", h.Type(), h.Semantics(), h.Unit())
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	c.MustRegister(h)
+	c.MustStart()
+	defer c.MustStop()
+
+	h.MustRecord(20)
+	h.MustRecordN(5, 2)
+	h.MustRecord(1000)
+	h.MustRecord(50)
+
+	if err = h.RecordN(1, -1); err == nil {
+		t.Errorf("expected recording a value a negative number of times to fail")
+	}
+
+	expected := map[string]int64{
+		"<=10ms": 2, "10ms-50ms": 2, "50ms-100ms": 0, ">100ms": 1,
+		"count": 5, "sum": 1080, "min": 5, "max": 1000,
+	}
+	for instance, val := range expected {
+		if v, err := mmvdump.Lookup(c.writer.Bytes(), "latency["+instance+"]"); err != nil || v != val {
+			t.Errorf("expected %v to be %v, got %v, error: %v", instance, val, v, err)
+		}
+	}
+
+	if h.Count() != 5 || h.Sum() != 1080 || h.Min() != 5 || h.Max() != 1000 {
+		t.Errorf("expected count, sum, min and max to be 5, 1080, 5 and 1000, got %v, %v, %v and %v", h.Count(), h.Sum(), h.Min(), h.Max())
+	}
+
+	buckets := h.Buckets()
+	if len(buckets) != 4 {
+		t.Fatalf("expected a bucket for every bound and the overflow, got %v", len(buckets))
+	}
+
+	if *buckets[1] != (HistogramBucket{11, 50, 2}) || *buckets[3] != (HistogramBucket{101, math.MaxInt64, 1}) {
+		t.Errorf("unexpected buckets %+v and %+v", *buckets[1], *buckets[3])
+	}
+
+	if err = h.reset(); err != nil {
+		t.Fatalf("cannot reset histogram, error: %v", err)
+	}
+
+	h.MustRecord(70)
+	if h.Min() != 70 || h.Max() != 70 || h.Count() != 1 {
+		t.Errorf("expected min and max to start over after a reset, got %v and %v", h.Min(), h.Max())
+	}
+}
@@ -0,0 +1,61 @@
+//go:build go1.18
+// +build go1.18
+
+package speed
+
+import (
+	"errors"
+	"runtime/debug"
+)
+
+func init() {
+	builtinCollectors["buildinfo"] = func(c *PCPClient, conf *ClientConfig) error {
+		return RegisterBuildInfo(c)
+	}
+}
+
+// RegisterBuildInfo registers discrete string metrics with the passed client, describing
+// how the running binary was built, read using debug.ReadBuildInfo. The registered
+// metrics are
+//
+// build.version, the version of the main module
+//
+// build.vcs.revision, the version control revision the binary was built from
+//
+// build.goversion, the go version used to build the binary
+//
+// The metrics are registered together, so if one of them cannot be registered,
+// none of them are.
+func RegisterBuildInfo(c *PCPClient) error {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return errors.New("build information is not available in the running binary")
+	}
+
+	revision := ""
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			revision = s.Value
+		}
+	}
+
+	metrics := []struct {
+		name, val, desc string
+	}{
+		{"build.version", info.Main.Version, "version of the main module"},
+		{"build.vcs.revision", revision, "version control revision the binary was built from"},
+		{"build.goversion", info.GoVersion, "go version used to build the binary"},
+	}
+
+	registered := make([]Metric, 0, len(metrics))
+	for _, m := range metrics {
+		metric, err := NewPCPSingletonMetric(m.val, m.name, StringType, DiscreteSemantics, OneUnit, m.desc)
+		if err != nil {
+			return err
+		}
+
+		registered = append(registered, metric)
+	}
+
+	return c.registerAll(registered...)
+}
@@ -0,0 +1,64 @@
+//go:build go1.18
+// +build go1.18
+
+package speed
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestRegisterBuildInfo(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	if err = RegisterBuildInfo(c); err != nil {
+		t.Fatalf("cannot register build info, error: %v", err)
+	}
+
+	for _, name := range []string{"build.version", "build.vcs.revision", "build.goversion"} {
+		if !c.Registry().HasMetric(name) {
+			t.Errorf("expected %v to be registered", name)
+		}
+	}
+
+	if v := c.r.metrics["build.goversion"].(*PCPSingletonMetric).Val(); v != runtime.Version() {
+		t.Errorf("expected build.goversion to be %v, got %v", runtime.Version(), v)
+	}
+
+	if err = RegisterBuildInfo(c); err == nil {
+		t.Error("expected registering build info twice to fail")
+	}
+}
+
+func TestRegisterBuildInfoPartial(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	c.MustRegisterString("build.goversion", "taken", StringType, DiscreteSemantics, OneUnit)
+
+	if err = RegisterBuildInfo(c); err == nil {
+		t.Fatalf("expected registering build info over a registered metric to fail")
+	}
+
+	for _, name := range []string{"build.version", "build.vcs.revision"} {
+		if c.Registry().HasMetric(name) {
+			t.Errorf("expected %v to not be registered after registering build info failed", name)
+		}
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if err = RegisterBuildInfo(c); err == nil {
+		t.Fatalf("expected registering build info on a started client to fail the same way")
+	}
+
+	if c.Registry().HasMetric("build.version") || c.Registry().MetricCount() != 1 {
+		t.Errorf("expected the registry of the started client to be left as it was")
+	}
+}
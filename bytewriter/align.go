@@ -0,0 +1,31 @@
+package bytewriter
+
+import "unsafe"
+
+// CacheLineSize is the size of a cache line on the architectures supported by PCP,
+// which values updated concurrently should not share to avoid false sharing.
+const CacheLineSize = 64
+
+// HugePageSize is the size of a transparent huge page on x86-64 and most arm64 kernels.
+// Requesting huge pages only helps mappings that span at least one.
+const HugePageSize = 2 << 20
+
+// NewAlignedByteWriter creates a new ByteWriter of the specified size,
+// whose buffer starts at an address that is a multiple of align,
+// which must be a power of 2.
+func NewAlignedByteWriter(n, align int) *ByteWriter {
+	b := make([]byte, n+align-1)
+
+	skip := 0
+	if rem := int(uintptr(unsafe.Pointer(&b[0])) & uintptr(align-1)); rem != 0 {
+		skip = align - rem
+	}
+
+	return &ByteWriter{b[skip : skip+n : skip+n]}
+}
+
+// IsAligned returns true if the byte at offset in the buffer of the writer
+// is at an address that is a multiple of align.
+func (w *ByteWriter) IsAligned(offset, align int) bool {
+	return uintptr(unsafe.Pointer(&w.buffer[offset]))%uintptr(align) == 0
+}
@@ -0,0 +1,23 @@
+package bytewriter
+
+import "testing"
+
+func TestNewAlignedByteWriter(t *testing.T) {
+	for _, align := range []int{8, CacheLineSize, 4096} {
+		for n := 1; n < 200; n += 37 {
+			w := NewAlignedByteWriter(n, align)
+
+			if w.Len() != n {
+				t.Errorf("expected a writer of length %v, got %v", n, w.Len())
+			}
+
+			if !w.IsAligned(0, align) {
+				t.Errorf("expected the buffer of length %v to be aligned to %v", n, align)
+			}
+
+			if _, err := w.Write([]byte{1}, n); err == nil {
+				t.Errorf("expected a write past length %v to fail", n)
+			}
+		}
+	}
+}
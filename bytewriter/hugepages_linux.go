@@ -0,0 +1,12 @@
+package bytewriter
+
+import "syscall"
+
+// AdviseHugePages asks the kernel to back the mapping with transparent huge pages,
+// which reduces TLB misses when sampling very large mappings.
+//
+// The advice is only followed for file backed mappings if the filesystem supports it,
+// like tmpfs mounted with huge=advise or shmem_enabled set to advise.
+func (b *MemoryMappedWriter) AdviseHugePages() error {
+	return syscall.Madvise(b.buffer, syscall.MADV_HUGEPAGE)
+}
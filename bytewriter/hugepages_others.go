@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package bytewriter
+
+import "errors"
+
+// AdviseHugePages asks the kernel to back the mapping with transparent huge pages,
+// which is only supported on Linux.
+func (b *MemoryMappedWriter) AdviseHugePages() error {
+	return errors.New("transparent huge pages are only supported on Linux")
+}
@@ -0,0 +1,62 @@
+package bytewriter
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// memfdCreateTrap holds the number of the memfd_create system call on every architecture,
+// as neither the syscall package nor the vendored x/sys define it for all of them
+var memfdCreateTrap = map[string]uintptr{
+	"386":      356,
+	"amd64":    319,
+	"arm":      385,
+	"arm64":    279,
+	"loong64":  279,
+	"mips64":   5314,
+	"mips64le": 5314,
+	"ppc64":    360,
+	"ppc64le":  360,
+	"riscv64":  279,
+	"s390x":    350,
+}
+
+// mfdCloexec is the MFD_CLOEXEC flag for memfd_create
+const mfdCloexec = 0x1
+
+// NewMemfdWriter creates a MemoryMappedWriter backed by an anonymous memory file
+// created using memfd_create, for processes that cannot write to any directory.
+//
+// The name is only used to identify the file, which shows up as a link to
+// "/memfd:<name> (deleted)" under /proc/<pid>/fd. Other processes can read it
+// through that link, whose path is returned by Location. The memory is released
+// when the writer is unmapped or the process exits.
+func NewMemfdWriter(name string, size int) (*MemoryMappedWriter, error) {
+	trap, ok := memfdCreateTrap[runtime.GOARCH]
+	if !ok {
+		return nil, fmt.Errorf("memfd_create is not supported on %v", runtime.GOARCH)
+	}
+
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, _, errno := syscall.Syscall(trap, uintptr(unsafe.Pointer(p)), mfdCloexec, 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("memfd_create", errno)
+	}
+
+	loc := fmt.Sprintf("/proc/%d/fd/%d", os.Getpid(), fd)
+
+	w, err := mapFile(os.NewFile(fd, loc), loc, size)
+	if err != nil {
+		return nil, err
+	}
+
+	w.anonymous = true
+	return w, nil
+}
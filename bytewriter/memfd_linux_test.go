@@ -0,0 +1,45 @@
+package bytewriter
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMemfdWriter(t *testing.T) {
+	w, err := NewMemfdWriter("bytewriter_test", 10)
+	if err != nil {
+		t.Skipf("memfd_create is not available, error: %v", err)
+	}
+
+	if _, err = w.WriteString("x", 5); err != nil {
+		t.Fatalf("cannot write to memfd writer, error: %v", err)
+	}
+
+	target, err := os.Readlink(w.Location())
+	if err != nil {
+		t.Fatalf("cannot read link %v, error: %v", w.Location(), err)
+	}
+
+	if !strings.HasPrefix(target, "/memfd:bytewriter_test") {
+		t.Errorf("expected %v to link to the memfd, got %v", w.Location(), target)
+	}
+
+	data, err := ioutil.ReadFile(w.Location())
+	if err != nil {
+		t.Fatalf("cannot read %v, error: %v", w.Location(), err)
+	}
+
+	if len(data) != 10 || data[5] != 'x' {
+		t.Errorf("expected the written data to be visible through %v, got %v", w.Location(), data)
+	}
+
+	if err = w.Unmap(true); err != nil {
+		t.Errorf("expected a memfd writer to unmap without removing a file, error: %v", err)
+	}
+
+	if _, err = os.Stat(w.Location()); err == nil {
+		t.Errorf("expected %v to be closed after unmapping", w.Location())
+	}
+}
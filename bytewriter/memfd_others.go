@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package bytewriter
+
+import "errors"
+
+// NewMemfdWriter creates a MemoryMappedWriter backed by an anonymous memory file,
+// which is only supported on Linux.
+func NewMemfdWriter(name string, size int) (*MemoryMappedWriter, error) {
+	return nil, errors.New("memfd mappings are only supported on Linux")
+}
@@ -2,6 +2,7 @@ package bytewriter
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	mmap "github.com/edsrzf/mmap-go"
@@ -13,17 +14,34 @@ type MemoryMappedWriter struct {
 	handle *os.File // file handle
 	loc    string   // location of the memory mapped file
 	size   int      // size in bytes
+
+	anonymous bool   // not removed on Unmap, see NewMemfdWriter and OpenMemoryMappedWriter
+	staged    string // location of the file until it is published, see NewStagedMemoryMappedWriter
 }
 
-// NewMemoryMappedWriter will create and return a new instance of a MemoryMappedWriter
+// NewMemoryMappedWriter will create and return a new instance of a MemoryMappedWriter,
+// atomically replacing any file at loc
 func NewMemoryMappedWriter(loc string, size int) (*MemoryMappedWriter, error) {
-	if _, err := os.Stat(loc); err == nil {
-		err = os.Remove(loc)
-		if err != nil {
-			return nil, err
-		}
+	w, err := NewStagedMemoryMappedWriter(loc, size)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = w.Publish(); err != nil {
+		_ = w.Unmap(true)
+		return nil, err
 	}
 
+	return w, nil
+}
+
+// NewStagedMemoryMappedWriter creates a MemoryMappedWriter for a new temporary file
+// in the directory of loc, which replaces any file at loc once Publish is called.
+//
+// This lets the contents of the file be written before it is visible at loc, so readers
+// of loc see either the old file or the completely written new one, and never a missing
+// or partially written file.
+func NewStagedMemoryMappedWriter(loc string, size int) (*MemoryMappedWriter, error) {
 	// ensure destination directory exists
 	dir := filepath.Dir(loc)
 	err := os.MkdirAll(dir, 0700)
@@ -31,21 +49,61 @@ func NewMemoryMappedWriter(loc string, size int) (*MemoryMappedWriter, error) {
 		return nil, err
 	}
 
-	f, err := os.OpenFile(loc, os.O_CREATE|os.O_RDWR|os.O_EXCL, 0644)
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(loc)+".")
 	if err != nil {
 		return nil, err
 	}
 
-	l, err := f.Write(make([]byte, size))
+	if err = f.Chmod(0644); err != nil {
+		discard(f, f.Name())
+		return nil, err
+	}
+
+	w, err := mapFile(f, f.Name(), size)
 	if err != nil {
 		return nil, err
 	}
-	if l < size {
-		return nil, fmt.Errorf("Could not initialize %d bytes", size)
+
+	w.loc, w.staged = loc, f.Name()
+	return w, nil
+}
+
+// Publish renames the file of a writer created by NewStagedMemoryMappedWriter
+// to its location, replacing any file there. It does nothing for other writers.
+func (b *MemoryMappedWriter) Publish() error {
+	if b.staged == "" {
+		return nil
+	}
+
+	if err := os.Rename(b.staged, b.loc); err != nil {
+		return err
+	}
+
+	b.staged = ""
+	return nil
+}
+
+// OpenMemoryMappedWriter maps an existing file of the passed size created by another
+// writer, possibly in another process, without initializing it, so both write to
+// the same memory. The file is not removed when the writer is unmapped.
+func OpenMemoryMappedWriter(loc string, size int) (*MemoryMappedWriter, error) {
+	f, err := os.OpenFile(loc, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err == nil && info.Size() != int64(size) {
+		err = fmt.Errorf("%v is %d bytes long, expected %d bytes", loc, info.Size(), size)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
 	}
 
 	b, err := mmap.Map(f, mmap.RDWR, 0)
 	if err != nil {
+		_ = f.Close()
 		return nil, err
 	}
 
@@ -54,9 +112,46 @@ func NewMemoryMappedWriter(loc string, size int) (*MemoryMappedWriter, error) {
 		f,
 		loc,
 		size,
+		true,
+		"",
 	}, nil
 }
 
+// mapFile initializes size bytes of a newly created file and maps them into memory
+func mapFile(f *os.File, loc string, size int) (*MemoryMappedWriter, error) {
+	// the file is completely written before mapping, so a full filesystem fails
+	// here with ENOSPC instead of with a SIGBUS when writing to the mapping
+	l, err := f.Write(make([]byte, size))
+	if err == nil && l < size {
+		err = fmt.Errorf("Could not initialize %d bytes", size)
+	}
+	if err != nil {
+		discard(f, loc)
+		return nil, err
+	}
+
+	b, err := mmap.Map(f, mmap.RDWR, 0)
+	if err != nil {
+		discard(f, loc)
+		return nil, err
+	}
+
+	return &MemoryMappedWriter{
+		NewByteWriterSlice(b),
+		f,
+		loc,
+		size,
+		false,
+		"",
+	}, nil
+}
+
+// discard closes and removes a partially initialized file
+func discard(f *os.File, loc string) {
+	_ = f.Close()
+	_ = os.Remove(loc)
+}
+
 // Unmap will manually delete the memory mapping of a mapped buffer
 func (b *MemoryMappedWriter) Unmap(removefile bool) error {
 	m := mmap.MMap(b.buffer)
@@ -68,7 +163,12 @@ func (b *MemoryMappedWriter) Unmap(removefile bool) error {
 		return err
 	}
 
-	if removefile {
+	// a file that is not published yet is always removed, as nothing else can reach it
+	if b.staged != "" {
+		return os.Remove(b.staged)
+	}
+
+	if removefile && !b.anonymous {
 		if err := os.Remove(b.loc); err != nil {
 			return err
 		}
@@ -76,3 +176,6 @@ func (b *MemoryMappedWriter) Unmap(removefile bool) error {
 
 	return nil
 }
+
+// Location returns the path the mapped file can be opened at
+func (b *MemoryMappedWriter) Location() string { return b.loc }
@@ -1,6 +1,7 @@
 package bytewriter
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
@@ -62,3 +63,54 @@ func testUnmap(w *MemoryMappedWriter, loc string, t *testing.T) {
 		t.Error("Memory Mapped File not getting deleted on Unmap")
 	}
 }
+
+func TestStagedMemoryMappedWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bytewriter")
+	if err != nil {
+		t.Fatal("Cannot create directory:", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	loc := filepath.Join(dir, "mapping")
+	if err = ioutil.WriteFile(loc, []byte("old"), 0644); err != nil {
+		t.Fatal("Cannot create file:", err)
+	}
+
+	w, err := NewStagedMemoryMappedWriter(loc, 10)
+	if err != nil {
+		t.Fatal("Cannot create writer:", err)
+	}
+
+	if _, err = w.WriteString("new", 0); err != nil {
+		t.Fatal("Cannot write to writer:", err)
+	}
+
+	// the old file stays in place until the new one is published
+	if data, err := ioutil.ReadFile(loc); err != nil || string(data) != "old" {
+		t.Errorf("expected the old file before publishing, got %q, error: %v", data, err)
+	}
+
+	if err = w.Publish(); err != nil {
+		t.Fatal("Cannot publish writer:", err)
+	}
+
+	if data, err := ioutil.ReadFile(loc); err != nil || string(data[:3]) != "new" {
+		t.Errorf("expected the new file after publishing, got %q, error: %v", data, err)
+	}
+
+	testUnmap(w, loc, t)
+
+	// a writer that is never published leaves nothing behind
+	w, err = NewStagedMemoryMappedWriter(loc, 10)
+	if err != nil {
+		t.Fatal("Cannot create writer:", err)
+	}
+
+	if err = w.Unmap(false); err != nil {
+		t.Error(err)
+	}
+
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 0 {
+		t.Errorf("expected an unpublished file to be removed on Unmap, got %v, error: %v", files, err)
+	}
+}
@@ -0,0 +1,113 @@
+package speed
+
+// PCPCacheMetric bundles the metrics of a cache, registered together using RegisterCache.
+// Under the name of the cache, it publishes the counters hits, misses and evictions, and
+// the number of entries in the cache as size, all with Int64Type and OneUnit.
+//
+// WriteDerivedConfig defines the hit ratio of every registered cache, named after the
+// cache with a ".hit_ratio" suffix, as the rate of hits divided by the rate of lookups.
+type PCPCacheMetric struct {
+	name      string
+	hits      *PCPCounter
+	misses    *PCPCounter
+	evictions *PCPCounter
+	size      *PCPSingletonMetric
+}
+
+// NewPCPCacheMetric creates the metrics of a cache, all named under name.
+func NewPCPCacheMetric(name string) (*PCPCacheMetric, error) {
+	c := &PCPCacheMetric{name: name}
+
+	counters := []struct {
+		counter **PCPCounter
+		name    string
+		desc    string
+	}{
+		{&c.hits, "hits", "lookups that found an entry in the cache"},
+		{&c.misses, "misses", "lookups that found no entry in the cache"},
+		{&c.evictions, "evictions", "entries evicted from the cache"},
+	}
+
+	var err error
+	for _, ct := range counters {
+		if *ct.counter, err = NewPCPCounter(0, name+"."+ct.name, ct.desc); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.size, err = NewPCPSingletonMetric(int64(0), name+".size", Int64Type, InstantSemantics, OneUnit, "entries in the cache"); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Name returns the name the metrics of the cache are named under.
+func (c *PCPCacheMetric) Name() string { return c.name }
+
+// Metrics returns the metrics of the cache.
+func (c *PCPCacheMetric) Metrics() []Metric {
+	return []Metric{c.hits, c.misses, c.evictions, c.size}
+}
+
+// RecordHit counts a lookup that found an entry.
+func (c *PCPCacheMetric) RecordHit() error { return c.hits.Inc(1) }
+
+// MustRecordHit panics if RecordHit fails.
+func (c *PCPCacheMetric) MustRecordHit() { c.hits.MustInc(1) }
+
+// RecordMiss counts a lookup that found no entry.
+func (c *PCPCacheMetric) RecordMiss() error { return c.misses.Inc(1) }
+
+// MustRecordMiss panics if RecordMiss fails.
+func (c *PCPCacheMetric) MustRecordMiss() { c.misses.MustInc(1) }
+
+// RecordEvictions counts n evicted entries.
+func (c *PCPCacheMetric) RecordEvictions(n int64) error { return c.evictions.Inc(n) }
+
+// MustRecordEvictions panics if RecordEvictions fails.
+func (c *PCPCacheMetric) MustRecordEvictions(n int64) { c.evictions.MustInc(n) }
+
+// SetSize sets the number of entries in the cache.
+func (c *PCPCacheMetric) SetSize(n int64) error { return c.size.Set(n) }
+
+// MustSetSize panics if SetSize fails.
+func (c *PCPCacheMetric) MustSetSize(n int64) { c.size.MustSet(n) }
+
+// HitRatio returns the fraction of all lookups so far that found an entry, or 0 if
+// there were no lookups.
+func (c *PCPCacheMetric) HitRatio() float64 {
+	hits, misses := c.hits.Val(), c.misses.Val()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// RegisterCache registers all metrics of a cache with the client.
+func (c *PCPClient) RegisterCache(cache *PCPCacheMetric) error {
+	for _, m := range cache.Metrics() {
+		if err := c.Register(m); err != nil {
+			return err
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.caches = append(c.caches, cache)
+	return nil
+}
+
+// MustRegisterCache is simply a RegisterCache that can panic
+func (c *PCPClient) MustRegisterCache(cache *PCPCacheMetric) {
+	must("register", cache.name, c.name(), c.RegisterCache(cache))
+}
+
+// registeredCaches returns the caches registered with the client
+func (c *PCPClient) registeredCaches() []*PCPCacheMetric {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return append([]*PCPCacheMetric(nil), c.caches...)
+}
@@ -0,0 +1,71 @@
+package speed
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestPCPCacheMetric(t *testing.T) {
+	c, err := NewPCPClient("app")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	cache, err := NewPCPCacheMetric("sessions")
+	if err != nil {
+		t.Fatalf("cannot create cache metric, error: %v", err)
+	}
+
+	if r := cache.HitRatio(); r != 0 {
+		t.Errorf("expected the hit ratio without lookups to be 0, got %v", r)
+	}
+
+	c.MustRegisterCache(cache)
+
+	if err = c.RegisterCache(cache); err == nil {
+		t.Errorf("expected registering a cache twice to fail")
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	cache.MustRecordHit()
+	cache.MustRecordHit()
+	cache.MustRecordHit()
+	cache.MustRecordMiss()
+	cache.MustRecordEvictions(2)
+	cache.MustSetSize(40)
+
+	if err = cache.RecordEvictions(-1); err == nil {
+		t.Errorf("expected recording a negative number of evictions to fail")
+	}
+
+	if r := cache.HitRatio(); r != 0.75 {
+		t.Errorf("expected the hit ratio to be 0.75, got %v", r)
+	}
+
+	expected := map[string]int64{
+		"sessions.hits":      3,
+		"sessions.misses":    1,
+		"sessions.evictions": 2,
+		"sessions.size":      40,
+	}
+
+	for name, val := range expected {
+		if v, err := mmvdump.Lookup(c.writer.Bytes(), name); err != nil || v != val {
+			t.Errorf("expected %v to be %v, got %v, error: %v", name, val, v, err)
+		}
+	}
+
+	var b bytes.Buffer
+	if err = c.WriteDerivedConfig(&b); err != nil {
+		t.Fatalf("cannot write derived config, error: %v", err)
+	}
+
+	ratio := "derived.app.sessions.hit_ratio = rate(mmv.app.sessions.hits) / (rate(mmv.app.sessions.hits) + rate(mmv.app.sessions.misses))\n"
+	if !bytes.Contains(b.Bytes(), []byte(ratio)) {
+		t.Errorf("expected the derived config to define the hit ratio of the cache, got\n%v", b.String())
+	}
+}
@@ -0,0 +1,213 @@
+package speed
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// CardinalityPolicy decides what happens when a value is set for a new instance
+// of a vector that already has its maximum number of instances.
+type CardinalityPolicy int
+
+// values for CardinalityPolicy
+const (
+	RejectInstances   CardinalityPolicy = iota // the update fails with a *CardinalityError
+	OverflowInstances                          // the update is applied to OverflowInstance
+	EvictInstances                             // the least recently updated instance is deleted to make room
+)
+
+// OverflowInstance is the instance values of new instances are collapsed into
+// by the OverflowInstances policy. It does not count towards the limit.
+const OverflowInstance = "_overflow"
+
+// RejectedInstancesMetricName is the name of the counter registered by WithMaxInstances,
+// counting the updates to new instances that were over the limit of a vector,
+// which for the EvictInstances policy is the number of evicted instances.
+const RejectedInstancesMetricName = "speed.instances.rejected"
+
+// CardinalityError is returned by updates to new instances of a vector
+// over its limit, when the RejectInstances policy is used.
+type CardinalityError struct {
+	Metric   string // name of the vector
+	Instance string // the rejected instance
+	Max      int    // maximum number of instances of the vector
+	Client   string // name of the client the vector is registered with
+}
+
+func (e *CardinalityError) Error() string {
+	err := fmt.Errorf("metric already has the maximum of %v instances", e.Max)
+	return describeFailure("add instance", instanceName(e.Metric, e.Instance), e.Client, err)
+}
+
+// cardinalityLimit limits the instances of a vector
+type cardinalityLimit struct {
+	max      int
+	policy   CardinalityPolicy
+	rejected *PCPCounter // counts updates over the limit
+	changed  func()      // called when an instance is added
+}
+
+// WithMaxInstances lets values be set for new instances of a counter or gauge vector,
+// up to a total of n instances. Updates to new instances over the limit are handled
+// according to the policy, and counted by a counter registered under
+// RejectedInstancesMetricName, protecting the mapping from an explosion of instances.
+//
+// New instances are published once the client is compacted, which happens
+// automatically within a publish interval, see PCPClient.Compact.
+//
+// n has to be positive. It has no effect on other metrics.
+func WithMaxInstances(n int, policy CardinalityPolicy) RegisterOption {
+	return func(c *PCPClient, m Metric) error {
+		var im *pcpInstanceMetric
+		switch v := m.(type) {
+		case *PCPCounterVector:
+			im = v.pcpInstanceMetric
+		case *PCPGaugeVector:
+			im = v.pcpInstanceMetric
+		}
+
+		if im == nil {
+			return nil
+		}
+
+		if n <= 0 {
+			return fmt.Errorf("maximum of %v instances is not positive", n)
+		}
+
+		rejected, err := c.rejectedInstances()
+		if err != nil {
+			return err
+		}
+
+		im.mutex.Lock()
+		defer im.mutex.Unlock()
+
+		im.limit = &cardinalityLimit{n, policy, rejected, c.scheduleCompact}
+		return nil
+	}
+}
+
+// rejectedInstances returns the counter of rejected instances, registering it if needed
+func (c *PCPClient) rejectedInstances() (*PCPCounter, error) {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	if c.rejected != nil {
+		return c.rejected, nil
+	}
+
+	m, err := NewPCPCounter(0, RejectedInstancesMetricName, "number of updates to new instances of vectors over their limit")
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.addToRegistryLocked(func() error { return c.r.add(m) }); err != nil {
+		return nil, err
+	}
+
+	c.rejected = m
+	return m, nil
+}
+
+// scheduleCompact compacts the client after a publish interval,
+// unless a compaction is already scheduled.
+//
+// It is called while holding the lock of a metric, so it does not take the lock of
+// the client, which is held while taking the locks of metrics when writing a mapping.
+func (c *PCPClient) scheduleCompact() {
+	if !atomic.CompareAndSwapInt32(&c.compactScheduled, 0, 1) {
+		return
+	}
+
+	go func() {
+		c.mutex.Lock()
+		s := c.schedule(DefaultCollectInterval)
+		c.mutex.Unlock()
+
+		s.clock.AfterFunc(s.next(), func() {
+			atomic.StoreInt32(&c.compactScheduled, 0)
+			if err := c.Compact(); err != nil && logging {
+				clientlogger.WithField("error", err).Error("cannot compact the client")
+			}
+		})
+	}()
+}
+
+// instanceFor returns the normalized instance an update to instance is applied to, adding
+// it to the metric if it is new and the metric is limited, the mutex must be held for writing.
+func (m *pcpInstanceMetric) instanceFor(instance string) (string, error) {
+	instance = m.normalized(instance)
+
+	if v, ok := m.vals[instance]; (ok && !v.deleted) || m.limit == nil {
+		return instance, nil
+	}
+
+	if len(instance) > StringLength {
+		return "", m.errorf("add instance", instance, "instance name is longer than %v bytes", StringLength)
+	}
+
+	live := 0
+	for name, v := range m.vals {
+		if !v.deleted && name != OverflowInstance {
+			live++
+		}
+	}
+
+	if live < m.limit.max || instance == OverflowInstance {
+		m.addInstance(instance)
+		return instance, nil
+	}
+
+	_ = m.limit.rejected.Inc(1)
+
+	switch m.limit.policy {
+	case RejectInstances:
+		return "", &CardinalityError{m.name, instance, m.limit.max, m.client}
+	case EvictInstances:
+		_ = m.deleteInstance(m.leastRecentlyUpdated())
+		m.addInstance(instance)
+		return instance, nil
+	}
+
+	if v, ok := m.vals[OverflowInstance]; !ok || v.deleted {
+		m.addInstance(OverflowInstance)
+	}
+
+	return OverflowInstance, nil
+}
+
+// leastRecentlyUpdated returns the live instance that was updated the longest
+// time ago, the mutex must be held.
+func (m *pcpInstanceMetric) leastRecentlyUpdated() string {
+	var (
+		lru     string
+		touched uint64
+		found   bool
+	)
+
+	for name, v := range m.vals {
+		if v.deleted || name == OverflowInstance {
+			continue
+		}
+
+		if !found || v.touched < touched {
+			lru, touched, found = name, v.touched, true
+		}
+	}
+
+	return lru
+}
+
+// addInstance adds an instance with the zero value, or revives a deleted one,
+// which is published once the registry is reconciled.
+func (m *pcpInstanceMetric) addInstance(instance string) {
+	if v, ok := m.vals[instance]; ok {
+		v.deleted, v.val = false, m.t.zero()
+	} else {
+		m.vals[instance] = newinstanceValue(m.t.zero())
+	}
+
+	m.touch(instance)
+
+	m.limit.changed()
+}
@@ -0,0 +1,208 @@
+package speed
+
+import (
+	"testing"
+	"time"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestMaxInstancesReject(t *testing.T) {
+	cv, err := NewPCPCounterVector(map[string]int64{"a": 0}, "tenants.requests")
+	if err != nil {
+		t.Fatalf("cannot create CounterVector, error: %v", err)
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	c.MustRegister(cv, WithMaxInstances(2, RejectInstances))
+
+	if !c.r.HasMetric(RejectedInstancesMetricName) {
+		t.Fatalf("expected %v to be registered", RejectedInstancesMetricName)
+	}
+
+	// instances added before start are published on start
+	cv.MustInc(1, "b")
+
+	err = cv.Inc(1, "c")
+	if cerr, ok := err.(*CardinalityError); !ok || cerr.Instance != "c" || cerr.Max != 2 {
+		t.Errorf("expected a CardinalityError for c, got %v", err)
+	}
+
+	if c.rejected.Val() != 1 {
+		t.Errorf("expected 1 rejected update, got %v", c.rejected.Val())
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "tenants.requests[b]"); err != nil || v != int64(1) {
+		t.Errorf("expected tenants.requests[b] to be 1, got %v, error: %v", v, err)
+	}
+
+	// deleting an instance makes room for a new one
+	if err = cv.Delete("a"); err != nil {
+		t.Fatalf("cannot delete instance, error: %v", err)
+	}
+	cv.MustInc(5, "c")
+
+	if err = c.Compact(); err != nil {
+		t.Fatalf("cannot compact, error: %v", err)
+	}
+
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "tenants.requests[c]"); err != nil || v != int64(5) {
+		t.Errorf("expected tenants.requests[c] to be 5, got %v, error: %v", v, err)
+	}
+
+	if _, err = mmvdump.Lookup(c.writer.Bytes(), "tenants.requests[a]"); err == nil {
+		t.Errorf("expected tenants.requests[a] to be removed")
+	}
+
+	cv.Up("c")
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "tenants.requests[c]"); err != nil || v != int64(6) {
+		t.Errorf("expected tenants.requests[c] to be updated to 6, got %v, error: %v", v, err)
+	}
+}
+
+func TestMaxInstancesOverflow(t *testing.T) {
+	g, err := NewPCPGaugeVector(map[string]float64{}, "tenants.load")
+	if err != nil {
+		t.Fatalf("cannot create GaugeVector, error: %v", err)
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	c.MustRegister(g, WithMaxInstances(1, OverflowInstances))
+
+	c.MustStart()
+	defer c.MustStop()
+
+	g.MustInc(1, "x")
+	g.MustInc(2, "y")
+	g.MustInc(3, "z")
+
+	if v, err := g.Val(OverflowInstance); err != nil || v != 5 {
+		t.Errorf("expected %v to be 5, got %v, error: %v", OverflowInstance, v, err)
+	}
+
+	if _, err = g.Val("y"); err == nil {
+		t.Errorf("expected y not to be added")
+	}
+
+	if c.rejected.Val() != 2 {
+		t.Errorf("expected 2 rejected updates, got %v", c.rejected.Val())
+	}
+
+	if err = c.Compact(); err != nil {
+		t.Fatalf("cannot compact, error: %v", err)
+	}
+
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "tenants.load["+OverflowInstance+"]"); err != nil || v != float64(5) {
+		t.Errorf("expected tenants.load[%v] to be 5, got %v, error: %v", OverflowInstance, v, err)
+	}
+}
+
+func TestMaxInstancesScheduledCompact(t *testing.T) {
+	cv, err := NewPCPCounterVector(map[string]int64{"a": 0}, "conns")
+	if err != nil {
+		t.Fatalf("cannot create CounterVector, error: %v", err)
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	c.MustRegister(cv, WithMaxInstances(10, RejectInstances))
+
+	if err = c.SetPublishInterval(5 * time.Millisecond); err != nil {
+		t.Fatalf("cannot set publish interval, error: %v", err)
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	cv.MustInc(3, "b")
+
+	for i := 0; i < 100; i++ {
+		c.mutex.Lock()
+		v, err := mmvdump.Lookup(c.writer.Bytes(), "conns[b]")
+		c.mutex.Unlock()
+
+		if err == nil && v == int64(3) {
+			return
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Errorf("expected the new instance to be published within a publish interval")
+}
+
+func TestMaxInstancesEvict(t *testing.T) {
+	cv, err := NewPCPCounterVector(map[string]int64{"a": 0, "b": 0}, "sessions")
+	if err != nil {
+		t.Fatalf("cannot create CounterVector, error: %v", err)
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	c.MustRegister(cv, WithMaxInstances(3, EvictInstances))
+
+	c.MustStart()
+	defer c.MustStop()
+
+	cv.Up("b")
+	cv.Up("c")
+	cv.Up("a")
+
+	// b is the least recently updated instance
+	cv.Up("d")
+
+	if _, err = cv.Val("b"); err == nil {
+		t.Errorf("expected b to be evicted")
+	}
+
+	cv.Up("c")
+	cv.Up("e")
+
+	if _, err = cv.Val("a"); err == nil {
+		t.Errorf("expected a to be evicted")
+	}
+
+	if c.rejected.Val() != 2 {
+		t.Errorf("expected 2 evictions, got %v", c.rejected.Val())
+	}
+
+	if err = c.Compact(); err != nil {
+		t.Fatalf("cannot compact, error: %v", err)
+	}
+
+	for ins, expected := range map[string]int64{"c": 2, "d": 1, "e": 1} {
+		if v, err := mmvdump.Lookup(c.writer.Bytes(), "sessions["+ins+"]"); err != nil || v != expected {
+			t.Errorf("expected sessions[%v] to be %v, got %v, error: %v", ins, expected, v, err)
+		}
+	}
+
+	for _, ins := range []string{"a", "b"} {
+		if _, err = mmvdump.Lookup(c.writer.Bytes(), "sessions["+ins+"]"); err == nil {
+			t.Errorf("expected sessions[%v] to be removed", ins)
+		}
+	}
+
+	// an evicted instance can come back, evicting another one
+	cv.Up("b")
+
+	if _, err = cv.Val("d"); err == nil {
+		t.Errorf("expected d to be evicted")
+	}
+
+	if v, err := cv.Val("b"); err != nil || v != 1 {
+		t.Errorf("expected b to start again from 1, got %v, error: %v", v, err)
+	}
+}
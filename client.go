@@ -1,11 +1,15 @@
 package speed
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/performancecopilot/speed/bytewriter"
@@ -31,6 +35,29 @@ const MaxV1NameLength = 63
 // MaxDataValueSize is the maximum byte length for a stored metric value, unless it is a string
 const MaxDataValueSize = 16
 
+// ErrClientStarted is returned by all operations that affect the layout of a mapping,
+// like setting the mmv flag or adding metrics to the registry directly,
+// when they are attempted while the client is started. Registering metrics through
+// the client remaps a started client instead, unless its mapping is shared.
+var ErrClientStarted = errors.New("cannot change the layout of a mapping while the client is started")
+
+// ErrAlreadyStarted is returned when starting a client that is already started.
+var ErrAlreadyStarted = errors.New("client is already started")
+
+// MappingError is returned when a mapping cannot be created,
+// for example when the filesystem holding it is full.
+type MappingError struct {
+	Loc string // location of the mmv file
+	Err error  // underlying error
+}
+
+func (e *MappingError) Error() string {
+	return fmt.Sprintf("cannot create a mapping at %v: %v", e.Loc, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *MappingError) Unwrap() error { return e.Err }
+
 // EraseFileOnStop if set to true, will also delete the memory mapped file
 var EraseFileOnStop = false
 
@@ -54,10 +81,10 @@ type Client interface {
 	MustStop()
 
 	// adds a metric to be monitored
-	Register(Metric) error
+	Register(Metric, ...RegisterOption) error
 
 	// tries to add a metric to be written and panics on error
-	MustRegister(Metric)
+	MustRegister(Metric, ...RegisterOption)
 
 	// adds metric from a string
 	RegisterString(string, interface{}, MetricType, MetricSemantics, MetricUnit) (Metric, error)
@@ -78,7 +105,7 @@ func mmvFileLocation(name string) (string, error) {
 	if present {
 		loc = filepath.Join(rootPath, tdir)
 	} else {
-		loc = os.TempDir()
+		loc = defaultTmpDir()
 	}
 
 	return filepath.Join(loc, "mmv", name), nil
@@ -111,12 +138,56 @@ type PCPClient struct {
 	r *PCPRegistry // current registry
 
 	writer bytewriter.Writer
+	layout *mmvLayout // layout of the active mapping
+
+	collectors collectorRunner
+	limiter    *writeLimiter     // limits writes to the mapping, if set
+	caches     []*PCPCacheMetric // caches registered using RegisterCache, see WriteDerivedConfig
+
+	onStart, onStop []func() // lifecycle hooks
+	stopping        bool     // whether OnStop hooks of a Stop are being called
+
+	state  ClientState // current state, see Status
+	err    error       // error that caused the last failure
+	writes writeStatus // result of the last write to the mapping
+	stats  *Stats      // updated atomically, allocated separately so the counters are 64 bit aligned
+
+	tags       map[string][]string    // names of the metrics registered with every tag
+	thresholds map[string][]Threshold // alarm conditions on metrics, see WithThreshold
+	hot        map[string]bool        // names of the metrics registered using Hot
+
+	registrations map[Metric]*registration // of the metrics being registered, see Register
+
+	history  *metricHistory  // values of the metrics registered using WithHistory
+	rejected *PCPCounter     // counts updates to new instances over the limit of a vector
+	resets   *resetScheduler // resets the metrics registered using WithResetSchedule
+
+	compactScheduled int32          // set atomically while a compaction is scheduled
+	lazy             *lazyCollector // lazy metrics, collected along with the collectors
+
+	metadata map[string]string // passed to description templates
+	descdata *DescriptionData  // passed to description templates in the mapping being written
+
+	errorHandler func(error) // called with errors that cannot be returned to the caller
+	deferred     []error     // errors for the error handler, passed to it once the lock is released
+	fallback     bool        // fall back to an in-memory writer if a mapping cannot be created
+	inMemory     bool        // whether the current writer is an in-memory fallback
+	memfd        bool        // map an anonymous memory file instead of a file under loc
 
-	instanceoffsetc chan int
-	indomoffsetc    chan int
-	metricoffsetc   chan int
-	valueoffsetc    chan int
-	stringoffsetc   chan int
+	recordUpdates bool         // record the times values are updated at, see SetUpdateTimes
+	updates       *updateTimes // times the values of the active mapping were updated at, if recorded
+
+	alignment int  // alignment of the values section, 0 if it is not aligned
+	hugePages bool // request transparent huge pages for large mappings
+	headroom  int  // instances the mapping has room for, see SetInstanceHeadroom
+	version   int  // lowest MMV version of the mapping, see SetMMVVersion
+
+	shared *sharedMapping // set if the mapping is shared with other processes, see SetSharedMapping
+
+	publishInterval time.Duration // interval of periodic work, defaults are used if 0
+	publishJitter   float64       // fraction by which publish intervals are randomly varied
+	manualTick      bool          // periodic work is only done by Tick, see SetManualTick
+	clock           Clock         // source of time, see SetClock
 }
 
 // NewPCPClient initializes a new PCPClient object
@@ -135,12 +206,42 @@ func NewPCPClientWithRegistry(name string, registry *PCPRegistry) (*PCPClient, e
 		clientlogger.WithField("location", fileLocation).Info("deduced location to write the MMV file")
 	}
 
-	return &PCPClient{
+	c := &PCPClient{
 		loc:       fileLocation,
 		r:         registry,
 		clusterID: hash(name, PCPClusterIDBitLength),
 		flag:      ProcessFlag,
-	}, nil
+		stats:     new(Stats),
+		clock:     SystemClock,
+	}
+
+	registry.instanceChanger = c.changeInstance
+	return c, nil
+}
+
+// name returns the name of the client, which its metrics are published under
+func (c *PCPClient) name() string { return filepath.Base(c.loc) }
+
+// opError names the client in an error returned by the operation op on name,
+// which is empty for operations on the client itself. ErrClientStarted,
+// ErrAlreadyStarted and errors of their own type, like *MappingError,
+// are returned as they are, so they can still be compared and asserted.
+func (c *PCPClient) opError(op, name string, err error) error {
+	switch e := err.(type) {
+	case nil, *MappingError, *CardinalityError, *ConversionError:
+		return err
+	case *OpError:
+		if e.Client != "" {
+			return e
+		}
+		return &OpError{e.Op, e.Name, c.name(), e.Err}
+	}
+
+	if err == ErrClientStarted || err == ErrAlreadyStarted {
+		return err
+	}
+
+	return &OpError{op, name, c.name(), err}
 }
 
 // Registry returns a writer's registry
@@ -154,7 +255,11 @@ func (c *PCPClient) SetFlag(flag MMVFlag) error {
 	defer c.mutex.Unlock()
 
 	if c.r.mapped {
-		return errors.New("cannot set mmv flag for an active client")
+		return ErrClientStarted
+	}
+
+	if err := c.checkFullNames(flag); err != nil {
+		return c.opError("set the flag of", "", err)
 	}
 
 	c.flag = flag
@@ -168,7 +273,7 @@ func (c *PCPClient) tocCount() int {
 		ans += 2
 	}
 
-	if c.r.StringCount() > 0 {
+	if stringCount(c.r, c.encoder()) > 0 {
 		ans++
 	}
 
@@ -177,130 +282,252 @@ func (c *PCPClient) tocCount() int {
 
 // Length returns the byte length of data in the mmv file written by the current writer
 func (c *PCPClient) Length() int {
-	var (
-		InstanceLength = Instance1Length
-		MetricLength   = Metric1Length
-	)
-
-	if c.r.version2 {
-		InstanceLength = Instance2Length
-		MetricLength = Metric2Length
-	}
+	enc := c.encoder()
+	instances, values, strings := headroom(c.r, enc, c.headroom)
 
-	return HeaderLength +
+	offset := HeaderLength +
 		(c.tocCount() * TocLength) +
-		(c.r.InstanceCount() * InstanceLength) +
+		((c.r.InstanceCount() + instances) * enc.instanceLength()) +
 		(c.r.InstanceDomainCount() * InstanceDomainLength) +
-		(c.r.MetricCount() * MetricLength) +
-		(c.r.ValuesCount() * ValueLength) +
-		(c.r.StringCount() * StringLength)
+		(c.r.MetricCount() * enc.metricLength())
+
+	return alignOffset(offset, c.valueAlignment()) +
+		((c.r.ValuesCount() + values) * ValueLength) +
+		((stringCount(c.r, enc) + strings) * StringLength)
 }
 
-// Start dumps existing registry data
+// Start dumps existing registry data, and then calls all functions registered using OnStart.
+// Starting a client that is already started returns ErrAlreadyStarted.
+// It is safe to call Start and Stop from multiple goroutines.
 func (c *PCPClient) Start() error {
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
+	if c.r.mapped {
+		c.mutex.Unlock()
+		return ErrAlreadyStarted
+	}
 
-	l := c.Length()
+	collect, err := c.mapRegistry()
+	err = c.opError("start", "", err)
+	if err != nil {
+		c.state, c.err = ClientFailed, err
+	} else {
+		c.state, c.err = ClientStarted, nil
+	}
+	hooks := c.onStart
+	c.unlock()
 
-	writer, err := bytewriter.NewMemoryMappedWriter(c.loc, l)
 	if err != nil {
-		if logging {
-			clientlogger.WithField("error", err).Error("cannot create MemoryMappedBuffer")
-		}
 		return err
 	}
-	c.writer = writer
 
-	c.start()
-	if logging {
-		clientlogger.Info("written the different components, the registered metrics should be visible now")
+	// like hooks, collectors are run without holding the lock, so they can use the client
+	if collect {
+		c.collectors.collect()
 	}
 
-	c.r.mapped = true
+	for _, f := range hooks {
+		f()
+	}
 
 	return nil
 }
 
-func (c *PCPClient) start() {
+// newWriter creates a writer for a new mapping of the registry, which is published
+// at the location of the client by publishWriter once it is written. If the mapping
+// cannot be created, the error is passed to the error handler, and if the in-memory
+// fallback is enabled, an in-memory writer is returned instead.
+func (c *PCPClient) newWriter() (bytewriter.Writer, error) {
+	l := c.Length()
+
 	var (
-		InstanceLength = Instance1Length
-		MetricLength   = Metric1Length
+		writer *bytewriter.MemoryMappedWriter
+		err    error
 	)
 
-	if c.r.version2 {
-		InstanceLength = Instance2Length
-		MetricLength = Metric2Length
+	if c.memfd {
+		writer, err = bytewriter.NewMemfdWriter(MemfdPrefix+filepath.Base(c.loc), l)
+	} else {
+		writer, err = bytewriter.NewStagedMemoryMappedWriter(c.loc, l)
 	}
 
-	c.r.indomoffset = HeaderLength + TocLength*c.tocCount()
-	c.r.instanceoffset = c.r.indomoffset + InstanceDomainLength*c.r.InstanceDomainCount()
-	c.r.metricsoffset = c.r.instanceoffset + InstanceLength*c.r.InstanceCount()
-	c.r.valuesoffset = c.r.metricsoffset + MetricLength*c.r.MetricCount()
-	c.r.stringsoffset = c.r.valuesoffset + ValueLength*c.r.ValuesCount()
+	if err == nil {
+		if c.hugePages && l >= bytewriter.HugePageSize {
+			if herr := writer.AdviseHugePages(); herr != nil && logging {
+				clientlogger.WithField("error", herr).Warn("cannot request transparent huge pages")
+			}
+		}
 
-	if c.r.InstanceDomainCount() > 0 {
-		c.instanceoffsetc, c.indomoffsetc = make(chan int, 1), make(chan int, 1)
+		c.inMemory = false
+		return writer, nil
+	}
 
-		c.instanceoffsetc <- c.r.instanceoffset
-		c.indomoffsetc <- c.r.indomoffset
+	err = &MappingError{c.loc, err}
+	if logging {
+		clientlogger.WithField("error", err).Error("cannot create MemoryMappedBuffer")
 	}
 
-	if c.r.MetricCount() > 0 {
-		c.metricoffsetc, c.valueoffsetc = make(chan int, 1), make(chan int, 1)
+	c.deferError(err)
 
-		c.metricoffsetc <- c.r.metricsoffset
-		c.valueoffsetc <- c.r.valuesoffset
+	if !c.fallback {
+		return nil, err
 	}
 
-	if c.r.StringCount() > 0 {
-		c.stringoffsetc = make(chan int, 1)
-		c.stringoffsetc <- c.r.stringsoffset
+	if logging {
+		clientlogger.Warn("falling back to an in-memory writer, metrics will not be visible to PCP")
 	}
 
-	genc, g2offc := make(chan int64), make(chan int)
+	c.inMemory = true
+	return bytewriter.NewAlignedByteWriter(l, bytewriter.CacheLineSize), nil
+}
 
-	go c.writeHeaderBlock(genc, g2offc)
+// publishWriter replaces the file at the location of the client with the mapping
+// written to writer, so readers never find a missing or partially written mapping.
+// If it cannot be replaced, the error is passed to the error handler, and the client
+// keeps writing to the mapping like to an in-memory fallback, invisible to PCP.
+func (c *PCPClient) publishWriter(writer bytewriter.Writer) {
+	m, ok := writer.(*bytewriter.MemoryMappedWriter)
+	if !ok {
+		return
+	}
 
-	var wg sync.WaitGroup
-	wg.Add(2)
+	if err := m.Publish(); err != nil {
+		err = &MappingError{c.loc, err}
+		if logging {
+			clientlogger.WithField("error", err).Error("cannot publish MemoryMappedBuffer")
+		}
+
+		c.deferError(err)
+		c.inMemory = true
+	}
+}
+
+// closeWriter removes a mapping created by newWriter
+func closeWriter(writer bytewriter.Writer, erase bool) error {
+	if m, ok := writer.(*bytewriter.MemoryMappedWriter); ok {
+		return m.Unmap(erase)
+	}
+	return nil
+}
+
+// mapRegistry creates a new mapping and writes the registry to it, returning whether
+// the collectors were started, in which case they are due their first collection
+func (c *PCPClient) mapRegistry() (bool, error) {
+	c.r.reconcile()
+
+	if c.shared != nil {
+		if err := c.mapShared(); err != nil {
+			return false, err
+		}
+	} else {
+		writer, err := c.newWriter()
+		if err != nil {
+			return false, err
+		}
+		c.writer = writer
+		c.writes.reset()
+
+		c.start()
+		c.publishWriter(writer)
+	}
 
-	go func() {
-		c.writeTocBlock()
-		wg.Done()
-	}()
+	if logging {
+		clientlogger.Info("written the different components, the registered metrics should be visible now")
+	}
+
+	c.r.mapped = true
+
+	if c.resets != nil {
+		c.resets.start(c.clock)
+	}
+
+	if c.manualTick {
+		return false, nil
+	}
 
-	go func() {
-		// instance domains **have** to be written before metrics
-		// as metrics need instance offsets and multiple metrics
-		// can have the same indom, so they need to be cached
-		c.writeInstanceDomains()
-		c.writeMetrics()
-		wg.Done()
-	}()
+	collect := c.collectors.start(c.schedule(DefaultCollectInterval))
 
-	gen, g2off := <-genc, <-g2offc
-	wg.Wait()
+	if c.limiter != nil {
+		c.limiter.start(c.schedule(WriteLimiterFlushInterval))
+	}
 
-	// must *always* be the last thing to happen
-	_ = c.writer.MustWriteInt64(gen, g2off)
+	return collect, nil
 }
 
-func (c *PCPClient) writeHeaderBlock(genc chan int64, g2offc chan int) {
+func (c *PCPClient) start() {
+	l := newmmvLayout(c.r, c.encoder(), c.tocCount(), c.valueAlignment(), c.hot, c.headroom)
+	l.gen = time.Now().Unix()
+
+	// a remapped registry must have a new generation, even within the same second
+	if c.layout != nil && l.gen <= c.layout.gen {
+		l.gen = c.layout.gen + 1
+	}
+	c.layout = l
+
+	old := c.updates
+	c.updates = nil
+	if c.recordUpdates && !c.memfd && !c.inMemory && c.shared == nil {
+		c.updates = c.newUpdateTimes(l)
+	}
+
+	c.write(l, l.gen, int32(os.Getpid()), true)
+	atomic.AddUint64(&c.stats.Bytes, uint64(len(c.writer.Bytes())))
+
+	// the file was already replaced by the new one, so only unmap
+	_ = old.close(false)
+}
+
+// write writes the registry to the current writer, binding the metrics to it if bind is true
+func (c *PCPClient) write(l *mmvLayout, gen int64, pid int32, bind bool) {
+	c.descdata = c.newDescriptionData()
+
+	g2off := c.writeHeaderBlock(gen, pid, l)
+	c.writeTocBlock(l)
+
+	// instance domains **have** to be written before metrics
+	// as instance metric values need the instance offsets
+	for i, indom := range l.indoms {
+		c.writeInstanceDomain(indom, l.indom(i), l.indomDescriptor(i), l)
+	}
+
+	for i, m := range l.metrics {
+		c.writeMetric(m, l.metric(i), l.metricDescriptor(i), l, bind)
+	}
+
+	// must *always* be the last thing to happen, see barrier.go
+	publishGeneration(c.writer, gen, g2off)
+}
+
+// EncodeTo writes the mapping the client creates for its current registry to w,
+// without creating a file and without touching the mapping of a started client.
+//
+// The generation numbers and the process identifier are written as 0, so the output
+// only changes with the registered metrics and their values, and can be compared
+// against a golden file to catch unintended changes to the instrumentation of an application.
+func (c *PCPClient) EncodeTo(w io.Writer) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// metrics of a started client stay bound to the writer of the active mapping
+	active := c.writer
+	defer func() { c.writer = active }()
+
+	c.writer = bytewriter.NewByteWriter(c.Length())
+	c.write(newmmvLayout(c.r, c.encoder(), c.tocCount(), c.valueAlignment(), c.hot, c.headroom), 0, 0, false)
+
+	_, err := w.Write(c.writer.Bytes())
+	return err
+}
+
+// writeHeaderBlock writes the header, with the second generation number set to 0,
+// and returns the offset where the second generation number has to be written
+func (c *PCPClient) writeHeaderBlock(gen int64, pid int32, l *mmvLayout) int {
 	// tag
 	c.writer.MustWriteString("MMV", 0)
 
-	var pos int
-
 	// version
-	if c.r.version2 {
-		pos = c.writer.MustWriteUint32(2, 4)
-	} else {
-		pos = c.writer.MustWriteUint32(1, 4)
-	}
+	pos := c.writer.MustWriteUint32(l.enc.version(), 4)
 
 	// generation
-	gen := time.Now().Unix()
 	pos = c.writer.MustWriteInt64(gen, pos)
 
 	g2off := pos
@@ -313,75 +540,50 @@ func (c *PCPClient) writeHeaderBlock(genc chan int64, g2offc chan int) {
 	pos = c.writer.MustWriteInt32(int32(c.flag), pos)
 
 	// process identifier
-	pos = c.writer.MustWriteInt32(int32(os.Getpid()), pos)
+	pos = c.writer.MustWriteInt32(pid, pos)
 
 	// cluster identifier
 	_ = c.writer.MustWriteUint32(c.clusterID, pos)
 
-	// NOTE: the order here is important, should be same as in start()
-	// or deadlock
-	genc <- gen
-	g2offc <- g2off
-
-	return
+	return g2off
 }
 
-func (c *PCPClient) writeTocBlock() {
-	var wg sync.WaitGroup
+func (c *PCPClient) writeTocBlock(l *mmvLayout) {
 	tocpos := HeaderLength
 
-	wg.Add(c.tocCount())
-
 	// instance domains toc
-	if c.r.InstanceDomainCount() > 0 {
-		go func(pos int) {
-			// 1 is the identifier for instance domains
-			c.writeSingleToc(pos, 1, c.r.InstanceDomainCount(), c.r.indomoffset)
-			wg.Done()
-		}(tocpos)
+	if len(l.indoms) > 0 {
+		// 1 is the identifier for instance domains
+		c.writeSingleToc(tocpos, 1, len(l.indoms), l.indomoffset)
 		tocpos += TocLength
 	}
 
 	// instances toc
 	if c.r.InstanceCount() > 0 {
-		go func(pos int) {
-			// 2 is the identifier for instances
-			c.writeSingleToc(pos, 2, c.r.InstanceCount(), c.r.instanceoffset)
-			wg.Done()
-		}(tocpos)
+		// 2 is the identifier for instances
+		c.writeSingleToc(tocpos, 2, c.r.InstanceCount(), l.instanceoffset)
 		tocpos += TocLength
 	}
 
 	// metrics and values toc
-	metricsoffset, valuesoffset := c.r.metricsoffset, c.r.valuesoffset
-	if c.r.MetricCount() == 0 {
+	metricsoffset, valuesoffset := l.metricsoffset, l.valuesoffset
+	if len(l.metrics) == 0 {
 		metricsoffset, valuesoffset = 0, 0
 	}
 
-	go func(pos int) {
-		// 3 is the identifier for metrics
-		c.writeSingleToc(pos, 3, c.r.MetricCount(), metricsoffset)
-		wg.Done()
-	}(tocpos)
+	// 3 is the identifier for metrics
+	c.writeSingleToc(tocpos, 3, len(l.metrics), metricsoffset)
 	tocpos += TocLength
 
-	go func(pos int) {
-		// 4 is the identifier for values
-		c.writeSingleToc(pos, 4, c.r.ValuesCount(), valuesoffset)
-		wg.Done()
-	}(tocpos)
+	// 4 is the identifier for values
+	c.writeSingleToc(tocpos, 4, c.r.ValuesCount(), valuesoffset)
 	tocpos += TocLength
 
 	// strings toc
-	if c.r.StringCount() > 0 {
-		go func(pos int) {
-			// 5 is the identifier for strings
-			c.writeSingleToc(pos, 5, c.r.StringCount(), c.r.stringsoffset)
-			wg.Done()
-		}(tocpos)
+	if n := stringCount(c.r, l.enc); n > 0 {
+		// 5 is the identifier for strings
+		c.writeSingleToc(tocpos, 5, n, l.stringsoffset)
 	}
-
-	wg.Wait()
 }
 
 func (c *PCPClient) writeSingleToc(pos, identifier, count, offset int) {
@@ -390,280 +592,385 @@ func (c *PCPClient) writeSingleToc(pos, identifier, count, offset int) {
 	_ = c.writer.MustWriteUint64(uint64(offset), pos)
 }
 
-func (c *PCPClient) writeInstanceDomains() {
-	var wg sync.WaitGroup
-	wg.Add(c.r.InstanceDomainCount())
+// writeInstanceDomain writes an indom described by desc and its instances at the offsets in slots
+func (c *PCPClient) writeInstanceDomain(indom *PCPInstanceDomain, slots, desc []int, l *mmvLayout) {
+	off, so, lo := slots[0], slots[1], slots[2]
+	instances := slots[indomSlots:]
 
-	for _, indom := range c.r.instanceDomains {
-		go func(indom *PCPInstanceDomain) {
-			c.writeInstanceDomain(indom)
-			wg.Done()
-		}(indom)
+	ioff := 0
+	if len(instances) > 0 {
+		ioff = instances[0]
 	}
 
-	wg.Wait()
-}
-
-func (c *PCPClient) writeInstanceDomain(indom *PCPInstanceDomain) {
-	off := <-c.indomoffsetc
-	c.indomoffsetc <- off + InstanceDomainLength
+	pos := c.writer.MustWriteUint32(uint32(desc[0]), off)
+	pos = c.writer.MustWriteInt32(int32(len(instances)/instanceSlots), pos)
+	pos = c.writer.MustWriteInt64(int64(ioff), pos)
 
-	InstanceLength := Instance1Length
-	if c.r.version2 {
-		InstanceLength = Instance2Length
+	if so != 0 {
+		c.writeDescription(l.str(desc, 1), so)
 	}
 
-	inoff := off
-	ioff := <-c.instanceoffsetc
-	c.instanceoffsetc <- ioff + InstanceLength*indom.InstanceCount()
-
-	var wg sync.WaitGroup
-	wg.Add(indom.InstanceCount())
+	if lo != 0 {
+		c.writeDescription(l.str(desc, 3), lo)
+	}
 
-	off = c.writer.MustWriteUint32(indom.id, off)
-	off = c.writer.MustWriteInt32(int32(indom.InstanceCount()), off)
-	off = c.writer.MustWriteInt64(int64(ioff), off)
+	pos = c.writer.MustWriteUint64(uint64(so), pos)
+	_ = c.writer.MustWriteUint64(uint64(lo), pos)
 
-	for _, i := range indom.instances {
-		go func(i *pcpInstance, offset int) {
-			c.writeInstance(i, inoff, offset)
-			wg.Done()
-		}(i, ioff)
-		ioff += InstanceLength
+	for i, ins := range indom.sortedInstances() {
+		c.writeInstance(ins, off, instances[i*instanceSlots:], desc[indomDescWords+i*instanceDescWords:], l)
 	}
+}
+
+func (c *PCPClient) writeInstance(i *pcpInstance, indomoff int, slots, desc []int, l *mmvLayout) {
+	off := slots[0]
+	i.offset = off
 
-	so, lo := 0, 0
+	off = c.writer.MustWriteInt64(int64(indomoff), off)
+	off = c.writer.MustWriteInt32(0, off)
+	off = c.writer.MustWriteUint32(uint32(desc[0]), off)
 
-	if indom.shortDescription != "" {
-		so = <-c.stringoffsetc
-		c.stringoffsetc <- so + StringLength
+	l.enc.writeInstanceName(c.writer, l.str(desc, 1), off, slots[1])
+}
 
-		c.writer.MustWriteString(indom.shortDescription, so)
+// writeDescription writes a description stored in the arena of a layout at off,
+// expanding it first if it is a template, see DescriptionData
+func (c *PCPClient) writeDescription(desc []byte, off int) {
+	if bytes.Contains(desc, descriptionTemplate) {
+		c.writer.MustWriteString(c.expandDescription(string(desc)), off)
+		return
 	}
 
-	if indom.longDescription != "" {
-		lo = <-c.stringoffsetc
-		c.stringoffsetc <- lo + StringLength
+	c.writer.MustWrite(desc, off)
+}
 
-		c.writer.MustWriteString(indom.longDescription, lo)
-	}
+// singletonMetric is implemented by all metrics embedding a pcpSingletonMetric
+type singletonMetric interface {
+	singleton() *pcpSingletonMetric
+}
 
-	off = c.writer.MustWriteUint64(uint64(so), off)
-	_ = c.writer.MustWriteUint64(uint64(lo), off)
+// instanceMetric is implemented by all metrics embedding a pcpInstanceMetric
+type instanceMetric interface {
+	instance() *pcpInstanceMetric
+}
 
-	wg.Wait()
+// writeMetric writes a metric described by desc and its values at the offsets in slots
+func (c *PCPClient) writeMetric(m PCPMetric, slots, desc []int, l *mmvLayout, bind bool) {
+	switch metric := m.(type) {
+	case singletonMetric:
+		c.writeSingletonMetric(metric.singleton(), slots, desc, l, bind)
+	case instanceMetric:
+		c.writeInstanceMetric(metric.instance(), slots, desc, l, bind)
+	}
 }
 
-func (c *PCPClient) writeInstance(i *pcpInstance, indomoff int, off int) {
-	i.offset = off
+// unbindMetric unbinds all values of a metric from the current mapping,
+// so updates are buffered in the metric until it is written again
+func unbindMetric(m PCPMetric) {
+	switch metric := m.(type) {
+	case singletonMetric:
+		metric.singleton().unbind()
+	case instanceMetric:
+		metric.instance().unbind()
+	}
+}
 
-	off = c.writer.MustWriteInt64(int64(indomoff), off)
-	off = c.writer.MustWriteInt32(0, off)
-	off = c.writer.MustWriteUint32(i.id, off)
+func (c *PCPClient) writeSingletonMetric(m *pcpSingletonMetric, slots, desc []int, l *mmvLayout, bind bool) {
+	doff := slots[0]
+	c.writeMetricDesc(slots, desc, l)
 
-	if c.r.version2 {
-		soff := <-c.stringoffsetc
-		c.stringoffsetc <- soff + StringLength
+	v := slots[metricSlots:]
 
-		c.writer.MustWriteUint64(uint64(soff), off)
-		c.writer.MustWriteString(i.name, soff)
+	if !bind {
+		m.mutex.RLock()
+		c.writeValue(m.pcpMetricDesc, m.published(), v[0], v[1], false)
+		m.mutex.RUnlock()
 	} else {
-		c.writer.MustWriteString(i.name, off)
-	}
-}
-
-func (c *PCPClient) writeMetrics() {
-	var wg sync.WaitGroup
-
-	launchSingletonMetric := func(metric *pcpSingletonMetric) {
-		go func() {
-			c.writeSingletonMetric(metric)
-			wg.Done()
-		}()
-	}
-
-	launchInstanceMetric := func(metric *pcpInstanceMetric) {
-		go func() {
-			c.writeInstanceMetric(metric)
-			wg.Done()
-		}()
-	}
-
-	wg.Add(c.r.MetricCount())
-	for _, m := range c.r.metrics {
-		switch metric := m.(type) {
-		case *PCPSingletonMetric:
-			launchSingletonMetric(metric.pcpSingletonMetric)
-		case *PCPCounter:
-			launchSingletonMetric(metric.pcpSingletonMetric)
-		case *PCPGauge:
-			launchSingletonMetric(metric.pcpSingletonMetric)
-		case *PCPTimer:
-			launchSingletonMetric(metric.pcpSingletonMetric)
-		case *PCPInstanceMetric:
-			launchInstanceMetric(metric.pcpInstanceMetric)
-		case *PCPCounterVector:
-			launchInstanceMetric(metric.pcpInstanceMetric)
-		case *PCPGaugeVector:
-			launchInstanceMetric(metric.pcpInstanceMetric)
-		case *PCPHistogram:
-			launchInstanceMetric(metric.pcpInstanceMetric)
+		// the counter of coalesced writes is never limited
+		limited := c.limiter != nil && m != c.limiter.metric.pcpSingletonMetric
+
+		m.mutex.Lock()
+
+		var word *uint64
+		if m.t.isAtomic() && !limited && c.updates == nil {
+			word = wordAt(c.writer, v[0])
 		}
-	}
 
-	wg.Wait()
-}
+		if word != nil {
+			m.bindWord(word)
+		} else {
+			m.bindUpdate(c.updates.stamp(c.writeValue(m.pcpMetricDesc, m.published(), v[0], v[1], limited), v[0]))
+		}
 
-func (c *PCPClient) writeSingletonMetric(m *pcpSingletonMetric) {
-	var wg sync.WaitGroup
-	wg.Add(2)
+		m.mutex.Unlock()
+	}
 
-	doff := <-c.metricoffsetc
+	off := c.writer.MustWriteInt64(int64(doff), v[0]+MaxDataValueSize)
+	_ = c.writer.MustWriteInt64(0, off)
+}
 
-	go func() {
-		c.writeMetricDesc(m.pcpMetricDesc, m.Indom(), doff)
-		wg.Done()
-	}()
+func (c *PCPClient) writeInstanceMetric(m *pcpInstanceMetric, slots, desc []int, l *mmvLayout, bind bool) {
+	doff := slots[0]
+	c.writeMetricDesc(slots, desc, l)
 
-	off := <-c.valueoffsetc
-	c.valueoffsetc <- off + ValueLength
+	v := slots[metricSlots:]
 
-	go func(offset int) {
-		m.update = c.writeValue(m.t, m.val, offset)
-		wg.Done()
-	}(off)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
-	off = c.writer.MustWriteInt64(int64(doff), off+MaxDataValueSize)
-	_ = c.writer.MustWriteInt64(0, off)
+	for j, i := range m.indom.sortedInstances() {
+		val := m.vals[i.name]
+		update := c.writeValue(m.pcpMetricDesc, val.val, v[j*valueSlots], v[j*valueSlots+1], bind && c.limiter != nil)
+		if bind && !val.deleted && c.owns(m.indom, i.name) {
+			val.update = c.updates.stamp(update, v[j*valueSlots])
+		}
 
-	wg.Wait()
+		off := c.writer.MustWriteInt64(int64(doff), v[j*valueSlots]+MaxDataValueSize)
+		_ = c.writer.MustWriteInt64(int64(i.offset), off)
+	}
 }
 
-func (c *PCPClient) writeInstanceMetric(m *pcpInstanceMetric) {
-	var wg sync.WaitGroup
-	wg.Add(1 + m.Indom().InstanceCount())
+func (c *PCPClient) writeMetricDesc(slots, desc []int, l *mmvLayout) {
+	off, noff, so, lo := slots[0], slots[1], slots[2], slots[3]
+
+	off = l.enc.writeMetricName(c.writer, l.str(desc, 5), off, noff)
 
-	doff := <-c.metricoffsetc
+	off = c.writer.MustWriteUint32(uint32(desc[0]), off)
+	off = c.writer.MustWriteInt32(int32(desc[1]), off)
+	off = c.writer.MustWriteInt32(int32(desc[2]), off)
+	off = c.writer.MustWriteUint32(uint32(desc[3]), off)
 
-	go func() {
-		c.writeMetricDesc(m.pcpMetricDesc, m.Indom(), doff)
-		wg.Done()
-	}()
+	// -1 for metrics without instances
+	off = c.writer.MustWriteInt32(int32(desc[4]), off)
 
-	for name, i := range m.indom.instances {
-		off := <-c.valueoffsetc
-		c.valueoffsetc <- off + ValueLength
+	off = c.writer.MustWriteInt32(0, off)
 
-		go func(i *instanceValue, offset int) {
-			i.update = c.writeValue(m.t, i.val, offset)
-			wg.Done()
-		}(m.vals[name], off)
+	if so != 0 {
+		c.writeDescription(l.str(desc, 7), so)
+	}
 
-		off = c.writer.MustWriteInt64(int64(doff), off+MaxDataValueSize)
-		_ = c.writer.MustWriteInt64(int64(i.offset), off)
+	if lo != 0 {
+		c.writeDescription(l.str(desc, 9), lo)
 	}
 
-	wg.Wait()
+	off = c.writer.MustWriteUint64(uint64(so), off)
+	_ = c.writer.MustWriteUint64(uint64(lo), off)
 }
 
-func (c *PCPClient) writeMetricDesc(desc *pcpMetricDesc, indom *PCPInstanceDomain, off int) {
-	if c.r.version2 {
-		c.metricoffsetc <- off + Metric2Length
+// writeValue writes the passed value at offset, with string values being written
+// at stringoffset, and returns a closure that can update the written value,
+// writing through the write rate limit if limited is true
+func (c *PCPClient) writeValue(md *pcpMetricDesc, val interface{}, offset, stringoffset int, limited bool) updateClosure {
+	t := md.t
+	if t == StringType {
+		pos := c.writer.MustWriteUint64(StringLength-1, offset)
+		c.writer.MustWriteUint64(uint64(stringoffset), pos)
+		offset = stringoffset
+	}
 
-		noff := <-c.stringoffsetc
-		c.stringoffsetc <- noff + StringLength
+	update := c.writes.track(newvalueClosure(t, offset, c.writer))
+	_ = update(val)
 
-		off = c.writer.MustWriteUint64(uint64(noff), off)
-		c.writer.MustWriteString(desc.name, noff)
-	} else {
-		c.metricoffsetc <- off + Metric1Length
+	// the initial write is counted as part of the mapping
+	update = md.counts.track(update)
 
-		c.writer.MustWriteString(desc.name, off)
-		off += MaxV1NameLength + 1
+	if limited {
+		update = c.limiter.wrap(update, c.writer, offset)
 	}
 
-	off = c.writer.MustWriteUint32(desc.id, off)
-	off = c.writer.MustWriteInt32(int32(desc.t), off)
-	off = c.writer.MustWriteInt32(int32(desc.sem), off)
-	off = c.writer.MustWriteUint32(desc.u.PMAPI(), off)
+	return update
+}
+
+// remap writes the registry to a new mapping replacing the current one,
+// for changes to the layout made while the client is started.
+// Metrics keep updating the old mapping until they are rebound to the new one,
+// after which the old mapping is removed.
+func (c *PCPClient) remap() error {
+	if c.shared != nil {
+		return errors.New("the layout of a shared mapping cannot change while it is started")
+	}
 
-	if indom != nil {
-		off = c.writer.MustWriteUint32(indom.ID(), off)
-	} else {
-		off = c.writer.MustWriteInt32(-1, off)
+	writer, err := c.newWriter()
+	if err != nil {
+		return err
 	}
 
-	off = c.writer.MustWriteInt32(0, off)
+	old := c.writer
+	c.writer = writer
 
-	so, lo := 0, 0
+	c.start()
+	c.publishWriter(writer)
+	atomic.AddUint64(&c.stats.Remaps, 1)
+	if logging {
+		clientlogger.Info("remapped the registry")
+	}
 
-	if desc.shortDescription != "" {
-		so = <-c.stringoffsetc
-		c.stringoffsetc <- so + StringLength
+	if c.limiter != nil {
+		c.limiter.forget(old)
+	}
 
-		c.writer.MustWriteString(desc.shortDescription, so)
+	// readers still holding the old mapping see it is no longer written, and reopen
+	// the file, which was already replaced by the new mapping, so only unmap.
+	// The new mapping is live by now, so failing to unmap the old one is reported
+	// to the error handler instead of failing the change that was already published.
+	invalidateGeneration(old, g2Offset)
+	if err = closeWriter(old, false); err != nil {
+		if logging {
+			clientlogger.WithField("error", err).Error("error unmapping MemoryMappedBuffer")
+		}
+		c.deferError(err)
 	}
 
-	if desc.longDescription != "" {
-		lo = <-c.stringoffsetc
-		c.stringoffsetc <- lo + StringLength
+	return nil
+}
 
-		c.writer.MustWriteString(desc.longDescription, lo)
+// relayout writes the registry to the active mapping in place if only instances
+// were added to it and they fit in the room reserved for them, and to a new mapping otherwise
+func (c *PCPClient) relayout() error {
+	if c.shared == nil {
+		if l := c.layout.extend(c.r, c.encoder(), c.tocCount()); l != nil {
+			c.extend(l)
+			return nil
+		}
 	}
 
-	off = c.writer.MustWriteUint64(uint64(so), off)
-	_ = c.writer.MustWriteUint64(uint64(lo), off)
+	return c.remap()
 }
 
-func (c *PCPClient) writeValue(t MetricType, val interface{}, offset int) updateClosure {
-	if t == StringType {
-		pos := c.writer.MustWriteUint64(StringLength-1, offset)
+// extend writes a layout computed by mmvLayout.extend to the active mapping, rewriting
+// the instance domains and instances, and writing and binding the values of the new instances.
+// Values of existing instances are not touched, so metrics keep updating them, and readers
+// see the mapping as being written until the new generation is written last.
+func (c *PCPClient) extend(l *mmvLayout) {
+	l.gen = time.Now().Unix()
+	if l.gen <= c.layout.gen {
+		l.gen = c.layout.gen + 1
+	}
 
-		offset = <-c.stringoffsetc
-		c.stringoffsetc <- offset + StringLength
+	invalidateGeneration(c.writer, g2Offset)
 
-		c.writer.MustWriteUint64(uint64(offset), pos)
+	g2off := c.writeHeaderBlock(l.gen, int32(os.Getpid()), l)
+	c.writeTocBlock(l)
+
+	for i, indom := range l.indoms {
+		c.writeInstanceDomain(indom, l.indom(i), l.indomDescriptor(i), l)
 	}
 
-	update := newupdateClosure(offset, c.writer)
-	_ = update(val)
+	for i, m := range l.metrics {
+		if im, ok := m.(instanceMetric); ok {
+			c.extendInstanceMetric(im.instance(), l.metric(i))
+		}
+	}
 
-	return update
+	publishGeneration(c.writer, l.gen, g2off)
+	c.updates.setGeneration(l.gen)
+
+	c.layout = l
+	atomic.AddUint64(&c.stats.Extensions, 1)
+	if logging {
+		clientlogger.Info("extended the mapping in place")
+	}
+}
+
+// extendInstanceMetric writes the values of the instances of a metric that are not
+// bound to the mapping, and the offsets of the instances of all of its values
+func (c *PCPClient) extendInstanceMetric(m *pcpInstanceMetric, slots []int) {
+	doff := slots[0]
+	v := slots[metricSlots:]
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for j, i := range m.indom.sortedInstances() {
+		val := m.vals[i.name]
+		if val.update == nil {
+			update := c.writeValue(m.pcpMetricDesc, val.val, v[j*valueSlots], v[j*valueSlots+1], c.limiter != nil)
+			if !val.deleted {
+				val.update = c.updates.stamp(update, v[j*valueSlots])
+			}
+		}
+
+		off := c.writer.MustWriteInt64(int64(doff), v[j*valueSlots]+MaxDataValueSize)
+		_ = c.writer.MustWriteInt64(int64(i.offset), off)
+	}
 }
 
 // MustStart is a start that panics
 func (c *PCPClient) MustStart() {
-	if err := c.Start(); err != nil {
-		panic(err)
-	}
+	must("start", "", c.name(), c.Start())
 }
 
-// Stop removes existing mapping and cleans up
+// Stop calls all functions registered using OnStop, and then removes existing mapping and cleans up.
+// Stopping a client that is not started, or that is being stopped by another goroutine, does nothing.
 func (c *PCPClient) Stop() error {
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
+	if !c.r.mapped || c.stopping {
+		c.mutex.Unlock()
+		return nil
+	}
 
-	if !c.r.mapped {
-		return errors.New("trying to stop an already stopped mapping")
+	// hooks and collectors are called without holding the lock, so they can use the client
+	c.stopping = true
+	hooks := c.onStop
+	stopCollectors := c.collectors.stop()
+	c.mutex.Unlock()
+
+	for _, f := range hooks {
+		f()
 	}
 
+	stopCollectors()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.stopping = false
+
 	if logging {
 		clientlogger.Info("stopping the client")
 	}
 
+	if c.resets != nil {
+		c.resets.stop()
+	}
+
+	if c.limiter != nil {
+		c.limiter.stop()
+	}
+
 	c.stop()
 
+	if c.limiter != nil {
+		c.limiter.forget(c.writer)
+	}
+
 	c.r.mapped = false
 
-	err := c.writer.(*bytewriter.MemoryMappedWriter).Unmap(EraseFileOnStop)
+	err := closeWriter(c.writer, EraseFileOnStop)
 	c.writer = nil
+
+	if uerr := c.updates.close(EraseFileOnStop); err == nil {
+		err = uerr
+	}
+	c.updates = nil
+
+	if c.shared != nil {
+		if rerr := c.release(c.shared.leader && EraseFileOnStop); err == nil {
+			err = rerr
+		}
+	}
+
+	err = c.opError("stop", "", err)
 	if err != nil {
+		c.state, c.err = ClientFailed, err
 		if logging {
 			clientlogger.WithField("error", err).Error("error unmapping MemoryMappedBuffer")
 		}
 		return err
 	}
 
+	c.state = ClientStopped
+
 	if logging {
 		clientlogger.Info("unmapped the memory mapped file")
 	}
@@ -672,50 +979,634 @@ func (c *PCPClient) Stop() error {
 }
 
 func (c *PCPClient) stop() {
-	c.instanceoffsetc, c.indomoffsetc = nil, nil
-	c.metricoffsetc, c.valueoffsetc = nil, nil
-	c.stringoffsetc = nil
+	for _, m := range c.layout.metrics {
+		unbindMetric(m)
+	}
+
+	c.layout = nil
 }
 
 // MustStop is a stop that panics
 func (c *PCPClient) MustStop() {
-	if err := c.Stop(); err != nil {
-		panic(err)
+	must("stop", "", c.name(), c.Stop())
+}
+
+// OnStart registers a function to be called every time the client is started,
+// after the mapping has been written. Functions are called in the order they are registered.
+func (c *PCPClient) OnStart(f func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.onStart = append(c.onStart, f)
+}
+
+// OnStop registers a function to be called every time the client is stopped,
+// before the mapping is removed. Functions are called in the order they are registered.
+func (c *PCPClient) OnStop(f func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.onStop = append(c.onStop, f)
+}
+
+// SetErrorHandler sets a function that is called with errors that happen while
+// creating or writing a mapping, like a MappingError when the filesystem holding
+// the mapping is full, in addition to them being returned where possible.
+//
+// It is also called with errors updating the metrics of instrumented queues, pools,
+// breakers and jobs, whose hooks never fail the code they instrument.
+func (c *PCPClient) SetErrorHandler(h func(error)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.errorHandler = h
+}
+
+// deferError records an error for the error handler while the lock is held, which is
+// passed to it by unlock, so a handler using the client does not deadlock.
+func (c *PCPClient) deferError(err error) {
+	c.deferred = append(c.deferred, err)
+}
+
+// unlock releases the lock of the client, then passes the errors deferred
+// while it was held to the error handler.
+func (c *PCPClient) unlock() {
+	errs, h := c.deferred, c.errorHandler
+	c.deferred = nil
+	c.mutex.Unlock()
+
+	if h == nil {
+		return
+	}
+
+	for _, err := range errs {
+		h(err)
+	}
+}
+
+// handleError passes an error that cannot be returned to the caller to the error handler.
+func (c *PCPClient) handleError(err error) {
+	c.mutex.Lock()
+	h := c.errorHandler
+	c.mutex.Unlock()
+
+	if h != nil {
+		h(err)
+	}
+}
+
+// reportError passes an error updating a metric to the error handler, doing nothing for nil,
+// for hooks that cannot fail the code they are called by.
+func (c *PCPClient) reportError(err error) {
+	if err != nil {
+		c.handleError(err)
+	}
+}
+
+// SetInMemoryFallback sets whether the client falls back to writing metrics to memory
+// when a mapping cannot be created, instead of failing to start.
+//
+// Metrics written to memory are not visible to PCP, but the application keeps
+// running normally, with the error being passed to the error handler.
+func (c *PCPClient) SetInMemoryFallback(fallback bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.fallback = fallback
+}
+
+// InMemory returns true if the client is started, but writing metrics to memory
+// as the mapping could not be created.
+func (c *PCPClient) InMemory() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.r.mapped && c.inMemory
+}
+
+// SetValueAlignment aligns the start of the values section of the mapping to a multiple
+// of align bytes, which must be a power of 2, or 0 to not align it.
+//
+// Aligning it to bytewriter.CacheLineSize keeps every value within a single cache line,
+// so PCP never reads a cache line that is partially updated, and adjacent values
+// share as few cache lines as possible. Mappings themselves are always page aligned.
+func (c *PCPClient) SetValueAlignment(align int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.r.mapped {
+		return ErrClientStarted
+	}
+
+	if align < 0 || align&(align-1) != 0 {
+		return fmt.Errorf("alignment %v is not a power of 2", align)
+	}
+
+	c.alignment = align
+	return nil
+}
+
+// valueAlignment returns the alignment of the values section, which is at least
+// a word, and at least a cache line when hot metrics are registered
+func (c *PCPClient) valueAlignment() int {
+	if len(c.hot) > 0 && c.alignment < bytewriter.CacheLineSize {
+		return bytewriter.CacheLineSize
+	}
+
+	if c.alignment < wordAlignment {
+		return wordAlignment
+	}
+
+	return c.alignment
+}
+
+// SetInstanceHeadroom reserves room in the mapping for n instances added to the instance
+// domains of the client after it is started, by vectors registered using WithMaxInstances.
+//
+// While they fit, instances added by Compact are written to the active mapping in place,
+// without writing the metadata of the registry and the values of existing instances
+// to a new mapping, which is cheaper and does not make readers map a new file.
+// Other changes to the registry and instances that do not fit still remap it.
+func (c *PCPClient) SetInstanceHeadroom(n int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.r.mapped {
+		return ErrClientStarted
+	}
+
+	if n < 0 {
+		return fmt.Errorf("instance headroom %v is negative", n)
+	}
+
+	c.headroom = n
+	return nil
+}
+
+// SetHugePages sets whether the client requests transparent huge pages for mappings
+// of at least bytewriter.HugePageSize bytes, which reduces TLB misses when updating
+// and sampling registries with very many values. Failing to get huge pages is not an error,
+// as the mapping works the same without them.
+func (c *PCPClient) SetHugePages(huge bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.r.mapped {
+		return ErrClientStarted
+	}
+
+	c.hugePages = huge
+	return nil
+}
+
+// MemfdPrefix prefixes the client name in the name of the anonymous memory file
+// created for a client using SetMemfd, which readers look for under /proc/<pid>/fd.
+const MemfdPrefix = "mmv:"
+
+// SetMemfd sets whether the client maps an anonymous memory file created using
+// memfd_create instead of a file under PCP_TMP_DIR, for applications that cannot
+// write to it, like ones running in containers with a read only filesystem.
+//
+// PCP does not discover such mappings by itself, but they can be read by other
+// processes through /proc/<pid>/fd, see Location and mmvdump -pid.
+// Anonymous mappings are only supported on Linux.
+func (c *PCPClient) SetMemfd(memfd bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.r.mapped {
+		return ErrClientStarted
+	}
+
+	c.memfd = memfd
+	return nil
+}
+
+// Location returns the path the mapping of a started client can be read at,
+// which is under /proc/<pid>/fd for anonymous mappings.
+func (c *PCPClient) Location() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if m, ok := c.writer.(*bytewriter.MemoryMappedWriter); ok {
+		return m.Location()
+	}
+
+	return c.loc
+}
+
+// SetWriteRateLimit limits the number of values written to the mapping every second.
+//
+// Updates made over the limit are stored in their metrics and only the latest update
+// to every value is written once every WriteLimiterFlushInterval, protecting the application
+// from a metric being updated in a tight loop. The number of updates held back this way
+// is published by a counter registered under CoalescedWritesMetricName.
+func (c *PCPClient) SetWriteRateLimit(limit int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.r.mapped {
+		return ErrClientStarted
+	}
+
+	if limit <= 0 {
+		return errors.New("write rate limit must be positive")
+	}
+
+	if c.limiter != nil {
+		c.limiter.limit, c.limiter.tokens = float64(limit), float64(limit)
+		return nil
+	}
+
+	m, err := NewPCPCounter(0, CoalescedWritesMetricName, "number of updates held back by the write rate limit")
+	if err != nil {
+		return err
+	}
+
+	if err = c.r.AddMetric(m); err != nil {
+		return err
+	}
+
+	c.limiter = newwriteLimiter(limit, m, c.stats, c.clock)
+	return nil
+}
+
+// Compact removes the instances deleted from vector metrics from the registry, and
+// adds the instances added to vectors registered using WithMaxInstances. If the client
+// is started and instances changed, the registry is written to a new mapping.
+// Clients are also compacted on Start.
+func (c *PCPClient) Compact() error {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	if c.r.reconcile() > 0 && c.r.mapped {
+		return c.relayout()
+	}
+
+	return nil
+}
+
+// changeInstance adds or removes an instance of a registered instance domain,
+// writing the registry to the mapping again if the client is started.
+func (c *PCPClient) changeInstance(indom *PCPInstanceDomain, instance string, add bool) error {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	if c.r.mapped && c.shared != nil {
+		return ErrClientStarted
+	}
+
+	if err := c.r.applyInstanceChange(indom, instance, add); err != nil {
+		return err
+	}
+
+	if c.r.mapped {
+		return c.relayout()
+	}
+
+	return nil
+}
+
+// InstanceDomains returns all instance domains registered with the client ordered by name,
+// along with the metrics that reference each of them
+func (c *PCPClient) InstanceDomains() []RegisteredInstanceDomain {
+	return c.r.registeredInstanceDomains()
+}
+
+// SetInstanceDomainDescription updates the short and long description of a
+// registered instance domain. If the client is started, the registry is
+// written to a new mapping containing the updated description.
+func (c *PCPClient) SetInstanceDomainDescription(name string, desc ...string) error {
+	if err := validateDescriptions(desc...); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.unlock()
+
+	if err := c.r.setInstanceDomainDescription(name, desc...); err != nil {
+		return err
+	}
+
+	if c.r.mapped {
+		return c.remap()
+	}
+
+	return nil
+}
+
+// SetMetricDescription updates the short and long description of a
+// registered metric. If the client is started, the registry is
+// written to a new mapping containing the updated description.
+func (c *PCPClient) SetMetricDescription(name string, desc ...string) error {
+	if err := validateDescriptions(desc...); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.unlock()
+
+	if err := c.r.setMetricDescription(name, desc...); err != nil {
+		return err
+	}
+
+	if c.r.mapped {
+		return c.remap()
+	}
+
+	return nil
+}
+
+// Register is simply a shorthand for Registry().AddMetric,
+// that also applies the passed options, like WithTags.
+//
+// Unlike Registry().AddMetric, it also works while the client is started,
+// writing the registry to a new mapping with a new generation, which readers
+// pick up like the mapping of a restarted application. Mappings shared with
+// other processes cannot be remapped, and return ErrClientStarted.
+//
+// Errors other than ErrClientStarted are returned as an *OpError
+// naming the metric and the client.
+func (c *PCPClient) Register(m Metric, opts ...RegisterOption) error {
+	if err := c.checkMetric(m); err != nil {
+		return c.opError("register", m.Name(), err)
+	}
+
+	if err := c.checkRegistrable(m.Name()); err != nil {
+		return c.opError("register", m.Name(), err)
+	}
+
+	reg := c.beginRegistration(m)
+	err := c.applyOptions(m, reg, opts)
+	c.endRegistration(m, reg, err)
+
+	return c.opError("register", m.Name(), err)
+}
+
+// applyOptions applies the options of a metric and adds it to the registry,
+// along with the metrics attached to it by the options
+func (c *PCPClient) applyOptions(m Metric, reg *registration, opts []RegisterOption) error {
+	// options are applied before the metric is added, so one failing leaves it unpublished
+	for _, opt := range opts {
+		if err := opt(c, m); err != nil {
+			return err
+		}
+	}
+
+	return c.addMetrics(append([]Metric{m}, reg.attached...))
+}
+
+// addMetrics adds metrics to the registry, either all of them or none of them,
+// and records that they are registered with the client
+func (c *PCPClient) addMetrics(metrics []Metric) error {
+	err := c.addToRegistry(func() error {
+		if len(metrics) == 1 {
+			return c.r.add(metrics[0])
+		}
+
+		// the metrics added before one fails are removed again
+		s := c.r.snapshot()
+		for _, m := range metrics {
+			if err := c.r.add(m); err != nil {
+				c.r.restore(s)
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
+
+	for _, m := range metrics {
+		c.registered(m)
+
+		if lm, ok := m.(lazyMetric); ok {
+			c.addLazy(lm)
+		}
+	}
+
+	return nil
+}
+
+// registerAll registers metrics without options, either all of them or none of them,
+// for sets of metrics that only make sense together
+func (c *PCPClient) registerAll(metrics ...Metric) error {
+	for _, m := range metrics {
+		if err := c.checkMetric(m); err != nil {
+			return c.opError("register", m.Name(), err)
+		}
+	}
+
+	return c.opError("register", "", c.addMetrics(metrics))
+}
+
+// checkMetric returns an error if a metric cannot be registered with the client
+// whatever the registry holds, like for a name too long with the metric prefix
+func (c *PCPClient) checkMetric(m Metric) error {
+	if pm, ok := m.(PCPMetric); ok {
+		if err := validateDescriptions(pm.ShortDescription(), pm.LongDescription()); err != nil {
+			return err
+		}
+	}
+
+	return checkFullName(c.MetricPrefix(), m.Name())
 }
 
-// Register is simply a shorthand for Registry().AddMetric
-func (c *PCPClient) Register(m Metric) error { return c.r.AddMetric(m) }
+// checkRegistrable returns the error registering a metric named name would fail with,
+// for errors that can be found before its options are applied
+func (c *PCPClient) checkRegistrable(name string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.r.mapped && c.shared != nil {
+		return ErrClientStarted
+	}
+
+	if c.r.HasMetric(name) {
+		return errors.New("metric is already registered")
+	}
+
+	return nil
+}
+
+// registration holds what the options passed to Register did for a metric being registered
+type registration struct {
+	undo     []func() // undo the changes of the options to the client if the metric is not added
+	attached []Metric // added along with the metric, like its rate, see WithRate
+}
+
+// beginRegistration starts recording what the options of a metric do
+func (c *PCPClient) beginRegistration(m Metric) *registration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.registrations == nil {
+		c.registrations = make(map[Metric]*registration)
+	}
+
+	reg := new(registration)
+	c.registrations[m] = reg
+	return reg
+}
+
+// endRegistration stops recording what the options of a metric do, undoing
+// their changes to the client in reverse order if the metric was not added
+func (c *PCPClient) endRegistration(m Metric, reg *registration, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.registrations[m] == reg {
+		delete(c.registrations, m)
+	}
+
+	if err == nil {
+		return
+	}
+
+	for i := len(reg.undo) - 1; i >= 0; i-- {
+		reg.undo[i]()
+	}
+}
+
+// undoOnFailure records how to undo a change an option made to the client, for when
+// the metric it was passed with is not added. Options applied outside of Register have
+// nothing to undo. The mutex must be held, and is held while undoing.
+func (c *PCPClient) undoOnFailure(m Metric, undo func()) {
+	if reg := c.registrations[m]; reg != nil {
+		reg.undo = append(reg.undo, undo)
+	}
+}
+
+// attachMetric adds a metric along with the metric an option was passed with, so either
+// both are registered or neither is, returning false if the option was applied outside
+// of Register. The mutex must be held.
+func (c *PCPClient) attachMetric(m, attached Metric) bool {
+	reg := c.registrations[m]
+	if reg == nil {
+		return false
+	}
+
+	reg.attached = append(reg.attached, attached)
+	return true
+}
 
 // MustRegister is simply a Register that can panic
-func (c *PCPClient) MustRegister(m Metric) {
-	if err := c.Register(m); err != nil {
-		panic(err)
+func (c *PCPClient) MustRegister(m Metric, opts ...RegisterOption) {
+	must("register", m.Name(), c.name(), c.Register(m, opts...))
+}
+
+// RegisterCollector registers all metrics of the passed collector, which is then
+// run once on Start and at every publish interval until Stop, see SetPublishInterval.
+//
+// Collectors are named by their type, like speed.DiskCollector, followed by #n for
+// the nth collector of a type, which names them to DisableCollector and EnableCollector.
+func (c *PCPClient) RegisterCollector(col Collector) error {
+	for _, m := range col.Metrics() {
+		if err := c.Register(m); err != nil {
+			return err
+		}
 	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.collectors.add(c.collectors.collectorName(col), col)
+	return nil
+}
+
+// MustRegisterCollector is simply a RegisterCollector that can panic
+func (c *PCPClient) MustRegisterCollector(col Collector) {
+	must("register collector with", "", c.name(), c.RegisterCollector(col))
 }
 
 // RegisterIndom is simply a shorthand for Registry().AddInstanceDomain
 func (c *PCPClient) RegisterIndom(indom InstanceDomain) error {
-	return c.r.AddInstanceDomain(indom)
+	return c.opError("register", indom.Name(), c.addToRegistry(func() error { return c.r.addInstanceDomain(indom) }))
 }
 
 // MustRegisterIndom is simply a RegisterIndom that can panic
 func (c *PCPClient) MustRegisterIndom(indom InstanceDomain) {
-	if err := c.RegisterIndom(indom); err != nil {
-		panic(err)
-	}
+	must("register", indom.Name(), c.name(), c.RegisterIndom(indom))
 }
 
 // RegisterString is simply a shorthand for Registry().AddMetricByString
 func (c *PCPClient) RegisterString(str string, val interface{}, t MetricType, s MetricSemantics, u MetricUnit) (Metric, error) {
-	return c.r.AddMetricByString(str, val, t, s, u)
+	if name, _, _, err := parseString(str); err == nil {
+		if err = checkFullName(c.MetricPrefix(), name); err != nil {
+			return nil, c.opError("register", str, err)
+		}
+	}
+
+	var m Metric
+	err := c.addToRegistry(func() (err error) {
+		m, err = c.r.addMetricByString(str, val, t, s, u)
+		return err
+	})
+	if err != nil {
+		return nil, c.opError("register", str, err)
+	}
+
+	c.registered(m)
+	return m, nil
+}
+
+// addToRegistry adds metrics to the registry using add. If the client is started,
+// the registry is written to a new mapping including them, with a new generation,
+// so readers of the mapping pick them up like after a restart of the application.
+//
+// Shared mappings are laid out by all processes sharing them, so their layout
+// cannot change while they are started.
+func (c *PCPClient) addToRegistry(add func() error) error {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	return c.addToRegistryLocked(add)
+}
+
+// addToRegistryLocked is addToRegistry for callers holding the mutex
+func (c *PCPClient) addToRegistryLocked(add func() error) error {
+	if !c.r.mapped {
+		return add()
+	}
+
+	if c.shared != nil {
+		return ErrClientStarted
+	}
+
+	s := c.r.snapshot()
+	if err := add(); err != nil {
+		return err
+	}
+
+	// remap only fails before the new mapping replaces the old one, in which case
+	// the registry is left as it was, so the addition can be retried
+	if err := c.remap(); err != nil {
+		c.r.restore(s)
+		return err
+	}
+
+	return nil
+}
+
+// registered records the client a metric is registered with, which is named in its errors,
+// and sets the clock of metrics that read the time
+func (c *PCPClient) registered(m Metric) {
+	if pm, ok := m.(PCPMetric); ok {
+		if md := metricDesc(pm); md != nil {
+			md.client = c.name()
+		}
+	}
+
+	if cm, ok := m.(clocked); ok {
+		cm.setClock(c.getClock())
+	}
 }
 
 // MustRegisterString is simply a RegisterString that panics
 func (c *PCPClient) MustRegisterString(str string, val interface{}, t MetricType, s MetricSemantics, u MetricUnit) Metric {
-	if m, err := c.RegisterString(str, val, t, s, u); err != nil {
-		panic(err)
-	} else {
-		return m
-	}
+	m, err := c.RegisterString(str, val, t, s, u)
+	must("register", str, c.name(), err)
+	return m
 }
@@ -0,0 +1,179 @@
+package speed
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ClientConfig configures a client created by NewClientFromConfig,
+// and is read from a JSON file like
+//
+//	{
+//		"name": "app",
+//		"directory": "/var/tmp/mmv",
+//		"cluster_id": 42,
+//		"flags": ["process", "sentinel"],
+//		"collectors": ["uptime", "disk"],
+//		"disk_paths": ["/var/lib/app"],
+//		"disabled": ["disk"],
+//		"metadata": {"version": "1.4.2"},
+//		"publish_interval": "5s",
+//		"publish_jitter": 0.1
+//	}
+type ClientConfig struct {
+	// name of the client, metrics are published under mmv.<name>
+	Name string `json:"name"`
+
+	// directory to write the mmv file to, defaults to the mmv directory under PCP_TMP_DIR
+	Directory string `json:"directory,omitempty"`
+
+	// cluster identifier, defaults to a hash of the name
+	ClusterID *uint32 `json:"cluster_id,omitempty"`
+
+	// mmv flags, any of "noprefix", "process" and "sentinel", defaults to "process"
+	Flags []string `json:"flags,omitempty"`
+
+	// built in collectors to register, see BuiltinCollectors
+	Collectors []string `json:"collectors,omitempty"`
+
+	// paths published by the "disk" collector
+	DiskPaths []string `json:"disk_paths,omitempty"`
+
+	// endpoint republished by the "prometheus" collector, see PrometheusCollector
+	PrometheusURL string `json:"prometheus_url,omitempty"`
+
+	// metrics and namespaces to disable, see DisableMetrics.
+	// Unlike the other settings, these are also applied by ReloadConfig
+	Disabled []string `json:"disabled,omitempty"`
+
+	// values passed to description templates, see SetMetadata
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// interval of collectors and deferred writes as a duration string, see SetPublishInterval
+	PublishInterval string `json:"publish_interval,omitempty"`
+
+	// fraction by which publish intervals are randomly varied, see SetPublishJitter
+	PublishJitter float64 `json:"publish_jitter,omitempty"`
+}
+
+// configFlags maps the flag names used in a ClientConfig to MMVFlag values
+var configFlags = map[string]MMVFlag{
+	"noprefix": NoPrefixFlag,
+	"process":  ProcessFlag,
+	"sentinel": SentinelFlag,
+}
+
+// builtinCollectors maps the collector names used in a ClientConfig to functions
+// registering the collectors with a client. Collectors that are only available on
+// some platforms or go versions add themselves from the files defining them.
+var builtinCollectors = map[string]func(c *PCPClient, conf *ClientConfig) error{
+	"uptime": func(c *PCPClient, conf *ClientConfig) error { return RegisterUptime(c) },
+}
+
+// BuiltinCollectors returns the names of the collectors that can be enabled
+// from a ClientConfig on the current platform.
+func BuiltinCollectors() []string {
+	names := make([]string, 0, len(builtinCollectors))
+	for name := range builtinCollectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewClientFromConfig creates a client configured by the JSON file at path.
+// Keys that are not settings of a ClientConfig, like a misspelled "colectors",
+// fail the config, from go 1.10. Configs kept as YAML have to be converted to
+// JSON first, as the package does not depend on a YAML parser.
+func NewClientFromConfig(path string) (*PCPClient, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var conf ClientConfig
+	if err = decodeConfig(data, &conf); err != nil {
+		return nil, fmt.Errorf("cannot parse config %v: %v", path, err)
+	}
+
+	return NewClientWithConfig(&conf)
+}
+
+// NewClientWithConfig creates a client configured by the passed ClientConfig.
+func NewClientWithConfig(conf *ClientConfig) (*PCPClient, error) {
+	if conf.Name == "" {
+		return nil, errors.New("config does not have a client name")
+	}
+
+	c, err := NewPCPClient(conf.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Directory != "" {
+		c.loc = filepath.Join(conf.Directory, conf.Name)
+	}
+
+	if conf.ClusterID != nil {
+		if *conf.ClusterID >= 1<<PCPClusterIDBitLength {
+			return nil, fmt.Errorf("cluster id %v does not fit in %v bits", *conf.ClusterID, PCPClusterIDBitLength)
+		}
+		c.clusterID = *conf.ClusterID
+	}
+
+	if conf.PublishInterval != "" {
+		interval, err := time.ParseDuration(conf.PublishInterval)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse publish interval: %v", err)
+		}
+
+		if err = c.SetPublishInterval(interval); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = c.SetPublishJitter(conf.PublishJitter); err != nil {
+		return nil, err
+	}
+
+	for k, v := range conf.Metadata {
+		c.SetMetadata(k, v)
+	}
+
+	if conf.Flags != nil {
+		var flag MMVFlag
+		for _, name := range conf.Flags {
+			f, ok := configFlags[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown flag %v", name)
+			}
+			flag |= f
+		}
+
+		if err = c.SetFlag(flag); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range conf.Collectors {
+		register, ok := builtinCollectors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown collector %v, available collectors are %v", name, BuiltinCollectors())
+		}
+
+		if err = register(c, conf); err != nil {
+			return nil, fmt.Errorf("cannot register collector %v: %v", name, err)
+		}
+	}
+
+	if err = c.DisableMetrics(conf.Disabled...); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
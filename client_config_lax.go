@@ -0,0 +1,12 @@
+//go:build !go1.10
+// +build !go1.10
+
+package speed
+
+import "encoding/json"
+
+// decodeConfig parses a JSON config. Unknown keys can only be rejected from go 1.10,
+// so before that misspelled settings are ignored.
+func decodeConfig(data []byte, conf *ClientConfig) error {
+	return json.Unmarshal(data, conf)
+}
@@ -0,0 +1,17 @@
+//go:build go1.10
+// +build go1.10
+
+package speed
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// decodeConfig parses a JSON config, failing on keys that are not settings of a
+// ClientConfig, so a misspelled setting is reported instead of being ignored.
+func decodeConfig(data []byte, conf *ClientConfig) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	return d.Decode(conf)
+}
@@ -0,0 +1,38 @@
+//go:build go1.10
+// +build go1.10
+
+package speed
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigUnknownKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "speed")
+	if err != nil {
+		t.Fatalf("cannot create directory, error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "config.json")
+	if err = ioutil.WriteFile(path, []byte(`{"name": "typo", "colectors": ["uptime"]}`), 0644); err != nil {
+		t.Fatalf("cannot write config, error: %v", err)
+	}
+
+	if _, err = NewClientFromConfig(path); err == nil || !strings.Contains(err.Error(), "colectors") {
+		t.Errorf("expected a misspelled key to fail the config, got %v", err)
+	}
+
+	c, err := NewPCPClient("typo")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	if err = c.ReloadConfig(path); err == nil {
+		t.Error("expected a misspelled key to fail reloading the config")
+	}
+}
@@ -0,0 +1,84 @@
+package speed
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewClientFromConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "speed")
+	if err != nil {
+		t.Fatalf("cannot create directory, error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "config.json")
+	conf := `{
+		"name": "configured",
+		"directory": "` + dir + `",
+		"cluster_id": 42,
+		"flags": ["process", "sentinel"],
+		"collectors": ["uptime"],
+		"publish_interval": "5s",
+		"publish_jitter": 0.25
+	}`
+
+	if err = ioutil.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatalf("cannot write config, error: %v", err)
+	}
+
+	c, err := NewClientFromConfig(path)
+	if err != nil {
+		t.Fatalf("cannot create client from config, error: %v", err)
+	}
+
+	if c.loc != filepath.Join(dir, "configured") {
+		t.Errorf("expected the mmv file to be written to %v, got %v", filepath.Join(dir, "configured"), c.loc)
+	}
+
+	if c.clusterID != 42 {
+		t.Errorf("expected cluster id 42, got %v", c.clusterID)
+	}
+
+	if c.flag != ProcessFlag|SentinelFlag {
+		t.Errorf("expected flags %v, got %v", ProcessFlag|SentinelFlag, c.flag)
+	}
+
+	if c.publishInterval != 5*time.Second || c.publishJitter != 0.25 {
+		t.Errorf("expected a publish interval of 5s with jitter 0.25, got %v with %v", c.publishInterval, c.publishJitter)
+	}
+
+	if !c.r.HasMetric("process.uptime") {
+		t.Errorf("expected the uptime collector to be registered")
+	}
+
+	c.MustStart()
+	if _, err = os.Stat(c.loc); err != nil {
+		t.Errorf("expected the mmv file to be written, error: %v", err)
+	}
+	c.MustStop()
+
+	id := uint32(1 << PCPClusterIDBitLength)
+	failing := []ClientConfig{
+		{},
+		{Name: "a/b"},
+		{Name: "x", ClusterID: &id},
+		{Name: "x", Flags: []string{"unknown"}},
+		{Name: "x", Collectors: []string{"unknown"}},
+		{Name: "x", PublishInterval: "soon"},
+		{Name: "x", PublishJitter: 1},
+	}
+
+	for _, conf := range failing {
+		if _, err := NewClientWithConfig(&conf); err == nil {
+			t.Errorf("expected config %+v to fail", conf)
+		}
+	}
+
+	if _, err = NewClientFromConfig(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("expected a missing config file to fail")
+	}
+}
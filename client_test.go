@@ -1,9 +1,16 @@
 package speed
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -112,8 +119,12 @@ func TestMapping(t *testing.T) {
 	}
 
 	_, err = c.RegisterString("test.2", 2, Int32Type, CounterSemantics, OneUnit)
-	if err == nil {
-		t.Error("expected registration to fail when a mapping is active")
+	if err != nil {
+		t.Errorf("expected registration to remap an active mapping, error: %v", err)
+	}
+
+	if val, err := mmvdump.Lookup(c.writer.Bytes(), "test.2"); err != nil || val != int32(2) {
+		t.Errorf("expected test.2 to be 2 in the new mapping, got %v, error: %v", val, err)
 	}
 
 	EraseFileOnStop = true
@@ -235,10 +246,10 @@ func matchSingletonValue(m *pcpSingletonMetric, value *mmvdump.Value, metrics ma
 	}
 
 	if m.t == StringType {
-		matchString(m.val.(string), strings[uint64(value.Extra)], t)
+		matchString(m.value().(string), strings[uint64(value.Extra)], t)
 	} else {
-		if av, err := mmvdump.FixedVal(value.Val, mmvdump.Type(m.t)); err != nil || av != m.val {
-			t.Errorf("expected the value to be %v, got %v", m.val, av)
+		if av, err := mmvdump.FixedVal(value.Val, mmvdump.Type(m.t)); err != nil || av != m.value() {
+			t.Errorf("expected the value to be %v, got %v", m.value(), av)
 		}
 	}
 
@@ -772,16 +783,16 @@ func TestWritingDifferentTypes(t *testing.T) {
 
 	c.MustRegisterString("m.1", 2147483647, Int32Type, CounterSemantics, OneUnit)
 	c.MustRegisterString("m.2", 2147483647, Int64Type, CounterSemantics, OneUnit)
-	c.MustRegisterString("m.3", 4294967295, Uint32Type, CounterSemantics, OneUnit)
-	c.MustRegisterString("m.4", 4294967295, Uint64Type, CounterSemantics, OneUnit)
+	c.MustRegisterString("m.3", 2147483647, Uint32Type, CounterSemantics, OneUnit)
+	c.MustRegisterString("m.4", 2147483647, Uint64Type, CounterSemantics, OneUnit)
 	c.MustRegisterString("m.5", 3.14, FloatType, CounterSemantics, OneUnit)
 	c.MustRegisterString("m.6", 6.28, DoubleType, CounterSemantics, OneUnit)
 	c.MustRegisterString("m.7", "luke", StringType, CounterSemantics, OneUnit)
 
 	c.MustRegisterString("m[a, b].8", Instances{"a": 2147483647, "b": -2147483648}, Int32Type, CounterSemantics, OneUnit)
 	c.MustRegisterString("m[a, b].9", Instances{"a": 2147483647, "b": -2147483648}, Int64Type, CounterSemantics, OneUnit)
-	c.MustRegisterString("m[a, b].10", Instances{"a": 4294967295, "b": 0}, Uint32Type, CounterSemantics, OneUnit)
-	c.MustRegisterString("m[a, b].11", Instances{"a": 4294967295, "b": 0}, Uint64Type, CounterSemantics, OneUnit)
+	c.MustRegisterString("m[a, b].10", Instances{"a": 2147483647, "b": 0}, Uint32Type, CounterSemantics, OneUnit)
+	c.MustRegisterString("m[a, b].11", Instances{"a": 2147483647, "b": 0}, Uint64Type, CounterSemantics, OneUnit)
 	c.MustRegisterString("m[a, b].12", Instances{"a": 3.14, "b": -3.14}, FloatType, CounterSemantics, OneUnit)
 	c.MustRegisterString("m[a, b].13", Instances{"a": 6.28, "b": -6.28}, DoubleType, CounterSemantics, OneUnit)
 	c.MustRegisterString("m[a, b].14", Instances{"a": "luke", "b": "skywalker"}, StringType, CounterSemantics, OneUnit)
@@ -1088,6 +1099,194 @@ func TestCounterVector(t *testing.T) {
 	} else if err != nil {
 		t.Errorf("cannot retrieve m.1[m1] value, error: %v", err)
 	}
+
+	// SetAll
+
+	cv.SetAll(20)
+
+	for _, ins := range []string{"m1", "m2"} {
+		if v, err := mmvdump.Lookup(c.writer.Bytes(), "m.1["+ins+"]"); err != nil || v != int64(20) {
+			t.Errorf("expected m.1[%v] to be written as 20, got %v, error: %v", ins, v, err)
+		}
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected setting all instances to a lesser value to panic")
+			}
+		}()
+		cv.SetAll(5)
+	}()
+}
+
+func TestInstanceMetricFill(t *testing.T) {
+	indom, err := NewPCPInstanceDomain("buckets", []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("cannot create instance domain, error: %v", err)
+	}
+
+	m, err := NewPCPInstanceMetricWithDefault(7, "bucket.count", indom, Int32Type, CounterSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	for _, ins := range indom.Instances() {
+		if v, err := m.ValInstance(ins); err != nil || v != int32(7) {
+			t.Errorf("expected %v to default to 7, got %v, error: %v", ins, v, err)
+		}
+	}
+
+	if _, err = NewPCPInstanceMetricWithDefault("seven", "bucket.bad", indom, Int32Type, CounterSemantics, OneUnit); err == nil {
+		t.Errorf("expected an error creating a metric with an incompatible default")
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	c.MustRegister(m)
+
+	c.MustStart()
+	defer c.MustStop()
+
+	m.MustSetInstance(3, "b")
+	m.MustFill(0)
+
+	for _, ins := range indom.Instances() {
+		if v, err := mmvdump.Lookup(c.writer.Bytes(), "bucket.count["+ins+"]"); err != nil || v != int32(0) {
+			t.Errorf("expected bucket.count[%v] to be written as 0, got %v, error: %v", ins, v, err)
+		}
+	}
+
+	if err = m.Fill("zero"); err == nil {
+		t.Errorf("expected an error filling with an incompatible value")
+	}
+}
+
+func TestVectorDelete(t *testing.T) {
+	cv, err := NewPCPCounterVector(map[string]int64{"a": 1, "b": 2, "c": 3}, "conns")
+	if err != nil {
+		t.Fatalf("cannot create CounterVector, error: %v", err)
+	}
+
+	g, err := NewPCPGaugeVector(map[string]float64{"x": 1, "y": 2}, "load")
+	if err != nil {
+		t.Fatalf("cannot create GaugeVector, error: %v", err)
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	c.MustRegister(cv)
+	c.MustRegister(g)
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if err = cv.Delete("d"); err == nil {
+		t.Errorf("expected an error deleting an unknown instance")
+	}
+
+	if err = cv.Delete("b"); err != nil {
+		t.Fatalf("cannot delete instance, error: %v", err)
+	}
+
+	if err = g.Delete("y"); err != nil {
+		t.Fatalf("cannot delete instance, error: %v", err)
+	}
+
+	if err = cv.Inc(1, "b"); err == nil {
+		t.Errorf("expected an error updating a deleted instance")
+	}
+
+	if _, err = cv.Val("b"); err == nil {
+		t.Errorf("expected an error getting the value of a deleted instance")
+	}
+
+	cv.UpAll()
+	g.IncAll(1)
+
+	if ins := cv.Instances(); len(ins) != 2 {
+		t.Errorf("expected 2 live instances, got %v", ins)
+	}
+
+	// the tombstoned value stays in the mapping, but is no longer updated
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "conns[b]"); err != nil || v != int64(2) {
+		t.Errorf("expected conns[b] to stay 2 until compaction, got %v, error: %v", v, err)
+	}
+
+	values := c.r.ValuesCount()
+
+	if err = c.Compact(); err != nil {
+		t.Fatalf("cannot compact, error: %v", err)
+	}
+
+	if c.r.ValuesCount() != values-2 || c.r.InstanceCount() != 3 {
+		t.Errorf("expected 3 instances and %v values after compaction, got %v and %v", values-2, c.r.InstanceCount(), c.r.ValuesCount())
+	}
+
+	if _, err = mmvdump.Lookup(c.writer.Bytes(), "conns[b]"); err == nil {
+		t.Errorf("expected conns[b] to be removed from the mapping")
+	}
+
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "conns[c]"); err != nil || v != int64(4) {
+		t.Errorf("expected conns[c] to be 4, got %v, error: %v", v, err)
+	}
+
+	cv.Up("c")
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "conns[c]"); err != nil || v != int64(5) {
+		t.Errorf("expected conns[c] to be updated in the new mapping to 5, got %v, error: %v", v, err)
+	}
+
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "load[x]"); err != nil || v != float64(2) {
+		t.Errorf("expected load[x] to be 2, got %v, error: %v", v, err)
+	}
+}
+
+func TestCompactSharedInstanceDomain(t *testing.T) {
+	indom, err := NewPCPInstanceDomain("tenants", []string{"t1", "t2"})
+	if err != nil {
+		t.Fatalf("cannot create instance domain, error: %v", err)
+	}
+
+	a, err := NewPCPInstanceMetricWithDefault(0, "a", indom, Int64Type, CounterSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	b, err := NewPCPInstanceMetricWithDefault(0, "b", indom, Int64Type, CounterSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	c.MustRegister(a)
+	c.MustRegister(b)
+
+	a.mutex.Lock()
+	_ = a.deleteInstance("t1")
+	a.mutex.Unlock()
+
+	if n := c.r.reconcile(); n != 0 {
+		t.Errorf("expected an instance deleted from only one metric over it to be kept, removed %v", n)
+	}
+
+	b.mutex.Lock()
+	_ = b.deleteInstance("t1")
+	b.mutex.Unlock()
+
+	if n := c.r.reconcile(); n != 1 {
+		t.Errorf("expected the instance deleted from all metrics over it to be removed, removed %v", n)
+	}
+
+	if indom.HasInstance("t1") || indom.InstanceCount() != 1 {
+		t.Errorf("expected t1 to be removed from the instance domain, got %v", indom.Instances())
+	}
 }
 
 func TestGaugeVector(t *testing.T) {
@@ -1214,3 +1413,854 @@ func TestHistogram(t *testing.T) {
 		}
 	}
 }
+
+func TestHistogramSnapshot(t *testing.T) {
+	h, err := NewPCPHistogram("test.hist", 0, 1000, 3, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	for i := int64(1); i <= 100; i++ {
+		h.MustRecord(i)
+	}
+
+	s := h.Snapshot()
+	if s.Count != 100 || s.Min != 1 || s.Max != 100 || s.Mean != h.Mean() || s.StandardDeviation != h.StandardDeviation() {
+		t.Errorf("unexpected snapshot %+v", s)
+	}
+
+	if p := s.Percentile(50); p != h.Percentile(50) {
+		t.Errorf("expected the median of the snapshot to be %v, got %v", h.Percentile(50), p)
+	}
+
+	h.MustRecordN(1000, 100)
+
+	if s.Count != 100 || s.Percentile(99) != 99 || s.Buckets()[len(s.Buckets())-1].To > 101 {
+		t.Errorf("expected the snapshot not to change with new values, got a 99th percentile of %v", s.Percentile(99))
+	}
+
+	// every snapshot of a histogram recording only pairs of 0 and 1000 is balanced
+	h2, err := NewPCPHistogram("test.pairs", 0, 1000, 3, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			h2.MustRecordN(0, 2)
+			h2.MustRecordN(1000, 2)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		s := h2.Snapshot()
+		if s.Count%4 != 0 && s.Count%4 != 2 {
+			t.Fatalf("expected whole pairs in a snapshot, got a count of %v", s.Count)
+		}
+
+		if s.Count%4 == 0 && s.Count > 0 && s.Percentile(50) != 0 {
+			t.Fatalf("expected the median of balanced pairs to be 0, got %v", s.Percentile(50))
+		}
+	}
+
+	<-done
+}
+
+func TestTimerSnapshot(t *testing.T) {
+	timer, err := NewPCPTimer("test.timer", MillisecondUnit)
+	if err != nil {
+		t.Fatalf("cannot create timer, error: %v", err)
+	}
+
+	clock := NewManualClock(time.Unix(100, 0))
+	timer.setClock(clock)
+
+	if s := timer.Snapshot(); s.Running || s.Elapsed != 0 {
+		t.Errorf("expected a stopped timer with nothing elapsed, got %+v", s)
+	}
+
+	timer.Start()
+	clock.Advance(20 * time.Millisecond)
+
+	if s := timer.Snapshot(); !s.Running || !s.Since.Equal(time.Unix(100, 0)) || s.Elapsed != 0 {
+		t.Errorf("expected a running timer started at 100s, got %+v", s)
+	}
+
+	timer.Stop()
+
+	if s := timer.Snapshot(); s.Running || s.Elapsed != 20 || !s.Since.IsZero() {
+		t.Errorf("expected a stopped timer with 20ms elapsed, got %+v", s)
+	}
+
+	if err = timer.Start(); err != nil {
+		t.Errorf("expected a stopped timer to start again, error: %v", err)
+	}
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	m, err := NewPCPCounter(0, "hooked")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+	c.MustRegister(m)
+
+	var calls []string
+
+	c.OnStart(func() {
+		calls = append(calls, "start1")
+		m.Up()
+		matchSingleDump(int64(1), m, c, t)
+	})
+	c.OnStart(func() { calls = append(calls, "start2") })
+	c.OnStop(func() {
+		calls = append(calls, "stop")
+		m.Up()
+		matchSingleDump(int64(2), m, c, t)
+	})
+
+	c.MustStart()
+	c.MustStop()
+
+	if err = c.Stop(); err != nil {
+		t.Errorf("expected stopping a stopped client to do nothing, got %v", err)
+	}
+
+	expected := []string{"start1", "start2", "stop"}
+	if len(calls) != len(expected) {
+		t.Fatalf("expected calls %v, got %v", expected, calls)
+	}
+
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Errorf("expected call %v to be %v, got %v", i, expected[i], calls[i])
+		}
+	}
+}
+
+func TestUpdatesBeforeStart(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	m, err := NewPCPCounter(0, "buffered.counter")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+	c.MustRegister(m)
+
+	cv, err := NewPCPCounterVector(map[string]int64{"a": 0, "b": 0}, "buffered.vector")
+	if err != nil {
+		t.Fatalf("cannot create counter vector, error: %v", err)
+	}
+	c.MustRegister(cv)
+
+	m.MustInc(10)
+	cv.MustInc(5, "a")
+
+	c.MustStart()
+	matchSingleDump(int64(10), m, c, t)
+
+	_, _, metrics, values, instances, _, strings, err := mmvdump.Dump(c.writer.Bytes())
+	if err != nil {
+		t.Fatalf("cannot get dump, error: %v", err)
+	}
+	matchMetricsAndValues(metrics, values, instances, strings, c, t)
+
+	c.MustStop()
+
+	// updates made while stopped are written on the next Start
+	m.MustInc(10)
+	cv.MustInc(5, "b")
+
+	c.MustStart()
+	defer c.MustStop()
+
+	matchSingleDump(int64(20), m, c, t)
+
+	_, _, metrics, values, instances, _, strings, err = mmvdump.Dump(c.writer.Bytes())
+	if err != nil {
+		t.Fatalf("cannot get dump, error: %v", err)
+	}
+	matchMetricsAndValues(metrics, values, instances, strings, c, t)
+}
+
+func TestFrozenAfterStart(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	c.MustRegisterString("frozen.existing[a, b]", Instances{"a": 1, "b": 2}, Int32Type, InstantSemantics, OneUnit)
+
+	c.MustStart()
+	defer c.MustStop()
+
+	counter, err := NewPCPCounter(0, "frozen.counter")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	indom, err := NewPCPInstanceDomain("frozen.indom", []string{"x"})
+	if err != nil {
+		t.Fatalf("cannot create indom, error: %v", err)
+	}
+
+	cases := []struct {
+		op string
+		f  func() error
+	}{
+		{"AddMetric", func() error { return c.Registry().AddMetric(counter) }},
+		{"AddInstanceDomain", func() error { return c.Registry().AddInstanceDomain(indom) }},
+		{"AddMetricByString", func() error {
+			_, err := c.Registry().AddMetricByString("frozen.singleton", 1, Int32Type, InstantSemantics, OneUnit)
+			return err
+		}},
+		{"AddInstanceDomainByName", func() error {
+			_, err := c.Registry().AddInstanceDomainByName("frozen.other", []string{"y"})
+			return err
+		}},
+		{"SetFlag", func() error { return c.SetFlag(NoPrefixFlag) }},
+	}
+
+	for _, cs := range cases {
+		if err := cs.f(); err != ErrClientStarted {
+			t.Errorf("expected %v to fail with ErrClientStarted, got %v", cs.op, err)
+		}
+	}
+
+	if c.Registry().MetricCount() != 1 || c.Registry().InstanceDomainCount() != 1 {
+		t.Errorf("expected the registry to not change after Start")
+	}
+}
+
+func TestRegisterAfterStart(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	existing := c.MustRegisterString("late.existing[a, b]", Instances{"a": 1, "b": 2}, Int32Type, InstantSemantics, OneUnit).(*PCPInstanceMetric)
+
+	c.MustStart()
+	defer c.MustStop()
+
+	h, _, _, _, _, _, _, err := mmvdump.Dump(c.writer.Bytes())
+	if err != nil {
+		t.Fatalf("cannot get dump, error: %v", err)
+	}
+	gen := h.G1
+
+	counter, err := NewPCPCounter(0, "late.counter")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	indom, err := NewPCPInstanceDomain("late.indom", []string{"x"})
+	if err != nil {
+		t.Fatalf("cannot create indom, error: %v", err)
+	}
+
+	uptime, err := NewUptimeCollector()
+	if err != nil {
+		t.Fatalf("cannot create collector, error: %v", err)
+	}
+
+	cases := []struct {
+		op string
+		f  func() error
+	}{
+		{"Register", func() error { return c.Register(counter) }},
+		{"RegisterIndom", func() error { return c.RegisterIndom(indom) }},
+		{"RegisterCollector", func() error { return c.RegisterCollector(uptime) }},
+		{"RegisterString", func() error {
+			_, err := c.RegisterString("late.singleton", 1, Int32Type, InstantSemantics, OneUnit)
+			return err
+		}},
+		{"RegisterString with instances", func() error {
+			_, err := c.RegisterString("late.existing[a, b].other", Instances{"a": 3, "b": 4}, Int32Type, InstantSemantics, OneUnit)
+			return err
+		}},
+	}
+
+	for _, cs := range cases {
+		if err := cs.f(); err != nil {
+			t.Errorf("expected %v to succeed after Start, got %v", cs.op, err)
+		}
+	}
+
+	if err := c.Register(counter); err == nil {
+		t.Errorf("expected registering a metric twice to fail after Start")
+	}
+
+	counter.MustInc(5)
+	existing.MustSetInstance(10, "b")
+
+	h, _, _, _, _, _, _, err = mmvdump.Dump(c.writer.Bytes())
+	if err != nil {
+		t.Fatalf("cannot get dump, error: %v", err)
+	}
+
+	if h.G1 <= gen || h.G1 != h.G2 {
+		t.Errorf("expected the new mapping to have a new generation after %v, got G1 %v and G2 %v", gen, h.G1, h.G2)
+	}
+
+	expected := map[string]interface{}{
+		"late.counter":           int64(5),
+		"late.singleton":         int32(1),
+		"late.existing[b]":       int32(10),
+		"late.existing.other[a]": int32(3),
+	}
+	for name, want := range expected {
+		if val, err := mmvdump.Lookup(c.writer.Bytes(), name); err != nil || val != want {
+			t.Errorf("expected %v to be %v, got %v, error: %v", name, want, val, err)
+		}
+	}
+
+	if c.Stats().Remaps < uint64(len(cases)) {
+		t.Errorf("expected a remap for every registration, got %v", c.Stats().Remaps)
+	}
+}
+
+func TestRemapReplacesMapping(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "speed")
+	if err != nil {
+		t.Fatalf("cannot create directory, error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	c.loc = filepath.Join(dir, "mmv", "test")
+	c.MustRegisterString("remap.counter", int64(1), Int64Type, CounterSemantics, OneUnit)
+
+	c.MustStart()
+	defer c.MustStop()
+
+	// a reader holding the old mapping open
+	old, err := os.Open(c.loc)
+	if err != nil {
+		t.Fatalf("cannot open mapping, error: %v", err)
+	}
+	defer func() { _ = old.Close() }()
+
+	// the directory holding the mapping is replaced by a regular file, so remapping fails
+	mmv := filepath.Dir(c.loc)
+	if err = os.Rename(mmv, mmv+".moved"); err != nil {
+		t.Fatalf("cannot move directory, error: %v", err)
+	}
+	if err = ioutil.WriteFile(mmv, nil, 0644); err != nil {
+		t.Fatalf("cannot create file, error: %v", err)
+	}
+
+	if _, err = c.RegisterString("remap.late", int64(2), Int64Type, CounterSemantics, OneUnit); err == nil {
+		t.Fatalf("expected registering to fail when the registry cannot be remapped")
+	}
+
+	if c.r.HasMetric("remap.late") {
+		t.Errorf("expected a metric that could not be mapped to be unregistered")
+	}
+
+	if err = os.Remove(mmv); err != nil {
+		t.Fatalf("cannot remove file, error: %v", err)
+	}
+	if err = os.Rename(mmv+".moved", mmv); err != nil {
+		t.Fatalf("cannot move directory back, error: %v", err)
+	}
+
+	// retrying succeeds once the registry can be remapped
+	if _, err = c.RegisterString("remap.late", int64(2), Int64Type, CounterSemantics, OneUnit); err != nil {
+		t.Fatalf("expected registering to be retried, got %v", err)
+	}
+
+	data, err := ioutil.ReadFile(c.loc)
+	if err != nil {
+		t.Fatalf("cannot read mapping, error: %v", err)
+	}
+
+	if val, err := mmvdump.Lookup(data, "remap.late"); err != nil || val != int64(2) {
+		t.Errorf("expected the new mapping at %v to hold remap.late, got %v, error: %v", c.loc, val, err)
+	}
+
+	// the old mapping no longer has a valid generation, so readers reopen the file
+	b := make([]byte, HeaderLength)
+	if _, err = old.ReadAt(b, 0); err != nil {
+		t.Fatalf("cannot read old mapping, error: %v", err)
+	}
+
+	if g1, g2 := binary.LittleEndian.Uint64(b[8:]), binary.LittleEndian.Uint64(b[g2Offset:]); g1 == 0 || g2 != 0 {
+		t.Errorf("expected the old mapping to be invalidated, got G1 %v and G2 %v", g1, g2)
+	}
+
+	files, err := ioutil.ReadDir(mmv)
+	if err != nil {
+		t.Fatalf("cannot read directory, error: %v", err)
+	}
+
+	if len(files) != 1 || files[0].Name() != "test" {
+		t.Errorf("expected only the mapping to be left in %v, got %v", mmv, files)
+	}
+}
+
+func TestInstanceDomains(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	c.MustRegisterString("b.m[x, y]", Instances{"x": 1, "y": 2}, Int32Type, InstantSemantics, OneUnit)
+	c.MustRegisterString("a.m[x, y]", Instances{"x": 1, "y": 2}, Int32Type, InstantSemantics, OneUnit)
+	c.MustRegisterString("s", 1, Int32Type, InstantSemantics, OneUnit)
+
+	empty, err := NewPCPInstanceDomain("empty", []string{"p", "q"})
+	if err != nil {
+		t.Fatalf("cannot create indom, error: %v", err)
+	}
+	c.MustRegisterIndom(empty)
+
+	indoms := c.InstanceDomains()
+	if len(indoms) != 3 {
+		t.Fatalf("expected 3 instance domains, got %v", len(indoms))
+	}
+
+	if indoms[0].Name() != "a.m" || indoms[1].Name() != "b.m" || indoms[2].Name() != "empty" {
+		t.Errorf("expected instance domains to be ordered by name, got %v, %v, %v", indoms[0], indoms[1], indoms[2])
+	}
+
+	for _, indom := range indoms[:2] {
+		if len(indom.Metrics) != 1 || indom.Metrics[0].Indom() != indom.InstanceDomain {
+			t.Errorf("expected indom %v to be referenced by a single metric, got %v", indom.Name(), indom.Metrics)
+		}
+	}
+
+	if len(indoms[2].Metrics) != 0 {
+		t.Errorf("expected indom empty to not be referenced by any metrics, got %v", indoms[2].Metrics)
+	}
+}
+
+func TestSetInstanceDomainDescription(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	m := c.MustRegisterString("discovered[a, b]", Instances{"a": 1, "b": 2}, Int32Type, InstantSemantics, OneUnit).(*PCPInstanceMetric)
+
+	if err = c.SetInstanceDomainDescription("discovered", "1", "2", "3"); err == nil {
+		t.Errorf("expected more than 2 description strings to fail")
+	}
+
+	if err = c.SetInstanceDomainDescription("undiscovered", "short"); err == nil {
+		t.Errorf("expected updating an unregistered instance domain to fail")
+	}
+
+	if err = c.SetInstanceDomainDescription("discovered", "short"); err != nil {
+		t.Fatalf("cannot update description before start, error: %v", err)
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if err = c.r.SetInstanceDomainDescription("discovered", "short"); err != ErrClientStarted {
+		t.Errorf("expected updating description in the registry after start to fail with ErrClientStarted, got %v", err)
+	}
+
+	matchIndomDescription := func(short, long string) {
+		_, _, _, _, _, indoms, strings, err := mmvdump.Dump(c.writer.Bytes())
+		if err != nil {
+			t.Fatalf("cannot get dump: %v", err)
+		}
+
+		if len(indoms) != 1 {
+			t.Fatalf("expected a single instance domain, got %v", len(indoms))
+		}
+
+		for _, indom := range indoms {
+			for _, d := range []struct {
+				off uint64
+				s   string
+			}{{indom.Shorttext, short}, {indom.Longtext, long}} {
+				if d.s == "" {
+					if d.off != 0 {
+						t.Errorf("expected no description to be written, got one at %v", d.off)
+					}
+					continue
+				}
+
+				if str, ok := strings[d.off]; !ok {
+					t.Errorf("expected description %v to be written", d.s)
+				} else if v := string(str.Payload[:len(d.s)]); v != d.s {
+					t.Errorf("expected description %v, got %v", d.s, v)
+				}
+			}
+		}
+	}
+
+	matchIndomDescription("short", "")
+
+	if err = c.SetInstanceDomainDescription("discovered", "updated short", "updated long"); err != nil {
+		t.Fatalf("cannot update description after start, error: %v", err)
+	}
+
+	matchIndomDescription("updated short", "updated long")
+
+	// metrics must be writing to the new mapping
+	m.MustSetInstance(10, "a")
+
+	_, _, metrics, values, instances, _, strings, err := mmvdump.Dump(c.writer.Bytes())
+	if err != nil {
+		t.Fatalf("cannot get dump: %v", err)
+	}
+	matchMetricsAndValues(metrics, values, instances, strings, c, t)
+}
+
+func TestLookupVersion2(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	name := fmt.Sprintf("%0*d.metric", MaxV1NameLength, 0)
+	m := c.MustRegisterString(name+"[x, y]", Instances{"x": 1, "y": 2}, Int32Type, InstantSemantics, OneUnit).(*PCPInstanceMetric)
+	c.MustRegisterString("s", "hello", StringType, InstantSemantics, OneUnit)
+
+	c.MustStart()
+	defer c.MustStop()
+
+	m.MustSetInstance(10, "y")
+
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), name+"[y]"); err != nil || v != int32(10) {
+		t.Errorf("expected %v[y] to be 10, got %v, error: %v", name, v, err)
+	}
+
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "mmv.test.s"); err != nil || v != "hello" {
+		t.Errorf("expected mmv.test.s to be hello, got %v, error: %v", v, err)
+	}
+}
+
+func TestInMemoryFallback(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	// a mapping cannot be created under a regular file
+	f, err := ioutil.TempFile("", "speed")
+	if err != nil {
+		t.Fatalf("cannot create file, error: %v", err)
+	}
+	_ = f.Close()
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	c.loc = f.Name() + "/mmv/test"
+
+	m, err := NewPCPCounter(0, "fallback.counter")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+	c.MustRegister(m)
+
+	var handled []error
+	c.SetErrorHandler(func(err error) { handled = append(handled, err) })
+
+	if err = c.Start(); err == nil {
+		t.Fatalf("expected start to fail without the in-memory fallback")
+	} else if _, ok := err.(*MappingError); !ok {
+		t.Errorf("expected a MappingError, got %T", err)
+	}
+
+	if len(handled) != 1 || handled[0] != err {
+		t.Errorf("expected the error to be passed to the error handler, got %v", handled)
+	}
+
+	c.SetInMemoryFallback(true)
+
+	if err = c.Start(); err != nil {
+		t.Fatalf("expected start to succeed with the in-memory fallback, got %v", err)
+	}
+
+	if !c.InMemory() {
+		t.Errorf("expected the client to be writing to memory")
+	}
+
+	if len(handled) != 2 {
+		t.Errorf("expected the error to be passed to the error handler again, got %v", handled)
+	}
+
+	if err = m.Set(10); err != nil {
+		t.Errorf("cannot set counter, error: %v", err)
+	}
+
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "fallback.counter"); err != nil || v != int64(10) {
+		t.Errorf("expected the counter to be written to memory as 10, got %v, error: %v", v, err)
+	}
+
+	if err = c.Stop(); err != nil {
+		t.Errorf("cannot stop client, error: %v", err)
+	}
+
+	if c.InMemory() {
+		t.Errorf("expected InMemory to be false after stop")
+	}
+}
+
+func TestErrorHandlerUsingClient(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	// a mapping cannot be created under a regular file
+	f, err := ioutil.TempFile("", "speed")
+	if err != nil {
+		t.Fatalf("cannot create file, error: %v", err)
+	}
+	_ = f.Close()
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	c.loc = f.Name() + "/mmv/test"
+	c.SetInMemoryFallback(true)
+	c.MustRegisterString("handler.counter", int64(0), Int64Type, CounterSemantics, OneUnit)
+
+	var inMemory []bool
+	c.SetErrorHandler(func(err error) { inMemory = append(inMemory, c.InMemory(), c.Status().InMemory) })
+
+	done := make(chan error)
+	go func() {
+		err := c.Start()
+		if err == nil {
+			// remapping the started client passes the error to the handler again
+			_, err = c.RegisterString("handler.other", int64(0), Int64Type, CounterSemantics, OneUnit)
+		}
+		done <- err
+	}()
+
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an error handler calling the client to not deadlock")
+	}
+
+	if err != nil {
+		t.Fatalf("expected start to succeed with the in-memory fallback, got %v", err)
+	}
+	defer c.MustStop()
+
+	if len(inMemory) != 4 || !inMemory[2] || !inMemory[3] {
+		t.Errorf("expected the handler to be called twice and see the client in memory after start, got %v", inMemory)
+	}
+}
+
+func TestMemfd(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("memfd mappings are only supported on Linux")
+	}
+
+	c, err := NewPCPClient("memfd")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	if err = c.SetMemfd(true); err != nil {
+		t.Fatalf("cannot set memfd, error: %v", err)
+	}
+
+	counter := newTestCounter(t, c, "requests")
+
+	if err = c.Start(); err != nil {
+		t.Skipf("cannot create an anonymous mapping, error: %v", err)
+	}
+	defer c.MustStop()
+
+	if err = c.SetMemfd(false); err != ErrClientStarted {
+		t.Errorf("expected SetMemfd to fail on a started client, got %v", err)
+	}
+
+	if _, err = os.Stat(c.loc); err == nil {
+		t.Errorf("expected no file to be created at %v", c.loc)
+	}
+
+	counter.MustInc(42)
+
+	mappings, err := mmvdump.ProcMappings(os.Getpid())
+	if err != nil {
+		t.Fatalf("cannot find anonymous mappings, error: %v", err)
+	}
+
+	if mappings["memfd"] != c.Location() {
+		t.Errorf("expected the anonymous mapping to be found at %v, got %v", c.Location(), mappings)
+	}
+
+	data, err := ioutil.ReadFile(c.Location())
+	if err != nil {
+		t.Fatalf("cannot read %v, error: %v", c.Location(), err)
+	}
+
+	if v, err := mmvdump.Lookup(data, "requests"); err != nil || v != int64(42) {
+		t.Errorf("expected the anonymous mapping to hold 42, got %v, error: %v", v, err)
+	}
+}
+
+func TestEncodeTo(t *testing.T) {
+	c, err := NewPCPClient("encode")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	counter := newTestCounter(t, c, "requests")
+	_, err = c.RegisterString("status[a, b]", Instances{"a": "up", "b": "down"}, StringType, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot register metric, error: %v", err)
+	}
+
+	counter.MustInc(42)
+
+	// remove a mapping left behind by an earlier run
+	_ = os.Remove(c.loc)
+
+	var b1, b2 bytes.Buffer
+	if err = c.EncodeTo(&b1); err != nil {
+		t.Fatalf("cannot encode client, error: %v", err)
+	}
+
+	if _, err = os.Stat(c.loc); err == nil {
+		t.Errorf("expected EncodeTo to not create %v", c.loc)
+	}
+
+	if err = c.EncodeTo(&b2); err != nil {
+		t.Fatalf("cannot encode client, error: %v", err)
+	}
+
+	if !bytes.Equal(b1.Bytes(), b2.Bytes()) {
+		t.Errorf("expected encoding the same registry twice to give the same bytes")
+	}
+
+	h, _, _, _, _, _, _, err := mmvdump.Dump(b1.Bytes())
+	if err != nil {
+		t.Fatalf("cannot dump encoded mapping, error: %v", err)
+	}
+
+	if h.G1 != 0 || h.G2 != 0 || h.Process != 0 {
+		t.Errorf("expected the generations and process to be 0, got %v, %v and %v", h.G1, h.G2, h.Process)
+	}
+
+	if v, err := mmvdump.Lookup(b1.Bytes(), "requests"); err != nil || v != int64(42) {
+		t.Errorf("expected the encoded counter to be 42, got %v, error: %v", v, err)
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	// the mapping of a started client only differs in the generations and the process
+	mapped := append([]byte(nil), c.writer.Bytes()...)
+	for i := 8; i < 24; i++ {
+		mapped[i] = 0
+	}
+	for i := 32; i < 36; i++ {
+		mapped[i] = 0
+	}
+
+	var b3 bytes.Buffer
+	if err = c.EncodeTo(&b3); err != nil {
+		t.Fatalf("cannot encode started client, error: %v", err)
+	}
+
+	if !bytes.Equal(mapped, b3.Bytes()) {
+		t.Errorf("expected the encoding of a started client to match its mapping")
+	}
+
+	// metrics stay bound to the active mapping
+	counter.MustInc(1)
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "requests"); err != nil || v != int64(43) {
+		t.Errorf("expected the mapped counter to be 43 after encoding, got %v, error: %v", v, err)
+	}
+}
+
+func TestStartStopIdempotent(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	newTestCounter(t, c, "requests")
+
+	if err = c.Stop(); err != nil {
+		t.Errorf("expected stopping a client that was never started to do nothing, got %v", err)
+	}
+
+	c.MustStart()
+	writer := c.writer
+
+	if err = c.Start(); err != ErrAlreadyStarted {
+		t.Errorf("expected ErrAlreadyStarted, got %v", err)
+	}
+
+	if c.writer != writer {
+		t.Errorf("expected starting a started client to keep its mapping")
+	}
+
+	c.MustStop()
+	c.MustStop()
+
+	c.MustStart()
+	c.MustStop()
+}
+
+func TestStartStopConcurrent(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	newTestCounter(t, c, "requests")
+
+	var starts, stops int32
+	c.OnStart(func() { atomic.AddInt32(&starts, 1) })
+	c.OnStop(func() { atomic.AddInt32(&stops, 1) })
+
+	const n = 8
+
+	run := func(f func() error) int32 {
+		var (
+			wg        sync.WaitGroup
+			succeeded int32
+		)
+
+		wg.Add(n)
+		for i := 0; i < n; i++ {
+			go func() {
+				defer wg.Done()
+				if f() == nil {
+					atomic.AddInt32(&succeeded, 1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		return succeeded
+	}
+
+	if s := run(c.Start); s != 1 {
+		t.Errorf("expected exactly one concurrent Start to succeed, %v did", s)
+	}
+
+	if s := run(c.Stop); s != n {
+		t.Errorf("expected all concurrent Stops to succeed, %v did", s)
+	}
+
+	if starts != 1 || stops != 1 {
+		t.Errorf("expected hooks to be called once, got %v OnStart and %v OnStop calls", starts, stops)
+	}
+
+	if c.writer != nil {
+		t.Errorf("expected the mapping to be removed")
+	}
+}
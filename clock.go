@@ -0,0 +1,173 @@
+package speed
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Clock is a source of time, used by a client for timers, timestamps of
+// metric histories and the schedule of its collectors, so tests can control
+// time instead of sleeping. SystemClock is used unless one is set using SetClock.
+type Clock interface {
+	// returns the current time
+	Now() time.Time
+
+	// calls f in its own goroutine after d has elapsed, like time.AfterFunc
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+// ClockTimer is a call scheduled on a Clock.
+type ClockTimer interface {
+	// prevents the call if it has not happened yet,
+	// returning false if it already happened or was stopped
+	Stop() bool
+}
+
+// systemClock is the clock of the system
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) ClockTimer { return time.AfterFunc(d, f) }
+
+// SystemClock is the Clock that reads the time of the system.
+var SystemClock Clock = systemClock{}
+
+// clocked is implemented by metrics that read the time, which use the clock
+// of the client they are registered with
+type clocked interface {
+	setClock(clock Clock)
+}
+
+// ManualClock is a Clock that only moves when it is advanced, for deterministic tests.
+// Calls scheduled on it are made by Advance when their time is reached, in the
+// goroutine calling Advance, so all scheduled work is done when Advance returns.
+type ManualClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+// manualTimer is a call scheduled on a ManualClock
+type manualTimer struct {
+	clock *ManualClock
+	at    time.Time
+	f     func()
+}
+
+// NewManualClock creates a ManualClock starting at now.
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now returns the time of the clock.
+func (c *ManualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+// AfterFunc schedules a call to f when the clock is advanced by at least d.
+func (c *ManualClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	t := &manualTimer{c, c.now.Add(d), f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Stop cancels the call.
+func (t *manualTimer) Stop() bool {
+	c := t.clock
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for i, timer := range c.timers {
+		if timer == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// next removes and returns the earliest call due by the passed time, moving the clock to it
+func (c *ManualClock) next(until time.Time) *manualTimer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	first := -1
+	for i, t := range c.timers {
+		if !t.at.After(until) && (first == -1 || t.at.Before(c.timers[first].at)) {
+			first = i
+		}
+	}
+
+	if first == -1 {
+		c.now = until
+		return nil
+	}
+
+	t := c.timers[first]
+	c.timers = append(c.timers[:first], c.timers[first+1:]...)
+	if t.at.After(c.now) {
+		c.now = t.at
+	}
+
+	return t
+}
+
+// Advance moves the clock forward by d, making all calls scheduled up to the new time
+// in the order they are due, including calls scheduled by those calls.
+func (c *ManualClock) Advance(d time.Duration) {
+	until := c.Now().Add(d)
+	for t := c.next(until); t != nil; t = c.next(until) {
+		t.f()
+	}
+}
+
+// getClock returns the clock of the client
+func (c *PCPClient) getClock() Clock {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.clock
+}
+
+// SetClock sets the clock the client and the metrics registered with it read the time from,
+// and that schedules its collectors, lazy metrics, metric histories and flushes of held back
+// updates. Set a ManualClock to test code using a client without sleeping.
+func (c *PCPClient) SetClock(clock Clock) error {
+	if clock == nil {
+		return errors.New("clock cannot be nil")
+	}
+
+	c.mutex.Lock()
+	if c.r.mapped {
+		c.mutex.Unlock()
+		return ErrClientStarted
+	}
+	c.clock = clock
+	if c.history != nil {
+		c.history.clock = clock
+	}
+	if c.limiter != nil {
+		c.limiter.mutex.Lock()
+		c.limiter.clock, c.limiter.last = clock, clock.Now()
+		c.limiter.mutex.Unlock()
+	}
+	c.mutex.Unlock()
+
+	for _, m := range c.r.sortedMetrics() {
+		if cm, ok := m.(clocked); ok {
+			cm.setClock(clock)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package speed
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManualClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewManualClock(start)
+
+	var calls []int
+	clock.AfterFunc(3*time.Second, func() { calls = append(calls, 3) })
+	clock.AfterFunc(time.Second, func() {
+		calls = append(calls, 1)
+		clock.AfterFunc(time.Second, func() { calls = append(calls, 2) })
+	})
+	stopped := clock.AfterFunc(2*time.Second, func() { calls = append(calls, -1) })
+	clock.AfterFunc(5*time.Second, func() { calls = append(calls, 5) })
+
+	if !stopped.Stop() {
+		t.Errorf("expected stopping a pending call to return true")
+	}
+
+	if stopped.Stop() {
+		t.Errorf("expected stopping a stopped call to return false")
+	}
+
+	clock.Advance(time.Second / 2)
+	if len(calls) != 0 {
+		t.Errorf("expected no calls before they are due, got %v", calls)
+	}
+
+	clock.Advance(4 * time.Second)
+	if len(calls) != 3 || calls[0] != 1 || calls[1] != 2 || calls[2] != 3 {
+		t.Errorf("expected calls 1, 2 and 3 in order, got %v", calls)
+	}
+
+	if now := clock.Now(); !now.Equal(start.Add(4*time.Second + time.Second/2)) {
+		t.Errorf("expected the clock to be at the time it was advanced to, got %v", now)
+	}
+}
+
+func TestClientClock(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	timer, err := NewPCPTimer("timer", MillisecondUnit)
+	if err != nil {
+		t.Fatalf("cannot create timer, error: %v", err)
+	}
+	c.MustRegister(timer, WithHistory(5))
+
+	if err = c.SetClock(nil); err == nil {
+		t.Errorf("expected setting a nil clock to fail")
+	}
+
+	clock := NewManualClock(time.Unix(0, 0))
+	if err = c.SetClock(clock); err != nil {
+		t.Fatalf("cannot set clock, error: %v", err)
+	}
+
+	if err = timer.Start(); err != nil {
+		t.Fatalf("cannot start timer, error: %v", err)
+	}
+
+	clock.Advance(1500 * time.Millisecond)
+
+	if v, err := timer.Stop(); err != nil || v != 1500 {
+		t.Errorf("expected the timer to measure 1500ms on the clock, got %v, error: %v", v, err)
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if err = c.SetClock(SystemClock); err != ErrClientStarted {
+		t.Errorf("expected ErrClientStarted setting the clock after start, got %v", err)
+	}
+
+	clock.Advance(DefaultCollectInterval)
+
+	history, err := c.History("timer")
+	if err != nil {
+		t.Fatalf("cannot get history, error: %v", err)
+	}
+
+	if len(history) != 2 || !history[1].Time.Equal(time.Unix(0, 0).Add(1500*time.Millisecond+DefaultCollectInterval)) {
+		t.Errorf("expected history timestamped by the clock, got %v", history)
+	}
+}
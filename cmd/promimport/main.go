@@ -0,0 +1,59 @@
+// promimport republishes the metrics of a Prometheus /metrics endpoint through an MMV mapping,
+// so applications that only expose Prometheus metrics, like closed source binaries or sidecars,
+// can be monitored using PCP.
+//
+// ```
+// go get github.com/performancecopilot/speed/cmd/promimport
+// promimport -url http://localhost:9100/metrics -name node -interval 5s
+// ```
+//
+// The endpoint is scraped every interval, and its metrics are published under mmv.<name>,
+// see speed.PrometheusCollector for how samples are mapped to metrics.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/performancecopilot/speed"
+)
+
+var (
+	url      = flag.String("url", "http://localhost:9090/metrics", "Prometheus endpoint to scrape")
+	name     = flag.String("name", "prometheus", "name of the client, the metrics are published under mmv.<name>")
+	interval = flag.Duration("interval", 10*time.Second, "interval between scrapes")
+)
+
+func main() {
+	flag.Parse()
+
+	client, err := speed.NewPCPClient(*name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err = client.SetPublishInterval(*interval); err != nil {
+		log.Fatal(err)
+	}
+
+	collector, err := speed.NewPrometheusCollector(*url)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err = client.RegisterCollector(collector); err != nil {
+		log.Fatal(err)
+	}
+
+	client.MustStart()
+	defer client.MustStop()
+
+	log.Printf("republishing %v under mmv.%v, %d metrics", *url, *name, len(collector.Metrics()))
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	<-stop
+}
@@ -0,0 +1,209 @@
+// speedbench creates a mapping with a configurable number of metrics and instances,
+// drives updates to it at a configurable rate and reports the achieved throughput,
+// the latency of individual updates and the allocations made per update.
+//
+// It can be used to size instrumentation before adding it to an application,
+// and as a regression harness for changes to the writer.
+//
+// ```
+// go get github.com/performancecopilot/speed/cmd/speedbench
+// speedbench -metrics 1000 -instances 10 -rate 1000000 -duration 30s
+// ```
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+	"github.com/performancecopilot/speed"
+)
+
+var (
+	metrics   = flag.Int("metrics", 100, "number of metrics to create")
+	instances = flag.Int("instances", 10, "number of instances of every metric, 0 creates singleton metrics")
+	rate      = flag.Int("rate", 0, "target number of updates per second across all workers, 0 is unlimited")
+	workers   = flag.Int("workers", runtime.NumCPU(), "number of goroutines updating metrics")
+	duration  = flag.Duration("duration", 10*time.Second, "how long to drive updates for")
+	name      = flag.String("name", "speedbench", "name of the client, the mapping is written as mmv.<name>")
+)
+
+// updater updates the value of a single metric or instance
+type updater func(v int64) error
+
+func setup(c *speed.PCPClient) ([]updater, error) {
+	var updaters []updater
+
+	if *instances == 0 {
+		for i := 0; i < *metrics; i++ {
+			m, err := speed.NewPCPSingletonMetric(int64(0), "speedbench.m"+strconv.Itoa(i), speed.Int64Type, speed.InstantSemantics, speed.OneUnit)
+			if err != nil {
+				return nil, err
+			}
+
+			if err = c.Register(m); err != nil {
+				return nil, err
+			}
+
+			updaters = append(updaters, func(v int64) error { return m.Set(v) })
+		}
+
+		return updaters, nil
+	}
+
+	names := make([]string, *instances)
+	for i := range names {
+		names[i] = "i" + strconv.Itoa(i)
+	}
+
+	indom, err := speed.NewPCPInstanceDomain("speedbench.instances", names)
+	if err != nil {
+		return nil, err
+	}
+
+	vals := make(speed.Instances, len(names))
+	for _, n := range names {
+		vals[n] = int64(0)
+	}
+
+	for i := 0; i < *metrics; i++ {
+		m, err := speed.NewPCPInstanceMetric(vals, "speedbench.m"+strconv.Itoa(i), indom, speed.Int64Type, speed.InstantSemantics, speed.OneUnit)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = c.Register(m); err != nil {
+			return nil, err
+		}
+
+		for _, n := range names {
+			n := n
+			updaters = append(updaters, func(v int64) error { return m.SetInstance(v, n) })
+		}
+	}
+
+	return updaters, nil
+}
+
+// drive updates the values assigned to a worker until the deadline,
+// spacing updates by interval if it is not 0, and records the latency of every update
+func drive(updaters []updater, worker int, interval time.Duration, deadline time.Time, h *hdrhistogram.Histogram) (int64, error) {
+	var (
+		n     int64
+		start = time.Now()
+	)
+
+	for i := worker; ; i += *workers {
+		if interval != 0 {
+			if d := time.Duration(n)*interval - time.Since(start); d > 0 {
+				time.Sleep(d)
+			}
+		}
+
+		t := time.Now()
+		if !t.Before(deadline) {
+			return n, nil
+		}
+
+		if err := updaters[i%len(updaters)](n); err != nil {
+			return n, err
+		}
+
+		_ = h.RecordValue(int64(time.Since(t)))
+		n++
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	if *metrics <= 0 || *instances < 0 || *rate < 0 || *workers <= 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	c, err := speed.NewPCPClient(*name)
+	if err != nil {
+		panic(err)
+	}
+
+	updaters, err := setup(c)
+	if err != nil {
+		panic(err)
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	var interval time.Duration
+	if *rate > 0 {
+		interval = time.Duration(int64(time.Second) * int64(*workers) / int64(*rate))
+	}
+
+	var (
+		wg         sync.WaitGroup
+		mutex      sync.Mutex
+		updates    int64
+		histograms = make([]*hdrhistogram.Histogram, *workers)
+		before     runtime.MemStats
+		after      runtime.MemStats
+	)
+
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	start := time.Now()
+	deadline := start.Add(*duration)
+
+	wg.Add(*workers)
+	for w := 0; w < *workers; w++ {
+		histograms[w] = hdrhistogram.New(1, int64(time.Second), 3)
+
+		go func(w int) {
+			defer wg.Done()
+
+			n, err := drive(updaters, w, interval, deadline, histograms[w])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+
+			mutex.Lock()
+			updates += n
+			mutex.Unlock()
+		}(w)
+	}
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	h := hdrhistogram.New(1, int64(time.Second), 3)
+	for _, wh := range histograms {
+		h.Merge(wh)
+	}
+
+	fmt.Printf("metrics: %v, instances: %v, values: %v, workers: %v\n", *metrics, *instances, len(updaters), *workers)
+	if *rate > 0 {
+		fmt.Printf("updates: %v in %v, %.0f/s achieved, %v/s targeted\n", updates, elapsed, float64(updates)/elapsed.Seconds(), *rate)
+	} else {
+		fmt.Printf("updates: %v in %v, %.0f/s achieved\n", updates, elapsed, float64(updates)/elapsed.Seconds())
+	}
+	fmt.Printf(
+		"update latency: p50 %v, p99 %v, p99.9 %v, max %v\n",
+		time.Duration(h.ValueAtQuantile(50)), time.Duration(h.ValueAtQuantile(99)),
+		time.Duration(h.ValueAtQuantile(99.9)), time.Duration(h.Max()),
+	)
+
+	if updates > 0 {
+		fmt.Printf(
+			"allocations: %.2f per update, %.2f bytes per update\n",
+			float64(after.Mallocs-before.Mallocs)/float64(updates),
+			float64(after.TotalAlloc-before.TotalAlloc)/float64(updates),
+		)
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/performancecopilot/speed"
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+// exportedIndom is an instance domain read from a mapping
+type exportedIndom struct {
+	serial      uint32
+	name        string   // mappings do not hold the names of instance domains, so one is made up
+	instances   []string // ordered by their identifiers
+	short, long string
+}
+
+// exportedMetric is a metric read from a mapping
+type exportedMetric struct {
+	name        string
+	typ         mmvdump.Type
+	sem         mmvdump.Semantics
+	unit        mmvdump.Unit
+	short, long string
+	indom       *exportedIndom         // nil for singleton metrics
+	vals        map[string]interface{} // by instance, under "" for singleton metrics
+}
+
+// mapping reads the strings and instances of a dumped mapping
+type mapping struct {
+	strings   map[uint64]*mmvdump.String
+	instances map[uint64]mmvdump.Instance
+}
+
+// str returns the string at offset, or "" for offset 0
+func (m *mapping) str(offset uint64) string {
+	s, ok := m.strings[offset]
+	if offset == 0 || !ok {
+		return ""
+	}
+	return cstring(s.Payload[:])
+}
+
+// instanceName returns the name of the instance at offset
+func (m *mapping) instanceName(offset uint64) string {
+	switch i := m.instances[offset].(type) {
+	case *mmvdump.Instance1:
+		return cstring(i.External[:])
+	case *mmvdump.Instance2:
+		return m.str(i.External)
+	}
+	return ""
+}
+
+// cstring returns the contents of a null terminated string stored in b
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// byInternal orders the offsets of instances by their identifiers
+type byInternal struct {
+	offsets   []uint64
+	instances map[uint64]mmvdump.Instance
+}
+
+func (s byInternal) Len() int      { return len(s.offsets) }
+func (s byInternal) Swap(i, j int) { s.offsets[i], s.offsets[j] = s.offsets[j], s.offsets[i] }
+func (s byInternal) Less(i, j int) bool {
+	return s.instances[s.offsets[i]].Internal() < s.instances[s.offsets[j]].Internal()
+}
+
+// byName orders metrics by name
+type byName []*exportedMetric
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byName) Less(i, j int) bool { return s[i].name < s[j].name }
+
+// readMapping returns the metrics of a mapping ordered by name
+func readMapping(data []byte) ([]*exportedMetric, error) {
+	_, _, metrics, values, instances, indoms, strs, err := mmvdump.Dump(data)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &mapping{strs, instances}
+
+	// instance domains by serial, with their instances ordered by identifier
+	serials := make(map[uint32]*exportedIndom)
+	for off, indom := range indoms {
+		d := &exportedIndom{
+			serial: indom.Serial,
+			short:  m.str(indom.Shorttext),
+			long:   m.str(indom.Longtext),
+		}
+
+		var offsets []uint64
+		for ioff, i := range instances {
+			if i.Indom() == off {
+				offsets = append(offsets, ioff)
+			}
+		}
+
+		sort.Sort(byInternal{offsets, instances})
+
+		for _, ioff := range offsets {
+			d.instances = append(d.instances, m.instanceName(ioff))
+		}
+
+		serials[d.serial] = d
+	}
+
+	// metrics by offset
+	exported := make(map[uint64]*exportedMetric)
+	for off, metric := range metrics {
+		var name string
+		switch mt := metric.(type) {
+		case *mmvdump.Metric1:
+			name = cstring(mt.Name[:])
+		case *mmvdump.Metric2:
+			name = m.str(mt.Name)
+		}
+
+		e := &exportedMetric{
+			name:  name,
+			typ:   metric.Typ(),
+			sem:   metric.Sem(),
+			unit:  metric.Unit(),
+			short: m.str(metric.ShortText()),
+			long:  m.str(metric.LongText()),
+			vals:  make(map[string]interface{}),
+		}
+
+		if metric.Indom() != mmvdump.NoIndom {
+			e.indom = serials[uint32(metric.Indom())]
+			if e.indom == nil {
+				return nil, fmt.Errorf("metric %v refers to a missing instance domain %v", name, metric.Indom())
+			}
+		}
+
+		exported[off] = e
+	}
+
+	for _, v := range values {
+		e, ok := exported[v.Metric]
+		if !ok {
+			return nil, fmt.Errorf("a value refers to a missing metric at offset %v", v.Metric)
+		}
+
+		var val interface{}
+		if e.typ == mmvdump.StringType {
+			val = m.str(uint64(v.Extra))
+		} else if val, err = mmvdump.FixedVal(v.Val, e.typ); err != nil {
+			return nil, fmt.Errorf("cannot read the value of %v: %v", e.name, err)
+		}
+
+		instance := ""
+		if e.indom != nil {
+			instance = m.instanceName(v.Instance)
+		}
+		e.vals[instance] = val
+	}
+
+	ans := make([]*exportedMetric, 0, len(exported))
+	for _, e := range exported {
+		ans = append(ans, e)
+	}
+	sort.Sort(byName(ans))
+
+	// name every instance domain after the first metric over it, like speed's own collectors do
+	for _, e := range ans {
+		if e.indom != nil && e.indom.name == "" {
+			e.indom.name = e.name + ".indom"
+		}
+	}
+
+	return ans, nil
+}
+
+// units are the names of the units speed has constants for
+var units = map[mmvdump.Unit]bool{
+	mmvdump.OneUnit:  true,
+	mmvdump.ByteUnit: true, mmvdump.KilobyteUnit: true, mmvdump.MegabyteUnit: true, mmvdump.GigabyteUnit: true,
+	mmvdump.TerabyteUnit: true, mmvdump.PetabyteUnit: true, mmvdump.ExabyteUnit: true,
+	mmvdump.NanosecondUnit: true, mmvdump.MicrosecondUnit: true, mmvdump.MillisecondUnit: true,
+	mmvdump.SecondUnit: true, mmvdump.MinuteUnit: true, mmvdump.HourUnit: true,
+}
+
+// literal returns a Go expression for a value of type t, and whether it uses the math package
+func literal(val interface{}, t mmvdump.Type) (string, bool) {
+	float := func(f float64, bits int, conv string) (string, bool) {
+		switch {
+		case math.IsInf(f, 1):
+			return conv + "(math.Inf(1))", true
+		case math.IsInf(f, -1):
+			return conv + "(math.Inf(-1))", true
+		case math.IsNaN(f):
+			return conv + "(math.NaN())", true
+		}
+		return conv + "(" + strconv.FormatFloat(f, 'g', -1, bits) + ")", false
+	}
+
+	switch v := val.(type) {
+	case float32:
+		return float(float64(v), 32, "float32")
+	case float64:
+		return float(v, 64, "float64")
+	case string:
+		return strconv.Quote(v), false
+	case nil:
+		return literal(zero(t), t)
+	}
+
+	return fmt.Sprintf("%T(%v)", val, val), false
+}
+
+// zero returns the zero value of a type
+func zero(t mmvdump.Type) interface{} {
+	switch t {
+	case mmvdump.Int32Type:
+		return int32(0)
+	case mmvdump.Uint32Type:
+		return uint32(0)
+	case mmvdump.Int64Type:
+		return int64(0)
+	case mmvdump.Uint64Type:
+		return uint64(0)
+	case mmvdump.FloatType:
+		return float32(0)
+	case mmvdump.DoubleType:
+		return float64(0)
+	}
+	return ""
+}
+
+// descriptions returns the description arguments of a constructor
+func descriptions(short, long string) string {
+	switch {
+	case long != "":
+		return fmt.Sprintf(", %q, %q", short, long)
+	case short != "":
+		return fmt.Sprintf(", %q", short)
+	}
+	return ""
+}
+
+// generate returns the formatted source of a function named fn in package pkg, that
+// creates the passed metrics and registers them with a client
+func generate(metrics []*exportedMetric, pkg, fn, source string) ([]byte, error) {
+	var b bytes.Buffer
+	usesMath := false
+
+	fmt.Fprintf(&b, "// %v creates the metrics read from the mapping, with the values they had, and registers them with c.\n", fn)
+	fmt.Fprintf(&b, "func %v(c *speed.PCPClient) error {\n", fn)
+
+	indoms := make(map[*exportedIndom]string)
+	for i, e := range metrics {
+		if e.indom != nil && indoms[e.indom] == "" {
+			v := fmt.Sprintf("indom%d", len(indoms)+1)
+			indoms[e.indom] = v
+
+			fmt.Fprintf(&b, "%v, err := speed.NewPCPInstanceDomain(%q, %#v%v)\n", v, e.indom.name, e.indom.instances, descriptions(e.indom.short, e.indom.long))
+			b.WriteString("if err != nil {\nreturn err\n}\n\n")
+		}
+
+		unit := "speed." + e.unit.String()
+		if !units[e.unit] {
+			unit = fmt.Sprintf("speed.OneUnit /* the mapping has unit %#x, which speed has no constant for */", uint32(e.unit))
+		}
+
+		args := fmt.Sprintf("%q, speed.%v, speed.%v, %v%v", e.name, e.typ, e.sem, unit, descriptions(e.short, e.long))
+
+		v := fmt.Sprintf("m%d", i+1)
+		if e.indom == nil {
+			val, m := literal(e.vals[""], e.typ)
+			usesMath = usesMath || m
+			fmt.Fprintf(&b, "%v, err := speed.NewPCPSingletonMetric(%v, %v)\n", v, val, args)
+		} else {
+			fmt.Fprintf(&b, "%v, err := speed.NewPCPInstanceMetric(speed.Instances{\n", v)
+			for _, instance := range e.indom.instances {
+				val, m := literal(e.vals[instance], e.typ)
+				usesMath = usesMath || m
+				fmt.Fprintf(&b, "%q: %v,\n", instance, val)
+			}
+			fmt.Fprintf(&b, "}, %q, %v, speed.%v, speed.%v, %v%v)\n", e.name, indoms[e.indom], e.typ, e.sem, unit, descriptions(e.short, e.long))
+		}
+
+		b.WriteString("if err != nil {\nreturn err\n}\n\n")
+		fmt.Fprintf(&b, "if err = c.Register(%v); err != nil {\nreturn err\n}\n\n", v)
+	}
+
+	b.WriteString("return nil\n}\n")
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Generated by speedgen export from %v.\n\n", source)
+	fmt.Fprintf(&src, "package %v\n\n", pkg)
+	if usesMath {
+		src.WriteString("import (\n\"math\"\n\n\"github.com/performancecopilot/speed\"\n)\n\n")
+	} else {
+		src.WriteString("import \"github.com/performancecopilot/speed\"\n\n")
+	}
+	_, _ = b.WriteTo(&src)
+
+	return format.Source(src.Bytes())
+}
+
+// runExport implements speedgen export
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	pkg := flags.String("package", "main", "package of the generated code")
+	fn := flags.String("func", "register", "name of the generated function")
+	out := flags.String("o", "", "file the code is written to, defaults to the standard output")
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: speedgen export [flags] <client name or mapping file>\n\n")
+		flags.PrintDefaults()
+	}
+
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("export takes exactly one client name or mapping file")
+	}
+
+	path := flags.Arg(0)
+	if _, err := os.Stat(path); err != nil && !strings.ContainsRune(path, os.PathSeparator) {
+		// not a file, read the mapping of the client of that name
+		c, err := speed.NewPCPClient(path)
+		if err != nil {
+			return err
+		}
+		path = c.Location()
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	metrics, err := readMapping(data)
+	if err != nil {
+		return fmt.Errorf("cannot read %v: %v", path, err)
+	}
+
+	src, err := generate(metrics, *pkg, *fn, path)
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+
+	return ioutil.WriteFile(*out, src, 0644)
+}
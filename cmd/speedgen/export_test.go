@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/performancecopilot/speed"
+)
+
+func TestExport(t *testing.T) {
+	c, err := speed.NewPCPClient("exported")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	c.MustRegisterString("requests", int64(42), speed.Int64Type, speed.CounterSemantics, speed.OneUnit)
+
+	g, err := speed.NewPCPGauge(math.Inf(1), "ratio", "a ratio", "a ratio of things")
+	if err != nil {
+		t.Fatalf("cannot create gauge, error: %v", err)
+	}
+	c.MustRegister(g)
+
+	indom, err := speed.NewPCPInstanceDomain("sizes", []string{"small", "large"}, "sizes of things")
+	if err != nil {
+		t.Fatalf("cannot create instance domain, error: %v", err)
+	}
+
+	m, err := speed.NewPCPInstanceMetric(speed.Instances{"small": uint32(1), "large": uint32(100)},
+		"size", indom, speed.Uint32Type, speed.InstantSemantics, speed.KilobyteUnit)
+	if err != nil {
+		t.Fatalf("cannot create instance metric, error: %v", err)
+	}
+	c.MustRegister(m)
+
+	c.MustRegisterString("version", "1.0", speed.StringType, speed.DiscreteSemantics, speed.OneUnit)
+
+	var mapping bytes.Buffer
+	if err = c.EncodeTo(&mapping); err != nil {
+		t.Fatalf("cannot encode mapping, error: %v", err)
+	}
+
+	metrics, err := readMapping(mapping.Bytes())
+	if err != nil {
+		t.Fatalf("cannot read mapping, error: %v", err)
+	}
+
+	if len(metrics) != 4 || metrics[0].name != "ratio" || metrics[1].name != "requests" {
+		t.Fatalf("expected 4 metrics ordered by name, got %v", len(metrics))
+	}
+
+	size := metrics[2]
+	if size.indom == nil || len(size.indom.instances) != 2 || size.indom.short != "sizes of things" || size.vals["large"] != uint32(100) {
+		t.Errorf("unexpected instance metric %+v", size)
+	}
+
+	src, err := generate(metrics, "metrics", "setup", "exported")
+	if err != nil {
+		t.Fatalf("cannot generate code, error: %v", err)
+	}
+
+	for _, expected := range []string{
+		"package metrics",
+		`"math"`,
+		"func setup(c *speed.PCPClient) error {",
+		`speed.NewPCPSingletonMetric(int64(42), "requests", speed.Int64Type, speed.CounterSemantics, speed.OneUnit)`,
+		`speed.NewPCPSingletonMetric(float64(math.Inf(1)), "ratio", speed.DoubleType, speed.InstantSemantics, speed.OneUnit, "a ratio", "a ratio of things")`,
+		`speed.NewPCPInstanceDomain("size.indom", []string{"small", "large"}, "sizes of things")`,
+		`"large": uint32(100),`,
+		`"size", indom1, speed.Uint32Type, speed.InstantSemantics, speed.KilobyteUnit)`,
+		`speed.NewPCPSingletonMetric("1.0", "version", speed.StringType, speed.DiscreteSemantics, speed.OneUnit)`,
+		"if err = c.Register(m4); err != nil {",
+	} {
+		if !bytes.Contains(src, []byte(expected)) {
+			t.Errorf("expected the generated code to contain %v, got\n%s", expected, src)
+		}
+	}
+}
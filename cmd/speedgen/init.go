@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"text/template"
+)
+
+// templates are the application skeletons speedgen init can write, by name
+var templates = map[string]*template.Template{
+	"http":   template.Must(template.New("http").Parse(httpTemplate)),
+	"worker": template.Must(template.New("worker").Parse(workerTemplate)),
+	"grpc":   template.Must(template.New("grpc").Parse(grpcTemplate)),
+}
+
+// validName matches names that can be used for a client and its metrics
+var validName = regexp.MustCompile(`\A[A-Za-z][A-Za-z0-9_]*\z`)
+
+// skeleton is passed to the templates
+type skeleton struct {
+	Name     string // name of the client, and the prefix of the metrics
+	Template string // name of the template
+}
+
+// templateNames returns the names of all templates, ordered by name
+func templateNames() []string {
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// render returns the formatted source of a skeleton
+func render(s skeleton) ([]byte, error) {
+	t, ok := templates[s.Template]
+	if !ok {
+		return nil, fmt.Errorf("unknown template %q, expected one of %v", s.Template, templateNames())
+	}
+
+	if !validName.MatchString(s.Name) {
+		return nil, fmt.Errorf("invalid name %q, names start with a letter followed by letters, digits and underscores", s.Name)
+	}
+
+	var b bytes.Buffer
+	if err := t.Execute(&b, s); err != nil {
+		return nil, err
+	}
+
+	return format.Source(b.Bytes())
+}
+
+// runInit implements speedgen init
+func runInit(args []string) error {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	name := flags.String("name", "", "name of the client, defaults to the name of the directory")
+	dir := flags.String("dir", ".", "directory the skeleton is written to, created if it does not exist")
+	force := flags.Bool("force", false, "overwrite an existing main.go")
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: speedgen init [flags] <%v>\n\n", templateNames())
+		flags.PrintDefaults()
+	}
+
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("init takes exactly one template")
+	}
+
+	abs, err := filepath.Abs(*dir)
+	if err != nil {
+		return err
+	}
+
+	s := skeleton{Name: *name, Template: flags.Arg(0)}
+	if s.Name == "" {
+		s.Name = filepath.Base(abs)
+	}
+
+	src, err := render(s)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(abs, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(abs, "main.go")
+	if _, err = os.Stat(path); err == nil && !*force {
+		return fmt.Errorf("%v already exists, pass -force to overwrite it", path)
+	}
+
+	if err = ioutil.WriteFile(path, src, 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("wrote %v, run it and read its metrics using\n\n\tgo run %v\n\tpminfo -f mmv.%v\n", path, path, s.Name)
+	return nil
+}
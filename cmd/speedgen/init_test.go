@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	for _, name := range templateNames() {
+		src, err := render(skeleton{Name: "myapp", Template: name})
+		if err != nil {
+			t.Errorf("cannot render %v, error: %v", name, err)
+			continue
+		}
+
+		if !bytes.Contains(src, []byte(`speed.NewPCPClient("myapp")`)) {
+			t.Errorf("expected %v to create a client named myapp", name)
+		}
+	}
+
+	if _, err := render(skeleton{Name: "myapp", Template: "cron"}); err == nil {
+		t.Errorf("expected rendering an unknown template to fail")
+	}
+
+	if _, err := render(skeleton{Name: "my-app", Template: "http"}); err == nil {
+		t.Errorf("expected rendering with an invalid name to fail")
+	}
+}
@@ -0,0 +1,68 @@
+// speedgen generates code using speed.
+//
+// speedgen init writes a runnable, instrumented skeleton of a common kind of application,
+// to start instrumenting a new application from, instead of copying one of the examples.
+//
+// ```
+// go get github.com/performancecopilot/speed/cmd/speedgen
+// speedgen init -name myapp -dir myapp http
+// ```
+//
+// The available templates are
+//
+//   - http: an HTTP server counting requests by status class and recording their latency
+//   - worker: a background worker counting processed and failed jobs and recording their duration
+//   - grpc: a gRPC server instrumented through a unary interceptor
+//
+// speedgen export reads the live mapping of a client, or a mapping written by EncodeTo,
+// and writes a function with the constructor calls that recreate its metrics, to migrate
+// the instrumentation of an existing binary, or hand-written registration, to code.
+//
+// ```
+// speedgen export -func setup -o metrics.go myapp
+// ```
+//
+// Mappings do not hold everything speed knows about a metric, so all metrics are
+// recreated as singleton or instance metrics, and instance domains are named
+// after the first metric over them.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `usage: speedgen <command> [arguments]
+
+commands:
+  init    write an instrumented application skeleton from a template
+  export  write the code that recreates the metrics of a mapping
+
+run speedgen <command> -h for the arguments of a command
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "init":
+		err = runInit(os.Args[2:])
+	case "export":
+		err = runExport(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "speedgen: unknown command %q\n\n%v", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "speedgen: %v\n", err)
+		os.Exit(1)
+	}
+}
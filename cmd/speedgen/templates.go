@@ -0,0 +1,360 @@
+package main
+
+// httpTemplate is an HTTP server counting requests by status class and recording their latency
+const httpTemplate = `// Command {{.Name}} is an HTTP server instrumented using speed,
+// generated by speedgen init {{.Template}}.
+//
+// Its metrics are published under mmv.{{.Name}}, and can be read using
+//
+//	pminfo -f mmv.{{.Name}}
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/performancecopilot/speed"
+)
+
+var addr = flag.String("addr", ":8080", "address to listen on")
+
+var (
+	requests *speed.PCPCounterVector
+	inflight *speed.PCPGauge
+	latency  *speed.PCPHistogram
+)
+
+// setup creates the metrics of the server and registers them with the client
+func setup(client *speed.PCPClient) error {
+	var err error
+
+	requests, err = speed.NewPCPCounterVector(map[string]int64{
+		"1xx": 0, "2xx": 0, "3xx": 0, "4xx": 0, "5xx": 0,
+	}, "{{.Name}}.http.requests", "Number of requests served, by status class")
+	if err != nil {
+		return err
+	}
+
+	inflight, err = speed.NewPCPGauge(0, "{{.Name}}.http.inflight", "Number of requests being served")
+	if err != nil {
+		return err
+	}
+
+	latency, err = speed.NewPCPHistogram("{{.Name}}.http.latency", 0, int64(time.Minute/time.Microsecond), 3,
+		speed.MicrosecondUnit, "Time taken to serve requests, in microseconds")
+	if err != nil {
+		return err
+	}
+
+	for _, m := range []speed.Metric{requests, inflight, latency} {
+		if err = client.Register(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// statusRecorder records the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// instrument wraps a handler, updating the metrics of the server for every request
+func instrument(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		inflight.MustInc(1)
+		defer inflight.MustInc(-1)
+
+		rec := &statusRecorder{w, http.StatusOK}
+		h.ServeHTTP(rec, r)
+
+		requests.Up(fmt.Sprintf("%dxx", rec.status/100))
+		latency.MustRecord(int64(time.Since(start) / time.Microsecond))
+	})
+}
+
+func main() {
+	flag.Parse()
+
+	client, err := speed.NewPCPClient("{{.Name}}")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err = setup(client); err != nil {
+		log.Fatal(err)
+	}
+
+	client.MustStart()
+	defer client.MustStop()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "hello from {{.Name}}")
+	})
+
+	go func() {
+		log.Fatal(http.ListenAndServe(*addr, instrument(mux)))
+	}()
+
+	log.Printf("listening on %v, press Ctrl+C to stop", *addr)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	<-stop
+}
+`
+
+// workerTemplate is a background worker counting processed and failed jobs and recording their duration
+const workerTemplate = `// Command {{.Name}} is a background worker instrumented using speed,
+// generated by speedgen init {{.Template}}.
+//
+// Its metrics are published under mmv.{{.Name}}, and can be read using
+//
+//	pminfo -f mmv.{{.Name}}
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/performancecopilot/speed"
+)
+
+var interval = flag.Duration("interval", 100*time.Millisecond, "interval between jobs")
+
+var (
+	jobs     *speed.PCPCounterVector
+	queued   *speed.PCPGauge
+	duration *speed.PCPHistogram
+)
+
+// setup creates the metrics of the worker and registers them with the client
+func setup(client *speed.PCPClient) error {
+	var err error
+
+	jobs, err = speed.NewPCPCounterVector(map[string]int64{
+		"processed": 0, "failed": 0,
+	}, "{{.Name}}.jobs", "Number of jobs run, by outcome")
+	if err != nil {
+		return err
+	}
+
+	queued, err = speed.NewPCPGauge(0, "{{.Name}}.queued", "Number of jobs waiting to run")
+	if err != nil {
+		return err
+	}
+
+	duration, err = speed.NewPCPHistogram("{{.Name}}.duration", 0, int64(time.Hour/time.Millisecond), 3,
+		speed.MillisecondUnit, "Time taken to run jobs, in milliseconds")
+	if err != nil {
+		return err
+	}
+
+	for _, m := range []speed.Metric{jobs, queued, duration} {
+		if err = client.Register(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// job is the work done by the worker, replace it with your own
+func job() error {
+	time.Sleep(time.Duration(rand.Intn(50)) * time.Millisecond)
+	if rand.Intn(10) == 0 {
+		return errors.New("job failed")
+	}
+	return nil
+}
+
+// run runs a job, updating the metrics of the worker
+func run() {
+	start := time.Now()
+	err := job()
+	duration.MustRecord(int64(time.Since(start) / time.Millisecond))
+
+	if err != nil {
+		jobs.Up("failed")
+		log.Print(err)
+		return
+	}
+
+	jobs.Up("processed")
+}
+
+func main() {
+	flag.Parse()
+
+	client, err := speed.NewPCPClient("{{.Name}}")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err = setup(client); err != nil {
+		log.Fatal(err)
+	}
+
+	client.MustStart()
+	defer client.MustStop()
+
+	queue := make(chan struct{}, 100)
+	go func() {
+		for range queue {
+			queued.MustInc(-1)
+			run()
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+
+	log.Print("running jobs, press Ctrl+C to stop")
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			select {
+			case queue <- struct{}{}:
+				queued.MustInc(1)
+			default:
+				log.Print("queue is full, dropping a job")
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+`
+
+// grpcTemplate is a gRPC server instrumented through a unary interceptor
+const grpcTemplate = `// Command {{.Name}} is a gRPC server instrumented using speed,
+// generated by speedgen init {{.Template}}.
+//
+// Register your services with the server in main. Its metrics are published
+// under mmv.{{.Name}}, and can be read using
+//
+//	pminfo -f mmv.{{.Name}}
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/performancecopilot/speed"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var addr = flag.String("addr", ":50051", "address to listen on")
+
+var (
+	calls   *speed.PCPCounterVector
+	latency *speed.PCPHistogram
+)
+
+// setup creates the metrics of the server and registers them with the client
+func setup(client *speed.PCPClient) error {
+	var err error
+
+	calls, err = speed.NewPCPCounterVector(map[string]int64{
+		"ok": 0, "error": 0,
+	}, "{{.Name}}.grpc.calls", "Number of unary calls served, by outcome")
+	if err != nil {
+		return err
+	}
+
+	latency, err = speed.NewPCPHistogram("{{.Name}}.grpc.latency", 0, int64(time.Minute/time.Microsecond), 3,
+		speed.MicrosecondUnit, "Time taken to serve unary calls, in microseconds")
+	if err != nil {
+		return err
+	}
+
+	for _, m := range []speed.Metric{calls, latency} {
+		if err = client.Register(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// instrument is a unary interceptor updating the metrics of the server for every call
+func instrument(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	latency.MustRecord(int64(time.Since(start) / time.Microsecond))
+
+	if err != nil {
+		calls.Up("error")
+		log.Printf("%v failed with %v", info.FullMethod, status.Code(err))
+	} else {
+		calls.Up("ok")
+	}
+
+	return resp, err
+}
+
+func main() {
+	flag.Parse()
+
+	client, err := speed.NewPCPClient("{{.Name}}")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err = setup(client); err != nil {
+		log.Fatal(err)
+	}
+
+	client.MustStart()
+	defer client.MustStop()
+
+	lis, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(instrument))
+	// register your services here, like pb.RegisterGreeterServer(server, &greeter{})
+
+	go func() {
+		log.Fatal(server.Serve(lis))
+	}()
+
+	log.Printf("listening on %v, press Ctrl+C to stop", *addr)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	<-stop
+
+	server.GracefulStop()
+}
+`
@@ -0,0 +1,208 @@
+package speed
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Collector defines the interface for a type that owns a set of metrics
+// and periodically refreshes their values.
+type Collector interface {
+	// returns the metrics updated by the collector
+	Metrics() []Metric
+
+	// refreshes the values of all metrics owned by the collector
+	Collect() error
+}
+
+// newCountMetric creates an instant Uint64 instance metric counting instances of something,
+// with all instances set to 0.
+func newCountMetric(name string, instances []string, desc ...string) (*PCPInstanceMetric, error) {
+	vals := make(Instances)
+	for _, i := range instances {
+		vals[i] = uint64(0)
+	}
+
+	indom, err := NewPCPInstanceDomain(name+".indom", instances)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPCPInstanceMetric(vals, name, indom, Uint64Type, InstantSemantics, OneUnit, desc...)
+}
+
+// DefaultCollectInterval is the interval at which a client runs its collectors,
+// unless a publish interval is set using SetPublishInterval.
+var DefaultCollectInterval = time.Second
+
+var collectorlogger = log.WithField("prefix", "collector")
+
+// runningCollector is a collector run by a collectorRunner
+type runningCollector struct {
+	name     string
+	col      Collector
+	disabled int32 // set atomically, see DisableCollector
+}
+
+// collectorRunner runs a set of collectors on a ticker while a mapping is active.
+//
+// Collectors can use the client, like adding instances or registering metrics,
+// so they are never run while holding the lock of the client.
+type collectorRunner struct {
+	mutex      sync.Mutex // guards collectors, which are added while collections run
+	collectors []*runningCollector
+	cancel     func()     // stops the schedule, nil if the collectors are not running
+	running    sync.Mutex // held while collecting, so scheduled runs and Tick do not overlap
+}
+
+// collectorName returns the name of a collector, which is its type name,
+// like speed.DiskCollector, followed by #n for the nth collector of the type
+func (r *collectorRunner) collectorName(col Collector) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	name := strings.TrimPrefix(fmt.Sprintf("%T", col), "*")
+
+	n := 1
+	for _, rc := range r.collectors {
+		if rc.name == name || strings.HasPrefix(rc.name, name+"#") {
+			n++
+		}
+	}
+
+	if n > 1 {
+		name = fmt.Sprintf("%v#%d", name, n)
+	}
+
+	return name
+}
+
+// add adds a collector to be run under the passed name.
+func (r *collectorRunner) add(name string, col Collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.collectors = append(r.collectors, &runningCollector{name: name, col: col})
+}
+
+// find returns the collector with the passed name, or nil
+func (r *collectorRunner) find(name string) *runningCollector {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, rc := range r.collectors {
+		if rc.name == name {
+			return rc
+		}
+	}
+	return nil
+}
+
+// collect runs all enabled collectors once.
+func (r *collectorRunner) collect() {
+	r.running.Lock()
+	defer r.running.Unlock()
+
+	for _, rc := range r.list() {
+		if atomic.LoadInt32(&rc.disabled) != 0 {
+			continue
+		}
+
+		if err := rc.col.Collect(); err != nil && logging {
+			collectorlogger.WithFields(logrus.Fields{
+				"collector": rc.name,
+				"error":     err,
+			}).Error("collection failed")
+		}
+	}
+}
+
+// list returns the collectors in the order they are run
+func (r *collectorRunner) list() []*runningCollector {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]*runningCollector(nil), r.collectors...)
+}
+
+// start runs all collectors on the schedule until stop is called, returning
+// whether there are any, in which case the caller runs the first collection
+// right away, once it released the lock of the client.
+func (r *collectorRunner) start(s *publishSchedule) bool {
+	if len(r.list()) == 0 {
+		return false
+	}
+
+	r.cancel = s.start(r.collect)
+	return true
+}
+
+// stop stops running the collectors on their schedule, and returns a function waiting
+// for a running collection to finish, which is called without holding the lock of the client.
+func (r *collectorRunner) stop() func() {
+	cancel := r.cancel
+	r.cancel = nil
+
+	if cancel == nil {
+		return func() {}
+	}
+
+	return cancel
+}
+
+// CollectorInfo describes a collector registered with a client.
+type CollectorInfo struct {
+	Name    string // name of the collector, see RegisterCollector
+	Enabled bool   // whether the collector is run, see DisableCollector
+}
+
+// Collectors returns the collectors registered with the client, in the order they are run.
+func (c *PCPClient) Collectors() []CollectorInfo {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	collectors := c.collectors.list()
+
+	ans := make([]CollectorInfo, 0, len(collectors))
+	for _, rc := range collectors {
+		ans = append(ans, CollectorInfo{rc.name, atomic.LoadInt32(&rc.disabled) == 0})
+	}
+
+	return ans
+}
+
+// setCollectorEnabled enables or disables the collector with the passed name
+func (c *PCPClient) setCollectorEnabled(name string, enabled bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	rc := c.collectors.find(name)
+	if rc == nil {
+		return fmt.Errorf("no collector is named %v", name)
+	}
+
+	var disabled int32
+	if !enabled {
+		disabled = 1
+	}
+	atomic.StoreInt32(&rc.disabled, disabled)
+
+	return nil
+}
+
+// DisableCollector stops running the collector with the passed name, leaving the
+// metrics it owns at their last values until it is enabled again, which sheds
+// the cost of an expensive collector at runtime without a restart.
+func (c *PCPClient) DisableCollector(name string) error {
+	return c.setCollectorEnabled(name, false)
+}
+
+// EnableCollector runs a disabled collector again from the next collection.
+func (c *PCPClient) EnableCollector(name string) error {
+	return c.setCollectorEnabled(name, true)
+}
@@ -0,0 +1,107 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package speed
+
+import (
+	"errors"
+	"syscall"
+)
+
+func init() {
+	builtinCollectors["disk"] = func(c *PCPClient, conf *ClientConfig) error {
+		d, err := NewDiskCollector("disk", conf.DiskPaths...)
+		if err != nil {
+			return err
+		}
+		return c.RegisterCollector(d)
+	}
+}
+
+// DiskCollector publishes the free and used space and inodes of the
+// filesystems containing a set of paths, with the paths as instances of
+// <name>.bytes.free, <name>.bytes.used, <name>.inodes.free and <name>.inodes.used
+// in the instance domain <name>.paths.
+type DiskCollector struct {
+	paths                  []string
+	freeBytes, usedBytes   *PCPInstanceMetric
+	freeInodes, usedInodes *PCPInstanceMetric
+}
+
+// NewDiskCollector creates a new DiskCollector for the passed paths, naming
+// its metrics and instance domain under name, so collectors for different
+// sets of paths can be registered on the same client under different names.
+func NewDiskCollector(name string, paths ...string) (*DiskCollector, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("at least one path is required to collect disk usage")
+	}
+
+	indom, err := NewPCPInstanceDomain(name+".paths", paths, "monitored paths")
+	if err != nil {
+		return nil, err
+	}
+
+	vals := make(Instances)
+	for _, p := range paths {
+		vals[p] = uint64(0)
+	}
+
+	d := &DiskCollector{paths: paths}
+
+	metrics := []struct {
+		m    **PCPInstanceMetric
+		name string
+		unit MetricUnit
+		desc string
+	}{
+		{&d.freeBytes, ".bytes.free", ByteUnit, "space available to unprivileged users"},
+		{&d.usedBytes, ".bytes.used", ByteUnit, "space used"},
+		{&d.freeInodes, ".inodes.free", OneUnit, "free inodes"},
+		{&d.usedInodes, ".inodes.used", OneUnit, "used inodes"},
+	}
+
+	for _, m := range metrics {
+		*m.m, err = NewPCPInstanceMetric(vals, name+m.name, indom, Uint64Type, InstantSemantics, m.unit, m.desc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// Metrics returns the metrics updated by the collector.
+func (d *DiskCollector) Metrics() []Metric {
+	return []Metric{d.freeBytes, d.usedBytes, d.freeInodes, d.usedInodes}
+}
+
+// Collect reads the usage of the filesystems of all paths.
+func (d *DiskCollector) Collect() error {
+	var st syscall.Statfs_t
+
+	for _, p := range d.paths {
+		if err := syscall.Statfs(p, &st); err != nil {
+			return err
+		}
+
+		bsize := uint64(st.Bsize)
+
+		vals := []struct {
+			m   *PCPInstanceMetric
+			val uint64
+		}{
+			{d.freeBytes, uint64(st.Bavail) * bsize},
+			{d.usedBytes, (uint64(st.Blocks) - uint64(st.Bfree)) * bsize},
+			{d.freeInodes, uint64(st.Ffree)},
+			{d.usedInodes, uint64(st.Files) - uint64(st.Ffree)},
+		}
+
+		for _, v := range vals {
+			if err := v.m.SetInstance(v.val, p); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package speed
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDiskCollector(t *testing.T) {
+	if _, err := NewDiskCollector("disk"); err == nil {
+		t.Error("expected creating a collector without paths to fail")
+	}
+
+	dir := os.TempDir()
+
+	col, err := NewDiskCollector("disk", dir)
+	if err != nil {
+		t.Fatalf("cannot create collector, error: %v", err)
+	}
+
+	if err = col.Collect(); err != nil {
+		t.Fatalf("cannot collect, error: %v", err)
+	}
+
+	v, err := col.usedBytes.ValInstance(dir)
+	if err != nil {
+		t.Fatalf("cannot get used bytes, error: %v", err)
+	}
+
+	if v.(uint64) == 0 {
+		t.Errorf("expected used bytes for %v to be non zero", dir)
+	}
+
+	col.paths = append(col.paths, "/does/not/exist")
+	if err = col.Collect(); err == nil {
+		t.Error("expected collecting a path that does not exist to fail")
+	}
+}
+
+func TestDiskCollectorNames(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	for _, name := range []string{"disk.tmp", "disk.root"} {
+		col, err := NewDiskCollector(name, os.TempDir())
+		if err != nil {
+			t.Fatalf("cannot create collector %v, error: %v", name, err)
+		}
+
+		if err = c.RegisterCollector(col); err != nil {
+			t.Fatalf("cannot register collector %v, error: %v", name, err)
+		}
+
+		if !c.Registry().HasMetric(name + ".bytes.free") {
+			t.Errorf("expected %v.bytes.free to be registered", name)
+		}
+
+		if !c.Registry().HasInstanceDomain(name + ".paths") {
+			t.Errorf("expected instance domain %v.paths to be registered", name)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package speed
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	builtinCollectors["fd"] = func(c *PCPClient, conf *ClientConfig) error {
+		f, err := NewFDCollector()
+		if err != nil {
+			return err
+		}
+		return c.RegisterCollector(f)
+	}
+}
+
+// types of file descriptors counted by FDCollector
+var fdTypes = []string{"file", "socket", "pipe", "other"}
+
+// TCP connection states as numbered in the kernel's /proc/net/tcp
+//
+// see: https://github.com/torvalds/linux/blob/master/include/net/tcp_states.h
+var tcpStates = []string{
+	"", // states start at 1
+	"established",
+	"syn_sent",
+	"syn_recv",
+	"fin_wait1",
+	"fin_wait2",
+	"time_wait",
+	"close",
+	"close_wait",
+	"last_ack",
+	"listen",
+	"closing",
+}
+
+// FDCollector publishes the number of open file descriptors of the current
+// process by type as process.fd.count, and the states of its TCP connections
+// as process.tcp.connections.
+//
+// It reads /proc, so it is only available on linux.
+type FDCollector struct {
+	fds, tcp *PCPInstanceMetric
+}
+
+// NewFDCollector creates a new FDCollector.
+func NewFDCollector() (*FDCollector, error) {
+	fds, err := newCountMetric(
+		"process.fd.count", fdTypes,
+		"open file descriptors",
+		"Number of file descriptors currently opened by the process, by type",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	tcp, err := newCountMetric(
+		"process.tcp.connections", tcpStates[1:],
+		"TCP connections by state",
+		"Number of TCP sockets opened by the process, by connection state",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FDCollector{fds, tcp}, nil
+}
+
+// Metrics returns the metrics updated by the collector.
+func (f *FDCollector) Metrics() []Metric { return []Metric{f.fds, f.tcp} }
+
+// Collect counts the open file descriptors and TCP connections.
+func (f *FDCollector) Collect() error {
+	fds, sockets, err := readFDs()
+	if err != nil {
+		return err
+	}
+
+	for _, t := range fdTypes {
+		if err = f.fds.SetInstance(fds[t], t); err != nil {
+			return err
+		}
+	}
+
+	states := make([]uint64, len(tcpStates))
+	for _, file := range []string{"/proc/self/net/tcp", "/proc/self/net/tcp6"} {
+		if err = readTCPStates(file, sockets, states); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	for i, s := range tcpStates[1:] {
+		if err = f.tcp.SetInstance(states[i+1], s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readFDs counts the open file descriptors of the process by type,
+// and returns the inodes of all open sockets.
+func readFDs() (map[string]uint64, map[string]bool, error) {
+	const dir = "/proc/self/fd"
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = d.Close() }()
+
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	self := strconv.Itoa(int(d.Fd()))
+	fds, sockets := make(map[string]uint64), make(map[string]bool)
+
+	for _, name := range names {
+		if name == self {
+			continue
+		}
+
+		link, err := os.Readlink(filepath.Join(dir, name))
+		if err != nil {
+			// closed since the directory was read
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(link, "socket:["):
+			fds["socket"]++
+			sockets[link[len("socket:["):len(link)-1]] = true
+		case strings.HasPrefix(link, "pipe:["):
+			fds["pipe"]++
+		case strings.HasPrefix(link, "/"):
+			fds["file"]++
+		default:
+			fds["other"]++
+		}
+	}
+
+	return fds, sockets, nil
+}
+
+// readTCPStates adds the states of all sockets in the passed /proc/net/tcp
+// formatted file having an inode in sockets to states.
+func readTCPStates(file string, sockets map[string]bool, states []uint64) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+
+	// header
+	scanner.Scan()
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || !sockets[fields[9]] {
+			continue
+		}
+
+		st, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil || st == 0 || int(st) >= len(states) {
+			continue
+		}
+
+		states[st]++
+	}
+
+	return scanner.Err()
+}
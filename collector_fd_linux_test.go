@@ -0,0 +1,51 @@
+package speed
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+func TestFDCollector(t *testing.T) {
+	col, err := NewFDCollector()
+	if err != nil {
+		t.Fatalf("cannot create collector, error: %v", err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("cannot create pipe, error: %v", err)
+	}
+	defer func() { _, _ = r.Close(), w.Close() }()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen, error: %v", err)
+	}
+	defer func() { _ = l.Close() }()
+
+	if err = col.Collect(); err != nil {
+		t.Fatalf("cannot collect, error: %v", err)
+	}
+
+	cases := []struct {
+		m        *PCPInstanceMetric
+		instance string
+		min      uint64
+	}{
+		{col.fds, "pipe", 2},
+		{col.fds, "socket", 1},
+		{col.tcp, "listen", 1},
+	}
+
+	for _, c := range cases {
+		v, err := c.m.ValInstance(c.instance)
+		if err != nil {
+			t.Fatalf("cannot get %v[%v], error: %v", c.m.Name(), c.instance, err)
+		}
+
+		if v.(uint64) < c.min {
+			t.Errorf("expected %v[%v] to be at least %v, got %v", c.m.Name(), c.instance, c.min, v)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package speed
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+func init() {
+	builtinCollectors["prometheus"] = func(c *PCPClient, conf *ClientConfig) error {
+		p, err := NewPrometheusCollector(conf.PrometheusURL)
+		if err != nil {
+			return err
+		}
+		return c.RegisterCollector(p)
+	}
+}
+
+// PrometheusScrapeTimeout is the timeout of a scrape of a PrometheusCollector.
+var PrometheusScrapeTimeout = 10 * time.Second
+
+// PrometheusSkippedMetricName is the name of the counter registered by a PrometheusCollector,
+// counting samples of series that did not exist when the collector was created.
+const PrometheusSkippedMetricName = "prometheus.skipped"
+
+// PrometheusCollector republishes the samples of a Prometheus /metrics endpoint, for
+// monitoring applications that only expose Prometheus metrics, like closed source binaries,
+// using PCP. It scrapes the endpoint every time it is collected.
+//
+// Every sample name becomes a metric of type DoubleType, so a histogram or a summary x
+// becomes the metrics x, x_sum, x_count and x_bucket. Samples of counters, and the sums,
+// counts and buckets of histograms and summaries have counter semantics, and all other
+// samples have instant semantics. Metrics of samples with labels have an instance
+// for every label set, named like code=200,method=get, with labels ordered by name.
+//
+// The metrics and their instances are created from a scrape made by NewPrometheusCollector,
+// and are not changed by later scrapes, so samples of series that appear later are not
+// published. They are counted by a counter registered under PrometheusSkippedMetricName.
+type PrometheusCollector struct {
+	url    string
+	client *http.Client
+
+	metrics map[string]Metric // metrics by the name of their samples
+	order   []Metric          // metrics ordered by name
+	skipped *PCPCounter
+}
+
+// NewPrometheusCollector creates a PrometheusCollector republishing the samples
+// of the endpoint at url, creating the metrics from a first scrape of it.
+func NewPrometheusCollector(url string) (*PrometheusCollector, error) {
+	if url == "" {
+		return nil, errors.New("a URL is required to collect Prometheus metrics")
+	}
+
+	p := &PrometheusCollector{
+		url:     url,
+		client:  &http.Client{Timeout: PrometheusScrapeTimeout},
+		metrics: make(map[string]Metric),
+	}
+
+	samples, families, err := p.scrape()
+	if err != nil {
+		return nil, err
+	}
+
+	// the values of every label set of every sample name, and the first sample of every name
+	instances := make(map[string]Instances)
+	first := make(map[string]promSample)
+
+	var names []string
+	for _, s := range samples {
+		if _, ok := first[s.name]; !ok {
+			first[s.name] = s
+			names = append(names, s.name)
+		}
+
+		if s.labels != "" {
+			if instances[s.name] == nil {
+				instances[s.name] = make(Instances)
+			}
+			instances[s.name][s.labels] = s.value
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		m, err := newPrometheusMetric(first[name], first[name].family(families), instances[name])
+		if err != nil {
+			return nil, err
+		}
+
+		p.metrics[name] = m
+		p.order = append(p.order, m)
+	}
+
+	p.skipped, err = NewPCPCounter(0, PrometheusSkippedMetricName, "number of samples of series that appeared after the collector was created")
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// newPrometheusMetric creates the metric publishing the samples named like s
+func newPrometheusMetric(s promSample, f *promFamily, vals Instances) (Metric, error) {
+	name := strings.Replace(s.name, ":", "_", -1)
+
+	var desc []string
+	if f != nil && f.help != "" {
+		help := f.help
+		if n := StringLength - 1; len(help) > n {
+			// truncate without splitting a multi-byte character
+			for n > 0 && !utf8.RuneStart(help[n]) {
+				n--
+			}
+			help = help[:n]
+		}
+		desc = append(desc, help)
+	}
+
+	sem, unit := prometheusSemantics(s.name, f)
+
+	if len(vals) == 0 {
+		return NewPCPSingletonMetric(s.value, name, DoubleType, sem, unit, desc...)
+	}
+
+	indom, err := NewPCPInstanceDomain(name, vals.Keys())
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPCPInstanceMetric(vals, name, indom, DoubleType, sem, unit, desc...)
+}
+
+// prometheusSemantics returns the semantics and the unit of the samples with the passed name
+// of a family, guessing the unit from the suffixes recommended by the Prometheus naming conventions
+func prometheusSemantics(name string, f *promFamily) (MetricSemantics, MetricUnit) {
+	if f == nil {
+		return InstantSemantics, OneUnit
+	}
+
+	sem := InstantSemantics
+	if f.typ == "counter" {
+		sem = CounterSemantics
+	}
+
+	if f.typ == "histogram" || f.typ == "summary" {
+		if strings.HasSuffix(name, "_count") || strings.HasSuffix(name, "_bucket") {
+			return CounterSemantics, OneUnit
+		}
+
+		if strings.HasSuffix(name, "_sum") {
+			sem = CounterSemantics
+		}
+	}
+
+	base := strings.TrimSuffix(f.name, "_total")
+	switch {
+	case strings.HasSuffix(base, "_seconds"):
+		return sem, SecondUnit
+	case strings.HasSuffix(base, "_bytes"):
+		return sem, ByteUnit
+	}
+
+	return sem, OneUnit
+}
+
+// scrape fetches and parses the samples of the endpoint
+func (p *PrometheusCollector) scrape() ([]promSample, map[string]*promFamily, error) {
+	resp, err := p.client.Get(p.url)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("cannot scrape %v: %v", p.url, resp.Status)
+	}
+
+	samples, families, err := parsePrometheus(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot parse the metrics of %v: %v", p.url, err)
+	}
+
+	return samples, families, nil
+}
+
+// Metrics returns the metrics updated by the collector.
+func (p *PrometheusCollector) Metrics() []Metric {
+	return append(append([]Metric(nil), p.order...), p.skipped)
+}
+
+// Collect scrapes the endpoint and updates the metrics with its samples.
+func (p *PrometheusCollector) Collect() error {
+	samples, _, err := p.scrape()
+	if err != nil {
+		return err
+	}
+
+	skipped := int64(0)
+	for _, s := range samples {
+		switch m := p.metrics[s.name].(type) {
+		case *PCPSingletonMetric:
+			if s.labels != "" {
+				skipped++
+			} else if err = m.Set(s.value); err != nil {
+				return err
+			}
+		case *PCPInstanceMetric:
+			if s.labels == "" || !m.Indom().HasInstance(s.labels) {
+				skipped++
+			} else if err = m.SetInstance(s.value, s.labels); err != nil {
+				return err
+			}
+		default:
+			skipped++
+		}
+	}
+
+	if skipped > 0 {
+		return p.skipped.Inc(skipped)
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package speed
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const promExposition = `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3 1395066363000
+
+# Escaping in label values:
+msdos_file_access_time_seconds{path="C:\\DIR\\FILE.TXT",error="Cannot find file:\n\"FILE.TXT\""} 1.458255915e9
+
+# Minimalistic line:
+metric_without_timestamp_and_labels 12.47
+
+# A weird metric from before the epoch:
+something_weird{problem="division by zero"} +Inf -3982045
+
+# A histogram, which has a pretty complex representation in the text format:
+# HELP http_request_duration_seconds A histogram of the request duration.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.05"} 24054
+http_request_duration_seconds_bucket{le="+Inf"} 144320
+http_request_duration_seconds_sum 53423
+http_request_duration_seconds_count 144320
+
+# HELP process_resident_memory_bytes Resident memory size in bytes.
+# TYPE process_resident_memory_bytes gauge
+process_resident_memory_bytes %v
+`
+
+func TestParsePrometheus(t *testing.T) {
+	samples, families, err := parsePrometheus(strings.NewReader(fmt.Sprintf(promExposition, 100)))
+	if err != nil {
+		t.Fatalf("cannot parse, error: %v", err)
+	}
+
+	if len(samples) != 10 {
+		t.Fatalf("expected 10 samples, got %v", len(samples))
+	}
+
+	if s := samples[1]; s.name != "http_requests_total" || s.labels != "code=400,method=post" || s.value != 3 {
+		t.Errorf("expected labels ordered by name, got %+v", s)
+	}
+
+	if s := samples[2]; s.labels != `error=Cannot find file:`+"\n"+`"FILE.TXT",path=C:\DIR\FILE.TXT` {
+		t.Errorf("expected escaped label values to be unescaped, got %q", s.labels)
+	}
+
+	if s := samples[4]; !math.IsInf(s.value, 1) {
+		t.Errorf("expected +Inf, got %v", s.value)
+	}
+
+	if f := samples[6].family(families); f == nil || f.name != "http_request_duration_seconds" || f.typ != "histogram" {
+		t.Errorf("expected buckets to belong to the histogram family, got %+v", f)
+	}
+
+	if f := families["http_requests_total"]; f.help != "The total number of HTTP requests." {
+		t.Errorf("expected the help text of the counter, got %q", f.help)
+	}
+
+	for _, invalid := range []string{`a{b="c} 1`, `a{b=c} 1`, `a`, `a b`, `{a="b"} 1`} {
+		if _, _, err = parsePrometheus(strings.NewReader(invalid)); err == nil {
+			t.Errorf("expected parsing %q to fail", invalid)
+		}
+	}
+}
+
+func TestPrometheusCollector(t *testing.T) {
+	rss := int64(100)
+	extra := int32(0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, promExposition, atomic.LoadInt64(&rss))
+		if atomic.LoadInt32(&extra) != 0 {
+			fmt.Fprintln(w, `http_requests_total{method="get",code="200"} 1`)
+		}
+	}))
+	defer server.Close()
+
+	if _, err := NewPrometheusCollector(""); err == nil {
+		t.Errorf("expected creating a collector without a URL to fail")
+	}
+
+	p, err := NewPrometheusCollector(server.URL)
+	if err != nil {
+		t.Fatalf("cannot create collector, error: %v", err)
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	c.MustRegisterCollector(p)
+
+	requests := p.metrics["http_requests_total"].(*PCPInstanceMetric)
+	if requests.Semantics() != CounterSemantics || requests.ShortDescription() != "The total number of HTTP requests." {
+		t.Errorf("expected a counter with the help text as description")
+	}
+
+	if v, err := requests.ValInstance("code=200,method=post"); err != nil || v != float64(1027) {
+		t.Errorf("expected the first scrape to set the values, got %v, error: %v", v, err)
+	}
+
+	rssm := p.metrics["process_resident_memory_bytes"].(*PCPSingletonMetric)
+	if rssm.Unit() != ByteUnit || rssm.Semantics() != InstantSemantics {
+		t.Errorf("expected an instant metric in bytes, got %v and %v", rssm.Unit(), rssm.Semantics())
+	}
+
+	if count := p.metrics["http_request_duration_seconds_count"]; count.Semantics() != CounterSemantics || count.Unit() != OneUnit {
+		t.Errorf("expected the count of a histogram to be a counter of events")
+	}
+
+	atomic.StoreInt64(&rss, 200)
+	atomic.StoreInt32(&extra, 1)
+
+	if err = p.Collect(); err != nil {
+		t.Fatalf("cannot collect, error: %v", err)
+	}
+
+	if v := rssm.Val(); v != float64(200) {
+		t.Errorf("expected the gauge to be updated to 200, got %v", v)
+	}
+
+	if v := p.skipped.Val(); v != 1 {
+		t.Errorf("expected the sample of a new series to be skipped, got %v", v)
+	}
+}
+
+func TestPrometheusLongHelp(t *testing.T) {
+	// the multi-byte character straddles the length limit of descriptions
+	help := strings.Repeat("a", StringLength-2) + "é" + "a"
+
+	m, err := newPrometheusMetric(promSample{name: "long_help"}, &promFamily{"long_help", help, "gauge"}, nil)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	if desc := m.(*PCPSingletonMetric).ShortDescription(); desc != help[:StringLength-2] {
+		t.Errorf("expected the description to be cut before the multi-byte character, got %q", desc)
+	}
+}
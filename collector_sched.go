@@ -0,0 +1,130 @@
+//go:build go1.16
+// +build go1.16
+
+package speed
+
+import (
+	"math"
+	"runtime/metrics"
+)
+
+func init() {
+	builtinCollectors["sched"] = func(c *PCPClient, conf *ClientConfig) error {
+		s, err := NewSchedLatencyCollector()
+		if err != nil {
+			return err
+		}
+		return c.RegisterCollector(s)
+	}
+}
+
+// schedLatencySample is the runtime/metrics key for the scheduling latency histogram
+const schedLatencySample = "/sched/latencies:seconds"
+
+// quantiles of the scheduling latency published by SchedLatencyCollector
+var schedLatencyQuantiles = []struct {
+	instance string
+	q        float64
+}{
+	{"p50", 0.5},
+	{"p90", 0.9},
+	{"p99", 0.99},
+	{"max", 1},
+}
+
+// SchedLatencyCollector publishes percentiles of the time goroutines spend
+// in the runnable state before actually running, as reported by the runtime.
+//
+// The values are published in microseconds as the instances of a single metric,
+// named runtime.sched.latency.
+type SchedLatencyCollector struct {
+	metric  *PCPInstanceMetric
+	samples []metrics.Sample
+}
+
+// NewSchedLatencyCollector creates a new SchedLatencyCollector.
+func NewSchedLatencyCollector() (*SchedLatencyCollector, error) {
+	instances := make([]string, len(schedLatencyQuantiles))
+	vals := make(Instances)
+	for i, q := range schedLatencyQuantiles {
+		instances[i] = q.instance
+		vals[q.instance] = float64(0)
+	}
+
+	indom, err := NewPCPInstanceDomain("runtime.sched.latency.indom", instances)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := NewPCPInstanceMetric(
+		vals,
+		"runtime.sched.latency",
+		indom,
+		DoubleType,
+		InstantSemantics,
+		MicrosecondUnit,
+		"goroutine scheduling latency",
+		"Percentiles of the time goroutines have spent in the runnable state before running",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SchedLatencyCollector{
+		metric:  m,
+		samples: []metrics.Sample{{Name: schedLatencySample}},
+	}, nil
+}
+
+// Metrics returns the metrics updated by the collector.
+func (s *SchedLatencyCollector) Metrics() []Metric { return []Metric{s.metric} }
+
+// Collect reads the current scheduling latency histogram and updates the percentiles.
+func (s *SchedLatencyCollector) Collect() error {
+	metrics.Read(s.samples)
+
+	if s.samples[0].Value.Kind() != metrics.KindFloat64Histogram {
+		// not supported by the running go version
+		return nil
+	}
+
+	h := s.samples[0].Value.Float64Histogram()
+	for _, q := range schedLatencyQuantiles {
+		if err := s.metric.SetInstance(histogramQuantile(h, q.q)*1e6, q.instance); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// histogramQuantile returns an upper bound of the value at quantile q in h.
+func histogramQuantile(h *metrics.Float64Histogram, q float64) float64 {
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	target := uint64(math.Ceil(q * float64(total)))
+	if target == 0 {
+		target = 1
+	}
+
+	var seen uint64
+	for i, c := range h.Counts {
+		seen += c
+		if seen >= target {
+			// the last bucket can be unbounded
+			if math.IsInf(h.Buckets[i+1], 1) {
+				return h.Buckets[i]
+			}
+			return h.Buckets[i+1]
+		}
+	}
+
+	return 0
+}
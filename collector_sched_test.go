@@ -0,0 +1,60 @@
+//go:build go1.16
+// +build go1.16
+
+package speed
+
+import (
+	"math"
+	"runtime/metrics"
+	"testing"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	h := &metrics.Float64Histogram{
+		Counts:  []uint64{5, 4, 1},
+		Buckets: []float64{0, 1, 2, math.Inf(1)},
+	}
+
+	cases := []struct {
+		q, expected float64
+	}{
+		{0.5, 1},
+		{0.9, 2},
+		{0.99, 2},
+		{1, 2},
+	}
+
+	for _, c := range cases {
+		if v := histogramQuantile(h, c.q); v != c.expected {
+			t.Errorf("expected quantile %v to be %v, got %v", c.q, c.expected, v)
+		}
+	}
+
+	if v := histogramQuantile(&metrics.Float64Histogram{Counts: []uint64{0}, Buckets: []float64{0, 1}}, 0.5); v != 0 {
+		t.Errorf("expected quantile of an empty histogram to be 0, got %v", v)
+	}
+}
+
+func TestSchedLatencyCollector(t *testing.T) {
+	col, err := NewSchedLatencyCollector()
+	if err != nil {
+		t.Fatalf("cannot create collector, error: %v", err)
+	}
+
+	if err = col.Collect(); err != nil {
+		t.Fatalf("cannot collect, error: %v", err)
+	}
+
+	prev := float64(0)
+	for _, q := range schedLatencyQuantiles {
+		v, err := col.metric.ValInstance(q.instance)
+		if err != nil {
+			t.Fatalf("cannot get value for %v, error: %v", q.instance, err)
+		}
+
+		if v.(float64) < prev {
+			t.Errorf("expected %v to be at least %v, got %v", q.instance, prev, v)
+		}
+		prev = v.(float64)
+	}
+}
@@ -0,0 +1,142 @@
+package speed
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type countingCollector struct {
+	m     *PCPCounter
+	mutex sync.Mutex
+	runs  int
+}
+
+func (c *countingCollector) Metrics() []Metric { return []Metric{c.m} }
+
+func (c *countingCollector) Collect() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.runs++
+	return c.m.Inc(1)
+}
+
+func (c *countingCollector) count() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.runs
+}
+
+func TestCollector(t *testing.T) {
+	m, err := NewPCPCounter(0, "collected")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	col := &countingCollector{m: m}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	c.MustRegisterCollector(col)
+	if !c.Registry().HasMetric("collected") {
+		t.Error("expected the collector's metrics to be registered")
+	}
+
+	clock := NewManualClock(time.Unix(0, 0))
+	if err = c.SetClock(clock); err != nil {
+		t.Fatalf("cannot set clock, error: %v", err)
+	}
+
+	c.MustStart()
+
+	if col.count() != 1 {
+		t.Errorf("expected collectors to run on Start, ran %v times", col.count())
+	}
+
+	clock.Advance(DefaultCollectInterval / 2)
+	if col.count() != 1 {
+		t.Errorf("expected collectors to not run before the interval, ran %v times", col.count())
+	}
+
+	clock.Advance(5*DefaultCollectInterval/2 - DefaultCollectInterval/2)
+	if col.count() != 3 {
+		t.Errorf("expected collectors to run at every interval, ran %v times", col.count())
+	}
+
+	c.MustStop()
+
+	runs := col.count()
+	clock.Advance(10 * DefaultCollectInterval)
+	if col.count() != runs {
+		t.Error("expected collectors to not run after Stop")
+	}
+
+	if m.Val() != int64(runs) {
+		t.Errorf("expected counter to be %v, got %v", runs, m.Val())
+	}
+}
+
+// instanceCollector adds an instance to the domain of its metric on every run
+type instanceCollector struct {
+	m     *PCPInstanceMetric
+	indom *PCPInstanceDomain
+	runs  int
+}
+
+func (c *instanceCollector) Metrics() []Metric { return []Metric{c.m} }
+
+func (c *instanceCollector) Collect() error {
+	c.runs++
+	return c.indom.AddInstance(fmt.Sprintf("run%d", c.runs))
+}
+
+func TestCollectorUsingClient(t *testing.T) {
+	indom, err := NewPCPInstanceDomain("runs", []string{"run0"})
+	if err != nil {
+		t.Fatalf("cannot create instance domain, error: %v", err)
+	}
+
+	m, err := NewPCPInstanceMetricWithDefault(uint64(0), "runs.seen", indom, Uint64Type, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	clock := NewManualClock(time.Unix(0, 0))
+	if err = c.SetClock(clock); err != nil {
+		t.Fatalf("cannot set clock, error: %v", err)
+	}
+
+	c.MustRegisterCollector(&instanceCollector{m: m, indom: indom})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		c.MustStart()
+		clock.Advance(2 * DefaultCollectInterval)
+		c.MustStop()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected a collector adding instances to not deadlock the client")
+	}
+
+	for _, i := range []string{"run1", "run2", "run3"} {
+		if !indom.HasInstance(i) {
+			t.Errorf("expected the collector to add instance %v", i)
+		}
+	}
+}
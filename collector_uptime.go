@@ -0,0 +1,63 @@
+package speed
+
+import "time"
+
+// processStart approximates the start time of the process
+// by the time the package was initialized
+var processStart = time.Now()
+
+// UptimeCollector publishes the start time of the process as process.starttime,
+// in seconds since the unix epoch, and the time elapsed since then as process.uptime.
+type UptimeCollector struct {
+	starttime *PCPSingletonMetric
+	uptime    *PCPSingletonMetric
+}
+
+// NewUptimeCollector creates a new UptimeCollector.
+func NewUptimeCollector() (*UptimeCollector, error) {
+	starttime, err := NewPCPSingletonMetric(
+		processStart.Unix(),
+		"process.starttime",
+		Int64Type,
+		DiscreteSemantics,
+		SecondUnit,
+		"process start time",
+		"Time the process started at, in seconds since the unix epoch",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	uptime, err := NewPCPSingletonMetric(
+		float64(0),
+		"process.uptime",
+		DoubleType,
+		InstantSemantics,
+		SecondUnit,
+		"process uptime",
+		"Seconds elapsed since the process started",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UptimeCollector{starttime, uptime}, nil
+}
+
+// Metrics returns the metrics updated by the collector.
+func (u *UptimeCollector) Metrics() []Metric { return []Metric{u.starttime, u.uptime} }
+
+// Collect updates the uptime.
+func (u *UptimeCollector) Collect() error {
+	return u.uptime.Set(time.Since(processStart).Seconds())
+}
+
+// RegisterUptime registers an UptimeCollector with the passed client.
+func RegisterUptime(c *PCPClient) error {
+	col, err := NewUptimeCollector()
+	if err != nil {
+		return err
+	}
+
+	return c.RegisterCollector(col)
+}
@@ -0,0 +1,29 @@
+package speed
+
+import "testing"
+
+func TestUptimeCollector(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	if err = RegisterUptime(c); err != nil {
+		t.Fatalf("cannot register uptime, error: %v", err)
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	m := c.r.metrics["process.uptime"].(*PCPSingletonMetric)
+	if m.Val().(float64) <= 0 {
+		t.Errorf("expected uptime to be set on Start, got %v", m.Val())
+	}
+
+	if m.Unit() != SecondUnit {
+		t.Errorf("expected uptime to be in seconds, got %v", m.Unit())
+	}
+
+	s := c.r.metrics["process.starttime"].(*PCPSingletonMetric)
+	matchSingleDump(processStart.Unix(), s, c, t)
+}
@@ -0,0 +1,310 @@
+package speed
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Control endpoint
+//
+// The control endpoint administers a running client over JSON-RPC 2.0, with
+// requests POSTed as JSON objects like
+//
+//	{"jsonrpc": "2.0", "id": 1, "method": "metrics.reset", "params": {"names": ["http"]}}
+//
+// The methods are
+//
+//	metrics.list        params {"name": n}, lists the metrics named by or under n, or all metrics
+//	metrics.reset       params {"names": [...]}, resets the metrics named by or under the names
+//	metrics.describe    params {"name": n, "short": s, "long": l}, sets the help text of a metric
+//	collectors.list     lists the collectors and whether they are enabled
+//	collectors.enable   params {"name": n}, runs a disabled collector again
+//	collectors.disable  params {"name": n}, stops running a collector
+//
+// Unlike the debug handler, it changes the client, so it is only served to trusted
+// callers, either on a unix socket only its owner can connect to, see ServeControl,
+// or to loopback addresses presenting a token, see ControlHandler.
+
+// JSON-RPC 2.0 error codes
+const (
+	controlParseError     = -32700
+	controlInvalidRequest = -32600
+	controlUnknownMethod  = -32601
+	controlInvalidParams  = -32602
+	controlFailed         = -32000
+)
+
+// controlRequest is a JSON-RPC request to the control endpoint
+type controlRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// controlError is the error of a failed JSON-RPC request
+type controlError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// controlResponse is a JSON-RPC response of the control endpoint
+type controlResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *controlError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// controlParams are the parameters of all control methods
+type controlParams struct {
+	Name  string   `json:"name"`
+	Names []string `json:"names"`
+	Short string   `json:"short"`
+	Long  string   `json:"long"`
+}
+
+// controlMethods are the methods of the control endpoint by name
+var controlMethods = map[string]func(c *PCPClient, p *controlParams) (interface{}, error){
+	"metrics.list": func(c *PCPClient, p *controlParams) (interface{}, error) {
+		metrics := c.debugMetrics(func(n string) bool { return p.Name == "" || inNamespace(n, p.Name) })
+		if p.Name != "" && len(metrics) == 0 {
+			return nil, fmt.Errorf("no metric is named %v or is under it", p.Name)
+		}
+		return metrics, nil
+	},
+	"metrics.reset": func(c *PCPClient, p *controlParams) (interface{}, error) {
+		if len(p.Names) == 0 {
+			return nil, errControlParams("names")
+		}
+
+		metrics, err := c.namedMetrics(p.Names)
+		if err != nil {
+			return nil, err
+		}
+
+		var reset []string
+		for _, m := range metrics {
+			r, ok := m.(resetter)
+			if !ok {
+				return nil, fmt.Errorf("metric %v cannot be reset", m.Name())
+			}
+
+			if err = r.reset(); err != nil {
+				return nil, fmt.Errorf("cannot reset metric %v: %v", m.Name(), err)
+			}
+			reset = append(reset, m.Name())
+		}
+
+		return reset, nil
+	},
+	"metrics.describe": func(c *PCPClient, p *controlParams) (interface{}, error) {
+		if p.Name == "" {
+			return nil, errControlParams("name")
+		}
+
+		desc := []string{p.Short}
+		if p.Long != "" {
+			desc = append(desc, p.Long)
+		}
+
+		return p.Name, c.SetMetricDescription(p.Name, desc...)
+	},
+	"collectors.list": func(c *PCPClient, p *controlParams) (interface{}, error) {
+		return c.Collectors(), nil
+	},
+	"collectors.enable": func(c *PCPClient, p *controlParams) (interface{}, error) {
+		return p.Name, c.EnableCollector(p.Name)
+	},
+	"collectors.disable": func(c *PCPClient, p *controlParams) (interface{}, error) {
+		return p.Name, c.DisableCollector(p.Name)
+	},
+}
+
+// errControlParams is returned by control methods missing a required parameter
+type errControlParams string
+
+func (e errControlParams) Error() string {
+	return fmt.Sprintf("missing parameter %v", string(e))
+}
+
+// call runs a control request
+func (c *PCPClient) call(req *controlRequest) controlResponse {
+	resp := controlResponse{Version: "2.0", ID: req.ID}
+	if resp.ID == nil {
+		resp.ID = json.RawMessage("null")
+	}
+
+	fail := func(code int, err error) controlResponse {
+		resp.Error = &controlError{code, err.Error()}
+		return resp
+	}
+
+	if req.Version != "2.0" || req.Method == "" {
+		return fail(controlInvalidRequest, errors.New("expected a JSON-RPC 2.0 request"))
+	}
+
+	method, ok := controlMethods[req.Method]
+	if !ok {
+		return fail(controlUnknownMethod, fmt.Errorf("unknown method %v", req.Method))
+	}
+
+	var p controlParams
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			return fail(controlInvalidParams, err)
+		}
+	}
+
+	result, err := method(c, &p)
+	if _, missing := err.(errControlParams); missing {
+		return fail(controlInvalidParams, err)
+	} else if err != nil {
+		return fail(controlFailed, err)
+	}
+
+	resp.Result = result
+	return resp
+}
+
+// controlHandler returns a handler serving the control endpoint without authenticating callers
+func (c *PCPClient) controlHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "control requests are POSTed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var resp controlResponse
+		var req controlRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			resp = controlResponse{
+				Version: "2.0",
+				Error:   &controlError{controlParseError, err.Error()},
+				ID:      json.RawMessage("null"),
+			}
+		} else {
+			resp = c.call(&req)
+		}
+
+		if logging {
+			entry := controllogger.WithField("method", req.Method)
+			if resp.Error != nil {
+				entry.WithField("error", resp.Error.Message).Warn("control request failed")
+			} else {
+				entry.Info("control request")
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil && logging {
+			controllogger.WithField("error", err).Error("cannot write control response")
+		}
+	})
+}
+
+var controllogger = log.WithField("prefix", "control")
+
+// isLoopback returns true if a remote address of a request is a loopback address
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ControlHandler returns a handler serving the control endpoint of the client, which
+// lists metrics, resets their values, changes their help text and enables and disables
+// collectors, using the JSON-RPC methods metrics.list, metrics.reset, metrics.describe,
+// collectors.list, collectors.enable and collectors.disable.
+//
+// Requests are only served from loopback addresses, and must carry the passed token as
+//
+//	Authorization: Bearer <token>
+//
+// If token is empty, all requests are refused. Mount it on a server listening on
+// localhost, like
+//
+//	http.Handle("/admin/speed", c.ControlHandler(os.Getenv("SPEED_CONTROL_TOKEN")))
+func (c *PCPClient) ControlHandler(token string) http.Handler {
+	h := c.controlHandler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isLoopback(r.RemoteAddr) {
+			http.Error(w, "the control endpoint is only served to localhost", http.StatusForbidden)
+			return
+		}
+
+		auth := r.Header.Get("Authorization")
+		if token == "" || !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "a valid token is required", http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ServeControl serves the control endpoint of the client on a unix socket at path,
+// which only the user running the client can connect to, so requests are not
+// authenticated further. A stale socket left at path is replaced.
+//
+// It returns a Closer that stops serving and removes the socket, so it can be queried like
+//
+//	curl --unix-socket <path> -d '{"jsonrpc":"2.0","id":1,"method":"metrics.list"}' http://speed/
+func (c *PCPClient) ServeControl(path string) (io.Closer, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%v exists and is not a socket", path)
+		}
+
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.Chmod(path, 0600); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+
+	s := &controlServer{Listener: l}
+	go func() {
+		err := http.Serve(l, c.controlHandler())
+		if err != nil && logging && atomic.LoadInt32(&s.closed) == 0 {
+			controllogger.WithField("error", err).Error("control endpoint stopped")
+		}
+	}()
+
+	return s, nil
+}
+
+// controlServer is the listener of a control endpoint served by ServeControl
+type controlServer struct {
+	net.Listener
+	closed int32 // set atomically when the endpoint is stopped
+}
+
+// Close stops serving the control endpoint and removes its socket.
+func (s *controlServer) Close() error {
+	atomic.StoreInt32(&s.closed, 1)
+	return s.Listener.Close()
+}
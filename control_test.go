@@ -0,0 +1,153 @@
+package speed
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testCollector struct {
+	collected int
+}
+
+func (t *testCollector) Metrics() []Metric { return nil }
+
+func (t *testCollector) Collect() error {
+	t.collected++
+	return nil
+}
+
+func TestControlHandler(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	requests := newTestCounter(t, c, "http.requests")
+	errs := newTestCounter(t, c, "http.errors")
+	other := newTestCounter(t, c, "other")
+
+	col := &testCollector{}
+	c.MustRegisterCollector(col)
+	c.MustRegisterCollector(&testCollector{})
+
+	requests.Inc(5)
+	errs.Inc(2)
+	other.Inc(1)
+
+	h := c.ControlHandler("secret")
+
+	post := func(body, addr, token string) (*httptest.ResponseRecorder, controlResponse) {
+		r, err := http.NewRequest("POST", "/admin/speed", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("cannot create request, error: %v", err)
+		}
+		r.RemoteAddr = addr
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+
+		var resp controlResponse
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("cannot decode response, error: %v", err)
+			}
+		}
+		return rec, resp
+	}
+
+	call := func(method, params string) controlResponse {
+		body := `{"jsonrpc": "2.0", "id": 7, "method": "` + method + `"`
+		if params != "" {
+			body += `, "params": ` + params
+		}
+		_, resp := post(body+"}", "127.0.0.1:4321", "secret")
+		return resp
+	}
+
+	if rec, _ := post(`{"jsonrpc": "2.0", "method": "collectors.list"}`, "10.0.0.1:4321", "secret"); rec.Code != http.StatusForbidden {
+		t.Errorf("expected status %v for a remote caller, got %v", http.StatusForbidden, rec.Code)
+	}
+
+	if rec, _ := post(`{"jsonrpc": "2.0", "method": "collectors.list"}`, "[::1]:4321", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %v for a wrong token, got %v", http.StatusUnauthorized, rec.Code)
+	}
+
+	r, _ := http.NewRequest("POST", "/", bytes.NewBufferString(`{}`))
+	r.RemoteAddr = "127.0.0.1:4321"
+	rec := httptest.NewRecorder()
+	c.ControlHandler("").ServeHTTP(rec, r)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected an empty token to refuse all requests, got status %v", rec.Code)
+	}
+
+	resp := call("metrics.list", `{"name": "http"}`)
+	if resp.Error != nil || string(resp.ID) != "7" {
+		t.Fatalf("expected listing to succeed with the id of the request, got %+v", resp)
+	}
+	if metrics := resp.Result.([]interface{}); len(metrics) != 2 {
+		t.Errorf("expected the 2 metrics under http, got %v", metrics)
+	}
+
+	if resp = call("metrics.reset", `{"names": ["http"]}`); resp.Error != nil {
+		t.Fatalf("expected reset to succeed, got %v", resp.Error.Message)
+	}
+	if requests.Val() != 0 || errs.Val() != 0 || other.Val() != 1 {
+		t.Errorf("expected only the metrics under http to be reset, got %v, %v and %v", requests.Val(), errs.Val(), other.Val())
+	}
+
+	if resp = call("metrics.describe", `{"name": "other", "short": "something else"}`); resp.Error != nil {
+		t.Fatalf("expected describe to succeed, got %v", resp.Error.Message)
+	}
+	if other.ShortDescription() != "something else" {
+		t.Errorf("expected the description to be updated, got %q", other.ShortDescription())
+	}
+
+	if resp = call("collectors.disable", `{"name": "speed.testCollector"}`); resp.Error != nil {
+		t.Fatalf("expected disabling the collector to succeed, got %v", resp.Error.Message)
+	}
+	c.collectors.collect()
+	if col.collected != 0 {
+		t.Errorf("expected the disabled collector not to run, ran %v times", col.collected)
+	}
+
+	infos := c.Collectors()
+	if len(infos) != 2 || infos[0].Enabled || infos[1].Name != "speed.testCollector#2" || !infos[1].Enabled {
+		t.Errorf("unexpected collectors %+v", infos)
+	}
+
+	if resp = call("collectors.enable", `{"name": "speed.testCollector"}`); resp.Error != nil {
+		t.Fatalf("expected enabling the collector to succeed, got %v", resp.Error.Message)
+	}
+	c.collectors.collect()
+	if col.collected != 1 {
+		t.Errorf("expected the enabled collector to run once, ran %v times", col.collected)
+	}
+
+	cases := []struct {
+		method, params string
+		code           int
+	}{
+		{"metrics.drop", "", controlUnknownMethod},
+		{"metrics.reset", "", controlInvalidParams},
+		{"metrics.reset", `{"names": "http"}`, controlInvalidParams},
+		{"metrics.reset", `{"names": ["missing"]}`, controlFailed},
+		{"collectors.disable", `{"name": "missing"}`, controlFailed},
+	}
+
+	for _, tc := range cases {
+		resp = call(tc.method, tc.params)
+		if resp.Error == nil || resp.Error.Code != tc.code {
+			t.Errorf("expected %v %v to fail with code %v, got %+v", tc.method, tc.params, tc.code, resp.Error)
+		}
+	}
+
+	if _, resp = post(`{"jsonrpc": `, "127.0.0.1:4321", "secret"); resp.Error == nil || resp.Error.Code != controlParseError {
+		t.Errorf("expected a parse error, got %+v", resp.Error)
+	}
+}
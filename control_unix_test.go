@@ -0,0 +1,74 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package speed
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServeControl(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	newTestCounter(t, c, "requests")
+
+	dir, err := ioutil.TempDir("", "speed")
+	if err != nil {
+		t.Fatalf("cannot create directory, error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "control.sock")
+
+	s, err := c.ServeControl(path)
+	if err != nil {
+		t.Fatalf("cannot serve control endpoint, error: %v", err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("expected a socket only its owner can use, got %v, error: %v", fi, err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(_, _ string) (net.Conn, error) { return net.Dial("unix", path) },
+	}}
+
+	res, err := client.Post("http://speed/", "application/json",
+		bytes.NewBufferString(`{"jsonrpc": "2.0", "id": 1, "method": "metrics.list"}`))
+	if err != nil {
+		t.Fatalf("cannot call control endpoint, error: %v", err)
+	}
+
+	var resp controlResponse
+	err = json.NewDecoder(res.Body).Decode(&resp)
+	_ = res.Body.Close()
+	if err != nil || resp.Error != nil || len(resp.Result.([]interface{})) != 1 {
+		t.Errorf("expected to list a single metric, got %+v, error: %v", resp, err)
+	}
+
+	if err = s.Close(); err != nil {
+		t.Errorf("cannot stop control endpoint, error: %v", err)
+	}
+
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the socket to be removed, got %v", err)
+	}
+
+	if err = ioutil.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("cannot write file, error: %v", err)
+	}
+
+	if _, err = c.ServeControl(path); err == nil {
+		t.Errorf("expected serving on a regular file to fail")
+	}
+}
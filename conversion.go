@@ -0,0 +1,212 @@
+package speed
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+)
+
+// MaxStringValueLength is the length of the longest value a string metric can publish,
+// as MMV stores strings in fixed size blocks of StringLength bytes, including a null byte.
+const MaxStringValueLength = StringLength - 1
+
+// StringOverflow decides how values set for string metrics that are longer than
+// their maximum length are handled, see WithStringLength.
+type StringOverflow int32
+
+// values for StringOverflow
+const (
+	RejectLongStrings    StringOverflow = iota // the update fails with a *ConversionError, the default
+	TruncateLongStrings                        // the value is cut at the maximum length
+	EllipsizeLongStrings                       // the value is cut, ending with "..." so readers can tell
+)
+
+// ellipsis ends values cut by EllipsizeLongStrings
+const ellipsis = "..."
+
+// FloatConversion decides how floating point values set for metrics
+// of integer types, including untyped constants like 2.5, are converted.
+type FloatConversion int32
+
+// values for FloatConversion
+const (
+	RejectFloats   FloatConversion = iota // the update fails with a *ConversionError, the default
+	TruncateFloats                        // the value is truncated towards zero
+	RoundFloats                           // the value is rounded to the nearest integer, halfway values away from zero
+)
+
+// ConversionError is returned when a value cannot be converted
+// to the type of the metric it is set for.
+type ConversionError struct {
+	Metric string      // name of the metric, or of the instance of the form metric[instance]
+	Client string      // name of the client the metric is registered with
+	Value  interface{} // the value that was set
+	Type   MetricType  // type of the metric
+	Reason string      // why the value cannot be converted
+}
+
+func (e *ConversionError) Error() string {
+	err := fmt.Errorf("value %v(%T) cannot be converted to %v, %v", e.Value, e.Value, e.Type, e.Reason)
+	return describeFailure("set", e.Metric, e.Client, err)
+}
+
+// WithFloatConversion sets how floating point values set for a metric of an integer type
+// are converted. By default they are rejected, as the conversion loses precision.
+//
+// Values that are not finite or are out of the range of the type are always rejected,
+// and the option has no effect on metrics of floating point or string types.
+func WithFloatConversion(conv FloatConversion) RegisterOption {
+	return func(c *PCPClient, m Metric) error {
+		pm, ok := m.(PCPMetric)
+		if !ok {
+			return nil
+		}
+
+		if md := metricDesc(pm); md != nil {
+			atomic.StoreInt32(&md.floats, int32(conv))
+		}
+
+		return nil
+	}
+}
+
+// WithStringLength sets the maximum length of the values of a string metric, which is
+// MaxStringValueLength if max is not between 1 and MaxStringValueLength, and how longer
+// values are handled. By default they are rejected, as a cut value, like a URL, can look
+// valid while being wrong.
+//
+// Strings are cut without splitting a multi-byte character, and values the metric was
+// created with must always fit in MaxStringValueLength bytes. The option has no effect
+// on metrics of other types.
+func WithStringLength(max int, overflow StringOverflow) RegisterOption {
+	return func(c *PCPClient, m Metric) error {
+		pm, ok := m.(PCPMetric)
+		if !ok || pm.Type() != StringType {
+			return nil
+		}
+
+		if max < 1 || max > MaxStringValueLength {
+			max = MaxStringValueLength
+		}
+
+		if md := metricDesc(pm); md != nil {
+			atomic.StoreInt32(&md.maxString, int32(max))
+			atomic.StoreInt32(&md.overflow, int32(overflow))
+		}
+
+		return nil
+	}
+}
+
+// fitString returns a string value set for the metric, or one of its instances if instance
+// is not empty, after applying the StringOverflow of the metric if it is too long.
+func (md *pcpMetricDesc) fitString(val string, instance string) (interface{}, error) {
+	max := int(atomic.LoadInt32(&md.maxString))
+	if max == 0 {
+		max = MaxStringValueLength
+	}
+
+	if len(val) <= max {
+		return val, nil
+	}
+
+	switch StringOverflow(atomic.LoadInt32(&md.overflow)) {
+	case TruncateLongStrings:
+		return TruncateInstance(max)(val), nil
+	case EllipsizeLongStrings:
+		if max > len(ellipsis) {
+			return TruncateInstance(max-len(ellipsis))(val) + ellipsis, nil
+		}
+		return TruncateInstance(max)(val), nil
+	}
+
+	name := md.name
+	if instance != "" {
+		name = instanceName(md.name, instance)
+	}
+
+	return nil, &ConversionError{name, md.client, val, md.t, fmt.Sprintf("it is longer than %v bytes, see WithStringLength", max)}
+}
+
+// isInteger returns true if values of the type are integers.
+func (m MetricType) isInteger() bool {
+	return m == Int32Type || m == Uint32Type || m == Int64Type || m == Uint64Type
+}
+
+// convert converts a floating point val set for the metric, or one of its instances
+// if instance is not empty, to the metric's integer type using its FloatConversion.
+// Other values are returned as they are.
+func (md *pcpMetricDesc) convert(val interface{}, instance string) (interface{}, error) {
+	if s, ok := val.(string); ok && md.t == StringType {
+		return md.fitString(s, instance)
+	}
+
+	if !md.t.isInteger() {
+		return val, nil
+	}
+
+	var f float64
+	switch v := val.(type) {
+	case float32:
+		f = float64(v)
+	case float64:
+		f = v
+	default:
+		return val, nil
+	}
+
+	name := md.name
+	if instance != "" {
+		name = instanceName(md.name, instance)
+	}
+
+	fail := func(reason string) (interface{}, error) {
+		return nil, &ConversionError{name, md.client, val, md.t, reason}
+	}
+
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fail("it is not finite")
+	}
+
+	switch FloatConversion(atomic.LoadInt32(&md.floats)) {
+	case TruncateFloats:
+		f = math.Trunc(f)
+	case RoundFloats:
+		f = roundFloat(f)
+	default:
+		if f != math.Trunc(f) {
+			return fail("it is not an integer, see WithFloatConversion")
+		}
+	}
+
+	switch md.t {
+	case Int32Type:
+		if f >= math.MinInt32 && f <= math.MaxInt32 {
+			return int32(f), nil
+		}
+	case Uint32Type:
+		if f >= 0 && f <= math.MaxUint32 {
+			return uint32(f), nil
+		}
+	case Int64Type:
+		// 1<<63 is exactly representable, unlike math.MaxInt64
+		if f >= math.MinInt64 && f < 1<<63 {
+			return int64(f), nil
+		}
+	case Uint64Type:
+		if f >= 0 && f < 1<<64 {
+			return uint64(f), nil
+		}
+	}
+
+	return fail("it is out of range")
+}
+
+// roundFloat rounds f to the nearest integer, rounding halfway values away from zero.
+func roundFloat(f float64) float64 {
+	t := math.Trunc(f)
+	if math.Abs(f-t) >= 0.5 {
+		t += math.Copysign(1, f)
+	}
+	return t
+}
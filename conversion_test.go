@@ -0,0 +1,158 @@
+package speed
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestFloatConversion(t *testing.T) {
+	cases := []struct {
+		conv FloatConversion
+		t    MetricType
+		in   float64
+		out  interface{}
+	}{
+		{RejectFloats, Int32Type, 3, int32(3)},
+		{RejectFloats, Int64Type, 2.5, nil},
+		{TruncateFloats, Int64Type, 2.7, int64(2)},
+		{TruncateFloats, Int64Type, -2.7, int64(-2)},
+		{RoundFloats, Int64Type, 2.5, int64(3)},
+		{RoundFloats, Int64Type, -2.5, int64(-3)},
+		{RoundFloats, Uint32Type, 2.49, uint32(2)},
+		{RoundFloats, Uint32Type, -1, nil},
+		{TruncateFloats, Int32Type, math.MaxInt32 + 1, nil},
+		{TruncateFloats, Uint64Type, math.Inf(1), nil},
+		{RoundFloats, Int64Type, math.NaN(), nil},
+	}
+
+	for _, c := range cases {
+		desc, err := newpcpMetricDesc("m", c.t, InstantSemantics, OneUnit)
+		if err != nil {
+			t.Fatalf("cannot create metric description, error: %v", err)
+		}
+		desc.floats = int32(c.conv)
+
+		out, err := desc.convert(c.in, "")
+		if c.out == nil {
+			if _, ok := err.(*ConversionError); !ok {
+				t.Errorf("expected converting %v to %v with %v to fail with a *ConversionError, got %v, %v", c.in, c.t, c.conv, out, err)
+			}
+			continue
+		}
+
+		if err != nil || out != c.out {
+			t.Errorf("expected converting %v to %v with %v to return %v, got %v, %v", c.in, c.t, c.conv, c.out, out, err)
+		}
+	}
+}
+
+func TestWithFloatConversion(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	m, err := NewPCPSingletonMetric(int32(0), "m", Int32Type, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	indom, err := NewPCPInstanceDomain("indom", []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("cannot create instance domain, error: %v", err)
+	}
+
+	v, err := NewPCPInstanceMetric(Instances{"a": 0, "b": 0}, "v", indom, Int64Type, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	c.MustRegister(m)
+	c.MustRegister(v, WithFloatConversion(RoundFloats))
+
+	err = m.Set(1.5)
+	if cerr, ok := err.(*ConversionError); !ok || cerr.Metric != "m" || cerr.Client != "test" {
+		t.Errorf("expected a *ConversionError naming the metric and client, got %v", err)
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	v.MustSetInstance(1.5, "a")
+	if val, err := mmvdump.Lookup(c.writer.Bytes(), "v[a]"); err != nil || val != int64(2) {
+		t.Errorf("expected the rounded value 2 to be written, got %v, %v", val, err)
+	}
+
+	perr := recoverPanicError(t, func() { v.MustSetInstance(math.NaN(), "b") })
+	if _, ok := perr.Err.(*ConversionError); !ok || perr.Name != "v[b]" {
+		t.Errorf("expected a panic with a *ConversionError for v[b], got %+v", perr)
+	}
+}
+
+func TestStringLength(t *testing.T) {
+	long := strings.Repeat("a", 8) + "é"
+
+	cases := []struct {
+		max      int
+		overflow StringOverflow
+		in       string
+		out      interface{}
+	}{
+		{0, RejectLongStrings, long, long},
+		{0, RejectLongStrings, strings.Repeat("a", MaxStringValueLength+1), nil},
+		{9, RejectLongStrings, long, nil},
+		{9, TruncateLongStrings, long, "aaaaaaaa"},
+		{10, TruncateLongStrings, long, long},
+		{9, EllipsizeLongStrings, long, "aaaaaa..."},
+		{2, EllipsizeLongStrings, long, "aa"},
+		{0, TruncateLongStrings, strings.Repeat("a", StringLength), strings.Repeat("a", MaxStringValueLength)},
+	}
+
+	for _, c := range cases {
+		desc, err := newpcpMetricDesc("m", StringType, InstantSemantics, OneUnit)
+		if err != nil {
+			t.Fatalf("cannot create metric description, error: %v", err)
+		}
+		desc.maxString, desc.overflow = int32(c.max), int32(c.overflow)
+
+		out, err := desc.convert(c.in, "")
+		if c.out == nil {
+			if _, ok := err.(*ConversionError); !ok {
+				t.Errorf("expected fitting %q in %v bytes with %v to fail with a *ConversionError, got %v, %v", c.in, c.max, c.overflow, out, err)
+			}
+			continue
+		}
+
+		if err != nil || out != c.out {
+			t.Errorf("expected fitting %q in %v bytes with %v to return %q, got %v, %v", c.in, c.max, c.overflow, c.out, out, err)
+		}
+	}
+}
+
+func TestWithStringLength(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	if _, err = NewPCPSingletonMetric(strings.Repeat("a", StringLength), "m", StringType, InstantSemantics, OneUnit); err == nil {
+		t.Error("expected creating a metric with a value longer than MaxStringValueLength to fail")
+	}
+
+	m, err := NewPCPSingletonMetric("", "m", StringType, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	c.MustRegister(m, WithStringLength(16, EllipsizeLongStrings))
+	c.MustStart()
+	defer c.MustStop()
+
+	m.MustSet("https://example.com/a/long/path")
+	if val, err := mmvdump.Lookup(c.writer.Bytes(), "m"); err != nil || val != "https://examp..." {
+		t.Errorf("expected the ellipsized value to be written, got %v, %v", val, err)
+	}
+}
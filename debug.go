@@ -0,0 +1,85 @@
+package speed
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// debugMetric is the state of a metric served by the debug handler
+type debugMetric struct {
+	Name      string         `json:"name"`
+	Type      string         `json:"type"`
+	Semantics string         `json:"semantics"`
+	Unit      string         `json:"unit"`
+	Short     string         `json:"short_description,omitempty"`
+	Long      string         `json:"long_description,omitempty"`
+	Enabled   bool           `json:"enabled"`
+	Tags      []string       `json:"tags,omitempty"`
+	Value     interface{}    `json:"value"`
+	History   []HistoryEntry `json:"history,omitempty"`
+}
+
+// debugClient is the state of a client served by the debug handler
+type debugClient struct {
+	Name     string        `json:"name"`
+	Location string        `json:"location"`
+	Metrics  []debugMetric `json:"metrics"`
+}
+
+// DebugHandler returns a handler serving the current values of all metrics
+// of the client as JSON, along with the values kept for metrics registered
+// using WithHistory. A single metric can be requested using ?metric=<name>.
+//
+// It is meant to be mounted on a debug endpoint during development, like
+//
+//	http.Handle("/debug/speed", c.DebugHandler())
+func (c *PCPClient) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := req.URL.Query().Get("metric")
+
+		data := debugClient{
+			Name:     c.name(),
+			Location: c.Location(),
+			Metrics:  c.debugMetrics(func(n string) bool { return name == "" || n == name }),
+		}
+
+		if name != "" && len(data.Metrics) == 0 {
+			http.Error(w, fmt.Sprintf("metric %v not found", name), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(data); err != nil && logging {
+			clientlogger.WithField("error", err).Error("cannot write debug data")
+		}
+	})
+}
+
+// debugMetrics returns the state of all metrics with names accepted by match, ordered by name
+func (c *PCPClient) debugMetrics(match func(name string) bool) []debugMetric {
+	metrics := []debugMetric{}
+
+	for _, m := range c.r.sortedMetrics() {
+		if !match(m.Name()) {
+			continue
+		}
+
+		short, long := c.r.metricDescription(m)
+		history, _ := c.History(m.Name())
+		metrics = append(metrics, debugMetric{
+			Name:      m.Name(),
+			Type:      m.Type().String(),
+			Semantics: m.Semantics().String(),
+			Unit:      fmt.Sprint(m.Unit()),
+			Short:     short,
+			Long:      long,
+			Enabled:   metricDesc(m).enabled(),
+			Tags:      c.Tags(m.Name()),
+			Value:     metricValue(m),
+			History:   history,
+		})
+	}
+
+	return metrics
+}
@@ -0,0 +1,80 @@
+package speed
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDebugHandler(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	m, err := NewPCPCounter(0, "requests")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+	c.MustRegister(m, WithHistory(10), WithTags("http"))
+	newTestCounter(t, c, "errors")
+
+	m.Inc(5)
+	if err = c.history.Collect(); err != nil {
+		t.Fatalf("cannot sample history, error: %v", err)
+	}
+
+	get := func(url string) (*httptest.ResponseRecorder, debugClient) {
+		r, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatalf("cannot create request, error: %v", err)
+		}
+
+		rec := httptest.NewRecorder()
+		c.DebugHandler().ServeHTTP(rec, r)
+
+		var data debugClient
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &data); err != nil {
+				t.Fatalf("cannot decode debug data, error: %v", err)
+			}
+		}
+		return rec, data
+	}
+
+	_, data := get("/debug/speed")
+	if data.Name != "test" {
+		t.Errorf("expected client name test, got %v", data.Name)
+	}
+
+	if len(data.Metrics) != 2 || data.Metrics[0].Name != "errors" || data.Metrics[1].Name != "requests" {
+		t.Fatalf("expected metrics errors and requests, got %v", data.Metrics)
+	}
+
+	req := data.Metrics[1]
+	if req.Value != float64(5) || !req.Enabled || req.Semantics != "CounterSemantics" {
+		t.Errorf("unexpected state of requests: %+v", req)
+	}
+
+	if len(req.Tags) != 1 || req.Tags[0] != "http" {
+		t.Errorf("expected tags [http], got %v", req.Tags)
+	}
+
+	if len(req.History) != 1 || req.History[0].Value != float64(5) {
+		t.Errorf("expected a single history entry of 5, got %v", req.History)
+	}
+
+	if len(data.Metrics[0].History) != 0 {
+		t.Errorf("expected no history for errors, got %v", data.Metrics[0].History)
+	}
+
+	_, data = get("/debug/speed?metric=errors")
+	if len(data.Metrics) != 1 || data.Metrics[0].Name != "errors" {
+		t.Errorf("expected only errors, got %v", data.Metrics)
+	}
+
+	if rec, _ := get("/debug/speed?metric=missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %v for a missing metric, got %v", http.StatusNotFound, rec.Code)
+	}
+}
@@ -0,0 +1,71 @@
+package speed
+
+import "sync"
+
+// PCPDeltaCounter is a PCPCounter following an absolute counter of another source,
+// like a counter of the kernel sampled by a collector, which can go backwards when
+// the source restarts or wraps. It counts the increases of the source between the
+// values observed, so it keeps increasing across restarts and wraps of the source.
+//
+// A drop of the source is taken as a wrap if the source wraps at a known value and the
+// drop is more than half of it, as a counter close to wrapping is then likely to have
+// wrapped, and as a restart of the source from 0 otherwise.
+type PCPDeltaCounter struct {
+	*PCPCounter
+	wrap uint64 // value the source wraps at, 0 if it does not wrap
+
+	observe sync.Mutex // serializes observations
+	last    uint64     // value of the source observed last
+	seen    bool       // whether a value of the source was observed
+}
+
+// NewPCPDeltaCounter creates a new PCPDeltaCounter following a source that wraps at
+// wrap, like 1<<32 for a 32 bit counter, or 0 if the source does not wrap. Optionally,
+// a couple of description strings may be passed as the short and long descriptions of
+// the metric.
+//
+// Internally it creates a PCPCounter starting at 0.
+func NewPCPDeltaCounter(name string, wrap uint64, desc ...string) (*PCPDeltaCounter, error) {
+	c, err := NewPCPCounter(0, name, desc...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PCPDeltaCounter{PCPCounter: c, wrap: wrap}, nil
+}
+
+// delta returns the increase of the source from last to val
+func (d *PCPDeltaCounter) delta(last, val uint64) uint64 {
+	if val >= last {
+		return val - last
+	}
+
+	if d.wrap != 0 && last < d.wrap && last-val > d.wrap/2 {
+		return d.wrap - last + val
+	}
+
+	// the source restarted, and counted val since
+	return val
+}
+
+// Observe records the current value of the source, increasing the counter by the increase
+// of the source since the last value observed. The first value observed is only taken as
+// the start of the source, so the counter counts from when the source is first observed.
+func (d *PCPDeltaCounter) Observe(val uint64) error {
+	d.observe.Lock()
+	defer d.observe.Unlock()
+
+	last, seen := d.last, d.seen
+	d.last, d.seen = val, true
+
+	if !seen {
+		return nil
+	}
+
+	return d.Inc(int64(d.delta(last, val)))
+}
+
+// MustObserve panics if Observe fails.
+func (d *PCPDeltaCounter) MustObserve(val uint64) {
+	must("observe", d.name, d.client, d.Observe(val))
+}
@@ -0,0 +1,60 @@
+package speed
+
+import (
+	"testing"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestPCPDeltaCounter(t *testing.T) {
+	d, err := NewPCPDeltaCounter("disk.reads", 1<<32, "reads")
+	if err != nil {
+		t.Fatalf("cannot create delta counter, error: %v", err)
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	c.MustRegister(d)
+	c.MustStart()
+	defer c.MustStop()
+
+	cases := []struct {
+		source uint64
+		inc    int64
+	}{
+		{1000, 0},                   // the start of the source
+		{1500, 500},                 // an increase
+		{1500, 0},                   // no change
+		{1<<32 - 100, 1<<32 - 1600}, // a jump
+		{50, 150},                   // a wrap
+		{20, 20},                    // a restart
+		{30, 10},                    // an increase after the restart
+	}
+
+	var total int64
+	for _, tc := range cases {
+		d.MustObserve(tc.source)
+		total += tc.inc
+
+		if v := d.Val(); v != total {
+			t.Errorf("expected %v after observing %v, got %v", total, tc.source, v)
+		}
+	}
+
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "disk.reads"); err != nil || v != total {
+		t.Errorf("expected %v to be written, got %v, error: %v", total, v, err)
+	}
+
+	nowrap, err := NewPCPDeltaCounter("requests", 0)
+	if err != nil {
+		t.Fatalf("cannot create delta counter, error: %v", err)
+	}
+
+	nowrap.MustObserve(1<<63 - 10)
+	nowrap.MustObserve(5)
+	if v := nowrap.Val(); v != 5 {
+		t.Errorf("expected a drop of a source that does not wrap to be a restart, got %v", v)
+	}
+}
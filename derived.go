@@ -0,0 +1,95 @@
+package speed
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DerivedNamespace is the namespace derived metrics written by WriteDerivedConfig are
+// defined under, followed by the name of the client unless NoPrefixFlag is set.
+const DerivedNamespace = "derived"
+
+// DerivedRatio defines a derived metric dividing one registered metric by another,
+// like a cache hit ratio from the number of hits and the total number of lookups.
+type DerivedRatio struct {
+	Name        string // name of the derived metric, under the derived namespace of the client
+	Numerator   string // name of the registered metric divided
+	Denominator string // name of the registered metric divided by
+}
+
+// WriteDerivedConfig writes a PCP derived metric configuration for the metrics
+// registered with the client, which can be loaded using pmLoadDerivedConfig(3),
+// the PCP_DERIVED_CONFIG environment variable or by installing it under
+// $PCP_SYSCONF_DIR/derived, so useful derived metrics ship with the application.
+//
+// A rate is defined for every metric with counter semantics, named after the metric
+// with a "_rate" suffix, along with the passed ratios and the hit ratios of the caches
+// registered using RegisterCache. All are defined under DerivedNamespace, so the
+// derived metrics of client app are named like derived.app.requests_rate. Ratios of metrics with counter semantics divide their rates.
+func (c *PCPClient) WriteDerivedConfig(w io.Writer, ratios ...DerivedRatio) error {
+	prefix := c.MetricPrefix()
+	derived := DerivedNamespace + "." + strings.TrimPrefix(prefix, "mmv.")
+
+	metrics := make(map[string]PCPMetric)
+	for _, m := range c.r.sortedMetrics() {
+		metrics[m.Name()] = m
+	}
+
+	operand := func(name string) (string, error) {
+		m, ok := metrics[name]
+		if !ok {
+			return "", fmt.Errorf("%v is not a registered metric", name)
+		}
+
+		if !m.Type().isNumeric() {
+			return "", fmt.Errorf("%v is not a numeric metric", name)
+		}
+
+		if m.Semantics() == CounterSemantics {
+			return "rate(" + prefix + name + ")", nil
+		}
+
+		return prefix + name, nil
+	}
+
+	var lines []string
+	for _, m := range c.r.sortedMetrics() {
+		if m.Semantics() == CounterSemantics && m.Type().isNumeric() {
+			lines = append(lines, fmt.Sprintf("%v%v_rate = rate(%v%v)", derived, m.Name(), prefix, m.Name()))
+		}
+	}
+
+	for _, r := range ratios {
+		if r.Name == "" {
+			return c.opError("write derived config of", "", errors.New("a derived ratio requires a name"))
+		}
+
+		num, err := operand(r.Numerator)
+		if err != nil {
+			return c.opError("write derived config of", "", fmt.Errorf("cannot define %v: %v", r.Name, err))
+		}
+
+		den, err := operand(r.Denominator)
+		if err != nil {
+			return c.opError("write derived config of", "", fmt.Errorf("cannot define %v: %v", r.Name, err))
+		}
+
+		lines = append(lines, fmt.Sprintf("%v%v = %v / %v", derived, r.Name, num, den))
+	}
+
+	for _, cache := range c.registeredCaches() {
+		hits, misses := "rate("+prefix+cache.hits.Name()+")", "rate("+prefix+cache.misses.Name()+")"
+		lines = append(lines, fmt.Sprintf("%v%v.hit_ratio = %v / (%v + %v)", derived, cache.name, hits, hits, misses))
+	}
+
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "# derived metrics of %v, generated by speed\n", strings.TrimSuffix(prefix, "."))
+	for _, l := range lines {
+		fmt.Fprintln(b, l)
+	}
+
+	return b.Flush()
+}
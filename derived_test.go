@@ -0,0 +1,64 @@
+package speed
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteDerivedConfig(t *testing.T) {
+	c, err := NewPCPClient("app")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	hits, err := NewPCPCounter(0, "cache.hits")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	total, err := NewPCPCounter(0, "cache.total")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	size, err := NewPCPGauge(0, "cache.size")
+	if err != nil {
+		t.Fatalf("cannot create gauge, error: %v", err)
+	}
+
+	c.MustRegister(hits)
+	c.MustRegister(total)
+	c.MustRegister(size)
+
+	var b bytes.Buffer
+	err = c.WriteDerivedConfig(&b, DerivedRatio{"cache.hit_ratio", "cache.hits", "cache.total"})
+	if err != nil {
+		t.Fatalf("cannot write derived config, error: %v", err)
+	}
+
+	expected := `# derived metrics of mmv.app, generated by speed
+derived.app.cache.hits_rate = rate(mmv.app.cache.hits)
+derived.app.cache.total_rate = rate(mmv.app.cache.total)
+derived.app.cache.hit_ratio = rate(mmv.app.cache.hits) / rate(mmv.app.cache.total)
+`
+	if b.String() != expected {
+		t.Errorf("expected\n%v\ngot\n%v", expected, b.String())
+	}
+
+	if err = c.WriteDerivedConfig(&b, DerivedRatio{"missing", "cache.hits", "cache.misses"}); err == nil {
+		t.Errorf("expected a ratio of a metric that is not registered to fail")
+	}
+
+	if err = c.SetNoPrefix(true); err != nil {
+		t.Fatalf("cannot remove the prefix, error: %v", err)
+	}
+
+	b.Reset()
+	if err = c.WriteDerivedConfig(&b, DerivedRatio{"fill", "cache.size", "cache.total"}); err != nil {
+		t.Fatalf("cannot write derived config, error: %v", err)
+	}
+
+	if !bytes.Contains(b.Bytes(), []byte("derived.fill = mmv.cache.size / rate(mmv.cache.total)\n")) {
+		t.Errorf("expected the ratio to be defined without the prefix, got\n%v", b.String())
+	}
+}
@@ -0,0 +1,113 @@
+package speed
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// DescriptionData is the data passed to templates in the descriptions of metrics and
+// instance domains, which are expanded every time a client writes its mapping, so
+// help text can carry deployment context, as in
+//
+//	m, err := speed.NewPCPCounter(0, "requests", "requests served by {{.Hostname}}",
+//		"running version {{.Meta.version}} of {{.Client}}")
+//	...
+//	c.SetMetadata("version", "1.4.2")
+//
+// Descriptions without "{{" are written unchanged. Expanded descriptions longer than
+// a PCP string are truncated.
+type DescriptionData struct {
+	Client   string            // name of the client
+	Hostname string            // hostname of the machine
+	PID      int               // process identifier
+	Meta     map[string]string // metadata set using SetMetadata, missing keys expand to ""
+}
+
+// isDescriptionTemplate returns true if the description has placeholders to expand
+func isDescriptionTemplate(desc string) bool {
+	return strings.Contains(desc, "{{")
+}
+
+// descriptionTemplate is what isDescriptionTemplate looks for, for descriptions
+// stored in the arena of a layout
+var descriptionTemplate = []byte("{{")
+
+// parseDescription parses a description template
+func parseDescription(desc string) (*template.Template, error) {
+	return template.New("description").Option("missingkey=zero").Parse(desc)
+}
+
+// validateDescriptions returns an error if any of the descriptions is not a valid template
+func validateDescriptions(desc ...string) error {
+	for _, d := range desc {
+		if !isDescriptionTemplate(d) {
+			continue
+		}
+
+		if _, err := parseDescription(d); err != nil {
+			return fmt.Errorf("invalid description %q: %v", d, err)
+		}
+	}
+
+	return nil
+}
+
+// newDescriptionData collects the data passed to description templates
+func (c *PCPClient) newDescriptionData() *DescriptionData {
+	hostname, _ := os.Hostname()
+
+	meta := make(map[string]string, len(c.metadata))
+	for k, v := range c.metadata {
+		meta[k] = v
+	}
+
+	return &DescriptionData{
+		Client:   c.name(),
+		Hostname: hostname,
+		PID:      os.Getpid(),
+		Meta:     meta,
+	}
+}
+
+// expandDescription expands the placeholders in a description using the data collected
+// for the mapping being written. A description that fails to expand is written unchanged.
+func (c *PCPClient) expandDescription(desc string) string {
+	if !isDescriptionTemplate(desc) {
+		return desc
+	}
+
+	t, err := parseDescription(desc)
+	if err != nil {
+		return desc
+	}
+
+	var b bytes.Buffer
+	if err = t.Execute(&b, c.descdata); err != nil {
+		if logging {
+			clientlogger.WithField("error", err).Error("cannot expand description")
+		}
+		return desc
+	}
+
+	if b.Len() > StringLength-1 {
+		b.Truncate(StringLength - 1)
+	}
+
+	return b.String()
+}
+
+// SetMetadata sets a value passed to description templates as {{.Meta.key}},
+// which is used from the next time the mapping is written.
+func (c *PCPClient) SetMetadata(key, value string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.metadata == nil {
+		c.metadata = make(map[string]string)
+	}
+
+	c.metadata[key] = value
+}
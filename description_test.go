@@ -0,0 +1,93 @@
+package speed
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestDescriptionTemplates(t *testing.T) {
+	c, err := NewPCPClient("described")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	c.SetMetadata("version", "1.4.2")
+
+	m, err := NewPCPCounter(0, "requests", "requests served by {{.Hostname}}", "version {{.Meta.version}} of {{.Client}}{{.Meta.missing}}")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+	c.MustRegister(m)
+
+	long, err := NewPCPCounter(0, "long", `{{printf "%300s" "x"}}`)
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+	c.MustRegister(long)
+
+	invalid, err := NewPCPCounter(0, "invalid", "{{.Hostname")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+	if err = c.Register(invalid); err == nil {
+		t.Errorf("expected registering a metric with an invalid description template to fail")
+	}
+
+	if err = c.SetInstanceDomainDescription("missing", "{{"); err == nil {
+		t.Errorf("expected an invalid instance domain description template to fail")
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	_, _, metrics, _, _, _, strs, err := mmvdump.Dump(c.writer.Bytes())
+	if err != nil {
+		t.Fatalf("cannot dump mapping, error: %v", err)
+	}
+
+	hostname, _ := os.Hostname()
+	expected := map[string]string{
+		"requests": "requests served by " + hostname + "\nversion 1.4.2 of described",
+		"long":     strings.Repeat(" ", StringLength-1) + "\n",
+	}
+
+	for _, dm := range metrics {
+		name, short, long := metricStrings(dm, strs, t)
+		if e, ok := expected[name]; ok && short+"\n"+long != e {
+			t.Errorf("expected %v to be described as %q, got %q", name, e, short+"\n"+long)
+		}
+	}
+
+	if m.ShortDescription() != "requests served by {{.Hostname}}" {
+		t.Errorf("expected the metric to keep its template, got %v", m.ShortDescription())
+	}
+}
+
+// metricStrings returns the name and descriptions of a metric in a dumped mapping
+func metricStrings(m mmvdump.Metric, strs map[uint64]*mmvdump.String, t *testing.T) (string, string, string) {
+	str := func(off uint64) string {
+		if off == 0 {
+			return ""
+		}
+		s := strs[off].Payload[:]
+		if i := strings.IndexByte(string(s), 0); i >= 0 {
+			s = s[:i]
+		}
+		return string(s)
+	}
+
+	var name string
+	switch dm := m.(type) {
+	case *mmvdump.Metric1:
+		name = str(0) + strings.TrimRight(string(dm.Name[:]), "\x00")
+	case *mmvdump.Metric2:
+		name = str(dm.Name)
+	default:
+		t.Fatalf("unknown metric type %T", m)
+	}
+
+	return name, str(m.ShortText()), str(m.LongText())
+}
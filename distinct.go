@@ -0,0 +1,156 @@
+package speed
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+)
+
+// PCPDistinctCounter estimates the number of distinct ids observed, like unique users or
+// keys, using a HyperLogLog sketch, publishing the estimate without exporting the ids.
+//
+// The sketch takes 2^precision bytes, and the estimate has a standard error of about
+// 1.04/sqrt(2^precision), so 1.6% for a precision of 12, regardless of the number of ids.
+type PCPDistinctCounter struct {
+	*pcpSingletonMetric
+	precision uint
+	registers []uint8
+	sum       float64 // sum of 2^-register over all registers
+	zeros     int     // number of registers that are 0
+}
+
+// the lowest and highest precision of a PCPDistinctCounter
+const (
+	DistinctCounterMinPrecision = 4
+	DistinctCounterMaxPrecision = 16
+)
+
+// NewPCPDistinctCounter creates a new PCPDistinctCounter. The precision can be
+// between 4 and 16, see PCPDistinctCounter for the memory it takes and the error of
+// the estimate. Optionally, a couple of description strings may be passed as the short
+// and long descriptions of the metric.
+//
+// Internally it creates a PCP SingletonMetric with Int64Type, InstantSemantics and OneUnit,
+// as the estimate is not guaranteed to never decrease.
+func NewPCPDistinctCounter(name string, precision int, desc ...string) (*PCPDistinctCounter, error) {
+	if precision < DistinctCounterMinPrecision || precision > DistinctCounterMaxPrecision {
+		return nil, &OpError{"create metric", name, "", fmt.Errorf("precision %v is not between %v and %v", precision, DistinctCounterMinPrecision, DistinctCounterMaxPrecision)}
+	}
+
+	d, err := newpcpMetricDesc(name, Int64Type, InstantSemantics, OneUnit, desc...)
+	if err != nil {
+		return nil, err
+	}
+
+	sm, err := newpcpSingletonMetric(int64(0), d)
+	if err != nil {
+		return nil, err
+	}
+
+	m := 1 << uint(precision)
+	return &PCPDistinctCounter{sm, uint(precision), make([]uint8, m), float64(m), m}, nil
+}
+
+// hashID returns a 64 bit hash of an id, with its bits mixed for use in the sketch
+func hashID(id string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(id))
+
+	// fnv does not spread short inputs over the high bits, so finish
+	// with the finalizer of murmur3
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// estimate returns the estimated number of distinct ids observed
+func (d *PCPDistinctCounter) estimate() int64 {
+	m := float64(len(d.registers))
+
+	var alpha float64
+	switch len(d.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+
+	e := alpha * m * m / d.sum
+
+	// for small cardinalities, use linear counting
+	if e <= 2.5*m && d.zeros > 0 {
+		e = m * math.Log(m/float64(d.zeros))
+	}
+
+	return int64(e + 0.5)
+}
+
+// Observe records an id, updating the estimate if the id was likely not seen before.
+func (d *PCPDistinctCounter) Observe(id string) error {
+	if !d.enabled() {
+		return nil
+	}
+
+	x := hashID(id)
+	i := x >> (64 - d.precision)
+
+	// the rank is the position of the first set bit in the remaining bits
+	rank := uint8(1)
+	for w := x << d.precision; w&(1<<63) == 0 && rank <= uint8(64-d.precision); w <<= 1 {
+		rank++
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	old := d.registers[i]
+	if rank <= old {
+		return nil
+	}
+
+	if old == 0 {
+		d.zeros--
+	}
+
+	d.registers[i] = rank
+	d.sum += math.Ldexp(1, -int(rank)) - math.Ldexp(1, -int(old))
+
+	if e := d.estimate(); e != d.value().(int64) {
+		return d.set(e)
+	}
+
+	return nil
+}
+
+// MustObserve panics if Observe fails.
+func (d *PCPDistinctCounter) MustObserve(id string) {
+	must("observe", d.name, d.client, d.Observe(id))
+}
+
+// Val returns the current estimate of the number of distinct ids observed.
+func (d *PCPDistinctCounter) Val() int64 {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.value().(int64)
+}
+
+// reset forgets all observed ids.
+func (d *PCPDistinctCounter) reset() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for i := range d.registers {
+		d.registers[i] = 0
+	}
+	d.sum, d.zeros = float64(len(d.registers)), len(d.registers)
+
+	return d.set(int64(0))
+}
@@ -0,0 +1,67 @@
+package speed
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestPCPDistinctCounter(t *testing.T) {
+	for _, p := range []int{3, 17} {
+		if _, err := NewPCPDistinctCounter("users", p); err == nil {
+			t.Errorf("expected creating a distinct counter with precision %v to fail", p)
+		}
+	}
+
+	d, err := NewPCPDistinctCounter("users", 12, "unique users")
+	if err != nil {
+		t.Fatalf("cannot create distinct counter, error: %v", err)
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	c.MustRegister(d)
+	c.MustStart()
+	defer c.MustStop()
+
+	for _, n := range []int{10, 1000, 100000} {
+		for i := 0; i < n; i++ {
+			d.MustObserve("user" + strconv.Itoa(i))
+		}
+
+		// observing the ids again does not change the estimate
+		e := d.Val()
+		for i := 0; i < n; i++ {
+			d.MustObserve("user" + strconv.Itoa(i))
+		}
+
+		if v := d.Val(); v != e {
+			t.Errorf("expected observing the same %v ids again to keep the estimate at %v, got %v", n, e, v)
+		}
+
+		if math.Abs(float64(e-int64(n))) > 0.05*float64(n) {
+			t.Errorf("expected an estimate within 5%% of %v, got %v", n, e)
+		}
+
+		if v, err := mmvdump.Lookup(c.writer.Bytes(), "users"); err != nil || v != e {
+			t.Errorf("expected the mapping to hold the estimate %v, got %v, error: %v", e, v, err)
+		}
+	}
+
+	if err = d.reset(); err != nil {
+		t.Fatalf("cannot reset the distinct counter, error: %v", err)
+	}
+
+	if v := d.Val(); v != 0 {
+		t.Errorf("expected a reset distinct counter to estimate 0, got %v", v)
+	}
+
+	d.MustObserve("user")
+	if v := d.Val(); v != 1 {
+		t.Errorf("expected a single id after the reset to be estimated as 1, got %v", v)
+	}
+}
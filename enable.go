@@ -0,0 +1,172 @@
+package speed
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Disabling metrics
+//
+// A disabled metric ignores updates, so its value stays at what it was when the
+// metric was disabled, both in the metric and in the mapping. Updates to disabled
+// metrics return early without taking the metric's lock, which makes disabling a way
+// to shed the cost of expensive or hot instrumentation at runtime without a restart.
+
+// enabled returns true if the metric accepts updates.
+func (md *pcpMetricDesc) enabled() bool {
+	return atomic.LoadInt32(&md.disabled) == 0
+}
+
+// setEnabled enables or disables updates to the metric.
+func (md *pcpMetricDesc) setEnabled(enabled bool) {
+	var disabled int32
+	if !enabled {
+		disabled = 1
+	}
+	atomic.StoreInt32(&md.disabled, disabled)
+}
+
+// metricDesc returns the description of a registered metric
+func metricDesc(m PCPMetric) *pcpMetricDesc {
+	switch metric := m.(type) {
+	case singletonMetric:
+		return metric.singleton().pcpMetricDesc
+	case instanceMetric:
+		return metric.instance().pcpMetricDesc
+	}
+	return nil
+}
+
+// inNamespace returns true if name is the namespace itself or a name under it
+func inNamespace(name, namespace string) bool {
+	return name == namespace || strings.HasPrefix(name, namespace+".")
+}
+
+// namedMetrics returns all registered metrics that are named by or are under
+// one of the passed names, or an error if a name matches no metric.
+func (c *PCPClient) namedMetrics(names []string) ([]PCPMetric, error) {
+	c.r.metricslock.RLock()
+	defer c.r.metricslock.RUnlock()
+
+	var matched []PCPMetric
+	for _, n := range names {
+		found := false
+		for name, m := range c.r.metrics {
+			if inNamespace(name, n) {
+				matched = append(matched, m)
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("no metric is named %v or is under it", n)
+		}
+	}
+
+	return matched, nil
+}
+
+// matchMetrics returns the descriptions of the metrics returned by namedMetrics
+func (c *PCPClient) matchMetrics(names []string) ([]*pcpMetricDesc, error) {
+	metrics, err := c.namedMetrics(names)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make([]*pcpMetricDesc, len(metrics))
+	for i, m := range metrics {
+		matched[i] = metricDesc(m)
+	}
+
+	return matched, nil
+}
+
+// DisableMetrics disables the passed metrics, along with all metrics under
+// the passed names when they are namespaces, so that "a.b" disables a.b as well as a.b.c.
+// Updates to disabled metrics are ignored and their values stay unchanged
+// until they are enabled again. Nothing is disabled if a name matches no metric.
+func (c *PCPClient) DisableMetrics(names ...string) error {
+	descs, err := c.matchMetrics(names)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range descs {
+		d.setEnabled(false)
+	}
+
+	return nil
+}
+
+// EnableMetrics enables metrics disabled by DisableMetrics, matching names the same way.
+func (c *PCPClient) EnableMetrics(names ...string) error {
+	descs, err := c.matchMetrics(names)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range descs {
+		d.setEnabled(true)
+	}
+
+	return nil
+}
+
+// SetDisabledMetrics disables the passed metrics and namespaces, and enables all others.
+func (c *PCPClient) SetDisabledMetrics(names ...string) error {
+	descs, err := c.matchMetrics(names)
+	if err != nil {
+		return err
+	}
+
+	disabled := make(map[*pcpMetricDesc]bool, len(descs))
+	for _, d := range descs {
+		disabled[d] = true
+	}
+
+	c.r.metricslock.RLock()
+	defer c.r.metricslock.RUnlock()
+
+	for _, m := range c.r.metrics {
+		d := metricDesc(m)
+		d.setEnabled(!disabled[d])
+	}
+
+	return nil
+}
+
+// DisabledMetrics returns the names of all disabled metrics in ascending order.
+func (c *PCPClient) DisabledMetrics() []string {
+	c.r.metricslock.RLock()
+	defer c.r.metricslock.RUnlock()
+
+	var names []string
+	for name, m := range c.r.metrics {
+		if !metricDesc(m).enabled() {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// ReloadConfig reads the JSON config at path, and applies the settings in it that
+// can change while a client is running. Currently that is the set of disabled metrics,
+// metrics that were disabled and are no longer listed are enabled again.
+func (c *PCPClient) ReloadConfig(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var conf ClientConfig
+	if err = decodeConfig(data, &conf); err != nil {
+		return fmt.Errorf("cannot parse config %v: %v", path, err)
+	}
+
+	return c.SetDisabledMetrics(conf.Disabled...)
+}
@@ -0,0 +1,153 @@
+package speed
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestDisableMetrics(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	counter := newTestCounter(t, c, "a.b.counter")
+
+	gauge, err := NewPCPGauge(1, "a.gauge")
+	if err != nil {
+		t.Fatalf("cannot create gauge, error: %v", err)
+	}
+	c.MustRegister(gauge)
+
+	im, err := c.RegisterString("a.b.instances[x, y]", Instances{"x": 1, "y": 2}, Int32Type, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create instance metric, error: %v", err)
+	}
+	instances := im.(*PCPInstanceMetric)
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if err = c.DisableMetrics("a.b", "missing"); err == nil {
+		t.Errorf("expected disabling a name that matches no metric to fail")
+	}
+
+	if names := c.DisabledMetrics(); len(names) != 0 {
+		t.Errorf("expected a failed DisableMetrics to disable nothing, got %v disabled", names)
+	}
+
+	counter.MustInc(10)
+
+	if err = c.DisableMetrics("a.b"); err != nil {
+		t.Fatalf("cannot disable metrics, error: %v", err)
+	}
+
+	if names, expected := c.DisabledMetrics(), []string{"a.b.counter", "a.b.instances"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v to be disabled, got %v", expected, names)
+	}
+
+	counter.MustInc(10)
+	if ok, _ := counter.CAS(10, 20); ok {
+		t.Errorf("expected CAS on a disabled counter to fail")
+	}
+	instances.MustSetInstance(5, "x")
+	gauge.MustSet(2)
+
+	if counter.Val() != 10 {
+		t.Errorf("expected a disabled counter to stay at 10, got %v", counter.Val())
+	}
+
+	if v, _ := instances.ValInstance("x"); v != int32(1) {
+		t.Errorf("expected a disabled instance to stay at 1, got %v", v)
+	}
+
+	if gauge.Val() != 2 {
+		t.Errorf("expected an enabled gauge to be set to 2, got %v", gauge.Val())
+	}
+
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "a.b.counter"); err != nil || v != int64(10) {
+		t.Errorf("expected the mapped counter to stay at 10, got %v, error: %v", v, err)
+	}
+
+	if err = c.EnableMetrics("a.b.counter"); err != nil {
+		t.Fatalf("cannot enable metrics, error: %v", err)
+	}
+
+	counter.MustInc(10)
+	if counter.Val() != 20 {
+		t.Errorf("expected an enabled counter to be incremented to 20, got %v", counter.Val())
+	}
+
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "a.b.counter"); err != nil || v != int64(20) {
+		t.Errorf("expected the mapped counter to be 20, got %v, error: %v", v, err)
+	}
+
+	if names, expected := c.DisabledMetrics(), []string{"a.b.instances"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v to be disabled, got %v", expected, names)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "speed")
+	if err != nil {
+		t.Fatalf("cannot create directory, error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "config.json")
+	write := func(conf string) {
+		if err := ioutil.WriteFile(path, []byte(conf), 0644); err != nil {
+			t.Fatalf("cannot write config, error: %v", err)
+		}
+	}
+
+	write(`{"name": "reloaded", "directory": "` + dir + `", "collectors": ["uptime"], "disabled": ["process"]}`)
+
+	c, err := NewClientFromConfig(path)
+	if err != nil {
+		t.Fatalf("cannot create client from config, error: %v", err)
+	}
+
+	counter := newTestCounter(t, c, "requests")
+
+	if names, expected := c.DisabledMetrics(), []string{"process.starttime", "process.uptime"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v to be disabled, got %v", expected, names)
+	}
+
+	write(`{"name": "reloaded", "disabled": ["requests"]}`)
+	if err = c.ReloadConfig(path); err != nil {
+		t.Fatalf("cannot reload config, error: %v", err)
+	}
+
+	if names, expected := c.DisabledMetrics(), []string{"requests"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v to be disabled after a reload, got %v", expected, names)
+	}
+
+	counter.Up()
+	if counter.Val() != 0 {
+		t.Errorf("expected a counter disabled by a reload to stay at 0, got %v", counter.Val())
+	}
+
+	write(`{"name": "reloaded", "disabled": ["missing"]}`)
+	if err = c.ReloadConfig(path); err == nil {
+		t.Errorf("expected reloading a config disabling a missing metric to fail")
+	}
+
+	if names, expected := c.DisabledMetrics(), []string{"requests"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected a failed reload to leave %v disabled, got %v", expected, names)
+	}
+}
+
+func newTestCounter(t *testing.T, c *PCPClient, name string) *PCPCounter {
+	m, err := NewPCPCounter(0, name)
+	if err != nil {
+		t.Fatalf("cannot create counter %v, error: %v", name, err)
+	}
+	c.MustRegister(m)
+	return m
+}
@@ -0,0 +1,106 @@
+package speed
+
+import (
+	"fmt"
+
+	"github.com/performancecopilot/speed/bytewriter"
+)
+
+// encoder writes the parts of a mapping that differ between versions of the MMV format,
+// so the layout and the writing of a mapping do not have to check for the version
+type encoder interface {
+	// version returns the version written to the header
+	version() uint32
+
+	// instanceLength and metricLength return the lengths of instances and metrics
+	instanceLength() int
+	metricLength() int
+
+	// nameStrings returns whether the names of instances and metrics are written
+	// to the strings section instead of inline
+	nameStrings() bool
+
+	// writeInstanceName writes the name of an instance at off, and to the string at
+	// stroff if names are strings
+	writeInstanceName(w bytewriter.Writer, name []byte, off, stroff int)
+
+	// writeMetricName writes the name of a metric at off, and to the string at stroff
+	// if names are strings, returning the offset following the name in the metric
+	writeMetricName(w bytewriter.Writer, name []byte, off, stroff int) int
+}
+
+// mmv1Encoder writes version 1 mappings, with names of at most MaxV1NameLength bytes inline
+type mmv1Encoder struct{}
+
+func (mmv1Encoder) version() uint32     { return 1 }
+func (mmv1Encoder) instanceLength() int { return Instance1Length }
+func (mmv1Encoder) metricLength() int   { return Metric1Length }
+func (mmv1Encoder) nameStrings() bool   { return false }
+
+func (mmv1Encoder) writeInstanceName(w bytewriter.Writer, name []byte, off, stroff int) {
+	w.MustWrite(name, off)
+}
+
+func (mmv1Encoder) writeMetricName(w bytewriter.Writer, name []byte, off, stroff int) int {
+	w.MustWrite(name, off)
+	return off + MaxV1NameLength + 1
+}
+
+// mmv2Encoder writes version 2 mappings, with names written to the strings section
+type mmv2Encoder struct{}
+
+func (mmv2Encoder) version() uint32     { return 2 }
+func (mmv2Encoder) instanceLength() int { return Instance2Length }
+func (mmv2Encoder) metricLength() int   { return Metric2Length }
+func (mmv2Encoder) nameStrings() bool   { return true }
+
+func (mmv2Encoder) writeInstanceName(w bytewriter.Writer, name []byte, off, stroff int) {
+	w.MustWriteUint64(uint64(stroff), off)
+	w.MustWrite(name, stroff)
+}
+
+func (mmv2Encoder) writeMetricName(w bytewriter.Writer, name []byte, off, stroff int) int {
+	off = w.MustWriteUint64(uint64(stroff), off)
+	w.MustWrite(name, stroff)
+	return off
+}
+
+// stringCount returns the number of strings written to a mapping of the registry by enc
+func stringCount(r *PCPRegistry, enc encoder) int {
+	if enc.nameStrings() {
+		return r.stringcount + r.MetricCount() + r.InstanceCount()
+	}
+
+	return r.stringcount
+}
+
+// SetMMVVersion sets the lowest version of the MMV format the mappings of the client are
+// written in, which can be 1, the default, or 2.
+//
+// Mappings are written in version 2 regardless when the names of metrics or instances
+// are longer than MaxV1NameLength, which version 1 cannot store, so setting it is only
+// needed for reading the mappings with tools expecting version 2.
+func (c *PCPClient) SetMMVVersion(version int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.r.mapped {
+		return ErrClientStarted
+	}
+
+	if version != 1 && version != 2 {
+		return fmt.Errorf("unsupported MMV version %v", version)
+	}
+
+	c.version = version
+	return nil
+}
+
+// encoder returns the encoder for the next mapping of the registry
+func (c *PCPClient) encoder() encoder {
+	if c.version == 2 || c.r.version2 {
+		return mmv2Encoder{}
+	}
+
+	return mmv1Encoder{}
+}
@@ -0,0 +1,80 @@
+package speed
+
+import (
+	"testing"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestSetMMVVersion(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.SetMMVVersion(3); err == nil {
+		t.Error("expected an error for MMV version 3")
+	}
+
+	c.MustRegisterString("test.short[a, b]", Instances{"a": 1, "b": 2}, Int32Type, CounterSemantics, OneUnit)
+
+	if _, ok := c.encoder().(mmv1Encoder); !ok {
+		t.Errorf("expected a version 1 encoder for short names, got %T", c.encoder())
+	}
+
+	if err = c.SetMMVVersion(2); err != nil {
+		t.Fatal(err)
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if err = c.SetMMVVersion(1); err != ErrClientStarted {
+		t.Errorf("expected ErrClientStarted, got %v", err)
+	}
+
+	if len(c.writer.Bytes()) != c.Length() {
+		t.Errorf("expected the mapping to be %v bytes, got %v", c.Length(), len(c.writer.Bytes()))
+	}
+
+	h, _, metrics, _, instances, _, _, err := mmvdump.Dump(c.writer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.Version != 2 {
+		t.Errorf("expected version 2, got %v", h.Version)
+	}
+
+	for _, m := range metrics {
+		if _, ok := m.(*mmvdump.Metric2); !ok {
+			t.Errorf("expected a version 2 metric, got %T", m)
+		}
+	}
+
+	for _, i := range instances {
+		if _, ok := i.(*mmvdump.Instance2); !ok {
+			t.Errorf("expected a version 2 instance, got %T", i)
+		}
+	}
+
+	for _, inst := range []string{"a", "b"} {
+		if _, err = mmvdump.Lookup(c.writer.Bytes(), "test.short["+inst+"]"); err != nil {
+			t.Errorf("cannot look up instance %v: %v", inst, err)
+		}
+	}
+}
+
+func TestEncoderForLongNames(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := "test.a.very.long.name.that.does.not.fit.in.the.sixty.three.bytes.of.mmv.version.one"
+	c.MustRegisterString(name, 1, Int32Type, CounterSemantics, OneUnit)
+
+	if _, ok := c.encoder().(mmv2Encoder); !ok {
+		t.Errorf("expected a version 2 encoder for long names, got %T", c.encoder())
+	}
+}
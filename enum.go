@@ -0,0 +1,135 @@
+package speed
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// PCPEnum implements a metric holding one of a set of named states, like the role of
+// the process in a leader election, published as the code of the state, which is its
+// position in the set. The codes of all states are listed in the long description of
+// the metric, as in "states: 0=follower, 1=candidate, 2=leader", so they can be looked
+// up using pminfo -T.
+type PCPEnum struct {
+	*pcpSingletonMetric
+	states []string
+	codes  map[string]int32
+}
+
+// NewPCPEnum creates a new PCPEnum instance.
+// It requires the initial state, the set of all states and a metric name for construction.
+// Optionally it can also take a couple of description strings that are used as
+// short and long descriptions respectively, the codes of the states are appended to
+// the long description, which must fit in StringLength bytes.
+// Internally it creates a PCP SingletonMetric with Int32Type, DiscreteSemantics
+// and CountUnit.
+func NewPCPEnum(state string, states []string, name string, desc ...string) (*PCPEnum, error) {
+	if len(states) == 0 {
+		return nil, &OpError{"create metric", name, "", errors.New("an enum needs at least one state")}
+	}
+
+	if len(desc) > 2 {
+		return nil, &OpError{"create metric", name, "", errors.New("only 2 optional strings allowed, short and long descriptions")}
+	}
+
+	codes := make(map[string]int32, len(states))
+
+	var b bytes.Buffer
+	if len(desc) > 1 && desc[1] != "" {
+		b.WriteString(desc[1])
+		b.WriteString("\n")
+	}
+	b.WriteString("states: ")
+
+	for i, s := range states {
+		if s == "" {
+			return nil, &OpError{"create metric", name, "", errors.New("state names cannot be empty")}
+		}
+
+		if _, ok := codes[s]; ok {
+			return nil, &OpError{"create metric", name, "", fmt.Errorf("state %v is passed twice", s)}
+		}
+		codes[s] = int32(i)
+
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%v=%v", i, s)
+	}
+
+	if b.Len() > StringLength-1 {
+		return nil, &OpError{"create metric", name, "", fmt.Errorf("the long description listing the states is longer than %v bytes", StringLength-1)}
+	}
+
+	code, ok := codes[state]
+	if !ok {
+		return nil, &OpError{"create metric", name, "", fmt.Errorf("%v is not a state of the enum", state)}
+	}
+
+	short := ""
+	if len(desc) > 0 {
+		short = desc[0]
+	}
+
+	d, err := newpcpMetricDesc(name, Int32Type, DiscreteSemantics, OneUnit, short, b.String())
+	if err != nil {
+		return nil, err
+	}
+
+	sm, err := newpcpSingletonMetric(code, d)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PCPEnum{sm, append([]string(nil), states...), codes}, nil
+}
+
+// States returns all states of the enum, ordered by their codes.
+func (e *PCPEnum) States() []string {
+	return append([]string(nil), e.states...)
+}
+
+// Code returns the code of a state, and false if it is not a state of the enum.
+func (e *PCPEnum) Code(state string) (int32, bool) {
+	code, ok := e.codes[state]
+	return code, ok
+}
+
+// State returns the current state, or an empty string if the metric holds no
+// code of a state, as it can after its value was written through its slot.
+func (e *PCPEnum) State() string {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	code := e.value().(int32)
+	if code < 0 || int(code) >= len(e.states) {
+		return ""
+	}
+	return e.states[code]
+}
+
+// SetState sets the current state.
+func (e *PCPEnum) SetState(state string) error {
+	_, err := e.swapState(state)
+	return err
+}
+
+// swapState sets the current state, returning the previous one.
+func (e *PCPEnum) swapState(state string) (int32, error) {
+	code, ok := e.codes[state]
+	if !ok {
+		return 0, e.errorf("set", "", "%v is not a state of the enum", state)
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	old := e.value().(int32)
+	return old, e.set(code)
+}
+
+// MustSetState panics if SetState fails.
+func (e *PCPEnum) MustSetState(state string) {
+	must("set", e.name, e.client, e.SetState(state))
+}
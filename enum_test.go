@@ -0,0 +1,74 @@
+package speed
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestPCPEnum(t *testing.T) {
+	states := []string{"follower", "candidate", "leader"}
+
+	invalid := []struct {
+		state  string
+		states []string
+	}{
+		{"leader", nil},
+		{"leader", []string{"leader", "leader"}},
+		{"", []string{"", "leader"}},
+		{"observer", states},
+		{"a", []string{"a", strings.Repeat("b", StringLength)}},
+	}
+
+	for _, i := range invalid {
+		if _, err := NewPCPEnum(i.state, i.states, "role"); err == nil {
+			t.Errorf("expected creating an enum in state %q with states %q to fail", i.state, i.states)
+		}
+	}
+
+	e, err := NewPCPEnum("follower", states, "role", "election role", "the role of the process")
+	if err != nil {
+		t.Fatalf("cannot create enum, error: %v", err)
+	}
+
+	if d := e.LongDescription(); d != "the role of the process\nstates: 0=follower, 1=candidate, 2=leader" {
+		t.Errorf("expected the long description to list the states, got %q", d)
+	}
+
+	if s := e.States(); !reflect.DeepEqual(s, states) {
+		t.Errorf("expected the states %v, got %v", states, s)
+	}
+
+	if code, ok := e.Code("leader"); !ok || code != 2 {
+		t.Errorf("expected leader to have the code 2, got %v, %v", code, ok)
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	c.MustRegister(e)
+	c.MustStart()
+	defer c.MustStop()
+
+	for code, state := range []string{"follower", "leader", "candidate"} {
+		if code > 0 {
+			e.MustSetState(state)
+		}
+
+		if s := e.State(); s != state {
+			t.Errorf("expected the state %v, got %v", state, s)
+		}
+
+		expected, _ := e.Code(state)
+		if v, err := mmvdump.Lookup(c.writer.Bytes(), "role"); err != nil || v != expected {
+			t.Errorf("expected the mapping to hold %v, got %v, error: %v", expected, v, err)
+		}
+	}
+
+	if err = e.SetState("observer"); err == nil {
+		t.Errorf("expected setting a state not of the enum to fail")
+	}
+}
@@ -99,41 +99,55 @@ func main() {
 	client.MustStart()
 	defer client.MustStop()
 
+	cgoCalls := cpuMetric.MustInstance("CGoCalls")
+	goroutines := cpuMetric.MustInstance("Goroutines")
+
+	// handles to the instances of mem, in the order of memMetricInstances
+	mem := make([]*speed.InstanceHandle, len(memMetricInstances))
+	for i, name := range memMetricInstances {
+		mem[i] = memMetric.MustInstance(name)
+	}
+
 	mStats := runtime.MemStats{}
 
 	c := time.Tick(interval)
 	go func() {
 		for range c {
-			cpuMetric.MustSetInstance(runtime.NumCgoCall(), "CGoCalls")
-			cpuMetric.MustSetInstance(runtime.NumGoroutine(), "Goroutines")
+			cgoCalls.MustSet(runtime.NumCgoCall())
+			goroutines.MustSet(runtime.NumGoroutine())
 
 			runtime.ReadMemStats(&mStats)
-			memMetric.MustSetInstance(mStats.Alloc, "Alloc")
-			memMetric.MustSetInstance(mStats.TotalAlloc, "TotalAlloc")
-			memMetric.MustSetInstance(mStats.Sys, "Sys")
-			memMetric.MustSetInstance(mStats.Mallocs, "Mallocs")
-			memMetric.MustSetInstance(mStats.Frees, "Frees")
-			memMetric.MustSetInstance(mStats.HeapAlloc, "HeapAlloc")
-			memMetric.MustSetInstance(mStats.HeapSys, "HeapSys")
-			memMetric.MustSetInstance(mStats.HeapIdle, "HeapIdle")
-			memMetric.MustSetInstance(mStats.HeapInuse, "HeapInuse")
-			memMetric.MustSetInstance(mStats.HeapReleased, "HeapReleased")
-			memMetric.MustSetInstance(mStats.HeapObjects, "HeapObjects")
-			memMetric.MustSetInstance(mStats.StackInuse, "StackInuse")
-			memMetric.MustSetInstance(mStats.StackSys, "StackSys")
-			memMetric.MustSetInstance(mStats.MSpanInuse, "MSpanInuse")
-			memMetric.MustSetInstance(mStats.MSpanSys, "MSpanSys")
-			memMetric.MustSetInstance(mStats.MCacheInuse, "MCacheInuse")
-			memMetric.MustSetInstance(mStats.MCacheSys, "MCacheSys")
-			memMetric.MustSetInstance(mStats.BuckHashSys, "BuckHashSys")
-			memMetric.MustSetInstance(mStats.GCSys, "GCSys")
-			memMetric.MustSetInstance(mStats.OtherSys, "OtherSys")
-			memMetric.MustSetInstance(mStats.NextGC, "NextGC")
-			memMetric.MustSetInstance(mStats.LastGC, "LastGC")
-			memMetric.MustSetInstance(mStats.PauseTotalNs, "PauseTotalNs")
-			memMetric.MustSetInstance(mStats.PauseNs[(mStats.NumGC+255)%256], "PauseNs")
-			memMetric.MustSetInstance(mStats.PauseEnd[(mStats.NumGC+255)%256], "PauseEnd")
-			memMetric.MustSetInstance(uint64(mStats.NumGC), "NumGC")
+			for i, v := range []uint64{
+				mStats.Alloc,
+				mStats.TotalAlloc,
+				mStats.Sys,
+				mStats.Lookups,
+				mStats.Mallocs,
+				mStats.Frees,
+				mStats.HeapAlloc,
+				mStats.HeapSys,
+				mStats.HeapIdle,
+				mStats.HeapInuse,
+				mStats.HeapReleased,
+				mStats.HeapObjects,
+				mStats.StackInuse,
+				mStats.StackSys,
+				mStats.MSpanInuse,
+				mStats.MSpanSys,
+				mStats.MCacheInuse,
+				mStats.MCacheSys,
+				mStats.BuckHashSys,
+				mStats.GCSys,
+				mStats.OtherSys,
+				mStats.NextGC,
+				mStats.LastGC,
+				mStats.PauseTotalNs,
+				mStats.PauseNs[(mStats.NumGC+255)%256],
+				mStats.PauseEnd[(mStats.NumGC+255)%256],
+				uint64(mStats.NumGC),
+			} {
+				mem[i].MustSet(v)
+			}
 		}
 	}()
 
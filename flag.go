@@ -0,0 +1,82 @@
+package speed
+
+// PCPFlag implements a boolean metric, like the state of a feature flag or whether
+// the process is the leader, published as 1 when set and 0 when not.
+type PCPFlag struct {
+	*pcpSingletonMetric
+}
+
+// NewPCPFlag creates a new PCPFlag instance.
+// It requires an initial value and a metric name for construction.
+// Optionally it can also take a couple of description strings that are used as
+// short and long descriptions respectively.
+// Internally it creates a PCP SingletonMetric with Int32Type, DiscreteSemantics
+// and CountUnit.
+func NewPCPFlag(val bool, name string, desc ...string) (*PCPFlag, error) {
+	d, err := newpcpMetricDesc(name, Int32Type, DiscreteSemantics, OneUnit, desc...)
+	if err != nil {
+		return nil, err
+	}
+
+	sm, err := newpcpSingletonMetric(flagValue(val), d)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PCPFlag{sm}, nil
+}
+
+// flagValue returns the value a flag is published as
+func flagValue(val bool) int32 {
+	if val {
+		return 1
+	}
+	return 0
+}
+
+// Val returns whether the flag is set.
+func (f *PCPFlag) Val() bool {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.value().(int32) != 0
+}
+
+// Set sets the flag to val.
+func (f *PCPFlag) Set(val bool) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.set(flagValue(val))
+}
+
+// MustSet panics if Set fails.
+func (f *PCPFlag) MustSet(val bool) {
+	must("set", f.name, f.client, f.Set(val))
+}
+
+// SetTrue sets the flag.
+func (f *PCPFlag) SetTrue() error { return f.Set(true) }
+
+// MustSetTrue panics if SetTrue fails.
+func (f *PCPFlag) MustSetTrue() { f.MustSet(true) }
+
+// SetFalse clears the flag.
+func (f *PCPFlag) SetFalse() error { return f.Set(false) }
+
+// MustSetFalse panics if SetFalse fails.
+func (f *PCPFlag) MustSetFalse() { f.MustSet(false) }
+
+// Toggle flips the flag, returning its new value.
+func (f *PCPFlag) Toggle() (bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	val := f.value().(int32) == 0
+	return val, f.set(flagValue(val))
+}
+
+// MustToggle panics if Toggle fails.
+func (f *PCPFlag) MustToggle() bool {
+	val, err := f.Toggle()
+	must("toggle", f.name, f.client, err)
+	return val
+}
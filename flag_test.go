@@ -0,0 +1,62 @@
+package speed
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestPCPFlag(t *testing.T) {
+	f, err := NewPCPFlag(true, "leader", "whether the process is the leader")
+	if err != nil {
+		t.Fatalf("cannot create flag, error: %v", err)
+	}
+
+	if f.Type() != Int32Type || f.Semantics() != DiscreteSemantics {
+		t.Errorf("expected the flag to be a discrete int32, got %v %v", f.Type(), f.Semantics())
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	c.MustRegister(f)
+	c.MustStart()
+	defer c.MustStop()
+
+	check := func(expected bool) {
+		if v := f.Val(); v != expected {
+			t.Errorf("expected the flag to be %v, got %v", expected, v)
+		}
+
+		if v, err := mmvdump.Lookup(c.writer.Bytes(), "leader"); err != nil || v != flagValue(expected) {
+			t.Errorf("expected the mapping to hold %v, got %v, error: %v", flagValue(expected), v, err)
+		}
+	}
+
+	check(true)
+
+	f.MustSetFalse()
+	check(false)
+
+	f.MustSetTrue()
+	check(true)
+
+	if v := f.MustToggle(); v {
+		t.Errorf("expected toggling a set flag to clear it")
+	}
+	check(false)
+
+	// an even number of concurrent toggles leaves the flag unchanged
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			f.MustToggle()
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	check(false)
+}
@@ -0,0 +1,96 @@
+package speed
+
+// InstanceHandle is a precomputed reference to an instance of a PCPInstanceMetric,
+// that updates it without looking the instance up by name, for metrics updated
+// on hot paths, like
+//
+//	alloc := m.MustInstance("Alloc")
+//	...
+//	alloc.MustSet(stats.Alloc)
+//
+// A handle stays valid while the client is started and restarted. If its instance
+// is deleted, updates fail until the instance is added again.
+type InstanceHandle struct {
+	m    *pcpInstanceMetric
+	name string
+	v    *instanceValue // the value of the instance, the mutex of m must be held to read it
+}
+
+// Name returns the name of the instance.
+func (h *InstanceHandle) Name() string { return h.name }
+
+// value returns the value of the instance read or written by the operation op,
+// looking the instance up again if it was deleted, as it could have been added back
+// since, the mutex of the metric must be held.
+func (h *InstanceHandle) value(op string) (*instanceValue, error) {
+	if h.v.deleted {
+		if err := h.m.checkInstance(op, h.name); err != nil {
+			return nil, err
+		}
+
+		h.v = h.m.vals[h.name]
+	}
+
+	return h.v, nil
+}
+
+// Val returns the value of the instance.
+func (h *InstanceHandle) Val() (interface{}, error) {
+	h.m.mutex.RLock()
+	defer h.m.mutex.RUnlock()
+
+	if h.v.deleted {
+		return h.m.valInstance("get", h.name)
+	}
+
+	return h.v.val, nil
+}
+
+// Set sets the value of the instance.
+func (h *InstanceHandle) Set(val interface{}) error {
+	m := h.m
+
+	val, err := m.convert(val, h.name)
+	if err != nil {
+		return err
+	}
+
+	if !m.t.IsCompatible(val) {
+		return m.errorf("set", h.name, "value %v(%T) is incompatible with type %v", val, val, m.t)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	v, err := h.value("set")
+	if err != nil {
+		return err
+	}
+
+	return m.setValue(v, val, h.name)
+}
+
+// MustSet is a Set that panics.
+func (h *InstanceHandle) MustSet(val interface{}) {
+	must("set", instanceName(h.m.name, h.name), h.m.client, h.Set(val))
+}
+
+// Instance returns a handle to an instance of the metric, setting its value
+// without looking it up by name.
+func (m *PCPInstanceMetric) Instance(instance string) (*InstanceHandle, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if err := m.checkInstance("get handle to", instance); err != nil {
+		return nil, err
+	}
+
+	return &InstanceHandle{m.pcpInstanceMetric, instance, m.vals[instance]}, nil
+}
+
+// MustInstance is an Instance that panics.
+func (m *PCPInstanceMetric) MustInstance(instance string) *InstanceHandle {
+	h, err := m.Instance(instance)
+	must("get handle to", instanceName(m.name, instance), m.client, err)
+	return h
+}
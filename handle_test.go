@@ -0,0 +1,70 @@
+package speed
+
+import (
+	"testing"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestInstanceHandle(t *testing.T) {
+	indom, err := NewPCPInstanceDomain("handle.indom", []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("cannot create instance domain, error: %v", err)
+	}
+
+	m, err := NewPCPInstanceMetricWithDefault(0, "handle.metric", indom, Uint64Type, CounterSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	if _, err = m.Instance("c"); err == nil {
+		t.Errorf("expected getting a handle to a missing instance to fail")
+	}
+
+	a, b := m.MustInstance("a"), m.MustInstance("b")
+	if a.Name() != "a" {
+		t.Errorf("expected the handle to be named a, got %v", a.Name())
+	}
+
+	a.MustSet(7)
+	if v, _ := m.ValInstance("a"); v != uint64(7) {
+		t.Errorf("expected the handle to set the instance to 7, got %v", v)
+	}
+
+	if err = a.Set("7"); err == nil {
+		t.Errorf("expected setting an incompatible value to fail")
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	c.MustRegister(m)
+
+	for i := 0; i < 2; i++ {
+		c.MustStart()
+
+		b.MustSet(uint64(10 + i))
+		if v, err := mmvdump.Lookup(c.writer.Bytes(), "handle.metric[b]"); err != nil || v != uint64(10+i) {
+			t.Errorf("expected the handle to write %v to the mapping, got %v, error: %v", 10+i, v, err)
+		}
+
+		c.MustStop()
+	}
+
+	if v, err := b.Val(); err != nil || v != uint64(11) {
+		t.Errorf("expected the handle to read 11, got %v, error: %v", v, err)
+	}
+
+	m.mutex.Lock()
+	_ = m.deleteInstance("a")
+	m.mutex.Unlock()
+
+	if err = a.Set(1); err == nil {
+		t.Errorf("expected setting a deleted instance to fail")
+	}
+
+	if _, err = a.Val(); err == nil {
+		t.Errorf("expected reading a deleted instance to fail")
+	}
+}
@@ -0,0 +1,86 @@
+package speed
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// writeStatus records whether the last write to a mapping failed.
+type writeStatus struct {
+	failed int32 // set atomically, so successful writes do not take the lock
+
+	mutex sync.Mutex
+	err   error
+}
+
+// track returns an update closure recording the result of every write.
+func (s *writeStatus) track(update updateClosure) updateClosure {
+	return func(val interface{}) error {
+		err := update(val)
+
+		if err != nil {
+			s.mutex.Lock()
+			s.err = err
+			atomic.StoreInt32(&s.failed, 1)
+			s.mutex.Unlock()
+		} else if atomic.LoadInt32(&s.failed) == 1 {
+			s.reset()
+		}
+
+		return err
+	}
+}
+
+// reset forgets a failed write
+func (s *writeStatus) reset() {
+	s.mutex.Lock()
+	s.err = nil
+	atomic.StoreInt32(&s.failed, 0)
+	s.mutex.Unlock()
+}
+
+// last returns the error of the last write if it failed.
+func (s *writeStatus) last() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.err
+}
+
+// Healthy returns an error if the client is not publishing metrics, that is if it is
+// not started, is writing to the in-memory fallback, its mapping file is missing or
+// has an unexpected size, or the last write to the mapping failed.
+//
+// It is suitable for wiring into readiness probes.
+func (c *PCPClient) Healthy() error {
+	loc := c.Location()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.r.mapped {
+		return fmt.Errorf("client is not started, its state is %v", c.state)
+	}
+
+	if c.inMemory {
+		return errors.New("metrics are written to memory instead of a mapping")
+	}
+
+	fi, err := os.Stat(loc)
+	if err != nil {
+		return fmt.Errorf("cannot find the mapping: %v", err)
+	}
+
+	if size := int64(len(c.writer.Bytes())); fi.Size() != size {
+		return fmt.Errorf("mapping %v is %v bytes instead of %v", loc, fi.Size(), size)
+	}
+
+	if err = c.writes.last(); err != nil {
+		return fmt.Errorf("last write to the mapping failed: %v", err)
+	}
+
+	return nil
+}
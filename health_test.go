@@ -0,0 +1,78 @@
+package speed
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestHealthy(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	newTestCounter(t, c, "requests")
+
+	if err = c.Healthy(); err == nil {
+		t.Errorf("expected a client that is not started to be unhealthy")
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if err = c.Healthy(); err != nil {
+		t.Errorf("expected a started client to be healthy, got %v", err)
+	}
+
+	if err = os.Truncate(c.loc, 8); err != nil {
+		t.Fatalf("cannot truncate mapping, error: %v", err)
+	}
+
+	if err = c.Healthy(); err == nil {
+		t.Errorf("expected a client with a truncated mapping to be unhealthy")
+	}
+
+	if err = os.Remove(c.loc); err != nil {
+		t.Fatalf("cannot remove mapping, error: %v", err)
+	}
+
+	if err = c.Healthy(); err == nil {
+		t.Errorf("expected a client without a mapping file to be unhealthy")
+	}
+}
+
+func TestHealthyWriteFailure(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	newTestCounter(t, c, "requests")
+
+	c.MustStart()
+	defer c.MustStop()
+
+	fail := true
+	update := c.writes.track(func(interface{}) error {
+		if fail {
+			return errors.New("out of range")
+		}
+		return nil
+	})
+
+	if err = update(1); err == nil {
+		t.Fatalf("expected the update to fail")
+	}
+
+	if err = c.Healthy(); err == nil {
+		t.Errorf("expected a client whose last write failed to be unhealthy")
+	}
+
+	fail = false
+	if err = update(1); err != nil {
+		t.Fatalf("cannot update, error: %v", err)
+	}
+
+	if err = c.Healthy(); err != nil {
+		t.Errorf("expected a successful write to make the client healthy again, got %v", err)
+	}
+}
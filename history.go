@@ -0,0 +1,188 @@
+package speed
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// HistoryEntry is a value of a metric at a point in time. For metrics with
+// instances, the value holds the values of all instances.
+type HistoryEntry struct {
+	Time  time.Time   `json:"time"`
+	Value interface{} `json:"value"`
+}
+
+// historyRing holds the last entries added to it
+type historyRing struct {
+	entries []HistoryEntry
+	next    int
+	full    bool
+}
+
+func newhistoryRing(n int) *historyRing {
+	return &historyRing{entries: make([]HistoryEntry, n)}
+}
+
+func (r *historyRing) add(e HistoryEntry) {
+	r.entries[r.next] = e
+	r.next++
+
+	if r.next == len(r.entries) {
+		r.next, r.full = 0, true
+	}
+}
+
+// list returns the entries in the ring, oldest first
+func (r *historyRing) list() []HistoryEntry {
+	if !r.full {
+		return append([]HistoryEntry(nil), r.entries[:r.next]...)
+	}
+
+	return append(append([]HistoryEntry(nil), r.entries[r.next:]...), r.entries[:r.next]...)
+}
+
+// metricValue returns the current value of a metric, and the values
+// of all instances as Instances for metrics with instances
+func metricValue(m PCPMetric) interface{} {
+	switch metric := m.(type) {
+	case singletonMetric:
+		sm := metric.singleton()
+
+		sm.mutex.RLock()
+		defer sm.mutex.RUnlock()
+
+		return sm.value()
+	case instanceMetric:
+		im := metric.instance()
+
+		im.mutex.RLock()
+		defer im.mutex.RUnlock()
+
+		vals := make(Instances, len(im.vals))
+		for name, v := range im.vals {
+			if !v.deleted {
+				vals[name] = v.val
+			}
+		}
+		return vals
+	}
+
+	return nil
+}
+
+// metricHistory samples the values of metrics into per metric rings. It is run
+// as a collector, so values are sampled every publish interval while
+// a client is started.
+type metricHistory struct {
+	mutex   sync.Mutex
+	metrics map[string]PCPMetric
+	rings   map[string]*historyRing
+	clock   Clock // timestamps the sampled values
+}
+
+func newmetricHistory(clock Clock) *metricHistory {
+	return &metricHistory{
+		metrics: make(map[string]PCPMetric),
+		rings:   make(map[string]*historyRing),
+		clock:   clock,
+	}
+}
+
+// track starts keeping the last n values of a metric
+func (h *metricHistory) track(m PCPMetric, n int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.metrics[m.Name()] = m
+	h.rings[m.Name()] = newhistoryRing(n)
+}
+
+// untrack stops keeping the values of a metric
+func (h *metricHistory) untrack(m PCPMetric) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.metrics[m.Name()] == m {
+		delete(h.metrics, m.Name())
+		delete(h.rings, m.Name())
+	}
+}
+
+// Metrics returns nil, as the history does not own any metrics.
+func (h *metricHistory) Metrics() []Metric { return nil }
+
+// Collect samples the values of all tracked metrics.
+func (h *metricHistory) Collect() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := h.clock.Now()
+	for name, m := range h.metrics {
+		h.rings[name].add(HistoryEntry{now, metricValue(m)})
+	}
+
+	return nil
+}
+
+// list returns the sampled values of a metric, oldest first,
+// and false if the metric's history is not kept
+func (h *metricHistory) list(name string) ([]HistoryEntry, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	r, ok := h.rings[name]
+	if !ok {
+		return nil, false
+	}
+
+	return r.list(), true
+}
+
+// WithHistory keeps the last n values of a metric in memory, sampled at every
+// publish interval while the client is started, so recent trends can be
+// looked at using History or the debug handler without running pmlogger.
+// n must be positive.
+func WithHistory(n int) RegisterOption {
+	return func(c *PCPClient, m Metric) error {
+		if n <= 0 {
+			return fmt.Errorf("history length %v is not positive", n)
+		}
+
+		pm, ok := m.(PCPMetric)
+		if !ok {
+			return errors.New("only PCP metrics can keep a history")
+		}
+
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		if c.history == nil {
+			c.history = newmetricHistory(c.clock)
+			c.collectors.add("history", c.history)
+		}
+
+		c.history.track(pm, n)
+
+		h := c.history
+		c.undoOnFailure(m, func() { h.untrack(pm) })
+
+		return nil
+	}
+}
+
+// History returns the values of a metric registered using WithHistory, oldest first.
+func (c *PCPClient) History(name string) ([]HistoryEntry, error) {
+	c.mutex.Lock()
+	h := c.history
+	c.mutex.Unlock()
+
+	if h != nil {
+		if entries, ok := h.list(name); ok {
+			return entries, nil
+		}
+	}
+
+	return nil, fmt.Errorf("history of metric %v is not kept, register it using WithHistory", name)
+}
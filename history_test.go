@@ -0,0 +1,110 @@
+package speed
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHistoryRing(t *testing.T) {
+	r := newhistoryRing(3)
+
+	if l := r.list(); len(l) != 0 {
+		t.Errorf("expected an empty ring, got %v", l)
+	}
+
+	values := func() []interface{} {
+		var vals []interface{}
+		for _, e := range r.list() {
+			vals = append(vals, e.Value)
+		}
+		return vals
+	}
+
+	r.add(HistoryEntry{Value: 1})
+	r.add(HistoryEntry{Value: 2})
+	if vals := values(); !reflect.DeepEqual(vals, []interface{}{1, 2}) {
+		t.Errorf("expected [1 2], got %v", vals)
+	}
+
+	r.add(HistoryEntry{Value: 3})
+	r.add(HistoryEntry{Value: 4})
+	r.add(HistoryEntry{Value: 5})
+	if vals := values(); !reflect.DeepEqual(vals, []interface{}{3, 4, 5}) {
+		t.Errorf("expected [3 4 5], got %v", vals)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	m, err := NewPCPCounter(0, "requests")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+	c.MustRegister(m, WithHistory(2))
+
+	newTestCounter(t, c, "untracked")
+
+	if _, err = c.History("untracked"); err == nil {
+		t.Errorf("expected an error getting the history of a metric registered without WithHistory")
+	}
+
+	for i := 0; i < 3; i++ {
+		m.Inc(1)
+		if err = c.history.Collect(); err != nil {
+			t.Fatalf("cannot sample history, error: %v", err)
+		}
+	}
+
+	h, err := c.History("requests")
+	if err != nil {
+		t.Fatalf("cannot get history, error: %v", err)
+	}
+
+	if len(h) != 2 {
+		t.Fatalf("expected 2 entries, got %v", len(h))
+	}
+
+	if h[0].Value != int64(2) || h[1].Value != int64(3) {
+		t.Errorf("expected values 2 and 3, got %v and %v", h[0].Value, h[1].Value)
+	}
+
+	if h[1].Time.Before(h[0].Time) {
+		t.Errorf("expected entries ordered oldest first")
+	}
+}
+
+func TestHistoryInstances(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	indom, err := NewPCPInstanceDomain("colors", []string{"red", "green"})
+	if err != nil {
+		t.Fatalf("cannot create instance domain, error: %v", err)
+	}
+
+	m, err := NewPCPInstanceMetric(Instances{"red": 1, "green": 2}, "seen", indom, Int32Type, CounterSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+	c.MustRegister(m, WithHistory(5))
+
+	if err = c.history.Collect(); err != nil {
+		t.Fatalf("cannot sample history, error: %v", err)
+	}
+
+	h, err := c.History("seen")
+	if err != nil {
+		t.Fatalf("cannot get history, error: %v", err)
+	}
+
+	expected := Instances{"red": int32(1), "green": int32(2)}
+	if len(h) != 1 || !reflect.DeepEqual(h[0].Value, expected) {
+		t.Errorf("expected a single entry with %v, got %v", expected, h)
+	}
+}
@@ -3,16 +3,19 @@ package speed
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"sync"
 )
 
 // InstanceDomain defines the interface for an instance domain
 type InstanceDomain interface {
-	ID() uint32                   // unique identifier for the instance domain
-	Name() string                 // name of the instance domain
-	Description() string          // description for the instance domain
-	HasInstance(name string) bool // checks if an instance is in the indom
-	InstanceCount() int           // returns the number of instances in the indom
-	Instances() []string          // returns a slice of instances in the instance domain
+	ID() uint32                             // unique identifier for the instance domain
+	Name() string                           // name of the instance domain
+	Description() string                    // description for the instance domain
+	HasInstance(name string) bool           // checks if an instance is in the indom
+	InstanceCount() int                     // returns the number of instances in the indom
+	Instances() []string                    // returns a slice of instances in the instance domain
+	InstanceID(name string) (uint32, error) // returns the identifier of an instance in the indom
 }
 
 // PCPInstanceDomainBitLength is the maximum bit length of a PCP Instance Domain
@@ -26,6 +29,11 @@ type PCPInstanceDomain struct {
 	name                              string
 	instances                         map[string]*pcpInstance
 	shortDescription, longDescription string
+	registry                          *PCPRegistry // the registry the instance domain is registered with
+
+	// guards instances and registry, as instances can be added and removed
+	// while the instance domain is in use, see AddInstance
+	mutex sync.RWMutex
 }
 
 // NewPCPInstanceDomain creates a new instance domain or returns an already created one for the passed name
@@ -34,28 +42,19 @@ type PCPInstanceDomain struct {
 // already been created, we create it, otherwise we return the already created version
 func NewPCPInstanceDomain(name string, instances []string, desc ...string) (*PCPInstanceDomain, error) {
 	if name == "" {
-		return nil, errors.New("Instance Domain name cannot be empty")
-	}
-
-	if len(desc) > 2 {
-		return nil, errors.New("Only 2 description strings allowed to define an instance domain")
-	}
-
-	shortDescription, longDescription := "", ""
-
-	if len(desc) > 0 {
-		shortDescription = desc[0]
+		return nil, &OpError{"create instance domain", name, "", errors.New("instance domain name cannot be empty")}
 	}
 
-	if len(desc) > 1 {
-		longDescription = desc[1]
+	shortDescription, longDescription, err := indomDescription(desc)
+	if err != nil {
+		return nil, &OpError{"create instance domain", name, "", err}
 	}
 
 	imap := make(map[string]*pcpInstance)
 
 	for _, instance := range instances {
 		if len(instance) > StringLength {
-			return nil, fmt.Errorf("instance name %v is too long", instance)
+			return nil, &OpError{"create instance domain", name, "", fmt.Errorf("instance name %v is longer than %v bytes", instance, StringLength)}
 		}
 
 		imap[instance] = newpcpInstance(instance)
@@ -70,25 +69,154 @@ func NewPCPInstanceDomain(name string, instances []string, desc ...string) (*PCP
 	}, nil
 }
 
+// indomDescription returns the short and long description from the
+// description strings passed for an instance domain
+func indomDescription(desc []string) (string, string, error) {
+	if len(desc) > 2 {
+		return "", "", errors.New("Only 2 description strings allowed to define an instance domain")
+	}
+
+	shortDescription, longDescription := "", ""
+
+	if len(desc) > 0 {
+		shortDescription = desc[0]
+	}
+
+	if len(desc) > 1 {
+		longDescription = desc[1]
+	}
+
+	return shortDescription, longDescription, nil
+}
+
 // HasInstance returns true if an instance of the specified name is in the Indom
 func (indom *PCPInstanceDomain) HasInstance(name string) bool {
+	indom.mutex.RLock()
+	defer indom.mutex.RUnlock()
+
+	return indom.hasInstance(name)
+}
+
+// hasInstance is HasInstance for callers holding the mutex
+func (indom *PCPInstanceDomain) hasInstance(name string) bool {
 	_, present := indom.instances[name]
 	return present
 }
 
+// InstanceID returns the identifier an instance is published with,
+// or an error if the instance is not in the instance domain
+func (indom *PCPInstanceDomain) InstanceID(name string) (uint32, error) {
+	indom.mutex.RLock()
+	defer indom.mutex.RUnlock()
+
+	i, present := indom.instances[name]
+	if !present {
+		return 0, fmt.Errorf("%v is not an instance of the instance domain %v", name, indom.name)
+	}
+	return i.id, nil
+}
+
 // ID returns the id for PCPInstanceDomain
 func (indom *PCPInstanceDomain) ID() uint32 { return indom.id }
 
+// SetID pins the serial the instance domain is published with, which is a hash of its
+// name by default, so configuration referring to it, like pmlogger configs, keeps working
+// when it is renamed. It has to be called before the instance domain is registered, and
+// the id must fit in PCPInstanceDomainBitLength bits.
+func (indom *PCPInstanceDomain) SetID(id uint32) error {
+	if id >= 1<<PCPInstanceDomainBitLength {
+		return &OpError{"set id", indom.name, "", fmt.Errorf("id %v is longer than %v bits", id, PCPInstanceDomainBitLength)}
+	}
+
+	indom.id = id
+	return nil
+}
+
+// AddInstance adds an instance to the instance domain, with a zero value in all metrics over it,
+// for instances that come and go, like connections or disks. If the instance domain is
+// registered with a started client, the registry is written to the mapping again, so
+// adding instances is best done as they appear rather than on every update.
+//
+// It is safe to call concurrently with reading the instances, and is not part of the
+// InstanceDomain interface, so implementations of it can keep a fixed set of instances.
+func (indom *PCPInstanceDomain) AddInstance(name string) error {
+	if len(name) > StringLength {
+		return &OpError{"add", instanceName(indom.name, name), "", fmt.Errorf("instance name is longer than %v bytes", StringLength)}
+	}
+
+	return indom.changeInstance("add", name, true)
+}
+
+// RemoveInstance removes an instance from the instance domain and its values from all
+// metrics over it. Like AddInstance, it rewrites the mapping of a started client.
+func (indom *PCPInstanceDomain) RemoveInstance(name string) error {
+	return indom.changeInstance("remove", name, false)
+}
+
+// changeInstance adds or removes an instance, through the registry if the
+// instance domain is registered, so the metrics over it change with it
+func (indom *PCPInstanceDomain) changeInstance(op, name string, add bool) error {
+	indom.mutex.Lock()
+	r := indom.registry
+
+	var err error
+	if r == nil {
+		if err = checkChange(indom, name, add); err == nil {
+			if add {
+				indom.instances[name] = newpcpInstance(name)
+			} else {
+				delete(indom.instances, name)
+			}
+		}
+	}
+	indom.mutex.Unlock()
+
+	if r != nil {
+		err = r.changeInstance(indom, name, add)
+	}
+
+	if err != nil && err != ErrClientStarted {
+		return &OpError{op, instanceName(indom.name, name), "", err}
+	}
+
+	return err
+}
+
+// checkChange returns an error if an instance cannot be added to or removed from an indom,
+// the mutex of the indom must be held
+func checkChange(indom *PCPInstanceDomain, name string, add bool) error {
+	if add && indom.hasInstance(name) {
+		return fmt.Errorf("%v is already an instance of the instance domain %v", name, indom.name)
+	}
+
+	if !add && !indom.hasInstance(name) {
+		return fmt.Errorf("%v is not an instance of the instance domain %v", name, indom.name)
+	}
+
+	return nil
+}
+
 // Name returns the name for PCPInstanceDomain
 func (indom *PCPInstanceDomain) Name() string { return indom.name }
 
 // InstanceCount returns the number of instances in the current instance domain
 func (indom *PCPInstanceDomain) InstanceCount() int {
+	indom.mutex.RLock()
+	defer indom.mutex.RUnlock()
+
 	return len(indom.instances)
 }
 
 // Instances returns a slice of defined instances for the instance domain
 func (indom *PCPInstanceDomain) Instances() []string {
+	indom.mutex.RLock()
+	defer indom.mutex.RUnlock()
+
+	return indom.instanceNames()
+}
+
+// instanceNames is Instances for callers holding the mutex
+func (indom *PCPInstanceDomain) instanceNames() []string {
 	ans, i := make([]string, len(indom.instances)), 0
 	for k := range indom.instances {
 		ans[i] = k
@@ -97,9 +225,28 @@ func (indom *PCPInstanceDomain) Instances() []string {
 	return ans
 }
 
+// sortedInstances returns the instances of the instance domain ordered by name,
+// which is the order they are laid out in a mapping
+func (indom *PCPInstanceDomain) sortedInstances() []*pcpInstance {
+	indom.mutex.RLock()
+	defer indom.mutex.RUnlock()
+
+	names := indom.instanceNames()
+	sort.Strings(names)
+
+	ans := make([]*pcpInstance, len(names))
+	for i, name := range names {
+		ans[i] = indom.instances[name]
+	}
+	return ans
+}
+
 // MatchInstances returns true if the passed InstanceDomain
 // has exactly the same instances as the passed array
 func (indom *PCPInstanceDomain) MatchInstances(ins []string) bool {
+	indom.mutex.RLock()
+	defer indom.mutex.RUnlock()
+
 	if len(ins) != len(indom.instances) {
 		return false
 	}
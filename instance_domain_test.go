@@ -0,0 +1,230 @@
+package speed
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestInstanceDomainAccessors(t *testing.T) {
+	indom, err := NewPCPInstanceDomain("test.indom", []string{"a", "b"}, "short", "long")
+	if err != nil {
+		t.Fatalf("cannot create instance domain, error: %v", err)
+	}
+
+	var i InstanceDomain = indom
+
+	if !i.HasInstance("a") || i.HasInstance("c") {
+		t.Error("expected HasInstance to only be true for instances in the indom")
+	}
+
+	id, err := i.InstanceID("b")
+	if err != nil {
+		t.Errorf("cannot get instance id, error: %v", err)
+	} else if id != hash("b", 0) {
+		t.Errorf("expected instance id of b to be %v, got %v", hash("b", 0), id)
+	}
+
+	if _, err = i.InstanceID("c"); err == nil {
+		t.Error("expected getting the id of an instance not in the indom to fail")
+	}
+
+	if d := i.Description(); d != "short\nlong" {
+		t.Errorf("expected description to be %q, got %q", "short\nlong", d)
+	}
+}
+
+func TestInstanceDomainSetID(t *testing.T) {
+	indom, err := NewPCPInstanceDomain("test.indom", []string{"a"})
+	if err != nil {
+		t.Fatalf("cannot create instance domain, error: %v", err)
+	}
+
+	if err = indom.SetID(1 << PCPInstanceDomainBitLength); err == nil {
+		t.Error("expected an id longer than PCPInstanceDomainBitLength bits to fail")
+	}
+
+	if err = indom.SetID(42); err != nil {
+		t.Fatalf("cannot set id, error: %v", err)
+	}
+
+	if indom.ID() != 42 {
+		t.Errorf("expected id to be 42, got %v", indom.ID())
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.MustRegisterIndom(indom)
+
+	other, err := NewPCPInstanceDomain("test.other", []string{"b"})
+	if err != nil {
+		t.Fatalf("cannot create instance domain, error: %v", err)
+	}
+
+	_ = other.SetID(42)
+	if err = c.RegisterIndom(other); err == nil {
+		t.Error("expected registering an instance domain with a taken id to fail")
+	}
+}
+
+func TestInstanceDomainAddInstance(t *testing.T) {
+	indom, err := NewPCPInstanceDomain("test.disks", []string{"sda"})
+	if err != nil {
+		t.Fatalf("cannot create instance domain, error: %v", err)
+	}
+
+	reads, err := NewPCPInstanceMetric(Instances{"sda": int64(1)}, "test.reads", indom, Int64Type, CounterSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	// changes to an unregistered instance domain are picked up by its metrics on registration
+	if err = indom.AddInstance("sdb"); err != nil {
+		t.Fatalf("cannot add instance, error: %v", err)
+	}
+
+	if err = indom.AddInstance("sdb"); err == nil {
+		t.Error("expected adding an existing instance to fail")
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	c.MustRegister(reads)
+	reads.MustSetInstance(int64(2), "sdb")
+
+	writes, err := NewPCPInstanceMetricWithDefault(int64(0), "test.writes", indom, Int64Type, CounterSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+	c.MustRegister(writes)
+
+	// changes to a registered instance domain apply to all metrics over it
+	if err = indom.AddInstance("sdc"); err != nil {
+		t.Fatalf("cannot add instance, error: %v", err)
+	}
+
+	if v, err := writes.ValInstance("sdc"); err != nil || v != int64(0) {
+		t.Errorf("expected the new instance to be 0, got %v, error: %v", v, err)
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if err = indom.AddInstance("sdd"); err != nil {
+		t.Fatalf("cannot add instance after Start, error: %v", err)
+	}
+	reads.MustSetInstance(int64(4), "sdd")
+
+	sda := reads.MustInstance("sda")
+	if err = indom.RemoveInstance("sda"); err != nil {
+		t.Fatalf("cannot remove instance after Start, error: %v", err)
+	}
+
+	if err = sda.Set(int64(5)); err == nil {
+		t.Error("expected setting a removed instance through a handle to fail")
+	}
+
+	if err = indom.RemoveInstance("sda"); err == nil {
+		t.Error("expected removing a missing instance to fail")
+	}
+
+	if err = reads.SetInstance(int64(5), "sda"); err == nil {
+		t.Error("expected setting a removed instance to fail")
+	}
+
+	if c.Registry().InstanceCount() != 3 || c.Registry().ValuesCount() != 6 {
+		t.Errorf("expected 3 instances and 6 values, got %v and %v", c.Registry().InstanceCount(), c.Registry().ValuesCount())
+	}
+
+	expected := map[string]interface{}{
+		"test.reads[sdb]":  int64(2),
+		"test.reads[sdd]":  int64(4),
+		"test.writes[sdd]": int64(0),
+	}
+	for name, want := range expected {
+		if val, err := mmvdump.Lookup(c.writer.Bytes(), name); err != nil || val != want {
+			t.Errorf("expected %v to be %v, got %v, error: %v", name, want, val, err)
+		}
+	}
+
+	if _, err := mmvdump.Lookup(c.writer.Bytes(), "test.reads[sda]"); err == nil {
+		t.Error("expected the removed instance to not be in the mapping")
+	}
+}
+
+func TestInstanceDomainConcurrentChanges(t *testing.T) {
+	indom, err := NewPCPInstanceDomain("test.conns", []string{"a"})
+	if err != nil {
+		t.Fatalf("cannot create instance domain, error: %v", err)
+	}
+
+	m, err := NewPCPInstanceMetric(Instances{"a": int64(1)}, "test.bytes", indom, Int64Type, CounterSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	c.MustRegister(m)
+	c.MustStart()
+	defer c.MustStop()
+
+	var wg, ready sync.WaitGroup
+	done := make(chan struct{})
+
+	// readers of the instances, which the race detector checks against the changes
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		ready.Add(1)
+		go func() {
+			defer wg.Done()
+			ready.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				_ = indom.HasInstance("b0")
+				_, _ = indom.InstanceID("a")
+				_ = indom.InstanceCount()
+				_ = indom.MatchInstances([]string{"a"})
+				_ = indom.String()
+			}
+		}()
+	}
+
+	ready.Wait()
+
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("b%v", i)
+		if err = indom.AddInstance(name); err != nil {
+			t.Errorf("cannot add instance %v, error: %v", name, err)
+		}
+
+		if i%2 == 0 {
+			if err = indom.RemoveInstance(name); err != nil {
+				t.Errorf("cannot remove instance %v, error: %v", name, err)
+			}
+		}
+	}
+
+	close(done)
+	wg.Wait()
+
+	if n := indom.InstanceCount(); n != 11 {
+		t.Errorf("expected 11 instances, got %v", n)
+	}
+}
@@ -0,0 +1,205 @@
+package speed
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// PCPInt64Histogram counts arbitrary int64 values, like request sizes or batch sizes,
+// into buckets chosen for the unit of the values, publishing the number of values in
+// every bucket as an instance of a counter.
+//
+// Unlike PCPHistogram, which is meant for durations, the values need not fit in its
+// range, and the distribution can be read by PCP itself, without percentiles.
+//
+// The buckets are bounded by powers of 4 of the unit for space units, so byte sizes are
+// counted in 1KiB, 4KiB, 16KiB... buckets, and by 1, 2 and 5 times powers of 10 otherwise.
+// Every bucket holds the values larger than the bound of the previous one and not larger
+// than its own, and is named after its bounds in the unit, like 1KiB-4KiB or 20ms-50ms,
+// with values not larger than the lowest bound counted in <=low and values larger than
+// the highest bound counted in >high.
+type PCPInt64Histogram struct {
+	*pcpInstanceMetric
+	bounds    []int64  // upper bounds of the buckets, ascending
+	instances []string // names of the buckets, one more than bounds for the overflow
+}
+
+// NewPCPInt64Histogram creates a new PCPInt64Histogram counting values in unit between
+// low and high, which are the lowest and highest bucket bounds, and must be increasing.
+// Optionally, a couple of description strings may be passed as the short and long
+// descriptions of the metric.
+//
+// The counts are published as an Int64Type metric with CounterSemantics and OneUnit,
+// over an instance domain of the buckets.
+func NewPCPInt64Histogram(name string, low, high int64, unit MetricUnit, desc ...string) (*PCPInt64Histogram, error) {
+	if low >= high {
+		return nil, &OpError{"create metric", name, "", fmt.Errorf("low %v must be less than high %v", low, high)}
+	}
+
+	bounds := unitBounds(low, high, unit)
+	instances := bucketNames(bounds, unit)
+
+	vals := make(Instances)
+	for _, s := range instances {
+		vals[s] = int64(0)
+	}
+
+	m, err := generateInstanceMetric(vals, name, instances, Int64Type, CounterSemantics, OneUnit, desc...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PCPInt64Histogram{m, bounds, instances}, nil
+}
+
+// unitBounds returns the bucket bounds for values in unit u between low and high,
+// from low to high with the steps of the unit in between
+func unitBounds(low, high int64, u MetricUnit) []int64 {
+	next := func(v int64) int64 {
+		if v > math.MaxInt64/4 {
+			return high
+		}
+
+		if _, ok := u.(SpaceUnit); ok {
+			return v * 4
+		}
+
+		// 1, 2, 5, 10, 20, 50...
+		for d := v; ; d /= 10 {
+			if d == 2 {
+				return v / 2 * 5
+			} else if d < 10 {
+				return v * 2
+			}
+		}
+	}
+
+	bounds := []int64{low}
+	for v := int64(1); v < high; v = next(v) {
+		if v > low {
+			bounds = append(bounds, v)
+		}
+	}
+
+	return append(bounds, high)
+}
+
+var (
+	spaceUnitNames = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+
+	timeUnitNames = []string{"ns", "us", "ms", "s", "min", "h"}
+	timeUnitSteps = []int64{1000, 1000, 1000, 60, 60}
+)
+
+// formatBound returns a bucket bound in unit u, in the largest unit it is a multiple of
+func formatBound(v int64, u MetricUnit) string {
+	switch u := u.(type) {
+	case SpaceUnit:
+		s := int(u >> 16 & 0xf)
+		for v != 0 && v%1024 == 0 && s < len(spaceUnitNames)-1 {
+			v, s = v/1024, s+1
+		}
+
+		if s < len(spaceUnitNames) {
+			return strconv.FormatInt(v, 10) + spaceUnitNames[s]
+		}
+	case TimeUnit:
+		s := int(u >> 12 & 0xf)
+		for s < len(timeUnitSteps) && v != 0 && v%timeUnitSteps[s] == 0 {
+			v, s = v/timeUnitSteps[s], s+1
+		}
+
+		if s < len(timeUnitNames) {
+			return strconv.FormatInt(v, 10) + timeUnitNames[s]
+		}
+	}
+
+	return strconv.FormatInt(v, 10)
+}
+
+// bucketNames returns the names of the buckets with bounds in unit u
+func bucketNames(bounds []int64, u MetricUnit) []string {
+	names := make([]string, len(bounds)+1)
+	names[0] = "<=" + formatBound(bounds[0], u)
+	for i := 1; i < len(bounds); i++ {
+		names[i] = formatBound(bounds[i-1], u) + "-" + formatBound(bounds[i], u)
+	}
+	names[len(bounds)] = ">" + formatBound(bounds[len(bounds)-1], u)
+	return names
+}
+
+// bucketOf returns the index of the bucket counting val, given the upper bounds of the buckets
+func bucketOf(bounds []int64, val int64) int {
+	return sort.Search(len(bounds), func(i int) bool { return val <= bounds[i] })
+}
+
+// countedBuckets returns the buckets with the passed upper bounds, with their counts in the
+// values of the instances named after them, followed by the bucket of values larger than all bounds
+func countedBuckets(bounds []int64, instances []string, vals map[string]*instanceValue) []*HistogramBucket {
+	buckets := make([]*HistogramBucket, len(bounds)+1)
+	from := int64(math.MinInt64)
+	for i := range buckets {
+		to := int64(math.MaxInt64)
+		if i < len(bounds) {
+			to = bounds[i]
+		}
+
+		buckets[i] = &HistogramBucket{from, to, vals[instances[i]].val.(int64)}
+		from = to + 1
+	}
+
+	return buckets
+}
+
+// Record counts a new value.
+func (h *PCPInt64Histogram) Record(val int64) error {
+	return h.RecordN(val, 1)
+}
+
+// MustRecord panics if Record fails.
+func (h *PCPInt64Histogram) MustRecord(val int64) {
+	must("record", h.name, h.client, h.Record(val))
+}
+
+// RecordN counts the same value n times.
+func (h *PCPInt64Histogram) RecordN(val, n int64) error {
+	if n < 0 {
+		return h.errorf("record", "", "cannot record a value %v times", n)
+	}
+
+	if !h.enabled() {
+		return nil
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	instance := h.instances[bucketOf(h.bounds, val)]
+	return h.setInstance(h.vals[instance].val.(int64)+n, instance)
+}
+
+// MustRecordN panics if RecordN fails.
+func (h *PCPInt64Histogram) MustRecordN(val, n int64) {
+	must("record", h.name, h.client, h.RecordN(val, n))
+}
+
+// Bounds returns the upper bounds of all buckets but the last, which is unbounded.
+func (h *PCPInt64Histogram) Bounds() []int64 {
+	return append([]int64(nil), h.bounds...)
+}
+
+// Buckets returns the buckets with the number of values counted in each, ordered by their
+// bounds. The first bucket starts at math.MinInt64 and the last ends at math.MaxInt64.
+func (h *PCPInt64Histogram) Buckets() []*HistogramBucket {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	return countedBuckets(h.bounds, h.instances, h.vals)
+}
+
+// BucketName returns the name of the instance counting val.
+func (h *PCPInt64Histogram) BucketName(val int64) string {
+	return h.instances[bucketOf(h.bounds, val)]
+}
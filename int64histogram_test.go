@@ -0,0 +1,107 @@
+package speed
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestUnitBounds(t *testing.T) {
+	cases := []struct {
+		low, high int64
+		unit      MetricUnit
+		bounds    []int64
+		names     []string
+	}{
+		{
+			0, 100, OneUnit,
+			[]int64{0, 1, 2, 5, 10, 20, 50, 100},
+			[]string{"<=0", "0-1", "1-2", "2-5", "5-10", "10-20", "20-50", "50-100", ">100"},
+		},
+		{
+			512, 1 << 20, ByteUnit,
+			[]int64{512, 1024, 4096, 16384, 65536, 262144, 1 << 20},
+			[]string{"<=512B", "512B-1KiB", "1KiB-4KiB", "4KiB-16KiB", "16KiB-64KiB", "64KiB-256KiB", "256KiB-1MiB", ">1MiB"},
+		},
+		{
+			1, 3000, MillisecondUnit,
+			[]int64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 3000},
+			[]string{"<=1ms", "1ms-2ms", "2ms-5ms", "5ms-10ms", "10ms-20ms", "20ms-50ms", "50ms-100ms", "100ms-200ms", "200ms-500ms", "500ms-1s", "1s-2s", "2s-3s", ">3s"},
+		},
+		{
+			-10, 1, KilobyteUnit,
+			[]int64{-10, 1},
+			[]string{"<=-10KiB", "-10KiB-1KiB", ">1KiB"},
+		},
+	}
+
+	for _, c := range cases {
+		bounds := unitBounds(c.low, c.high, c.unit)
+		if !reflect.DeepEqual(bounds, c.bounds) {
+			t.Errorf("expected the bounds between %v and %v in %v to be %v, got %v", c.low, c.high, c.unit, c.bounds, bounds)
+		}
+
+		if names := bucketNames(bounds, c.unit); !reflect.DeepEqual(names, c.names) {
+			t.Errorf("expected the buckets between %v and %v in %v to be named %v, got %v", c.low, c.high, c.unit, c.names, names)
+		}
+	}
+
+	if bounds := unitBounds(0, math.MaxInt64, ByteUnit); bounds[len(bounds)-1] != math.MaxInt64 || bounds[len(bounds)-2] != 1<<62 {
+		t.Errorf("expected the bounds to end with 4^31 and the highest int64, got %v", bounds)
+	}
+}
+
+func TestPCPInt64Histogram(t *testing.T) {
+	if _, err := NewPCPInt64Histogram("sizes", 10, 10, ByteUnit); err == nil {
+		t.Errorf("expected creating a histogram with equal bounds to fail")
+	}
+
+	h, err := NewPCPInt64Histogram("sizes", 1024, 1<<20, ByteUnit, "request sizes")
+	if err != nil {
+		t.Fatalf("cannot create histogram, error: %v", err)
+	}
+
+	if h.Type() != Int64Type || h.Semantics() != CounterSemantics || h.Unit() != OneUnit {
+		t.Errorf("expected the histogram to be an int64 counter of OneUnit, got %v %v %v", h.Type(), h.Semantics(), h.Unit())
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	c.MustRegister(h)
+	c.MustStart()
+	defer c.MustStop()
+
+	h.MustRecord(100)
+	h.MustRecord(2000)
+	h.MustRecordN(4096, 3)
+	h.MustRecord(1 << 30)
+
+	if err = h.RecordN(1, -1); err == nil {
+		t.Errorf("expected recording a value a negative number of times to fail")
+	}
+
+	expected := map[string]int64{"<=1KiB": 1, "1KiB-4KiB": 4, ">1MiB": 1, "64KiB-256KiB": 0}
+	for instance, count := range expected {
+		if v, err := mmvdump.Lookup(c.writer.Bytes(), "sizes["+instance+"]"); err != nil || v != count {
+			t.Errorf("expected %v values in bucket %v, got %v, error: %v", count, instance, v, err)
+		}
+	}
+
+	if b := h.BucketName(5000); b != "4KiB-16KiB" {
+		t.Errorf("expected 5000 to be counted in 4KiB-16KiB, got %v", b)
+	}
+
+	buckets := h.Buckets()
+	if len(buckets) != len(h.Bounds())+1 {
+		t.Fatalf("expected a bucket for every bound and the overflow, got %v", len(buckets))
+	}
+
+	first, second, last := buckets[0], buckets[1], buckets[len(buckets)-1]
+	if *first != (HistogramBucket{math.MinInt64, 1024, 1}) || *second != (HistogramBucket{1025, 4096, 4}) || *last != (HistogramBucket{1<<20 + 1, math.MaxInt64, 1}) {
+		t.Errorf("unexpected buckets %+v, %+v and %+v", *first, *second, *last)
+	}
+}
@@ -0,0 +1,112 @@
+package speed
+
+import (
+	"sync"
+	"time"
+)
+
+// JobMetrics maintain the metrics of a batch job, like a cron task, instrumented using
+// InstrumentJob, and are updated by running the job using Run. They are safe for
+// concurrent use.
+type JobMetrics struct {
+	client *PCPClient
+	clock  Clock
+
+	mutex    sync.Mutex // serializes the updates of concurrent runs
+	start    *PCPSingletonMetric
+	success  *PCPSingletonMetric
+	duration *PCPSingletonMetric
+	failures *PCPSingletonMetric
+}
+
+// InstrumentJob registers metrics for a batch job with the client, all named under name,
+// and returns them. The registered metrics are
+//
+// name.last_start, the time the last run started at, in seconds since the unix epoch
+//
+// name.last_success, the time the last successful run finished at, in seconds since
+// the unix epoch, or 0 if no run succeeded
+//
+// name.last_duration, the time the last finished run took, in seconds
+//
+// name.consecutive_failures, the number of runs that failed since the last successful one
+//
+// The metrics read the time from the clock the client has when the job is instrumented.
+func InstrumentJob(c *PCPClient, name string) (*JobMetrics, error) {
+	j := &JobMetrics{client: c, clock: c.getClock()}
+
+	metrics := []struct {
+		metric **PCPSingletonMetric
+		name   string
+		val    interface{}
+		t      MetricType
+		s      MetricSemantics
+		u      MetricUnit
+		desc   string
+	}{
+		{&j.start, "last_start", int64(0), Int64Type, DiscreteSemantics, SecondUnit, "time the last run started at, in seconds since the epoch"},
+		{&j.success, "last_success", int64(0), Int64Type, DiscreteSemantics, SecondUnit, "time the last successful run finished at, in seconds since the epoch"},
+		{&j.duration, "last_duration", float64(0), DoubleType, InstantSemantics, SecondUnit, "time the last finished run took"},
+		{&j.failures, "consecutive_failures", int64(0), Int64Type, InstantSemantics, OneUnit, "runs that failed since the last successful one"},
+	}
+
+	var err error
+	for _, m := range metrics {
+		if *m.metric, err = NewPCPSingletonMetric(m.val, name+"."+m.name, m.t, m.s, m.u, m.desc); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, m := range metrics {
+		if err = c.Register(*m.metric); err != nil {
+			return nil, err
+		}
+	}
+
+	return j, nil
+}
+
+// MustInstrumentJob panics if InstrumentJob fails.
+func MustInstrumentJob(c *PCPClient, name string) *JobMetrics {
+	j, err := InstrumentJob(c, name)
+	must("instrument job", name, c.name(), err)
+	return j
+}
+
+// Run runs the job f, updating the metrics of the job, and returns the error of f.
+// A run fails if f returns an error or panics, in which case the panic is continued
+// once the metrics are updated.
+func (j *JobMetrics) Run(f func() error) (err error) {
+	start := j.clock.Now()
+
+	j.mutex.Lock()
+	j.client.reportError(j.start.Set(start.Unix()))
+	j.mutex.Unlock()
+
+	failed := true
+	defer func() {
+		j.finish(start, failed)
+	}()
+
+	err = f()
+	failed = err != nil
+	return err
+}
+
+// finish updates the metrics of the job after a run that started at start
+func (j *JobMetrics) finish(start time.Time, failed bool) {
+	end := j.clock.Now()
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.client.reportError(j.duration.Set(end.Sub(start).Seconds()))
+
+	if failed {
+		j.client.reportError(j.failures.Set(j.failures.Val().(int64) + 1))
+		return
+	}
+
+	j.client.reportError(j.success.Set(end.Unix()))
+	j.client.reportError(j.failures.Set(int64(0)))
+}
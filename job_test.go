@@ -0,0 +1,81 @@
+package speed
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestInstrumentJob(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	clock := NewManualClock(time.Unix(1000, 0))
+	if err = c.SetClock(clock); err != nil {
+		t.Fatalf("cannot set clock, error: %v", err)
+	}
+
+	j := MustInstrumentJob(c, "backup")
+
+	c.MustStart()
+	defer c.MustStop()
+
+	check := func(expected map[string]interface{}) {
+		for name, val := range expected {
+			if v, err := mmvdump.Lookup(c.writer.Bytes(), "backup."+name); err != nil || v != val {
+				t.Errorf("expected %v to be %v, got %v, error: %v", name, val, v, err)
+			}
+		}
+	}
+
+	failure := errors.New("disk full")
+	for i := 0; i < 2; i++ {
+		if err = j.Run(func() error {
+			clock.Advance(3 * time.Second)
+			return failure
+		}); err != failure {
+			t.Errorf("expected the run to return the error of the job, got %v", err)
+		}
+	}
+
+	check(map[string]interface{}{
+		"last_start":           int64(1003),
+		"last_success":         int64(0),
+		"last_duration":        float64(3),
+		"consecutive_failures": int64(2),
+	})
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("expected the panic of the job to be continued, got %v", r)
+			}
+		}()
+
+		_ = j.Run(func() error { panic("boom") })
+	}()
+
+	check(map[string]interface{}{
+		"last_start":           int64(1006),
+		"last_duration":        float64(0),
+		"consecutive_failures": int64(3),
+	})
+
+	if err = j.Run(func() error {
+		clock.Advance(1500 * time.Millisecond)
+		return nil
+	}); err != nil {
+		t.Errorf("expected a successful run to return nil, got %v", err)
+	}
+
+	check(map[string]interface{}{
+		"last_start":           int64(1006),
+		"last_success":         int64(1007),
+		"last_duration":        float64(1.5),
+		"consecutive_failures": int64(0),
+	})
+}
@@ -0,0 +1,583 @@
+package speed
+
+import (
+	"unsafe"
+
+	"github.com/performancecopilot/speed/bytewriter"
+)
+
+// number of offsets reserved in the arena for each component of a mapping
+const (
+	indomSlots    = 3 // indom, shorttext, longtext
+	instanceSlots = 2 // instance, external name (mmv2)
+	metricSlots   = 4 // metric, name (mmv2), shorttext, longtext
+	valueSlots    = 2 // value, string payload
+)
+
+// number of words of the descriptor of each component in the arena, which holds
+// everything written for the component that is not an offset or a value, with every
+// string taking two words, its position in the strings of the arena and its length
+const (
+	indomDescWords    = 5  // id, shorttext, longtext
+	instanceDescWords = 3  // id, name
+	metricDescWords   = 11 // id, type, semantics, unit, indom, name, shorttext, longtext
+)
+
+// wordLength is the length of a word of the arena
+const wordLength = int(unsafe.Sizeof(int(0)))
+
+// wordAlignment is the alignment of every value in a mapping, which 64 bit atomic
+// operations require on 386 and arm. Mappings are page aligned, and the lengths of
+// all components are multiples of it, so every value is aligned as long as the values
+// section is, which TestValueWordAlignment checks for all kinds of layouts.
+const wordAlignment = 8
+
+// mmvLayout stores the position of every component of a registry in a mapping,
+// along with everything written for it.
+//
+// The descriptors, strings and offsets of all components live in a single arena
+// that is allocated once when the layout is computed, so a registry with thousands
+// of metrics costs one allocation for its metadata instead of one (plus a goroutine)
+// per component, and writing a mapping reads it from one place instead of chasing
+// the pointers of every metric and instance domain.
+//
+// The arena starts with an index holding the position of the slots and of the descriptor
+// of every instance domain and metric, followed by their records, and ends with the bytes
+// of all names and descriptions. The slots of an instance domain are followed by those
+// of its instances, and the slots of a metric by those of its values, and the same goes
+// for their descriptors. Values stay with the metrics, which are bound to the mapping.
+// A zero offset marks a component that is not written, the same way MMV marks
+// an absent string.
+type mmvLayout struct {
+	enc encoder // encoder the mapping is written with
+
+	indoms  []*PCPInstanceDomain // instance domains, ordered by name
+	metrics []PCPMetric          // metrics, ordered by name, which values are bound to
+
+	arena   []int  // index, slots and descriptors of all components, followed by their strings
+	strings []byte // strings at the end of the arena
+
+	// offsets of the different sections
+	indomoffset    int
+	instanceoffset int
+	metricsoffset  int
+	valuesoffset   int
+	stringsoffset  int
+	length         int
+
+	// number of components written to each section, which can be followed
+	// by room reserved for instances added later, see SetInstanceHeadroom
+	ninstances, nvalues, nstrings int
+
+	gen int64 // generation of the mapping the layout was written to
+}
+
+// Hot marks a metric as updated very frequently from multiple goroutines, so its values
+// are placed at the start of their own cache lines in the mapping, as in
+//
+//	c.MustRegister(requests, speed.Hot())
+//
+// Values in the mapping are 32 bytes long, so two of them fit in a cache line, and
+// adjacent counters updated by different goroutines thrash the line they share.
+// Every hot value shares its line with a value of a metric that is not hot instead,
+// which should be one that is rarely updated for this to help. Registering hot metrics
+// aligns the values section of the mapping to bytewriter.CacheLineSize.
+func Hot() RegisterOption {
+	return func(c *PCPClient, m Metric) error {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		if c.hot == nil {
+			c.hot = make(map[string]bool)
+		}
+
+		if !c.hot[m.Name()] {
+			c.hot[m.Name()] = true
+			c.undoOnFailure(m, func() { delete(c.hot, m.Name()) })
+		}
+
+		return nil
+	}
+}
+
+// headroom returns the number of instances, values and strings reserved in a mapping
+// of the registry for n instances added after it is written, which is enough for
+// adding them to the instance domain having the most metrics, see SetInstanceHeadroom
+func headroom(r *PCPRegistry, enc encoder, n int) (instances, values, strings int) {
+	if n == 0 || r.InstanceDomainCount() == 0 {
+		return 0, 0, 0
+	}
+
+	vals, strs := make(map[InstanceDomain]int), make(map[InstanceDomain]int)
+	for _, m := range r.sortedMetrics() {
+		if m.Indom() == nil {
+			continue
+		}
+
+		vals[m.Indom()]++
+		if m.Type() == StringType {
+			strs[m.Indom()]++
+		}
+
+		if vals[m.Indom()] > values {
+			values = vals[m.Indom()]
+		}
+
+		if strs[m.Indom()] > strings {
+			strings = strs[m.Indom()]
+		}
+	}
+
+	// external names of instances can be strings
+	if enc.nameStrings() {
+		strings++
+	}
+
+	return n, n * values, n * strings
+}
+
+// alignOffset rounds an offset up to the next multiple of align,
+// an align of 0 leaves it unchanged
+func alignOffset(offset, align int) int {
+	if align == 0 {
+		return offset
+	}
+	return (offset + align - 1) / align * align
+}
+
+// newmmvLayout computes the layout of the passed registry,
+// for a mapping written by enc having tocCount TOC entries, with the values
+// section starting at a multiple of align, reserving room for
+// reserve instances added after the mapping is written.
+//
+// Values of the metrics named in hot are placed at the start of a cache line, with the
+// rest of the line taken by a value of a metric that is not hot, so updates to hot values
+// do not invalidate the cache lines of other hot values. This requires align to be a
+// multiple of the cache line size. Once values of metrics that are not hot run out,
+// the remaining hot values share lines with each other.
+func newmmvLayout(r *PCPRegistry, enc encoder, tocCount int, align int, hot map[string]bool, reserve int) *mmvLayout {
+	InstanceLength, MetricLength := enc.instanceLength(), enc.metricLength()
+
+	l := &mmvLayout{
+		enc:     enc,
+		indoms:  r.sortedInstanceDomains(),
+		metrics: r.sortedMetrics(),
+	}
+
+	reservedInstances, reservedValues, reservedStrings := headroom(r, enc, reserve)
+
+	l.indomoffset = HeaderLength + TocLength*tocCount
+	l.instanceoffset = l.indomoffset + InstanceDomainLength*len(l.indoms)
+	l.metricsoffset = l.instanceoffset + InstanceLength*(r.InstanceCount()+reservedInstances)
+	l.valuesoffset = alignOffset(l.metricsoffset+MetricLength*len(l.metrics), align)
+	l.stringsoffset = l.valuesoffset + ValueLength*(r.ValuesCount()+reservedValues)
+	l.length = l.stringsoffset + StringLength*(stringCount(r, enc)+reservedStrings)
+
+	instances := make([][]*pcpInstance, len(l.indoms))
+	counts := make(map[InstanceDomain]int, len(l.indoms))
+
+	words, strs := 0, 0
+	for i, indom := range l.indoms {
+		instances[i] = indom.sortedInstances()
+		counts[indom] = len(instances[i])
+
+		w, n := indomSize(indom, instances[i])
+		words, strs = words+w, strs+n
+	}
+	for _, m := range l.metrics {
+		w, n := metricSize(m, valuesOf(m, counts))
+		words, strs = words+w, strs+n
+	}
+
+	b := newarenaBuilder(len(l.indoms)+len(l.metrics), words, strs)
+	l.arena, l.strings = b.arena, b.strings
+
+	var (
+		indomoff    = l.indomoffset
+		instanceoff = l.instanceoffset
+		metricoff   = l.metricsoffset
+		valueoff    = l.valuesoffset
+		stringoff   = l.stringsoffset
+	)
+
+	str := func(present bool) int {
+		if !present {
+			return 0
+		}
+		off := stringoff
+		stringoff += StringLength
+		return off
+	}
+
+	for i, indom := range l.indoms {
+		b.begin()
+		b.word(indomoff)
+		b.word(str(indom.shortDescription != ""))
+		b.word(str(indom.longDescription != ""))
+		indomoff += InstanceDomainLength
+
+		for range instances[i] {
+			b.word(instanceoff)
+			b.word(str(l.enc.nameStrings()))
+			instanceoff += InstanceLength
+		}
+
+		b.indomDescriptor(indom, instances[i])
+	}
+
+	// positions of the value slots in the arena, of hot and other metrics
+	var hotvals, coldvals []int
+
+	for _, m := range l.metrics {
+		b.begin()
+		b.word(metricoff)
+		b.word(str(l.enc.nameStrings()))
+		b.word(str(m.ShortDescription() != ""))
+		b.word(str(m.LongDescription() != ""))
+		metricoff += MetricLength
+
+		for j := 0; j < valuesOf(m, counts); j++ {
+			// the offset of the value is set once all values are placed
+			pos := b.word(0)
+			if hot[m.Name()] {
+				hotvals = append(hotvals, pos)
+			} else {
+				coldvals = append(coldvals, pos)
+			}
+
+			b.word(str(m.Type() == StringType))
+		}
+
+		b.metricDescriptor(m)
+	}
+
+	b.done()
+
+	value := func(pos int) {
+		l.arena[pos] = valueoff
+		valueoff += ValueLength
+	}
+
+	for _, p := range hotvals {
+		value(p)
+
+		for pad := ValueLength; pad < bytewriter.CacheLineSize && len(coldvals) > 0; pad += ValueLength {
+			value(coldvals[0])
+			coldvals = coldvals[1:]
+		}
+	}
+
+	for _, p := range coldvals {
+		value(p)
+	}
+
+	l.ninstances = (instanceoff - l.instanceoffset) / InstanceLength
+	l.nvalues = (valueoff - l.valuesoffset) / ValueLength
+	l.nstrings = (stringoff - l.stringsoffset) / StringLength
+
+	return l
+}
+
+// valuesOf returns the number of values written for a metric,
+// given the number of instances of every instance domain
+func valuesOf(m PCPMetric, counts map[InstanceDomain]int) int {
+	if m.Indom() != nil {
+		return counts[m.Indom()]
+	}
+	return 1
+}
+
+// extend returns the layout of the registry after instances were added to it, keeping
+// every other component of l at its offset. The instances section is laid out again,
+// and values and strings of the new instances are placed in the room reserved after
+// their sections. It returns nil if anything else changed in the registry, or if the new
+// instances do not fit, in which case the registry has to be written to a new mapping.
+func (l *mmvLayout) extend(r *PCPRegistry, enc encoder, tocCount int) *mmvLayout {
+	if enc != l.enc || HeaderLength+TocLength*tocCount != l.indomoffset {
+		return nil
+	}
+
+	indoms, metrics := r.sortedInstanceDomains(), r.sortedMetrics()
+	if len(indoms) != len(l.indoms) || len(metrics) != len(l.metrics) {
+		return nil
+	}
+
+	for i := range indoms {
+		if indoms[i] != l.indoms[i] {
+			return nil
+		}
+	}
+
+	for i := range metrics {
+		if metrics[i] != l.metrics[i] {
+			return nil
+		}
+	}
+
+	InstanceLength := l.enc.instanceLength()
+
+	e := *l
+
+	// instances of every indom, and the position of each of them in the old layout, or -1
+	instances := make([][]*pcpInstance, len(indoms))
+	old := make(map[InstanceDomain][]int, len(indoms))
+	counts := make(map[InstanceDomain]int, len(indoms))
+
+	words, strs := 0, 0
+	for i, indom := range indoms {
+		instances[i] = indom.sortedInstances()
+
+		index := make(map[string]int, len(instances[i]))
+		prev := make([]int, len(instances[i]))
+		for j, instance := range instances[i] {
+			index[instance.name] = j
+			prev[j] = -1
+		}
+
+		desc := l.indomDescriptor(i)
+		for j := 0; j < l.instanceCount(i); j++ {
+			k, ok := index[string(l.str(desc, indomDescWords+j*instanceDescWords+1))]
+			if !ok {
+				return nil
+			}
+			prev[k] = j
+		}
+
+		old[indom], counts[indom] = prev, len(instances[i])
+
+		w, n := indomSize(indom, instances[i])
+		words, strs = words+w, strs+n
+	}
+	for _, m := range metrics {
+		w, n := metricSize(m, valuesOf(m, counts))
+		words, strs = words+w, strs+n
+	}
+
+	b := newarenaBuilder(len(indoms)+len(metrics), words, strs)
+	e.arena, e.strings = b.arena, b.strings
+
+	var (
+		instanceoff = l.instanceoffset
+		valueoff    = l.valuesoffset + ValueLength*l.nvalues
+		stringoff   = l.stringsoffset + StringLength*l.nstrings
+	)
+
+	str := func(present bool) int {
+		if !present {
+			return 0
+		}
+		off := stringoff
+		stringoff += StringLength
+		return off
+	}
+
+	for i, indom := range indoms {
+		slots := l.indom(i)
+
+		b.begin()
+		b.words(slots[:indomSlots])
+
+		for _, j := range old[indom] {
+			b.word(instanceoff)
+			if j >= 0 {
+				b.word(slots[indomSlots+j*instanceSlots+1])
+			} else {
+				b.word(str(l.enc.nameStrings()))
+			}
+			instanceoff += InstanceLength
+		}
+
+		b.indomDescriptor(indom, instances[i])
+	}
+
+	for i, m := range metrics {
+		slots := l.metric(i)
+
+		b.begin()
+		b.words(slots[:metricSlots])
+
+		if m.Indom() == nil {
+			b.words(slots[metricSlots : metricSlots+valueSlots])
+		} else {
+			for _, j := range old[m.Indom()] {
+				if j >= 0 {
+					b.words(slots[metricSlots+j*valueSlots : metricSlots+(j+1)*valueSlots])
+					continue
+				}
+
+				b.word(valueoff)
+				b.word(str(m.Type() == StringType))
+				valueoff += ValueLength
+			}
+		}
+
+		b.metricDescriptor(m)
+	}
+
+	b.done()
+
+	if instanceoff > l.metricsoffset || valueoff > l.stringsoffset || stringoff > l.length {
+		return nil
+	}
+
+	e.ninstances = (instanceoff - l.instanceoffset) / InstanceLength
+	e.nvalues = (valueoff - l.valuesoffset) / ValueLength
+	e.nstrings = (stringoff - l.stringsoffset) / StringLength
+
+	return &e
+}
+
+// record returns the slots and the descriptor of the kth component of the arena,
+// the instance domains coming before the metrics
+func (l *mmvLayout) record(k int) (slots, desc []int) {
+	return l.arena[l.arena[2*k]:l.arena[2*k+1]], l.arena[l.arena[2*k+1]:l.arena[2*k+2]]
+}
+
+// indom returns the offsets for the ith instance domain,
+// followed by the offsets of its instances
+func (l *mmvLayout) indom(i int) []int {
+	slots, _ := l.record(i)
+	return slots
+}
+
+// indomDescriptor returns the descriptor of the ith instance domain,
+// followed by the descriptors of its instances
+func (l *mmvLayout) indomDescriptor(i int) []int {
+	_, desc := l.record(i)
+	return desc
+}
+
+// instanceCount returns the number of instances of the ith instance domain
+func (l *mmvLayout) instanceCount(i int) int {
+	return (len(l.indom(i)) - indomSlots) / instanceSlots
+}
+
+// metric returns the offsets for the ith metric,
+// followed by the offsets of its values
+func (l *mmvLayout) metric(i int) []int {
+	slots, _ := l.record(len(l.indoms) + i)
+	return slots
+}
+
+// metricDescriptor returns the descriptor of the ith metric
+func (l *mmvLayout) metricDescriptor(i int) []int {
+	_, desc := l.record(len(l.indoms) + i)
+	return desc
+}
+
+// str returns the string stored at the kth word of a descriptor
+func (l *mmvLayout) str(desc []int, k int) []byte {
+	return l.strings[desc[k] : desc[k]+desc[k+1]]
+}
+
+// indomSize returns the number of words of the record of an instance domain
+// in the arena, and the number of bytes of its strings
+func indomSize(indom *PCPInstanceDomain, instances []*pcpInstance) (words, strs int) {
+	words = indomSlots + indomDescWords + (instanceSlots+instanceDescWords)*len(instances)
+	strs = len(indom.shortDescription) + len(indom.longDescription)
+
+	for _, i := range instances {
+		strs += len(i.name)
+	}
+
+	return words, strs
+}
+
+// metricSize returns the number of words of the record of a metric having
+// the passed number of values in the arena, and the number of bytes of its strings
+func metricSize(m PCPMetric, values int) (words, strs int) {
+	return metricSlots + valueSlots*values + metricDescWords, len(m.Name()) + len(m.ShortDescription()) + len(m.LongDescription())
+}
+
+// arenaBuilder fills the arena of a layout, which is allocated once for the words
+// of the records of all components and the bytes of all of their strings
+type arenaBuilder struct {
+	arena   []int
+	strings []byte
+	index   int // next entry of the index
+	pos     int // next word of the records
+	spos    int // next byte of the strings
+}
+
+func newarenaBuilder(components, words, strs int) *arenaBuilder {
+	index := 2*components + 1
+	arena := make([]int, index+words+(strs+wordLength-1)/wordLength)
+
+	return &arenaBuilder{
+		arena:   arena,
+		strings: wordBytes(arena[index+words:])[:strs],
+		pos:     index,
+	}
+}
+
+// wordBytes returns the memory of words as bytes
+func wordBytes(words []int) []byte {
+	if len(words) == 0 {
+		return nil
+	}
+
+	n := len(words) * wordLength
+	return (*[1 << 30]byte)(unsafe.Pointer(&words[0]))[:n:n]
+}
+
+// begin starts the slots or the descriptor of the next component
+func (b *arenaBuilder) begin() {
+	b.arena[b.index] = b.pos
+	b.index++
+}
+
+// done ends the record of the last component
+func (b *arenaBuilder) done() {
+	b.arena[b.index] = b.pos
+}
+
+// word appends a word to the record being built, returning its position in the arena
+func (b *arenaBuilder) word(v int) int {
+	b.arena[b.pos] = v
+	b.pos++
+	return b.pos - 1
+}
+
+// words appends words to the record being built
+func (b *arenaBuilder) words(v []int) {
+	b.pos += copy(b.arena[b.pos:], v)
+}
+
+// str appends a string to the descriptor being built
+func (b *arenaBuilder) str(s string) {
+	b.word(b.spos)
+	b.word(len(s))
+	b.spos += copy(b.strings[b.spos:], s)
+}
+
+// indomDescriptor starts the descriptor of an instance domain and its instances
+func (b *arenaBuilder) indomDescriptor(indom *PCPInstanceDomain, instances []*pcpInstance) {
+	b.begin()
+	b.word(int(indom.id))
+	b.str(indom.shortDescription)
+	b.str(indom.longDescription)
+
+	for _, i := range instances {
+		b.word(int(i.id))
+		b.str(i.name)
+	}
+}
+
+// metricDescriptor starts the descriptor of a metric
+func (b *arenaBuilder) metricDescriptor(m PCPMetric) {
+	// PM_INDOM_NULL for metrics without instances
+	indom := -1
+	if m.Indom() != nil {
+		indom = int(m.Indom().ID())
+	}
+
+	b.begin()
+	b.word(int(m.ID()))
+	b.word(int(m.Type()))
+	b.word(int(m.Semantics()))
+	b.word(int(m.Unit().PMAPI()))
+	b.word(indom)
+	b.str(m.Name())
+	b.str(m.ShortDescription())
+	b.str(m.LongDescription())
+}
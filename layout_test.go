@@ -0,0 +1,322 @@
+package speed
+
+import (
+	"testing"
+
+	"github.com/performancecopilot/speed/bytewriter"
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestLayout(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	_, err = c.RegisterString("a.b[x, y, z].c", Instances{"x": 1, "y": 2, "z": 3}, Int32Type, CounterSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot register metric, error: %v", err)
+	}
+
+	m, err := NewPCPSingletonMetric("hello", "s", StringType, InstantSemantics, OneUnit, "short", "long")
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+	c.MustRegister(m)
+
+	l := newmmvLayout(c.r, c.encoder(), c.tocCount(), 0, nil, 0)
+
+	if n := len(l.indom(0)); n != indomSlots+3*instanceSlots {
+		t.Errorf("expected the indom to have %v slots, got %v", indomSlots+3*instanceSlots, n)
+	}
+
+	if n := len(l.metric(0)) + len(l.metric(1)); n != 2*metricSlots+4*valueSlots {
+		t.Errorf("expected the metrics to have %v slots, got %v", 2*metricSlots+4*valueSlots, n)
+	}
+
+	if l.metrics[0].Name() != "a.b.c" || l.metrics[1].Name() != "s" {
+		t.Errorf("expected metrics to be ordered by name")
+	}
+
+	// descriptors and strings are in the arena along with the offsets
+	d := l.metricDescriptor(1)
+	if uint32(d[0]) != m.ID() || MetricType(d[1]) != StringType || d[4] != -1 {
+		t.Errorf("expected the descriptor of s in the arena, got %v", d)
+	}
+
+	if name, short, long := string(l.str(d, 5)), string(l.str(d, 7)), string(l.str(d, 9)); name != "s" || short != "short" || long != "long" {
+		t.Errorf("expected the strings of s in the arena, got %q, %q and %q", name, short, long)
+	}
+
+	d = l.indomDescriptor(0)
+	for i, name := range []string{"x", "y", "z"} {
+		if s := string(l.str(d, indomDescWords+i*instanceDescWords+1)); s != name {
+			t.Errorf("expected instance %v to be %v, got %v", i, name, s)
+		}
+	}
+
+	if words := (len(l.strings) + wordLength - 1) / wordLength; &l.strings[0] != &wordBytes(l.arena[len(l.arena)-words:])[0] {
+		t.Errorf("expected the strings to be stored at the end of the arena")
+	}
+
+	// the last string is the payload of the string metric value
+	s := l.metric(1)
+	if last := s[len(s)-1]; last+StringLength != c.Length() {
+		t.Errorf("expected the last string to end at %v, ends at %v", c.Length(), last+StringLength)
+	}
+
+	l2 := newmmvLayout(c.r, c.encoder(), c.tocCount(), 0, nil, 0)
+	for i := range l.arena {
+		if l.arena[i] != l2.arena[i] {
+			t.Errorf("expected layout to be deterministic, slot %v differs", i)
+		}
+	}
+}
+
+func TestLayoutAlignment(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	if err = c.SetValueAlignment(48); err == nil {
+		t.Errorf("expected an alignment that is not a power of 2 to fail")
+	}
+
+	if err = c.SetValueAlignment(bytewriter.CacheLineSize); err != nil {
+		t.Fatalf("cannot set alignment, error: %v", err)
+	}
+
+	if err = c.SetHugePages(true); err != nil {
+		t.Fatalf("cannot request huge pages, error: %v", err)
+	}
+
+	_, err = c.RegisterString("a.b[x, y, z].c", Instances{"x": 1, "y": 2, "z": 3}, Int64Type, CounterSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot register metric, error: %v", err)
+	}
+
+	m, err := NewPCPSingletonMetric("hello", "s", StringType, InstantSemantics, OneUnit, "short", "long")
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+	c.MustRegister(m)
+
+	unaligned := newmmvLayout(c.r, c.encoder(), c.tocCount(), 0, nil, 0)
+	if unaligned.valuesoffset%bytewriter.CacheLineSize == 0 {
+		t.Fatalf("expected the unaligned values section to not be aligned by chance")
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if err = c.SetValueAlignment(0); err != ErrClientStarted {
+		t.Errorf("expected changing the alignment of a started client to fail, got %v", err)
+	}
+
+	if c.layout.valuesoffset%bytewriter.CacheLineSize != 0 {
+		t.Errorf("expected the values section to be aligned, starts at %v", c.layout.valuesoffset)
+	}
+
+	if c.Length() != c.writer.Len() {
+		t.Errorf("expected the mapping to be %v bytes, got %v", c.Length(), c.writer.Len())
+	}
+
+	// values are 32 bytes long, so none of them straddle a cache line
+	if !c.writer.(*bytewriter.MemoryMappedWriter).IsAligned(c.layout.valuesoffset, bytewriter.CacheLineSize) {
+		t.Errorf("expected the values section to be aligned in memory")
+	}
+
+	if problems := mmvdump.Validate(c.writer.Bytes()); len(problems) != 0 {
+		t.Errorf("expected an aligned mapping to be valid, got %v", problems)
+	}
+
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "a.b.c[y]"); err != nil || v != int64(2) {
+		t.Errorf("expected a.b.c[y] to be 2 in the aligned mapping, got %v, error: %v", v, err)
+	}
+}
+
+func TestLayoutHot(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	counters := make(map[string]*PCPCounter)
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		m, err := NewPCPCounter(0, name)
+		if err != nil {
+			t.Fatalf("cannot create counter, error: %v", err)
+		}
+
+		// a, c, e and f are hot, leaving only b and d to share their lines
+		if name == "b" || name == "d" {
+			c.MustRegister(m)
+		} else {
+			c.MustRegister(m, Hot())
+		}
+
+		counters[name] = m
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	offsets := make(map[string]int)
+	for i, m := range c.layout.metrics {
+		offsets[m.Name()] = c.layout.metric(i)[metricSlots]
+	}
+
+	line := func(name string) int { return offsets[name] / bytewriter.CacheLineSize }
+
+	for _, name := range []string{"a", "c", "e"} {
+		if offsets[name]%bytewriter.CacheLineSize != 0 {
+			t.Errorf("expected hot metric %v to start a cache line, starts at %v", name, offsets[name])
+		}
+	}
+
+	if line("a") != line("b") || line("c") != line("d") {
+		t.Errorf("expected hot metrics to share lines with the other metrics, got offsets %v", offsets)
+	}
+
+	if line("a") == line("c") || line("e") != line("f") {
+		t.Errorf("expected the remaining hot metrics to share a line, got offsets %v", offsets)
+	}
+
+	for name, m := range counters {
+		m.MustInc(int64(name[0]))
+	}
+
+	if problems := mmvdump.Validate(c.writer.Bytes()); len(problems) != 0 {
+		t.Errorf("expected a mapping with hot metrics to be valid, got %v", problems)
+	}
+
+	for name := range counters {
+		if v, err := mmvdump.Lookup(c.writer.Bytes(), name); err != nil || v != int64(name[0]) {
+			t.Errorf("expected %v to be %v in the mapping, got %v, error: %v", name, name[0], v, err)
+		}
+	}
+}
+
+func TestInstanceHeadroom(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	if err = c.SetInstanceHeadroom(2); err != nil {
+		t.Fatalf("cannot set headroom, error: %v", err)
+	}
+
+	cv, err := NewPCPCounterVector(map[string]int64{"b": 1, "d": 2}, "headroom.requests")
+	if err != nil {
+		t.Fatalf("cannot create CounterVector, error: %v", err)
+	}
+	c.MustRegister(cv, WithMaxInstances(10, RejectInstances))
+
+	s, err := NewPCPSingletonMetric("hello", "headroom.s", StringType, InstantSemantics, OneUnit, "short")
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+	c.MustRegister(s)
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if c.Length() != c.writer.Len() {
+		t.Errorf("expected the mapping to be %v bytes, got %v", c.Length(), c.writer.Len())
+	}
+
+	writer, gen := c.writer, c.layout.gen
+
+	cv.MustInc(3, "a")
+	cv.MustInc(4, "c")
+	if err = c.Compact(); err != nil {
+		t.Fatalf("cannot compact, error: %v", err)
+	}
+
+	if c.writer != writer || c.layout.gen <= gen {
+		t.Errorf("expected the instances to be added to the mapping in place")
+	}
+
+	if st := c.Stats(); st.Extensions != 1 || st.Remaps != 0 {
+		t.Errorf("expected 1 extension and no remaps, got %+v", st)
+	}
+
+	// existing values stay bound to the mapping
+	cv.MustInc(10, "b")
+
+	for name, val := range map[string]int64{"a": 3, "b": 11, "c": 4, "d": 2} {
+		if v, err := mmvdump.Lookup(c.writer.Bytes(), "headroom.requests["+name+"]"); err != nil || v != val {
+			t.Errorf("expected headroom.requests[%v] to be %v, got %v, error: %v", name, val, v, err)
+		}
+	}
+
+	if problems := mmvdump.Validate(c.writer.Bytes()); len(problems) != 0 {
+		t.Errorf("expected the extended mapping to be valid, got %v", problems)
+	}
+
+	// instances that do not fit remap the registry
+	cv.MustInc(5, "e")
+	if err = c.Compact(); err != nil {
+		t.Fatalf("cannot compact, error: %v", err)
+	}
+
+	if c.writer == writer || c.Stats().Remaps != 1 {
+		t.Errorf("expected instances over the headroom to remap the registry")
+	}
+
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "headroom.requests[e]"); err != nil || v != int64(5) {
+		t.Errorf("expected headroom.requests[e] to be 5, got %v, error: %v", v, err)
+	}
+}
+
+func TestValueWordAlignment(t *testing.T) {
+	for _, long := range []bool{false, true} {
+		for _, align := range []int{0, 1, 4, 16} {
+			for _, reserve := range []int{0, 3} {
+				c, err := NewPCPClient("test")
+				if err != nil {
+					t.Fatalf("cannot create client, error: %v", err)
+				}
+
+				if err = c.SetValueAlignment(align); err != nil {
+					t.Fatalf("cannot set alignment, error: %v", err)
+				}
+
+				if err = c.SetInstanceHeadroom(reserve); err != nil {
+					t.Fatalf("cannot set headroom, error: %v", err)
+				}
+
+				name := "word.counter"
+				if long {
+					// longer than MaxV1NameLength, written using mmv2
+					name = "word.counter_with_a_name_that_is_longer_than_sixty_three_characters"
+				}
+
+				counter := c.MustRegisterString(name, int64(1), Int64Type, CounterSemantics, OneUnit).(*PCPSingletonMetric)
+				c.MustRegisterString("word.int32", int32(1), Int32Type, InstantSemantics, OneUnit)
+				c.MustRegisterString("word.string", "s", StringType, InstantSemantics, OneUnit)
+				c.MustRegisterString("word.double", 2.5, DoubleType, InstantSemantics, OneUnit)
+				c.MustRegisterString("word[a, b, c].vals", Instances{"a": int64(1), "b": int64(2), "c": int64(3)}, Int64Type, CounterSemantics, OneUnit)
+
+				c.MustStart()
+
+				for i, m := range c.layout.metrics {
+					v := c.layout.metric(i)[metricSlots:]
+					for j := 0; j < len(v); j += valueSlots {
+						if v[j]%wordAlignment != 0 || wordAt(c.writer, v[j]) == nil {
+							t.Errorf("expected value %v of %v to be word aligned with alignment %v, headroom %v and mmv2 %v, at %v", j/valueSlots, m.Name(), align, reserve, long, v[j])
+						}
+					}
+				}
+
+				if counter.word == nil || counter.word != wordAt(c.writer, c.layout.metric(0)[metricSlots]) {
+					t.Errorf("expected %v to be updated atomically in the mapping", name)
+				}
+
+				c.MustStop()
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package speed
+
+import (
+	"errors"
+	"sync"
+)
+
+// LazyFunc returns the current value of a lazy metric.
+type LazyFunc func() (interface{}, error)
+
+// PCPLazyMetric is a singleton metric whose value is not set by the application,
+// but gathered by calling a function every time the client runs its collectors,
+// once on Start and then at every publish interval, see SetPublishInterval.
+//
+// This suits values that are expensive to compute, like the size of a directory,
+// and that would otherwise be recomputed on every change. Lazy metrics can be
+// registered alongside metrics that are set directly.
+//
+// The function is not called while the metric is disabled.
+type PCPLazyMetric struct {
+	*pcpSingletonMetric
+	f LazyFunc
+}
+
+// NewPCPLazyMetric creates a new lazy metric whose values are returned by f.
+// The metric has the zero value of its type until f is first called.
+func NewPCPLazyMetric(f LazyFunc, name string, t MetricType, s MetricSemantics, u MetricUnit, desc ...string) (*PCPLazyMetric, error) {
+	if f == nil {
+		return nil, &OpError{"create metric", name, "", errors.New("lazy metric requires a function")}
+	}
+
+	d, err := newpcpMetricDesc(name, t, s, u, desc...)
+	if err != nil {
+		return nil, err
+	}
+
+	sm, err := newpcpSingletonMetric(t.zero(), d)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PCPLazyMetric{sm, f}, nil
+}
+
+// Val returns the value last returned by the metric's function.
+func (m *PCPLazyMetric) Val() interface{} {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.value()
+}
+
+// collect calls the metric's function and stores the returned value.
+func (m *PCPLazyMetric) collect() error {
+	if !m.enabled() {
+		return nil
+	}
+
+	val, err := m.f()
+	if err != nil {
+		return m.opError("collect", "", err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.set(val)
+}
+
+// lazyMetric is implemented by metrics whose values are gathered every time
+// the client runs its collectors, like PCPLazyMetric and PCPQuantileHistogram.
+type lazyMetric interface {
+	collect() error
+}
+
+// lazyCollector collects all lazy metrics registered with a client.
+type lazyCollector struct {
+	mutex   sync.Mutex
+	metrics []lazyMetric
+}
+
+func (l *lazyCollector) add(m lazyMetric) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.metrics = append(l.metrics, m)
+}
+
+// Metrics returns nil, as lazy metrics are registered individually.
+func (l *lazyCollector) Metrics() []Metric { return nil }
+
+// Collect collects all lazy metrics, returning the first error
+// after attempting to collect every metric.
+func (l *lazyCollector) Collect() error {
+	l.mutex.Lock()
+	metrics := append([]lazyMetric(nil), l.metrics...)
+	l.mutex.Unlock()
+
+	var first error
+	for _, m := range metrics {
+		if err := m.collect(); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// addLazy collects the passed lazy metric along with the client's collectors.
+func (c *PCPClient) addLazy(m lazyMetric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.lazy == nil {
+		c.lazy = new(lazyCollector)
+		c.collectors.add("lazy", c.lazy)
+	}
+
+	c.lazy.add(m)
+}
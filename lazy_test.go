@@ -0,0 +1,105 @@
+package speed
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestLazyMetric(t *testing.T) {
+	if _, err := NewPCPLazyMetric(nil, "lazy", Int64Type, InstantSemantics, OneUnit); err == nil {
+		t.Errorf("expected an error creating a lazy metric without a function")
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	calls := 0
+	m, err := NewPCPLazyMetric(func() (interface{}, error) {
+		calls++
+		return calls * 10, nil
+	}, "dir.size", Int64Type, InstantSemantics, ByteUnit)
+	if err != nil {
+		t.Fatalf("cannot create lazy metric, error: %v", err)
+	}
+	c.MustRegister(m)
+
+	pushed := newTestCounter(t, c, "pushed")
+
+	if v := m.Val(); v != int64(0) {
+		t.Errorf("expected the zero value before collection, got %v", v)
+	}
+
+	// collect only on start and when called below
+	interval := DefaultCollectInterval
+	DefaultCollectInterval = time.Hour
+	defer func() { DefaultCollectInterval = interval }()
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if calls != 1 {
+		t.Errorf("expected the function to be called once on start, got %v calls", calls)
+	}
+
+	if v := m.Val(); v != int64(10) {
+		t.Errorf("expected 10, got %v", v)
+	}
+
+	if err = c.lazy.Collect(); err != nil {
+		t.Fatalf("cannot collect, error: %v", err)
+	}
+
+	pushed.Inc(3)
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "dir.size"); err != nil || v != int64(20) {
+		t.Errorf("expected dir.size to be written as 20, got %v, error: %v", v, err)
+	}
+
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "pushed"); err != nil || v != int64(3) {
+		t.Errorf("expected pushed to be written as 3, got %v, error: %v", v, err)
+	}
+
+	c.DisableMetrics("dir.size")
+	if err = c.lazy.Collect(); err != nil {
+		t.Fatalf("cannot collect, error: %v", err)
+	}
+
+	if calls != 2 {
+		t.Errorf("expected the function not to be called while disabled, got %v calls", calls)
+	}
+}
+
+func TestLazyMetricError(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	failing, err := NewPCPLazyMetric(func() (interface{}, error) {
+		return nil, errors.New("unavailable")
+	}, "failing", Int64Type, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create lazy metric, error: %v", err)
+	}
+	c.MustRegister(failing)
+
+	working, err := NewPCPLazyMetric(func() (interface{}, error) {
+		return 5, nil
+	}, "working", Int64Type, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create lazy metric, error: %v", err)
+	}
+	c.MustRegister(working)
+
+	if err = c.lazy.Collect(); err == nil {
+		t.Errorf("expected the error of the failing metric")
+	}
+
+	if v := working.Val(); v != int64(5) {
+		t.Errorf("expected the other metrics to be collected, got %v", v)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	histogram "github.com/codahale/hdrhistogram"
@@ -126,6 +127,25 @@ func (m MetricType) resolve(val interface{}) interface{} {
 	return val
 }
 
+// zero returns the zero value of the type.
+func (m MetricType) zero() interface{} {
+	switch m {
+	case Int32Type:
+		return int32(0)
+	case Uint32Type:
+		return uint32(0)
+	case Int64Type:
+		return int64(0)
+	case Uint64Type:
+		return uint64(0)
+	case FloatType:
+		return float32(0)
+	case DoubleType:
+		return float64(0)
+	}
+	return ""
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 
 // MetricUnit defines the interface for a unit type for speed.
@@ -231,42 +251,92 @@ type Metric interface {
 
 	// gets the description of a metric
 	Description() string
+
+	// gets the instance domain of a metric, nil for metrics without instances
+	Indom() InstanceDomain
 }
 
 ///////////////////////////////////////////////////////////////////////////////
 
-// SingletonMetric defines the interface for a metric that stores only one value.
-type SingletonMetric interface {
-	Metric
-
+// SingletonMetricReader defines the methods reading a metric that stores only one value,
+// which is all code exporting or checking its value needs.
+type SingletonMetricReader interface {
 	// gets the value of the metric
 	Val() interface{}
 
+	// returns false if the metric has no value
+	HasValue() bool
+}
+
+// SingletonMetricWriter defines the methods updating a metric that stores only one value,
+// which is all instrumented code needs.
+type SingletonMetricWriter interface {
 	// sets the value of the metric to a value, optionally returns an error on failure
 	Set(interface{}) error
 
 	// tries to set and panics on error
 	MustSet(interface{})
-}
 
-///////////////////////////////////////////////////////////////////////////////
+	// sets the value of the metric and returns the previous value
+	Swap(interface{}) (interface{}, error)
 
-// InstanceMetric defines the interface for a metric that stores multiple values
-// in instances and instance domains.
-type InstanceMetric interface {
+	// sets the value of a numeric metric to new if the current value is old
+	CAS(old, new interface{}) (bool, error)
+
+	// sets the value of a numeric metric if it is greater than the current value
+	StoreMax(interface{}) (bool, error)
+
+	// sets the value of a numeric metric if it is less than the current value
+	StoreMin(interface{}) (bool, error)
+
+	// marks a numeric metric as having no value until it is next set
+	Clear() error
+}
+
+// SingletonMetric defines the interface for a metric that stores only one value.
+type SingletonMetric interface {
 	Metric
+	SingletonMetricReader
+	SingletonMetricWriter
+}
+
+///////////////////////////////////////////////////////////////////////////////
 
+// InstanceMetricReader defines the methods reading a metric that stores
+// multiple values in instances and instance domains.
+type InstanceMetricReader interface {
 	// gets the value of a particular instance
 	ValInstance(string) (interface{}, error)
 
+	// returns a slice containing all instances in the metric
+	Instances() []string
+}
+
+// InstanceMetricWriter defines the methods updating a metric that stores
+// multiple values in instances and instance domains.
+type InstanceMetricWriter interface {
 	// sets the value of a particular instance
 	SetInstance(interface{}, string) error
 
 	// tries to set the value of a particular instance and panics on error
 	MustSetInstance(interface{}, string)
 
-	// returns a slice containing all instances in the metric
-	Instances() []string
+	// sets the value of a particular instance and returns the previous value
+	SwapInstance(interface{}, string) (interface{}, error)
+
+	// sets the values of all instances
+	Fill(interface{}) error
+
+	// tries to set the values of all instances and panics on error
+	MustFill(interface{})
+}
+
+// InstanceMetric defines the interface for a metric that stores multiple values
+// in instances and instance domains.
+type InstanceMetric interface {
+	Metric
+	InstanceMetricReader
+	InstanceMetricWriter
 }
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -275,9 +345,6 @@ type InstanceMetric interface {
 type PCPMetric interface {
 	Metric
 
-	// a PCPMetric will always have an instance domain, even if it is nil
-	Indom() *PCPInstanceDomain
-
 	ShortDescription() string
 
 	LongDescription() string
@@ -302,20 +369,25 @@ type pcpMetricDesc struct {
 	sem                               MetricSemantics // the semantics
 	u                                 MetricUnit      // the unit
 	shortDescription, longDescription string
+	disabled                          int32        // set atomically, see enable.go
+	client                            string       // name of the client the metric is registered with, used in errors
+	floats                            int32        // FloatConversion, set atomically, see conversion.go
+	maxString, overflow               int32        // maximum length of string values and StringOverflow, set atomically
+	counts                            *writeCounts // writes of the values of the metric to a mapping, see Stats
 }
 
 // newpcpMetricDesc creates a new Metric Description wrapper type.
 func newpcpMetricDesc(n string, t MetricType, s MetricSemantics, u MetricUnit, desc ...string) (*pcpMetricDesc, error) {
 	if n == "" {
-		return nil, errors.New("Metric name cannot be empty")
+		return nil, &OpError{"create metric", n, "", errors.New("metric name cannot be empty")}
 	}
 
 	if len(n) > StringLength {
-		return nil, errors.New("metric name is too long")
+		return nil, &OpError{"create metric", n, "", fmt.Errorf("metric name is longer than %v bytes", StringLength)}
 	}
 
 	if len(desc) > 2 {
-		return nil, errors.New("only 2 optional strings allowed, short and long descriptions")
+		return nil, &OpError{"create metric", n, "", errors.New("only 2 optional strings allowed, short and long descriptions")}
 	}
 
 	shortdesc, longdesc := "", ""
@@ -331,10 +403,26 @@ func newpcpMetricDesc(n string, t MetricType, s MetricSemantics, u MetricUnit, d
 	return &pcpMetricDesc{
 		hash(n, PCPMetricItemBitLength),
 		n, t, s, u,
-		shortdesc, longdesc,
+		shortdesc, longdesc, 0, "", 0, 0, 0,
+		new(writeCounts),
 	}, nil
 }
 
+// opError returns an *OpError for an operation on the metric, or on one of its instances
+// if instance is not empty, naming the client the metric is registered with.
+func (md *pcpMetricDesc) opError(op, instance string, err error) error {
+	name := md.name
+	if instance != "" {
+		name = instanceName(md.name, instance)
+	}
+	return &OpError{op, name, md.client, err}
+}
+
+// errorf is opError for an error formatted from the arguments.
+func (md *pcpMetricDesc) errorf(op, instance, format string, args ...interface{}) error {
+	return md.opError(op, instance, fmt.Errorf(format, args...))
+}
+
 // ID returns the generated id for PCPMetric.
 func (md *pcpMetricDesc) ID() uint32 { return md.id }
 
@@ -383,44 +471,141 @@ func newupdateClosure(offset int, writer bytewriter.Writer) updateClosure {
 ///////////////////////////////////////////////////////////////////////////////
 
 // pcpSingletonMetric defines an embeddable base singleton metric.
+//
+// The mutex guards the value as well as the update closure, which is bound
+// by a client when a mapping is written and unbound when it is removed.
+// While unbound, updates are only stored in the metric, and are flushed
+// to the mapping the next time it is written.
+//
+// Values of atomic types are stored in word instead of val, see atomic.go.
+// While the metric has no value, see novalue.go, the mapping holds a sentinel.
 type pcpSingletonMetric struct {
 	*pcpMetricDesc
-	val    interface{}
-	update updateClosure
+	mutex   sync.RWMutex
+	val     interface{}
+	update  updateClosure
+	word    *uint64
+	bound   bool // whether word is a word of a mapping
+	cleared bool // whether the metric has no value
 }
 
 // newpcpSingletonMetric creates a new instance of pcpSingletonMetric.
 func newpcpSingletonMetric(val interface{}, desc *pcpMetricDesc) (*pcpSingletonMetric, error) {
 	if !desc.t.IsCompatible(val) {
-		return nil, fmt.Errorf("type %v is not compatible with value %v(%T)", desc.t, val, val)
+		return nil, desc.errorf("create metric", "", "value %v(%T) is incompatible with type %v", val, val, desc.t)
+	}
+
+	if s, ok := val.(string); ok && len(s) > MaxStringValueLength {
+		return nil, desc.errorf("create metric", "", "value is longer than %v bytes", MaxStringValueLength)
 	}
 
 	val = desc.t.resolve(val)
-	return &pcpSingletonMetric{desc, val, nil}, nil
+
+	var word *uint64
+	if desc.t.isAtomic() {
+		word = newWord(desc.t, val)
+	}
+
+	return &pcpSingletonMetric{desc, sync.RWMutex{}, val, nil, word, false, false}, nil
+}
+
+// value returns the current value of pcpSingletonMetric,
+// the mutex must be held at least for reading.
+func (m *pcpSingletonMetric) value() interface{} {
+	if m.cleared {
+		return m.t.zero()
+	}
+
+	if m.word != nil {
+		return m.t.fromBits(atomic.LoadUint64(m.word))
+	}
+	return m.val
 }
 
 // set Sets the current value of pcpSingletonMetric.
 func (m *pcpSingletonMetric) set(val interface{}) error {
+	val, err := m.convert(val, "")
+	if err != nil {
+		return err
+	}
+
 	if !m.t.IsCompatible(val) {
-		return fmt.Errorf("value %v is incompatible with MetricType %v", val, m.t)
+		return m.errorf("set", "", "value %v(%T) is incompatible with type %v", val, val, m.t)
 	}
 
 	val = m.t.resolve(val)
 
-	if val != m.val {
+	if !m.enabled() {
+		return nil
+	}
+
+	if m.word != nil {
+		atomic.StoreUint64(m.word, m.t.bits(val))
+		m.wrote()
+		m.cleared = false
+		return nil
+	}
+
+	if val != m.val || m.cleared {
 		if m.update != nil {
 			err := m.update(val)
 			if err != nil {
-				return err
+				return m.opError("write", "", err)
 			}
 		}
-		m.val = val
+		m.val, m.cleared = val, false
 	}
 
 	return nil
 }
 
-func (m *pcpSingletonMetric) Indom() *PCPInstanceDomain { return nil }
+// reset sets the value of the metric to the zero value of its type.
+func (m *pcpSingletonMetric) reset() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.set(m.t.zero())
+}
+
+// Indom returns nil, as a singleton metric has no instance domain.
+func (m *pcpSingletonMetric) Indom() InstanceDomain { return nil }
+
+func (m *pcpSingletonMetric) singleton() *pcpSingletonMetric { return m }
+
+// bindWord binds the metric to a word of a mapping, which is then updated atomically,
+// and writes the current value to it. The mutex must be held for writing.
+func (m *pcpSingletonMetric) bindWord(word *uint64) {
+	atomic.StoreUint64(word, m.t.bits(m.published()))
+	m.word, m.update, m.bound = word, nil, true
+}
+
+// wrote counts a write to the word of the metric if it is a word of a mapping,
+// the mutex must be held at least for reading.
+func (m *pcpSingletonMetric) wrote() {
+	if m.bound {
+		atomic.AddUint64(&m.counts.writes, 1)
+	}
+}
+
+// bindUpdate binds the metric to a mapping through the closure used to update
+// the value in it. The mutex must be held for writing.
+func (m *pcpSingletonMetric) bindUpdate(update updateClosure) {
+	m.val = m.value()
+	m.word, m.update, m.bound = nil, update, false
+}
+
+// unbind unbinds the metric from its current mapping.
+func (m *pcpSingletonMetric) unbind() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.val = m.value()
+	m.word, m.update, m.bound = nil, nil, false
+
+	if m.t.isAtomic() {
+		m.word = newWord(m.t, m.val)
+	}
+}
 
 ///////////////////////////////////////////////////////////////////////////////
 
@@ -428,7 +613,6 @@ func (m *pcpSingletonMetric) Indom() *PCPInstanceDomain { return nil }
 // only a value and a valueoffset.
 type PCPSingletonMetric struct {
 	*pcpSingletonMetric
-	mutex sync.RWMutex
 }
 
 // NewPCPSingletonMetric creates a new instance of PCPSingletonMetric
@@ -445,7 +629,7 @@ func NewPCPSingletonMetric(val interface{}, name string, t MetricType, s MetricS
 		return nil, err
 	}
 
-	return &PCPSingletonMetric{sm, sync.RWMutex{}}, nil
+	return &PCPSingletonMetric{sm}, nil
 }
 
 // Val returns the current Set value of PCPSingletonMetric.
@@ -453,7 +637,7 @@ func (m *PCPSingletonMetric) Val() interface{} {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	return m.val
+	return m.value()
 }
 
 // Set Sets the current value of PCPSingletonMetric.
@@ -466,13 +650,49 @@ func (m *PCPSingletonMetric) Set(val interface{}) error {
 
 // MustSet is a Set that panics on failure.
 func (m *PCPSingletonMetric) MustSet(val interface{}) {
-	if err := m.Set(val); err != nil {
-		panic(err)
+	must("set", m.name, m.client, m.Set(val))
+}
+
+// Swap sets the current value of PCPSingletonMetric and returns the previous value,
+// as a single operation that cannot interleave with other updates.
+func (m *PCPSingletonMetric) Swap(val interface{}) (interface{}, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	old := m.value()
+	if err := m.set(val); err != nil {
+		return nil, err
 	}
+
+	return old, nil
+}
+
+// CAS sets the value of a numeric PCPSingletonMetric to new if its current value is old,
+// and returns whether the value was set.
+//
+// For Int64Type and Uint64Type metrics, the value is compared and set atomically
+// without serializing on the metric's lock.
+func (m *PCPSingletonMetric) CAS(old, new interface{}) (bool, error) {
+	return m.cas(old, new)
+}
+
+// StoreMax sets the value of a numeric PCPSingletonMetric to val if it is greater
+// than the current value, and returns whether the value was set.
+func (m *PCPSingletonMetric) StoreMax(val interface{}) (bool, error) {
+	return m.storeMax(val)
+}
+
+// StoreMin sets the value of a numeric PCPSingletonMetric to val if it is less
+// than the current value, and returns whether the value was set.
+func (m *PCPSingletonMetric) StoreMin(val interface{}) (bool, error) {
+	return m.storeMin(val)
 }
 
 func (m *PCPSingletonMetric) String() string {
-	return fmt.Sprintf("Val: %v\n%v", m.val, m.Description())
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return fmt.Sprintf("Val: %v\n%v", m.value(), m.Description())
 }
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -488,6 +708,8 @@ type Counter interface {
 	MustInc(int64)
 
 	Up() // same as MustInc(1)
+
+	CAS(old, new int64) (bool, error)
 }
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -495,7 +717,6 @@ type Counter interface {
 // PCPCounter implements a PCP compatible Counter Metric.
 type PCPCounter struct {
 	*pcpSingletonMetric
-	mutex sync.RWMutex
 }
 
 // NewPCPCounter creates a new PCPCounter instance.
@@ -515,7 +736,7 @@ func NewPCPCounter(val int64, name string, desc ...string) (*PCPCounter, error)
 		return nil, err
 	}
 
-	return &PCPCounter{sm, sync.RWMutex{}}, nil
+	return &PCPCounter{sm}, nil
 }
 
 // Val returns the current value of the counter.
@@ -523,7 +744,7 @@ func (c *PCPCounter) Val() int64 {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 
-	return c.val.(int64)
+	return c.value().(int64)
 }
 
 // Set sets the value of the counter.
@@ -531,43 +752,65 @@ func (c *PCPCounter) Set(val int64) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	v := c.val.(int64)
+	v := c.value().(int64)
 
 	if val < v {
-		return fmt.Errorf("cannot set counter to %v, current value is %v and PCP counters cannot go backwards", val, v)
+		return c.errorf("set", "", "current value is %v and PCP counters cannot go backwards to %v", v, val)
 	}
 
 	return c.set(val)
 }
 
 // Inc increases the stored counter's value by the passed increment.
+// The increment is atomic and does not serialize on the counter's lock.
 func (c *PCPCounter) Inc(val int64) error {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
 	if val < 0 {
-		return errors.New("cannot decrement a counter")
+		return c.errorf("increment", "", "increment %v is negative and PCP counters cannot go backwards", val)
 	}
 
 	if val == 0 {
 		return nil
 	}
 
-	v := c.val.(int64)
+	if !c.enabled() {
+		return nil
+	}
+
+	c.mutex.RLock()
+	if c.word != nil && !c.cleared {
+		atomic.AddUint64(c.word, uint64(val))
+		c.wrote()
+		c.mutex.RUnlock()
+		return nil
+	}
+	c.mutex.RUnlock()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	v := c.value().(int64)
 	v += val
 	return c.set(v)
 }
 
 // MustInc is Inc that panics on failure.
 func (c *PCPCounter) MustInc(val int64) {
-	if err := c.Inc(val); err != nil {
-		panic(err)
-	}
+	must("increment", c.name, c.client, c.Inc(val))
 }
 
 // Up increases the counter by 1.
 func (c *PCPCounter) Up() { c.MustInc(1) }
 
+// CAS sets the value of the counter to new if its current value is old,
+// and returns whether the value was set.
+func (c *PCPCounter) CAS(old, new int64) (bool, error) {
+	if new < old {
+		return false, c.errorf("compare and swap", "", "cannot set counter to %v from %v, PCP counters cannot go backwards", new, old)
+	}
+
+	return c.cas(old, new)
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 
 // Gauge defines a metric that holds a single double value that can be
@@ -585,6 +828,11 @@ type Gauge interface {
 
 	MustInc(float64)
 	MustDec(float64)
+
+	CAS(old, new float64) (bool, error)
+
+	StoreMax(float64) (bool, error)
+	StoreMin(float64) (bool, error)
 }
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -592,7 +840,6 @@ type Gauge interface {
 // PCPGauge defines a PCP compatible Gauge metric
 type PCPGauge struct {
 	*pcpSingletonMetric
-	mutex sync.RWMutex
 }
 
 // NewPCPGauge creates a new PCPGauge instance.
@@ -612,14 +859,14 @@ func NewPCPGauge(val float64, name string, desc ...string) (*PCPGauge, error) {
 		return nil, err
 	}
 
-	return &PCPGauge{sm, sync.RWMutex{}}, nil
+	return &PCPGauge{sm}, nil
 }
 
 // Val returns the current value of the Gauge.
 func (g *PCPGauge) Val() float64 {
 	g.mutex.RLock()
 	defer g.mutex.RUnlock()
-	return g.val.(float64)
+	return g.value().(float64)
 }
 
 // Set sets the current value of the Gauge.
@@ -631,29 +878,44 @@ func (g *PCPGauge) Set(val float64) error {
 
 // MustSet will panic if Set fails.
 func (g *PCPGauge) MustSet(val float64) {
-	if err := g.Set(val); err != nil {
-		panic(err)
-	}
+	must("set", g.name, g.client, g.Set(val))
 }
 
 // Inc adds a value to the existing Gauge value.
+// The addition is atomic and does not serialize on the gauge's lock.
 func (g *PCPGauge) Inc(val float64) error {
-	g.mutex.Lock()
-	defer g.mutex.Unlock()
-
 	if val == 0 {
 		return nil
 	}
 
-	v := g.val.(float64)
+	if !g.enabled() {
+		return nil
+	}
+
+	g.mutex.RLock()
+	if g.word != nil && !g.cleared {
+		for {
+			old := atomic.LoadUint64(g.word)
+			if atomic.CompareAndSwapUint64(g.word, old, math.Float64bits(math.Float64frombits(old)+val)) {
+				break
+			}
+		}
+		g.wrote()
+		g.mutex.RUnlock()
+		return nil
+	}
+	g.mutex.RUnlock()
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	v := g.value().(float64)
 	return g.set(v + val)
 }
 
 // MustInc will panic if Inc fails.
 func (g *PCPGauge) MustInc(val float64) {
-	if err := g.Inc(val); err != nil {
-		panic(err)
-	}
+	must("increment", g.name, g.client, g.Inc(val))
 }
 
 // Dec adds a value to the existing Gauge value.
@@ -663,9 +925,26 @@ func (g *PCPGauge) Dec(val float64) error {
 
 // MustDec will panic if Dec fails.
 func (g *PCPGauge) MustDec(val float64) {
-	if err := g.Dec(val); err != nil {
-		panic(err)
-	}
+	must("decrement", g.name, g.client, g.Dec(val))
+}
+
+// CAS sets the value of the Gauge to new if its current value is old,
+// and returns whether the value was set.
+func (g *PCPGauge) CAS(old, new float64) (bool, error) {
+	return g.cas(old, new)
+}
+
+// StoreMax sets the value of the Gauge to val if it is greater than the current value,
+// and returns whether the value was set. It is safe to call concurrently to record
+// the largest value seen, like the worst latency.
+func (g *PCPGauge) StoreMax(val float64) (bool, error) {
+	return g.storeMax(val)
+}
+
+// StoreMin sets the value of the Gauge to val if it is less than the current value,
+// and returns whether the value was set.
+func (g *PCPGauge) StoreMin(val float64) (bool, error) {
+	return g.storeMin(val)
 }
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -687,9 +966,9 @@ type Timer interface {
 // It also functionally implements a metric with elapsed type from PCP
 type PCPTimer struct {
 	*pcpSingletonMetric
-	mutex   sync.Mutex
 	started bool
 	since   time.Time
+	clock   Clock // the clock of the client the timer is registered with
 }
 
 // NewPCPTimer creates a new PCPTimer instance of the specified unit.
@@ -707,7 +986,7 @@ func NewPCPTimer(name string, unit TimeUnit, desc ...string) (*PCPTimer, error)
 		return nil, err
 	}
 
-	return &PCPTimer{sm, sync.Mutex{}, false, time.Time{}}, nil
+	return &PCPTimer{sm, false, time.Time{}, SystemClock}, nil
 }
 
 // Start signals the timer to start monitoring.
@@ -716,24 +995,51 @@ func (t *PCPTimer) Start() error {
 	defer t.mutex.Unlock()
 
 	if t.started {
-		return errors.New("trying to start an already started timer")
+		return t.errorf("start", "", "timer is already started")
 	}
 
-	t.since = time.Now()
+	t.since = t.clock.Now()
 	t.started = true
 	return nil
 }
 
+// TimerSnapshot holds the state of a timer at one point in time.
+type TimerSnapshot struct {
+	Elapsed float64   // time accumulated by the timer, in its unit
+	Running bool      // whether the timer is started
+	Since   time.Time // when the timer was started, if it is running
+}
+
+// Snapshot returns the state of the timer, read atomically with respect to Start and Stop.
+func (t *PCPTimer) Snapshot() TimerSnapshot {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	s := TimerSnapshot{Elapsed: t.value().(float64), Running: t.started}
+	if t.started {
+		s.Since = t.since
+	}
+	return s
+}
+
+// setClock sets the clock the timer measures time with.
+func (t *PCPTimer) setClock(clock Clock) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.clock = clock
+}
+
 // Stop signals the timer to end monitoring and return elapsed time so far.
 func (t *PCPTimer) Stop() (float64, error) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
 	if !t.started {
-		return 0, errors.New("trying to stop a stopped timer")
+		return 0, t.errorf("stop", "", "timer is not started")
 	}
 
-	d := time.Since(t.since)
+	d := t.clock.Now().Sub(t.since)
 
 	var inc float64
 	switch t.pcpMetricDesc.Unit() {
@@ -751,63 +1057,94 @@ func (t *PCPTimer) Stop() (float64, error) {
 		inc = d.Hours()
 	}
 
-	v := t.val.(float64)
+	v := t.value().(float64)
 
 	err := t.set(v + inc)
 	if err != nil {
 		return -1, err
 	}
 
+	t.started = false
 	return v + inc, nil
 }
 
 ///////////////////////////////////////////////////////////////////////////////
 
 type instanceValue struct {
-	val    interface{}
-	update updateClosure
+	val     interface{}
+	update  updateClosure
+	deleted bool   // tombstoned by a Delete, and removed when the registry is compacted
+	touched uint64 // when the value was last updated, in updates to the metric
 }
 
 func newinstanceValue(val interface{}) *instanceValue {
-	return &instanceValue{val, nil}
+	return &instanceValue{val, nil, false, 0}
 }
 
 // pcpInstanceMetric represents a PCPMetric that can have multiple values
 // over multiple instances in an instance domain.
+//
+// Like pcpSingletonMetric, the mutex guards the values and their update closures.
 type pcpInstanceMetric struct {
 	*pcpMetricDesc
+	mutex sync.RWMutex
 	indom *PCPInstanceDomain
 	vals  map[string]*instanceValue
+
+	limit     *cardinalityLimit  // limits the instances added by setting new instances, if set
+	updates   uint64             // number of updates, ordering the updates of instances
+	normalize InstanceNormalizer // normalizes the instances passed to vectors, if set
 }
 
 // newpcpInstanceMetric creates a new instance of PCPSingletonMetric.
 func newpcpInstanceMetric(vals Instances, indom *PCPInstanceDomain, desc *pcpMetricDesc) (*pcpInstanceMetric, error) {
 	if len(vals) != indom.InstanceCount() {
-		return nil, errors.New("values for all instances in the instance domain only should be passed")
+		return nil, desc.errorf("create metric", "", "values for all %v instances of instance domain %v only should be passed, got %v", indom.InstanceCount(), indom.name, len(vals))
 	}
 
 	mvals := make(map[string]*instanceValue)
 
-	for name := range indom.instances {
+	for _, name := range indom.Instances() {
 		val, present := vals[name]
 		if !present {
-			return nil, fmt.Errorf("Instance %v not initialized", name)
+			return nil, desc.errorf("create metric", name, "instance is not initialized")
 		}
 
 		if !desc.t.IsCompatible(val) {
-			return nil, fmt.Errorf("value %v is incompatible with type %v for Instance %v", val, desc.t, name)
+			return nil, desc.errorf("create metric", name, "value %v(%T) is incompatible with type %v", val, val, desc.t)
+		}
+
+		if s, ok := val.(string); ok && len(s) > MaxStringValueLength {
+			return nil, desc.errorf("create metric", name, "value is longer than %v bytes", MaxStringValueLength)
 		}
 
 		val = desc.t.resolve(val)
 		mvals[name] = newinstanceValue(val)
 	}
 
-	return &pcpInstanceMetric{desc, indom, mvals}, nil
+	return &pcpInstanceMetric{desc, sync.RWMutex{}, indom, mvals, nil, 0, nil}, nil
+}
+
+// checkInstance returns an error for the operation op
+// if instance is not a live instance of the metric
+func (m *pcpInstanceMetric) checkInstance(op, instance string) error {
+	v, ok := m.vals[instance]
+	if !ok {
+		return m.errorf(op, instance, "%v is not an instance of metric %v, valid instances are %v", instance, m.name, m.liveInstances())
+	}
+
+	if v.deleted {
+		return m.errorf(op, instance, "instance is deleted")
+	}
+
+	return nil
 }
 
-func (m *pcpInstanceMetric) valInstance(instance string) (interface{}, error) {
-	if !m.indom.HasInstance(instance) {
-		return nil, fmt.Errorf("%v is not an instance of this metric", instance)
+// valInstance returns the value of an instance read by the operation op,
+// which is named in the returned error.
+func (m *pcpInstanceMetric) valInstance(op, instance string) (interface{}, error) {
+	if err := m.checkInstance(op, instance); err != nil {
+		return nil, err
 	}
 
 	return m.vals[instance].val, nil
@@ -815,36 +1152,152 @@ func (m *pcpInstanceMetric) valInstance(instance string) (interface{}, error) {
 
 // setInstance sets the value for a particular instance of the metric.
 func (m *pcpInstanceMetric) setInstance(val interface{}, instance string) error {
+	val, err := m.convert(val, instance)
+	if err != nil {
+		return err
+	}
+
 	if !m.t.IsCompatible(val) {
-		return errors.New("the value is incompatible with this metrics MetricType")
+		return m.errorf("set", instance, "value %v(%T) is incompatible with type %v", val, val, m.t)
 	}
 
-	if !m.indom.HasInstance(instance) {
-		return fmt.Errorf("%v is not an instance of this metric", instance)
+	if err := m.checkInstance("set", instance); err != nil {
+		return err
 	}
 
+	return m.setValue(m.vals[instance], val, instance)
+}
+
+// setValue sets v, the value of a live instance, to a compatible val,
+// the mutex must be held for writing.
+func (m *pcpInstanceMetric) setValue(v *instanceValue, val interface{}, instance string) error {
 	val = m.t.resolve(val)
 
-	if m.vals[instance].val != val {
-		if m.vals[instance].update != nil {
-			err := m.vals[instance].update(val)
-			if err != nil {
-				return err
+	if !m.enabled() {
+		return nil
+	}
+
+	m.updates++
+	v.touched = m.updates
+
+	if v.val != val {
+		if v.update != nil {
+			if err := v.update(val); err != nil {
+				return m.opError("write", instance, err)
+			}
+		}
+
+		v.val = val
+	}
+
+	return nil
+}
+
+// fill sets the values of all instances to val in one pass,
+// the mutex must be held for writing.
+func (m *pcpInstanceMetric) fill(val interface{}) error {
+	val, err := m.convert(val, "")
+	if err != nil {
+		return err
+	}
+
+	if !m.t.IsCompatible(val) {
+		return m.errorf("set all instances of", "", "value %v(%T) is incompatible with type %v", val, val, m.t)
+	}
+
+	val = m.t.resolve(val)
+
+	if !m.enabled() {
+		return nil
+	}
+
+	for name, v := range m.vals {
+		if v.val == val || v.deleted {
+			continue
+		}
+
+		if v.update != nil {
+			if err := v.update(val); err != nil {
+				return m.opError("write", name, err)
 			}
 		}
 
-		m.vals[instance].val = val
+		v.val = val
 	}
 
 	return nil
 }
 
+// resetInstances sets the values of all instances to the zero value of the type,
+// the mutex must be held for writing.
+func (m *pcpInstanceMetric) resetInstances() error {
+	return m.fill(m.t.zero())
+}
+
+// reset sets the values of all instances to the zero value of the type.
+func (m *pcpInstanceMetric) reset() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.resetInstances()
+}
+
+// touch records an update to an instance, the mutex must be held for writing.
+func (m *pcpInstanceMetric) touch(instance string) {
+	m.updates++
+	m.vals[instance].touched = m.updates
+}
+
+// deleteInstance tombstones an instance, the mutex must be held for writing.
+//
+// A deleted instance is no longer updated, and its value stays in a mapping
+// until the registry is compacted, which removes it from the metric and,
+// once it is deleted from all metrics over it, from the instance domain.
+func (m *pcpInstanceMetric) deleteInstance(instance string) error {
+	v, ok := m.vals[instance]
+	if !ok {
+		return m.errorf("delete", instance, "%v is not an instance of metric %v, valid instances are %v", instance, m.name, m.liveInstances())
+	}
+
+	v.deleted, v.update = true, nil
+	return nil
+}
+
+// liveInstances returns the instances of the metric that are not deleted,
+// the mutex must be held.
+func (m *pcpInstanceMetric) liveInstances() []string {
+	ans := make([]string, 0, len(m.vals))
+	for name, v := range m.vals {
+		if !v.deleted {
+			ans = append(ans, name)
+		}
+	}
+	return ans
+}
+
 // Indom returns the instance domain for the metric.
-func (m *pcpInstanceMetric) Indom() *PCPInstanceDomain { return m.indom }
+func (m *pcpInstanceMetric) Indom() InstanceDomain { return m.indom }
 
-// Instances returns a slice containing all instances in the InstanceMetric.
-// Basically a shorthand for metric.Indom().Instances().
-func (m *pcpInstanceMetric) Instances() []string { return m.indom.Instances() }
+func (m *pcpInstanceMetric) instance() *pcpInstanceMetric { return m }
+
+// unbind unbinds all values from their current mapping.
+func (m *pcpInstanceMetric) unbind() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, v := range m.vals {
+		v.update = nil
+	}
+}
+
+// Instances returns a slice containing all instances in the InstanceMetric,
+// except the ones deleted from it.
+func (m *pcpInstanceMetric) Instances() []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.liveInstances()
+}
 
 ///////////////////////////////////////////////////////////////////////////////
 
@@ -852,7 +1305,6 @@ func (m *pcpInstanceMetric) Instances() []string { return m.indom.Instances() }
 // over multiple instances in an instance domain.
 type PCPInstanceMetric struct {
 	*pcpInstanceMetric
-	mutex sync.RWMutex
 }
 
 // NewPCPInstanceMetric creates a new instance of PCPSingletonMetric.
@@ -869,7 +1321,18 @@ func NewPCPInstanceMetric(vals Instances, name string, indom *PCPInstanceDomain,
 		return nil, err
 	}
 
-	return &PCPInstanceMetric{im, sync.RWMutex{}}, nil
+	return &PCPInstanceMetric{im}, nil
+}
+
+// NewPCPInstanceMetricWithDefault creates a new PCPInstanceMetric
+// with the values of all instances in the instance domain set to val.
+func NewPCPInstanceMetricWithDefault(val interface{}, name string, indom *PCPInstanceDomain, t MetricType, s MetricSemantics, u MetricUnit, desc ...string) (*PCPInstanceMetric, error) {
+	vals := make(Instances, indom.InstanceCount())
+	for _, instance := range indom.Instances() {
+		vals[instance] = val
+	}
+
+	return NewPCPInstanceMetric(vals, name, indom, t, s, u, desc...)
 }
 
 // ValInstance returns the value for a particular instance of the metric.
@@ -877,7 +1340,7 @@ func (m *PCPInstanceMetric) ValInstance(instance string) (interface{}, error) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	return m.valInstance(instance)
+	return m.valInstance("get", instance)
 }
 
 // SetInstance sets the value for a particular instance of the metric.
@@ -890,9 +1353,39 @@ func (m *PCPInstanceMetric) SetInstance(val interface{}, instance string) error
 
 // MustSetInstance is a SetInstance that panics.
 func (m *PCPInstanceMetric) MustSetInstance(val interface{}, instance string) {
-	if err := m.SetInstance(val, instance); err != nil {
-		panic(err)
+	must("set", instanceName(m.name, instance), m.client, m.SetInstance(val, instance))
+}
+
+// Fill sets the values of all instances of the metric to val in one pass,
+// so no update to a single instance can interleave with it.
+func (m *PCPInstanceMetric) Fill(val interface{}) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.fill(val)
+}
+
+// MustFill is a Fill that panics.
+func (m *PCPInstanceMetric) MustFill(val interface{}) {
+	must("fill", m.name, m.client, m.Fill(val))
+}
+
+// SwapInstance sets the value for a particular instance of the metric and returns
+// the previous value, as a single operation that cannot interleave with other updates.
+func (m *PCPInstanceMetric) SwapInstance(val interface{}, instance string) (interface{}, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	old, err := m.valInstance("swap", instance)
+	if err != nil {
+		return nil, err
 	}
+
+	if err = m.setInstance(val, instance); err != nil {
+		return nil, err
+	}
+
+	return old, nil
 }
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -913,6 +1406,10 @@ type CounterVector interface {
 
 	Up(string)
 	UpAll()
+
+	Delete(string) error
+
+	Instances() []string
 }
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -920,14 +1417,13 @@ type CounterVector interface {
 // PCPCounterVector implements a CounterVector
 type PCPCounterVector struct {
 	*pcpInstanceMetric
-	mutex sync.RWMutex
 }
 
 func generateInstanceMetric(vals map[string]interface{}, name string, instances []string, t MetricType, s MetricSemantics, u MetricUnit, desc ...string) (*pcpInstanceMetric, error) {
 	indomname := name + ".indom"
 	indom, err := NewPCPInstanceDomain(indomname, instances)
 	if err != nil {
-		return nil, fmt.Errorf("cannot create indom, error: %v", err)
+		return nil, &OpError{"create metric", name, "", err}
 	}
 
 	d, err := newpcpMetricDesc(name, t, s, u, desc...)
@@ -954,7 +1450,7 @@ func NewPCPCounterVector(values map[string]int64, name string, desc ...string) (
 		return nil, err
 	}
 
-	return &PCPCounterVector{im, sync.RWMutex{}}, nil
+	return &PCPCounterVector{im}, nil
 }
 
 // Val returns the value of a particular instance of PCPCounterVector.
@@ -962,7 +1458,7 @@ func (c *PCPCounterVector) Val(instance string) (int64, error) {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 
-	v, err := c.valInstance(instance)
+	v, err := c.valInstance("get", c.normalized(instance))
 	if err != nil {
 		return 0, err
 	}
@@ -975,13 +1471,18 @@ func (c *PCPCounterVector) Set(val int64, instance string) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	v, err := c.valInstance(instance)
+	instance, err := c.instanceFor(instance)
+	if err != nil {
+		return err
+	}
+
+	v, err := c.valInstance("set", instance)
 	if err != nil {
 		return err
 	}
 
 	if val < v.(int64) {
-		return fmt.Errorf("cannot set instance %s to a lesser value %v", instance, val)
+		return c.errorf("set", instance, "current value is %v and PCP counters cannot go backwards to %v", v, val)
 	}
 
 	return c.setInstance(val, instance)
@@ -989,16 +1490,21 @@ func (c *PCPCounterVector) Set(val int64, instance string) error {
 
 // MustSet panics if Set fails.
 func (c *PCPCounterVector) MustSet(val int64, instance string) {
-	if err := c.Set(val, instance); err != nil {
-		panic(err)
-	}
+	must("set", instanceName(c.name, instance), c.client, c.Set(val, instance))
 }
 
-// SetAll sets all instances to the same value and panics on an error.
+// SetAll sets all instances to the same value in one pass and panics on an error.
 func (c *PCPCounterVector) SetAll(val int64) {
-	for ins := range c.indom.instances {
-		c.MustSet(val, ins)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for instance, v := range c.vals {
+		if !v.deleted && val < v.val.(int64) {
+			must("", "", "", c.errorf("set", instance, "current value is %v and PCP counters cannot go backwards to %v", v.val, val))
+		}
 	}
+
+	must("set all instances of", c.name, c.client, c.fill(val))
 }
 
 // Inc increments the value of a particular instance of PCPCounterVector.
@@ -1006,15 +1512,25 @@ func (c *PCPCounterVector) Inc(inc int64, instance string) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
+	return c.inc(inc, instance)
+}
+
+// inc increments the value of an instance, the mutex must be held for writing.
+func (c *PCPCounterVector) inc(inc int64, instance string) error {
 	if inc < 0 {
-		return errors.New("increment cannot be negative")
+		return c.errorf("increment", instance, "increment %v is negative and PCP counters cannot go backwards", inc)
 	}
 
 	if inc == 0 {
 		return nil
 	}
 
-	v, err := c.valInstance(instance)
+	instance, err := c.instanceFor(instance)
+	if err != nil {
+		return err
+	}
+
+	v, err := c.valInstance("increment", instance)
 	if err != nil {
 		return err
 	}
@@ -1024,15 +1540,16 @@ func (c *PCPCounterVector) Inc(inc int64, instance string) error {
 
 // MustInc panics if Inc fails.
 func (c *PCPCounterVector) MustInc(inc int64, instance string) {
-	if err := c.Inc(inc, instance); err != nil {
-		panic(err)
-	}
+	must("increment", instanceName(c.name, instance), c.client, c.Inc(inc, instance))
 }
 
 // IncAll increments all instances by the same value and panics on an error.
 func (c *PCPCounterVector) IncAll(val int64) {
-	for ins := range c.indom.instances {
-		c.MustInc(val, ins)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, ins := range c.liveInstances() {
+		must("increment", instanceName(c.name, ins), c.client, c.inc(val, ins))
 	}
 }
 
@@ -1042,6 +1559,16 @@ func (c *PCPCounterVector) Up(instance string) { c.MustInc(1, instance) }
 // UpAll ups all instances and panics on an error.
 func (c *PCPCounterVector) UpAll() { c.IncAll(1) }
 
+// Delete stops publishing an instance, for when the entity it tracks goes away.
+// The instance can no longer be updated, and its value stays in the mapping
+// until the client is compacted, see PCPClient.Compact.
+func (c *PCPCounterVector) Delete(instance string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.deleteInstance(c.normalized(instance))
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 
 // GaugeVector defines a Gauge on multiple instances
@@ -1061,6 +1588,10 @@ type GaugeVector interface {
 	Dec(float64, string) error
 	MustDec(float64, string)
 	DecAll(float64)
+
+	Delete(string) error
+
+	Instances() []string
 }
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -1068,7 +1599,6 @@ type GaugeVector interface {
 // PCPGaugeVector implements a GaugeVector
 type PCPGaugeVector struct {
 	*pcpInstanceMetric
-	mutex sync.RWMutex
 }
 
 // NewPCPGaugeVector creates a new instance of a PCPGaugeVector.
@@ -1086,7 +1616,7 @@ func NewPCPGaugeVector(values map[string]float64, name string, desc ...string) (
 		return nil, err
 	}
 
-	return &PCPGaugeVector{im, sync.RWMutex{}}, nil
+	return &PCPGaugeVector{im}, nil
 }
 
 // Val returns the value of a particular instance of PCPGaugeVector
@@ -1094,7 +1624,7 @@ func (g *PCPGaugeVector) Val(instance string) (float64, error) {
 	g.mutex.RLock()
 	defer g.mutex.RUnlock()
 
-	val, err := g.valInstance(instance)
+	val, err := g.valInstance("get", g.normalized(instance))
 	if err != nil {
 		return 0, err
 	}
@@ -1106,21 +1636,26 @@ func (g *PCPGaugeVector) Val(instance string) (float64, error) {
 func (g *PCPGaugeVector) Set(val float64, instance string) error {
 	g.mutex.Lock()
 	defer g.mutex.Unlock()
+
+	instance, err := g.instanceFor(instance)
+	if err != nil {
+		return err
+	}
+
 	return g.setInstance(val, instance)
 }
 
 // MustSet panics if Set fails
 func (g *PCPGaugeVector) MustSet(val float64, instance string) {
-	if err := g.Set(val, instance); err != nil {
-		panic(err)
-	}
+	must("set", instanceName(g.name, instance), g.client, g.Set(val, instance))
 }
 
-// SetAll sets all instances to the same value and panics on an error
+// SetAll sets all instances to the same value in one pass and panics on an error
 func (g *PCPGaugeVector) SetAll(val float64) {
-	for ins := range g.indom.instances {
-		g.MustSet(val, ins)
-	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	must("set all instances of", g.name, g.client, g.fill(val))
 }
 
 // Inc increments the value of a particular instance of PCPGaugeVector
@@ -1128,7 +1663,17 @@ func (g *PCPGaugeVector) Inc(inc float64, instance string) error {
 	g.mutex.Lock()
 	defer g.mutex.Unlock()
 
-	v, err := g.valInstance(instance)
+	return g.inc(inc, instance)
+}
+
+// inc increments the value of an instance, the mutex must be held for writing.
+func (g *PCPGaugeVector) inc(inc float64, instance string) error {
+	instance, err := g.instanceFor(instance)
+	if err != nil {
+		return err
+	}
+
+	v, err := g.valInstance("increment", instance)
 	if err != nil {
 		return err
 	}
@@ -1138,15 +1683,16 @@ func (g *PCPGaugeVector) Inc(inc float64, instance string) error {
 
 // MustInc panics if Inc fails
 func (g *PCPGaugeVector) MustInc(inc float64, instance string) {
-	if err := g.Inc(inc, instance); err != nil {
-		panic(err)
-	}
+	must("increment", instanceName(g.name, instance), g.client, g.Inc(inc, instance))
 }
 
 // IncAll increments all instances by the same value and panics on an error
 func (g *PCPGaugeVector) IncAll(val float64) {
-	for ins := range g.indom.instances {
-		g.MustInc(val, ins)
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for _, ins := range g.liveInstances() {
+		must("increment", instanceName(g.name, ins), g.client, g.inc(val, ins))
 	}
 }
 
@@ -1159,10 +1705,22 @@ func (g *PCPGaugeVector) MustDec(inc float64, instance string) { g.MustInc(-inc,
 // DecAll decrements all instances by the same value and panics on an error
 func (g *PCPGaugeVector) DecAll(val float64) { g.IncAll(-val) }
 
+// Delete stops publishing an instance, for when the entity it tracks goes away.
+// The instance can no longer be updated, and its value stays in the mapping
+// until the client is compacted, see PCPClient.Compact.
+func (g *PCPGaugeVector) Delete(instance string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.deleteInstance(g.normalized(instance))
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 
 // Histogram defines a metric that records a distribution of data
 type Histogram interface {
+	Metric
+
 	Max() int64 // Maximum value recorded so far
 	Min() int64 // Minimum value recorded so far
 
@@ -1187,8 +1745,7 @@ type Histogram interface {
 // https://github.com/codahale/hdrhistogram
 type PCPHistogram struct {
 	*pcpInstanceMetric
-	mutex sync.RWMutex
-	h     *histogram.Histogram
+	h *histogram.Histogram
 }
 
 // the maximum and minimum values that can be recorded by a histogram
@@ -1235,7 +1792,7 @@ func normalize(low, high int64, sigfigures int) (int64, int64, int) {
 // long descriptions of the metric.
 func NewPCPHistogram(name string, low, high int64, sigfigures int, unit MetricUnit, desc ...string) (*PCPHistogram, error) {
 	if low > high {
-		return nil, errors.New("low cannot be larger than high")
+		return nil, &OpError{"create metric", name, "", fmt.Errorf("low %v cannot be larger than high %v", low, high)}
 	}
 
 	low, high, sigfigures = normalize(low, high, sigfigures)
@@ -1253,7 +1810,7 @@ func NewPCPHistogram(name string, low, high int64, sigfigures int, unit MetricUn
 		return nil, err
 	}
 
-	return &PCPHistogram{m, sync.RWMutex{}, h}, nil
+	return &PCPHistogram{m, h}, nil
 }
 
 // High returns the maximum recordable value.
@@ -1309,8 +1866,21 @@ func (h *PCPHistogram) update() error {
 	return nil
 }
 
+// reset clears all recorded values.
+func (h *PCPHistogram) reset() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.h.Reset()
+	return h.resetInstances()
+}
+
 // Record records a new value.
 func (h *PCPHistogram) Record(val int64) error {
+	if !h.enabled() {
+		return nil
+	}
+
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
@@ -1324,13 +1894,15 @@ func (h *PCPHistogram) Record(val int64) error {
 
 // MustRecord panics if Record fails.
 func (h *PCPHistogram) MustRecord(val int64) {
-	if err := h.Record(val); err != nil {
-		panic(err)
-	}
+	must("record", h.name, h.client, h.Record(val))
 }
 
 // RecordN records multiple instances of the same value.
 func (h *PCPHistogram) RecordN(val, n int64) error {
+	if !h.enabled() {
+		return nil
+	}
+
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
@@ -1344,9 +1916,7 @@ func (h *PCPHistogram) RecordN(val, n int64) error {
 
 // MustRecordN panics if RecordN fails.
 func (h *PCPHistogram) MustRecordN(val, n int64) {
-	if err := h.RecordN(val, n); err != nil {
-		panic(err)
-	}
+	must("record", h.name, h.client, h.RecordN(val, n))
 }
 
 // Mean returns the mean of all values recorded so far.
@@ -1371,7 +1941,11 @@ func (h *PCPHistogram) Variance() float64 {
 }
 
 // Percentile returns the value at the passed percentile.
-func (h *PCPHistogram) Percentile(p float64) int64 { return h.h.ValueAtQuantile(p) }
+func (h *PCPHistogram) Percentile(p float64) int64 {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.h.ValueAtQuantile(p)
+}
 
 // HistogramBucket is a single histogram bucket within a fixed range.
 type HistogramBucket struct {
@@ -1380,10 +1954,55 @@ type HistogramBucket struct {
 
 // Buckets returns a list of histogram buckets.
 func (h *PCPHistogram) Buckets() []*HistogramBucket {
-	b := h.h.Distribution()
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return histogramBuckets(h.h)
+}
+
+func histogramBuckets(h *histogram.Histogram) []*HistogramBucket {
+	b := h.Distribution()
 	buckets := make([]*HistogramBucket, len(b))
 	for i := 0; i < len(b); i++ {
 		buckets[i] = &HistogramBucket{b[i].From, b[i].To, b[i].Count}
 	}
 	return buckets
 }
+
+// HistogramSnapshot holds the values of a histogram at one point in time, all read
+// together, so they are consistent with each other even while values are recorded.
+type HistogramSnapshot struct {
+	Count             int64 // number of values recorded
+	Min, Max          int64
+	Mean              float64
+	Variance          float64
+	StandardDeviation float64
+
+	h *histogram.Histogram // a copy of the recorded values, for percentiles and buckets
+}
+
+// Percentile returns the value at the passed percentile when the snapshot was taken.
+func (s *HistogramSnapshot) Percentile(p float64) int64 { return s.h.ValueAtQuantile(p) }
+
+// Buckets returns the histogram buckets when the snapshot was taken.
+func (s *HistogramSnapshot) Buckets() []*HistogramBucket { return histogramBuckets(s.h) }
+
+// Snapshot returns the values of the histogram, read atomically with respect to Record,
+// so exporters reading several of them, like a set of percentiles, see a consistent view.
+func (h *PCPHistogram) Snapshot() *HistogramSnapshot {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	// the exported counts are shared with the histogram, so copy them
+	e := h.h.Export()
+	e.Counts = append([]int64(nil), e.Counts...)
+
+	return &HistogramSnapshot{
+		Count:             h.h.TotalCount(),
+		Min:               int64(h.vals["min"].val.(float64)),
+		Max:               int64(h.vals["max"].val.(float64)),
+		Mean:              h.vals["mean"].val.(float64),
+		Variance:          h.vals["variance"].val.(float64),
+		StandardDeviation: h.vals["standard_deviation"].val.(float64),
+		h:                 histogram.Import(e),
+	}
+}
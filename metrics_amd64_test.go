@@ -3,6 +3,8 @@ package speed
 import (
 	"math"
 	"testing"
+
+	"github.com/performancecopilot/speed/mmvdump"
 )
 
 func TestIsCompatible64(t *testing.T) {
@@ -31,6 +33,7 @@ func TestIsCompatible64(t *testing.T) {
 		{Uint32Type, math.MaxInt64, false},
 		{Uint64Type, math.MaxInt64, true},
 
+		{Uint32Type, math.MaxUint32, true},
 		{Uint32Type, math.MaxUint32 + 1, false},
 		{Uint64Type, math.MaxUint32 + 1, true},
 
@@ -71,3 +74,30 @@ func TestIsCompatible64(t *testing.T) {
 		}
 	}
 }
+
+// untyped constants over math.MaxInt32 are only ints on 64 bit platforms
+func TestWritingMaxUint32(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Errorf("cannot create client: %v", err)
+		return
+	}
+
+	c.MustRegisterString("m.3", 4294967295, Uint32Type, CounterSemantics, OneUnit)
+	c.MustRegisterString("m.4", 4294967295, Uint64Type, CounterSemantics, OneUnit)
+
+	c.MustRegisterString("m[a, b].10", Instances{"a": 4294967295, "b": 0}, Uint32Type, CounterSemantics, OneUnit)
+	c.MustRegisterString("m[a, b].11", Instances{"a": 4294967295, "b": 0}, Uint64Type, CounterSemantics, OneUnit)
+
+	c.MustStart()
+	defer c.MustStop()
+
+	_, _, metrics, values, instances, indoms, strings, err := mmvdump.Dump(c.writer.Bytes())
+	if err != nil {
+		t.Errorf("cannot get dump: %v", err)
+		return
+	}
+
+	matchMetricsAndValues(metrics, values, instances, strings, c, t)
+	matchInstancesAndInstanceDomains(instances, indoms, strings, c, t)
+}
@@ -2,6 +2,7 @@ package speed
 
 import (
 	"math"
+	"sync"
 	"testing"
 )
 
@@ -45,7 +46,6 @@ func TestIsCompatible(t *testing.T) {
 
 		{Uint32Type, uint(math.MaxUint32), true},
 
-		{Uint32Type, math.MaxUint32, true},
 		{Uint64Type, uint64(math.MaxUint64), true},
 
 		{FloatType, math.MaxFloat32, true},
@@ -110,3 +110,119 @@ func TestResolve(t *testing.T) {
 		}
 	}
 }
+
+func TestMetricIndom(t *testing.T) {
+	s, err := NewPCPSingletonMetric(1, "singleton", Int32Type, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	cv, err := NewPCPCounterVector(map[string]int64{"a": 1, "b": 2}, "vector")
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	h, err := NewPCPHistogram("histogram", 0, 100, 1, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	cases := []struct {
+		m         Metric
+		indom     string
+		instances int
+	}{
+		{s, "", 0},
+		{cv, "vector.indom", 2},
+		{h, "histogram.indom", 5},
+	}
+
+	for _, c := range cases {
+		indom := c.m.Indom()
+
+		if c.indom == "" {
+			if indom != nil {
+				t.Errorf("expected %v to not have an instance domain, got %v", c.m.Name(), indom)
+			}
+			continue
+		}
+
+		if indom == nil {
+			t.Errorf("expected %v to have an instance domain", c.m.Name())
+			continue
+		}
+
+		if indom.Name() != c.indom {
+			t.Errorf("expected instance domain of %v to be %v, got %v", c.m.Name(), c.indom, indom.Name())
+		}
+
+		if n := len(c.m.(interface {
+			Instances() []string
+		}).Instances()); n != c.instances {
+			t.Errorf("expected %v to have %v instances, got %v", c.m.Name(), c.instances, n)
+		}
+	}
+}
+
+func TestSwap(t *testing.T) {
+	m, err := NewPCPSingletonMetric(0, "swap.singleton", Int32Type, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	// every value must be returned by exactly one swap
+	const n = 100
+	olds := make(chan interface{}, n)
+
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 1; i <= n; i++ {
+		go func(i int) {
+			defer wg.Done()
+
+			old, err := m.Swap(i)
+			if err != nil {
+				t.Errorf("cannot swap, error: %v", err)
+			}
+			olds <- old
+		}(i)
+	}
+	wg.Wait()
+	close(olds)
+
+	seen := make(map[interface{}]bool)
+	for old := range olds {
+		seen[old] = true
+	}
+	seen[m.Val()] = true
+
+	if len(seen) != n+1 {
+		t.Errorf("expected %v distinct values to be swapped out, got %v", n+1, len(seen))
+	}
+
+	if _, err = m.Swap("a"); err == nil {
+		t.Errorf("expected swapping in an incompatible value to fail")
+	}
+
+	indom, err := NewPCPInstanceDomain("swap.indom", []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("cannot create indom, error: %v", err)
+	}
+
+	im, err := NewPCPInstanceMetric(Instances{"a": 1, "b": 2}, "swap.instance", indom, Int32Type, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	if old, err := im.SwapInstance(10, "b"); err != nil || old != int32(2) {
+		t.Errorf("expected to swap out 2, got %v, error: %v", old, err)
+	}
+
+	if v, _ := im.ValInstance("b"); v != int32(10) {
+		t.Errorf("expected b to be 10 after swap, got %v", v)
+	}
+
+	if _, err = im.SwapInstance(10, "c"); err == nil {
+		t.Errorf("expected swapping a value of an unknown instance to fail")
+	}
+}
@@ -0,0 +1,24 @@
+//go:build go1.7
+// +build go1.7
+
+package mmvdump
+
+import (
+	"fmt"
+	"testing"
+)
+
+func BenchmarkReadItems(b *testing.B) {
+	const count = 100000
+	d := make([]byte, count*ValueLength)
+
+	for _, p := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("parallelism=%v", p), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := readItems(d, 0, count, ValueLength, readValue, 1, p); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
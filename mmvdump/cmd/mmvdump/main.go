@@ -1,9 +1,15 @@
 package main
 
 import (
+	"encoding/csv"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	gostrings "strings"
+	"time"
 
 	"github.com/performancecopilot/speed/mmvdump"
 )
@@ -16,6 +22,9 @@ var (
 	instances map[uint64]mmvdump.Instance
 	indoms    map[uint64]*mmvdump.InstanceDomain
 	strings   map[uint64]*mmvdump.String
+
+	// times the values were last updated at, by offset, if the client recorded them
+	updated map[uint64]time.Time
 )
 
 func instanceName(m mmvdump.Instance) string {
@@ -83,63 +92,90 @@ func printMetric(offset uint64) {
 	}
 }
 
-func printValue(offset uint64) {
+// value returns the value stored at offset, along with the name of its metric and instance
+func value(offset uint64) (metric, instance string, val interface{}, err error) {
 	v := values[offset]
 	m := metrics[v.Metric]
 
-	fmt.Printf("\t[%v/%v] %v", m.Item(), offset, metricName(m))
-
-	var (
-		a   interface{}
-		err error
-	)
+	metric = metricName(m)
 
 	if m.Typ() != mmvdump.StringType {
-		a, err = mmvdump.FixedVal(v.Val, m.Typ())
+		val, err = mmvdump.FixedVal(v.Val, m.Typ())
 	} else {
-		v, ok := strings[uint64(v.Extra)]
+		s, ok := strings[uint64(v.Extra)]
 		if !ok {
-			panic("invalid string address")
+			return "", "", nil, errors.New("invalid string address")
 		}
-		a = string(v.Payload[:])
+		val = string(s.Payload[:])
 	}
 
 	if m.Indom() != mmvdump.NoIndom && m.Indom() != 0 {
-		i := instances[v.Instance]
-		fmt.Printf("[%d or \"%s\"]", i.Internal(), instanceName(i))
+		instance = instanceName(instances[v.Instance])
 	}
 
+	return
+}
+
+func printValue(offset uint64) {
+	v := values[offset]
+	m := metrics[v.Metric]
+
+	Name, _, a, err := value(offset)
 	if err != nil {
 		panic(err)
 	}
 
-	fmt.Printf(" = %v\n", a)
-}
+	fmt.Printf("\t[%v/%v] %v", m.Item(), offset, Name)
 
-func printString(offset uint64) {
-	fmt.Printf("\t[%v] %v\n", offset, string(strings[offset].Payload[:]))
+	if m.Indom() != mmvdump.NoIndom && m.Indom() != 0 {
+		i := instances[v.Instance]
+		fmt.Printf("[%d or \"%s\"]", i.Internal(), instanceName(i))
+	}
+
+	fmt.Printf(" = %v", a)
+
+	if updated != nil {
+		if t := updated[offset]; t.IsZero() {
+			fmt.Printf(" (not updated)")
+		} else {
+			fmt.Printf(" (updated %v ago)", time.Since(t))
+		}
+	}
+
+	fmt.Println()
 }
 
-func data(file string) []byte {
-	f, err := os.Open(file)
+// printValueQuiet prints a value as metric=value or metric[instance]=value
+func printValueQuiet(offset uint64) {
+	metric, instance, val, err := value(offset)
 	if err != nil {
 		panic(err)
 	}
 
-	fi, err := os.Stat(file)
-	if err != nil {
-		panic(err)
+	if instance != "" {
+		fmt.Printf("%v[%v]=%v\n", trim(metric), trim(instance), trimValue(val))
+	} else {
+		fmt.Printf("%v=%v\n", trim(metric), trimValue(val))
 	}
+}
 
-	len := fi.Size()
-	data := make([]byte, len)
+// trim removes the null padding of a fixed length string
+func trim(s string) string {
+	if i := gostrings.IndexByte(s, 0); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
 
-	_, err = f.Read(data)
-	if err != nil {
-		panic(err)
+func trimValue(val interface{}) interface{} {
+	if s, ok := val.(string); ok {
+		return trim(s)
 	}
+	return val
+}
 
-	return data
+func printString(offset uint64) {
+	fmt.Printf("\t[%v] %v\n", offset, string(strings[offset].Payload[:]))
 }
 
 func printComponents() {
@@ -193,21 +229,187 @@ func printComponents() {
 	}
 }
 
+// eachValue calls f with the offset of every value, in the order they are laid out in the file
+func eachValue(f func(uint64)) {
+	for _, toc := range tocs {
+		if toc.Type != mmvdump.TocValues {
+			continue
+		}
+
+		for i, offset := int32(0), toc.Offset; i < toc.Count; i, offset = i+1, offset+mmvdump.ValueLength {
+			f(offset)
+		}
+	}
+}
+
+// printValuesCSV prints a header row followed by one row for every value
+func printValuesCSV() error {
+	w := csv.NewWriter(os.Stdout)
+	columns := []string{"metric", "instance", "type", "semantics", "units", "value"}
+	if updated != nil {
+		columns = append(columns, "updated")
+	}
+	_ = w.Write(columns)
+
+	eachValue(func(offset uint64) {
+		metric, instance, val, err := value(offset)
+		if err != nil {
+			panic(err)
+		}
+
+		m := metrics[values[offset].Metric]
+		row := []string{
+			trim(metric),
+			trim(instance),
+			m.Typ().String(),
+			m.Sem().String(),
+			m.Unit().String(),
+			fmt.Sprint(trimValue(val)),
+		}
+
+		if updated != nil {
+			t := ""
+			if !updated[offset].IsZero() {
+				t = updated[offset].Format(time.RFC3339Nano)
+			}
+			row = append(row, t)
+		}
+
+		_ = w.Write(row)
+	})
+
+	w.Flush()
+	return w.Error()
+}
+
+// readUpdateTimes reads the times the values were last updated at from the file at path,
+// if it exists
+func readUpdateTimes(d []byte, path string) {
+	times, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	if updated, err = mmvdump.UpdateTimes(d, times); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: cannot read update times from %v: %v\n", path, err)
+	}
+}
+
+// exit codes
+const (
+	exitOK         = 0 // the file was read and is structurally valid
+	exitParseError = 1 // the file could not be read
+	exitInvalid    = 2 // the file was read, but has structural problems
+)
+
+var (
+	quiet  = flag.Bool("q", false, "print values as metric[instance]=value lines, one per value")
+	csvout = flag.Bool("csv", false, "print values as csv rows of metric, instance, type, semantics, units and value")
+	pid    = flag.Int("pid", 0, "read an anonymous mapping of the process, with the argument being the client name")
+)
+
+// procMapping returns the path of the anonymous mapping of a client in a process,
+// the client name can be empty if the process has a single anonymous mapping
+func procMapping(pid int, name string) (string, error) {
+	mappings, err := mmvdump.ProcMappings(pid)
+	if err != nil {
+		return "", err
+	}
+
+	if name != "" {
+		path, ok := mappings[name]
+		if !ok {
+			return "", fmt.Errorf("process %v has no anonymous mapping for client %v", pid, name)
+		}
+		return path, nil
+	}
+
+	if len(mappings) != 1 {
+		names := make([]string, 0, len(mappings))
+		for n := range mappings {
+			names = append(names, n)
+		}
+		return "", fmt.Errorf("process %v has %v anonymous mappings %v, pass the client name", pid, len(mappings), names)
+	}
+
+	for _, path := range mappings {
+		return path, nil
+	}
+
+	return "", nil
+}
+
 func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `usage: mmvdump [-q | -csv] <file>
+       mmvdump [-q | -csv] -pid <pid> [client]
+
+exits with %v if the file is valid, %v if it cannot be read and %v if it has structural problems
+
+`, exitOK, exitParseError, exitInvalid)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	if flag.NArg() < 1 {
-		fmt.Println("usage: mmvdump <file>")
-		return
+	if (flag.NArg() < 1 && *pid == 0) || (*quiet && *csvout) {
+		flag.Usage()
+		os.Exit(exitParseError)
 	}
 
 	file := flag.Arg(0)
-	d := data(file)
+	if *pid != 0 {
+		var err error
+		if file, err = procMapping(*pid, file); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitParseError)
+		}
+	}
+
+	d, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitParseError)
+	}
 
-	var err error
 	header, tocs, metrics, values, instances, indoms, strings, err = mmvdump.Dump(d)
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitParseError)
+	}
+
+	// flags conflicting with the header or the file name are only warned about
+	name := filepath.Base(file)
+	if *pid != 0 {
+		name = flag.Arg(0)
+	}
+	for _, w := range mmvdump.CheckFlags(header, name) {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", w)
+	}
+
+	// components of a file with structural problems cannot be printed safely
+	if problems := mmvdump.Validate(d); len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "%v problems found\n", len(problems))
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "\t%v\n", p)
+		}
+		os.Exit(exitInvalid)
+	}
+
+	// update times are only recorded for mappings under PCP_TMP_DIR
+	if *pid == 0 {
+		readUpdateTimes(d, mmvdump.UpdateTimesLocation(file))
+	}
+
+	switch {
+	case *quiet:
+		eachValue(printValueQuiet)
+		return
+	case *csvout:
+		if err := printValuesCSV(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitParseError)
+		}
+		return
 	}
 
 	fmt.Printf(`
@@ -217,9 +419,9 @@ Generated = %v
 Toc Count = %v
 Cluster   = %v
 Process   = %v
-Flags     = 0x%x
+Flags     = 0x%x (%v)
 
-`, file, header.Version, header.G1, header.Toc, header.Cluster, header.Process, int(header.Flag))
+`, file, header.Version, header.G1, header.Toc, header.Cluster, header.Process, int(header.Flag), header.Flags())
 
 	printComponents()
 }
@@ -0,0 +1,93 @@
+package mmvdump
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Flags are the flags of a mapping, stored in its header
+type Flags int32
+
+// Values for Flags, as defined by libpcp_mmv
+const (
+	// NoPrefixFlag publishes the metrics without prefixing their names with the file name
+	NoPrefixFlag Flags = 1 << iota
+
+	// ProcessFlag publishes the metrics only while the process in the header is running
+	ProcessFlag
+
+	// SentinelFlag reports values equal to the sentinel of their type as missing
+	SentinelFlag
+
+	// KnownFlags are all flags known to libpcp_mmv
+	KnownFlags = NoPrefixFlag | ProcessFlag | SentinelFlag
+)
+
+var flagNames = []struct {
+	flag Flags
+	name string
+}{
+	{NoPrefixFlag, "noprefix"},
+	{ProcessFlag, "process"},
+	{SentinelFlag, "sentinel"},
+}
+
+// String returns the names of the set flags separated by |, with unknown bits
+// in hexadecimal, or none if no flag is set
+func (f Flags) String() string {
+	if f == 0 {
+		return "none"
+	}
+
+	var names []string
+	for _, n := range flagNames {
+		if f&n.flag != 0 {
+			names = append(names, n.name)
+		}
+	}
+
+	if unknown := f &^ KnownFlags; unknown != 0 {
+		names = append(names, fmt.Sprintf("0x%x", int32(unknown)))
+	}
+
+	return strings.Join(names, "|")
+}
+
+// Flags returns the flags of the mapping
+func (h *Header) Flags() Flags { return Flags(h.Flag) }
+
+// validPrefix returns true if name can be the first component of metric names
+func validPrefix(name string) bool {
+	for i, r := range name {
+		letter := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		if !letter && (i == 0 || ((r < '0' || r > '9') && r != '_')) {
+			return false
+		}
+	}
+
+	return name != ""
+}
+
+// CheckFlags returns warnings about flags of a header that conflict with the rest of the
+// header, or with the name of the file the mapping is read from, if name is not empty.
+// Unlike the problems returned by Validate, the mapping can be read, but PCP may publish
+// it differently than its writer intended.
+func CheckFlags(h *Header, name string) []error {
+	var warnings []error
+	f := h.Flags()
+
+	if unknown := f &^ KnownFlags; unknown != 0 {
+		warnings = append(warnings, fmt.Errorf("unknown flags 0x%x are set, which PCP ignores", int32(unknown)))
+	}
+
+	if f&ProcessFlag != 0 && h.Process <= 0 {
+		warnings = append(warnings, errors.New("the process flag is set, but the header has no process id"))
+	}
+
+	if name != "" && f&NoPrefixFlag == 0 && !validPrefix(name) {
+		warnings = append(warnings, fmt.Errorf("the noprefix flag is not set, so the metric names are prefixed with the file name %q, which is not a valid name component", name))
+	}
+
+	return warnings
+}
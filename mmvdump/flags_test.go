@@ -0,0 +1,66 @@
+package mmvdump
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFlagsString(t *testing.T) {
+	cases := []struct {
+		flags    Flags
+		expected string
+	}{
+		{0, "none"},
+		{ProcessFlag, "process"},
+		{NoPrefixFlag | SentinelFlag, "noprefix|sentinel"},
+		{ProcessFlag | 0x10, "process|0x10"},
+	}
+
+	for _, c := range cases {
+		if s := c.flags.String(); s != c.expected {
+			t.Errorf("expected %d to be %v, got %v", int32(c.flags), c.expected, s)
+		}
+	}
+}
+
+func TestCheckFlags(t *testing.T) {
+	cases := []struct {
+		flags    Flags
+		process  int32
+		name     string
+		expected string
+	}{
+		{ProcessFlag, 1234, "app", ""},
+		{NoPrefixFlag, 0, "my.app", ""},
+		{ProcessFlag, 1234, "", ""},
+		{ProcessFlag | 0x8, 1234, "app", "unknown flags 0x8"},
+		{ProcessFlag, 0, "app", "no process id"},
+		{0, 1234, "app", ""},
+		{ProcessFlag, 1234, "my.app", `file name "my.app"`},
+		{ProcessFlag, 1234, "1app", `file name "1app"`},
+	}
+
+	for _, c := range cases {
+		warnings := CheckFlags(&Header{Flag: int32(c.flags), Process: c.process}, c.name)
+
+		if c.expected == "" {
+			if len(warnings) != 0 {
+				t.Errorf("expected no warnings for %v, %v and %q, got %v", c.flags, c.process, c.name, warnings)
+			}
+			continue
+		}
+
+		if len(warnings) != 1 || !strings.Contains(warnings[0].Error(), c.expected) {
+			t.Errorf("expected warning %q for %v, %v and %q, got %v", c.expected, c.flags, c.process, c.name, warnings)
+		}
+	}
+
+	h, _, _, _, _, _, _, err := Dump(data("testdata/test1.mmv"))
+	if err != nil {
+		t.Fatalf("cannot dump test1, error: %v", err)
+	}
+
+	if warnings := CheckFlags(h, "test1"); len(warnings) != 0 {
+		t.Errorf("expected no warnings for test1, got %v", warnings)
+	}
+}
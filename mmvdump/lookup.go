@@ -0,0 +1,186 @@
+package mmvdump
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// cstring returns the contents of a null terminated string stored in b
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// readName reads a name stored inline in mmv1 or at a string offset in mmv2
+func readName(data []byte, inline []byte, offset uint64, version int32) (string, error) {
+	if version == 1 {
+		return cstring(inline), nil
+	}
+
+	s, err := readString(data, offset, version)
+	if err != nil {
+		return "", err
+	}
+	return cstring(s.(*String).Payload[:]), nil
+}
+
+// parseLookupName splits a name of the form metric[instance] into its parts
+func parseLookupName(name string) (metric, instance string, err error) {
+	i := strings.IndexByte(name, '[')
+	if i < 0 {
+		return name, "", nil
+	}
+
+	if !strings.HasSuffix(name, "]") || i == len(name)-2 {
+		return "", "", fmt.Errorf("invalid name %v, expected metric or metric[instance]", name)
+	}
+
+	return name[:i], name[i+1 : len(name)-1], nil
+}
+
+// Lookup returns the value of a single metric, or of a single instance of a metric
+// if the name is of the form metric[instance].
+//
+// The metric name is matched against the names stored in the mapping, and can also be
+// passed the way PCP exposes it, prefixed with "mmv." or "mmv.<client>.".
+//
+// Unlike Dump, Lookup only reads the header, the TOCs and the components
+// needed to find the value.
+func Lookup(data []byte, name string) (interface{}, error) {
+	metric, instance, err := parseLookupName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := readHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	tocs, err := readTocs(data, h.Toc)
+	if err != nil {
+		return nil, err
+	}
+
+	var metrictoc, valuetoc *Toc
+	for _, toc := range tocs {
+		switch toc.Type {
+		case TocMetrics:
+			metrictoc = toc
+		case TocValues:
+			valuetoc = toc
+		}
+	}
+
+	if metrictoc == nil || valuetoc == nil {
+		return nil, errors.New("mapping does not contain any metrics")
+	}
+
+	moff, m, err := lookupMetric(data, metrictoc, metric, h.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Indom() == NoIndom && instance != "" {
+		return nil, fmt.Errorf("metric %v does not have instances", metric)
+	}
+
+	if m.Indom() != NoIndom && instance == "" {
+		return nil, fmt.Errorf("metric %v has instances, expected %v[instance]", metric, metric)
+	}
+
+	for i, off := int32(0), valuetoc.Offset; i < valuetoc.Count; i, off = i+1, off+ValueLength {
+		item, err := readValue(data, off, h.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		v := item.(*Value)
+		if v.Metric != moff {
+			continue
+		}
+
+		if instance != "" {
+			item, err := readInstance(data, v.Instance, h.Version)
+			if err != nil {
+				return nil, err
+			}
+
+			var iname string
+			switch ins := item.(type) {
+			case *Instance1:
+				iname = cstring(ins.External[:])
+			case *Instance2:
+				iname, err = readName(data, nil, ins.External, h.Version)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			if iname != instance {
+				continue
+			}
+		}
+
+		if m.Typ() == StringType {
+			s, err := readString(data, uint64(v.Extra), h.Version)
+			if err != nil {
+				return nil, err
+			}
+			return cstring(s.(*String).Payload[:]), nil
+		}
+
+		return FixedVal(v.Val, m.Typ())
+	}
+
+	if instance != "" {
+		return nil, fmt.Errorf("%v is not an instance of metric %v", instance, metric)
+	}
+
+	return nil, fmt.Errorf("no value found for metric %v", metric)
+}
+
+// lookupMetric returns the offset and contents of the metric of the passed name
+func lookupMetric(data []byte, toc *Toc, name string, version int32) (uint64, Metric, error) {
+	// the names a metric can be stored under
+	candidates := []string{name}
+	if strings.HasPrefix(name, "mmv.") {
+		stripped := strings.TrimPrefix(name, "mmv.")
+		candidates = append(candidates, stripped)
+
+		if i := strings.IndexByte(stripped, '.'); i >= 0 {
+			candidates = append(candidates, stripped[i+1:])
+		}
+	}
+
+	l := itemLength(TocMetrics, version)
+	for i, off := int32(0), toc.Offset; i < toc.Count; i, off = i+1, off+l {
+		item, err := readMetric(data, off, version)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		var mname string
+		switch m := item.(type) {
+		case *Metric1:
+			mname, err = readName(data, m.Name[:], 0, version)
+		case *Metric2:
+			mname, err = readName(data, nil, m.Name, version)
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+
+		for _, c := range candidates {
+			if mname == c {
+				return off, item.(Metric), nil
+			}
+		}
+	}
+
+	return 0, nil, fmt.Errorf("metric %v not found", name)
+}
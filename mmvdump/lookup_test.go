@@ -0,0 +1,42 @@
+package mmvdump
+
+import "testing"
+
+func TestLookup(t *testing.T) {
+	cases := []struct {
+		file, name string
+		expected   interface{}
+	}{
+		{"test1", "simple.counter", int32(42)},
+		{"test1", "mmv.simple.counter", int32(42)},
+		{"test1", "mmv.app.simple.counter", int32(42)},
+		{"test2", "language.users[go]", uint64(8388608)},
+		{"test2", "mmv.app.language.users[php]", uint64(33)},
+		{"test3", "bat.names", "Robin"},
+	}
+
+	for _, c := range cases {
+		v, err := Lookup(data("testdata/"+c.file+".mmv"), c.name)
+		if err != nil {
+			t.Errorf("cannot lookup %v in %v, error: %v", c.name, c.file, err)
+		} else if v != c.expected {
+			t.Errorf("expected %v in %v to be %v(%T), got %v(%T)", c.name, c.file, c.expected, c.expected, v, v)
+		}
+	}
+
+	failing := []struct {
+		file, name string
+	}{
+		{"test1", "simple.gauge"},
+		{"test1", "simple.counter[go]"},
+		{"test2", "language.users"},
+		{"test2", "language.users[rust]"},
+		{"test2", "language.users[go"},
+	}
+
+	for _, c := range failing {
+		if v, err := Lookup(data("testdata/"+c.file+".mmv"), c.name); err == nil {
+			t.Errorf("expected lookup of %v in %v to fail, got %v", c.name, c.file, v)
+		}
+	}
+}
@@ -108,6 +108,10 @@ func readString(data []byte, offset uint64, version int32) (interface{}, error)
 }
 
 func readTocs(data []byte, count int32) ([]*Toc, error) {
+	if count < 0 {
+		return nil, fmt.Errorf("negative TOC count %v", count)
+	}
+
 	tocs := make([]*Toc, count)
 
 	for i := int32(0); i < count; i++ {
@@ -121,49 +125,91 @@ func readTocs(data []byte, count int32) ([]*Toc, error) {
 	return tocs, nil
 }
 
-func readItems(data []byte, offset uint64, count int32, itemlength uint64, readItem itemReaderFunc, version int32) (map[uint64]interface{}, error) {
-	var wg sync.WaitGroup
-	wg.Add(int(count))
+// readItems reads count items of a section starting at offset, using up to workers goroutines
+func readItems(data []byte, offset uint64, count int32, itemlength uint64, readItem itemReaderFunc, version int32, workers int) (map[uint64]interface{}, error) {
+	// the count is checked before it sizes anything, as it comes straight from the mapping
+	if count < 0 {
+		return nil, fmt.Errorf("negative item count %v", count)
+	}
 
-	items := make(map[uint64]interface{})
+	if offset > uint64(len(data)) || uint64(count)*itemlength > uint64(len(data))-offset {
+		return nil, fmt.Errorf("%v items at offset %v do not fit in %v bytes", count, offset, len(data))
+	}
+
+	items := make(map[uint64]interface{}, count)
+
+	if workers > int(count) {
+		workers = int(count)
+	}
+
+	if workers <= 1 {
+		for i := int32(0); i < count; i, offset = i+1, offset+itemlength {
+			item, err := readItem(data, offset, version)
+			if err != nil {
+				return nil, err
+			}
+			items[offset] = item
+		}
+
+		return items, nil
+	}
+
+	// every worker reads a contiguous chunk of items into its own slice
+	chunk := (int(count) + workers - 1) / workers
 
 	var (
-		err error
-		m   sync.Mutex
+		wg     sync.WaitGroup
+		chunks = make([][]interface{}, workers)
+		errs   = make([]error, workers)
 	)
 
-	for i := int32(0); i < count; i, offset = i+1, offset+itemlength {
-		go func(offset uint64) {
-			if err == nil {
-				item, ierr := readItem(data, offset, version)
-				if ierr == nil {
-					m.Lock()
-					items[offset] = item
-					m.Unlock()
-				} else {
-					err = ierr
+	for w := 0; w < workers; w++ {
+		start := w * chunk
+		end := start + chunk
+		if end > int(count) {
+			end = int(count)
+		}
+
+		wg.Add(1)
+		go func(w, start, end int) {
+			defer wg.Done()
+
+			read := make([]interface{}, 0, end-start)
+			for i := start; i < end; i++ {
+				item, err := readItem(data, offset+uint64(i)*itemlength, version)
+				if err != nil {
+					errs[w] = err
+					return
 				}
+				read = append(read, item)
 			}
-			wg.Done()
-		}(offset)
+
+			chunks[w] = read
+		}(w, start, end)
 	}
 
 	wg.Wait()
 
-	if err != nil {
-		return nil, err
+	for w, read := range chunks {
+		if errs[w] != nil {
+			return nil, errs[w]
+		}
+
+		for i, item := range read {
+			items[offset+uint64(w*chunk+i)*itemlength] = item
+		}
 	}
 
 	return items, nil
 }
 
-func readInstances(data []byte, offset uint64, count int32, version int32) (map[uint64]Instance, error) {
+func readInstances(data []byte, offset uint64, count int32, version int32, workers int) (map[uint64]Instance, error) {
 	InstanceLength := Instance1Length
 	if version == 2 {
 		InstanceLength = Instance2Length
 	}
 
-	i, err := readItems(data, offset, count, InstanceLength, readInstance, version)
+	i, err := readItems(data, offset, count, InstanceLength, readInstance, version, workers)
 	if err != nil {
 		return nil, err
 	}
@@ -176,8 +222,8 @@ func readInstances(data []byte, offset uint64, count int32, version int32) (map[
 	return instances, nil
 }
 
-func readInstanceDomains(data []byte, offset uint64, count int32, version int32) (map[uint64]*InstanceDomain, error) {
-	i, err := readItems(data, offset, count, InstanceDomainLength, readInstanceDomain, version)
+func readInstanceDomains(data []byte, offset uint64, count int32, version int32, workers int) (map[uint64]*InstanceDomain, error) {
+	i, err := readItems(data, offset, count, InstanceDomainLength, readInstanceDomain, version, workers)
 	if err != nil {
 		return nil, err
 	}
@@ -190,13 +236,13 @@ func readInstanceDomains(data []byte, offset uint64, count int32, version int32)
 	return indoms, nil
 }
 
-func readMetrics(data []byte, offset uint64, count int32, version int32) (map[uint64]Metric, error) {
+func readMetrics(data []byte, offset uint64, count int32, version int32, workers int) (map[uint64]Metric, error) {
 	var MetricLength = Metric1Length
 	if version == 2 {
 		MetricLength = Metric2Length
 	}
 
-	m, err := readItems(data, offset, count, MetricLength, readMetric, version)
+	m, err := readItems(data, offset, count, MetricLength, readMetric, version, workers)
 	if err != nil {
 		return nil, err
 	}
@@ -209,8 +255,8 @@ func readMetrics(data []byte, offset uint64, count int32, version int32) (map[ui
 	return metrics, nil
 }
 
-func readValues(data []byte, offset uint64, count int32, version int32) (map[uint64]*Value, error) {
-	v, err := readItems(data, offset, count, ValueLength, readValue, version)
+func readValues(data []byte, offset uint64, count int32, version int32, workers int) (map[uint64]*Value, error) {
+	v, err := readItems(data, offset, count, ValueLength, readValue, version, workers)
 	if err != nil {
 		return nil, err
 	}
@@ -223,8 +269,8 @@ func readValues(data []byte, offset uint64, count int32, version int32) (map[uin
 	return values, nil
 }
 
-func readStrings(data []byte, offset uint64, count int32, version int32) (map[uint64]*String, error) {
-	s, err := readItems(data, offset, count, StringLength, readString, version)
+func readStrings(data []byte, offset uint64, count int32, version int32, workers int) (map[uint64]*String, error) {
+	s, err := readItems(data, offset, count, StringLength, readString, version, workers)
 	if err != nil {
 		return nil, err
 	}
@@ -237,53 +283,114 @@ func readStrings(data []byte, offset uint64, count int32, version int32) (map[ui
 	return strings, nil
 }
 
-func readComponents(data []byte, tocs []*Toc, version int32) (
+// SectionError describes a section of a mapping that could not be read
+type SectionError struct {
+	Section TocType
+	Err     error
+}
+
+func (e *SectionError) Error() string {
+	return fmt.Sprintf("cannot read %v: %v", e.Section, e.Err)
+}
+
+// DumpError lists all sections of a mapping that could not be read,
+// ordered the same way as their TOC entries
+type DumpError struct {
+	Sections []*SectionError
+}
+
+func (e *DumpError) Error() string {
+	if len(e.Sections) == 1 {
+		return e.Sections[0].Error()
+	}
+
+	msg := fmt.Sprintf("cannot read %v sections", len(e.Sections))
+	for _, s := range e.Sections {
+		msg += "; " + s.Error()
+	}
+
+	return msg
+}
+
+func readComponents(data []byte, tocs []*Toc, version int32, workers int) (
 	metrics map[uint64]Metric,
 	values map[uint64]*Value,
 	instances map[uint64]Instance,
 	indoms map[uint64]*InstanceDomain,
 	strings map[uint64]*String,
-	ierr, inerr, merr, verr, serr error,
+	err error,
 ) {
 	var wg sync.WaitGroup
-	wg.Add(len(tocs))
 
-	for _, toc := range tocs {
+	// every reader only writes its own component and its own error
+	errs := make([]error, len(tocs))
+
+	for i, toc := range tocs {
+		var read func(offset uint64, count int32) error
+
 		switch toc.Type {
 		case TocInstances:
-			go func(offset uint64, count int32) {
-				instances, ierr = readInstances(data, offset, count, version)
-				wg.Done()
-			}(toc.Offset, toc.Count)
+			read = func(offset uint64, count int32) (err error) {
+				instances, err = readInstances(data, offset, count, version, workers)
+				return
+			}
 		case TocIndoms:
-			go func(offset uint64, count int32) {
-				indoms, inerr = readInstanceDomains(data, offset, count, version)
-				wg.Done()
-			}(toc.Offset, toc.Count)
+			read = func(offset uint64, count int32) (err error) {
+				indoms, err = readInstanceDomains(data, offset, count, version, workers)
+				return
+			}
 		case TocMetrics:
-			go func(offset uint64, count int32) {
-				metrics, merr = readMetrics(data, offset, count, version)
-				wg.Done()
-			}(toc.Offset, toc.Count)
+			read = func(offset uint64, count int32) (err error) {
+				metrics, err = readMetrics(data, offset, count, version, workers)
+				return
+			}
 		case TocValues:
-			go func(offset uint64, count int32) {
-				values, verr = readValues(data, offset, count, version)
-				wg.Done()
-			}(toc.Offset, toc.Count)
+			read = func(offset uint64, count int32) (err error) {
+				values, err = readValues(data, offset, count, version, workers)
+				return
+			}
 		case TocStrings:
-			go func(offset uint64, count int32) {
-				strings, serr = readStrings(data, offset, count, version)
-				wg.Done()
-			}(toc.Offset, toc.Count)
+			read = func(offset uint64, count int32) (err error) {
+				strings, err = readStrings(data, offset, count, version, workers)
+				return
+			}
+		default:
+			errs[i] = fmt.Errorf("unknown TOC type %d", int32(toc.Type))
+			continue
 		}
+
+		wg.Add(1)
+		go func(i int, offset uint64, count int32) {
+			defer wg.Done()
+			errs[i] = read(offset, count)
+		}(i, toc.Offset, toc.Count)
 	}
 
 	wg.Wait()
 
+	var derr *DumpError
+	for i, e := range errs {
+		if e == nil {
+			continue
+		}
+
+		if derr == nil {
+			derr = &DumpError{}
+		}
+		derr.Sections = append(derr.Sections, &SectionError{tocs[i].Type, e})
+	}
+
+	if derr != nil {
+		err = derr
+	}
+
 	return
 }
 
-// Dump creates a data dump from the passed data
+// Dump creates a data dump from the passed data.
+//
+// If any of the sections of the mapping cannot be read, the returned error
+// is a *DumpError listing all of them.
 func Dump(data []byte) (
 	h *Header,
 	tocs []*Toc,
@@ -293,6 +400,24 @@ func Dump(data []byte) (
 	indoms map[uint64]*InstanceDomain,
 	strings map[uint64]*String,
 	err error,
+) {
+	return DumpParallel(data, 1)
+}
+
+// DumpParallel is Dump reading the items of every section using up to workers
+// goroutines.
+//
+// Reading an item only checks its bounds and casts a pointer, so for most mappings
+// this is faster done sequentially, like Dump does. See BenchmarkReadItems.
+func DumpParallel(data []byte, workers int) (
+	h *Header,
+	tocs []*Toc,
+	metrics map[uint64]Metric,
+	values map[uint64]*Value,
+	instances map[uint64]Instance,
+	indoms map[uint64]*InstanceDomain,
+	strings map[uint64]*String,
+	err error,
 ) {
 	h, err = readHeader(data)
 	if err != nil {
@@ -304,21 +429,9 @@ func Dump(data []byte) (
 		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
-	var ierr, inerr, merr, verr, serr error
-
-	metrics, values, instances, indoms, strings, ierr, inerr, merr, verr, serr = readComponents(data, tocs, h.Version)
-
-	switch {
-	case ierr != nil:
-		return nil, nil, nil, nil, nil, nil, nil, ierr
-	case inerr != nil:
-		return nil, nil, nil, nil, nil, nil, nil, inerr
-	case merr != nil:
-		return nil, nil, nil, nil, nil, nil, nil, merr
-	case verr != nil:
-		return nil, nil, nil, nil, nil, nil, nil, verr
-	case serr != nil:
-		return nil, nil, nil, nil, nil, nil, nil, serr
+	metrics, values, instances, indoms, strings, err = readComponents(data, tocs, h.Version, workers)
+	if err != nil {
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	return
@@ -66,3 +66,93 @@ func TestMmvDump1(t *testing.T) {
 		t.Errorf("expected number of instances %d, got %d", 0, len(instances))
 	}
 }
+
+func TestSemantics(t *testing.T) {
+	cases := []struct {
+		file     string
+		expected Semantics
+	}{
+		{"test1", CounterSemantics},
+		{"test3", InstantSemantics},
+	}
+
+	for _, c := range cases {
+		_, _, metrics, _, _, _, _, err := Dump(data("testdata/" + c.file + ".mmv"))
+		if err != nil {
+			t.Fatalf("cannot dump %v, error: %v", c.file, err)
+		}
+
+		for _, m := range metrics {
+			if m.Sem() != c.expected {
+				t.Errorf("expected semantics of the metric in %v to be %v, got %v", c.file, c.expected, m.Sem())
+			}
+		}
+	}
+}
+
+func TestDumpError(t *testing.T) {
+	d := data("testdata/test1.mmv")
+
+	// the values and strings TOCs claim more items than the file holds
+	d[HeaderLength+TocLength+4] = 100
+	d[HeaderLength+2*TocLength+4] = 100
+
+	_, _, _, _, _, _, _, err := Dump(d)
+
+	derr, ok := err.(*DumpError)
+	if !ok {
+		t.Fatalf("expected a *DumpError, got %v", err)
+	}
+
+	if len(derr.Sections) != 2 || derr.Sections[0].Section != TocValues || derr.Sections[1].Section != TocStrings {
+		t.Errorf("expected the values and strings sections to fail, got %v", derr)
+	}
+
+	// an unknown TOC type fails instead of hanging the readers
+	d = data("testdata/test1.mmv")
+	d[HeaderLength] = 42
+
+	_, _, _, _, _, _, _, err = Dump(d)
+	if derr, ok = err.(*DumpError); !ok || len(derr.Sections) != 1 {
+		t.Errorf("expected a *DumpError for an unknown TOC type, got %v", err)
+	}
+	// a negative count and one far larger than the file fail before anything is sized by them
+	for _, count := range [][]byte{{0xff, 0xff, 0xff, 0xff}, {0xff, 0xff, 0xff, 0x7f}} {
+		d = data("testdata/test1.mmv")
+		copy(d[HeaderLength+TocLength+4:], count)
+
+		_, _, _, _, _, _, _, err = Dump(d)
+		if derr, ok = err.(*DumpError); !ok || len(derr.Sections) != 1 || derr.Sections[0].Section != TocValues {
+			t.Errorf("expected a *DumpError for the values section with count %v, got %v", count, err)
+		}
+	}
+}
+
+func TestReadItemsParallel(t *testing.T) {
+	d := make([]byte, 1000*ValueLength)
+	for i := uint64(0); i < 1000; i++ {
+		d[i*ValueLength] = byte(i)
+	}
+
+	for _, p := range []int{1, 3, 8, 2000} {
+		items, err := readItems(d, 0, 1000, ValueLength, readValue, 1, p)
+		if err != nil {
+			t.Fatalf("cannot read items with parallelism %v, error: %v", p, err)
+		}
+
+		if len(items) != 1000 {
+			t.Errorf("expected 1000 items with parallelism %v, got %v", p, len(items))
+		}
+
+		for off, item := range items {
+			if v := item.(*Value); v.Val != uint64(byte(off/ValueLength)) {
+				t.Errorf("expected the item at %v to hold %v with parallelism %v, got %v", off, byte(off/ValueLength), p, v.Val)
+			}
+		}
+
+		// the last item is out of bounds
+		if _, err = readItems(d, ValueLength, 1000, ValueLength, readValue, 1, p); err == nil {
+			t.Errorf("expected reading past the data to fail with parallelism %v", p)
+		}
+	}
+}
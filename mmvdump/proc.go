@@ -0,0 +1,42 @@
+package mmvdump
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// memfdPrefix prefixes the link targets of anonymous mappings created by speed
+// clients using SetMemfd, followed by the name of the client
+const memfdPrefix = "/memfd:mmv:"
+
+// ProcMappings finds the anonymous mappings of a process, created using memfd_create,
+// by looking at its open files under /proc/<pid>/fd. It returns the paths the mappings
+// can be read at, keyed by the names of the clients that created them.
+//
+// Reading the open files of another process requires the same permissions as tracing it.
+func ProcMappings(pid int) (map[string]string, error) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid), "fd")
+
+	fds, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	mappings := make(map[string]string)
+	for _, fd := range fds {
+		path := filepath.Join(dir, fd.Name())
+
+		target, err := os.Readlink(path)
+		if err != nil || !strings.HasPrefix(target, memfdPrefix) {
+			continue
+		}
+
+		name := strings.TrimSuffix(strings.TrimPrefix(target, memfdPrefix), " (deleted)")
+		mappings[name] = path
+	}
+
+	return mappings, nil
+}
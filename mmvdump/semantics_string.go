@@ -4,13 +4,24 @@ package mmvdump
 
 import "fmt"
 
-const _Semantics_name = "NoSemantics"
+const (
+	_Semantics_name_0 = "NoSemanticsCounterSemantics"
+	_Semantics_name_1 = "InstantSemanticsDiscreteSemantics"
+)
 
-var _Semantics_index = [...]uint8{0, 11}
+var (
+	_Semantics_index_0 = [...]uint8{0, 11, 27}
+	_Semantics_index_1 = [...]uint8{0, 16, 33}
+)
 
 func (i Semantics) String() string {
-	if i < 0 || i >= Semantics(len(_Semantics_index)-1) {
+	switch {
+	case 0 <= i && i <= 1:
+		return _Semantics_name_0[_Semantics_index_0[i]:_Semantics_index_0[i+1]]
+	case 3 <= i && i <= 4:
+		i -= 3
+		return _Semantics_name_1[_Semantics_index_1[i]:_Semantics_index_1[i+1]]
+	default:
 		return fmt.Sprintf("Semantics(%d)", i)
 	}
-	return _Semantics_name[_Semantics_index[i]:_Semantics_index[i+1]]
 }
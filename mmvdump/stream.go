@@ -0,0 +1,75 @@
+package mmvdump
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Entry is a single component of a mapping, yielded by Stream
+type Entry struct {
+	Type   TocType     // the section holding the component
+	Offset uint64      // the offset of the component in the mapping
+	Item   interface{} // one of *InstanceDomain, Instance, Metric, *Value or *String
+}
+
+// ErrStop can be returned by the function passed to Stream to stop it without an error
+var ErrStop = errors.New("stop streaming")
+
+// itemReader returns the function reading items described by a toc of the passed type
+func itemReader(t TocType) itemReaderFunc {
+	switch t {
+	case TocIndoms:
+		return readInstanceDomain
+	case TocInstances:
+		return readInstance
+	case TocMetrics:
+		return readMetric
+	case TocValues:
+		return readValue
+	case TocStrings:
+		return readString
+	}
+
+	return nil
+}
+
+// Stream decodes the components of a mapping one at a time, in the order of their
+// TOC entries and then of their offsets, and passes every one of them to f.
+//
+// Unlike Dump, it does not build maps of all components, so memory use stays flat
+// for very large mappings. Items point into data, and are only valid as long as it is.
+// If f returns an error, streaming stops and Stream returns it, unless it is ErrStop.
+func Stream(data []byte, f func(Entry) error) error {
+	h, err := readHeader(data)
+	if err != nil {
+		return err
+	}
+
+	tocs, err := readTocs(data, h.Toc)
+	if err != nil {
+		return err
+	}
+
+	for _, toc := range tocs {
+		read, l := itemReader(toc.Type), itemLength(toc.Type, h.Version)
+		if read == nil {
+			return fmt.Errorf("unknown TOC type %d", int32(toc.Type))
+		}
+
+		for i, off := int32(0), toc.Offset; i < toc.Count; i, off = i+1, off+l {
+			item, err := read(data, off, h.Version)
+			if err != nil {
+				return &SectionError{toc.Type, err}
+			}
+
+			if err = f(Entry{toc.Type, off, item}); err != nil {
+				if err == ErrStop {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+
+	return nil
+}
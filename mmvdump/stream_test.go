@@ -0,0 +1,84 @@
+package mmvdump
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	for _, file := range []string{"test1", "test2", "test3", "test4"} {
+		d := data("testdata/" + file + ".mmv")
+
+		_, _, metrics, values, instances, indoms, strings, err := Dump(d)
+		if err != nil {
+			t.Fatalf("cannot dump %v, error: %v", file, err)
+		}
+
+		counts := make(map[TocType]int)
+		err = Stream(d, func(e Entry) error {
+			counts[e.Type]++
+
+			var ok bool
+			switch e.Type {
+			case TocMetrics:
+				ok = metrics[e.Offset] == e.Item.(Metric)
+			case TocValues:
+				ok = values[e.Offset] == e.Item.(*Value)
+			case TocInstances:
+				ok = instances[e.Offset] == e.Item.(Instance)
+			case TocIndoms:
+				ok = indoms[e.Offset] == e.Item.(*InstanceDomain)
+			case TocStrings:
+				ok = strings[e.Offset] == e.Item.(*String)
+			}
+
+			if !ok {
+				t.Errorf("expected the %v entry at %v in %v to match Dump", e.Type, e.Offset, file)
+			}
+
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("cannot stream %v, error: %v", file, err)
+		}
+
+		expected := map[TocType]int{
+			TocMetrics:   len(metrics),
+			TocValues:    len(values),
+			TocInstances: len(instances),
+			TocIndoms:    len(indoms),
+			TocStrings:   len(strings),
+		}
+
+		for typ, n := range expected {
+			if counts[typ] != n {
+				t.Errorf("expected %v %v entries in %v, got %v", n, typ, file, counts[typ])
+			}
+		}
+	}
+}
+
+func TestStreamStop(t *testing.T) {
+	d := data("testdata/test1.mmv")
+
+	n := 0
+	err := Stream(d, func(e Entry) error {
+		n++
+		return ErrStop
+	})
+	if err != nil || n != 1 {
+		t.Errorf("expected ErrStop to stop streaming after 1 entry without an error, got %v entries and %v", n, err)
+	}
+
+	failure := errors.New("failure")
+	if err = Stream(d, func(e Entry) error { return failure }); err != failure {
+		t.Errorf("expected the error returned by f, got %v", err)
+	}
+
+	// the values TOC claims more items than the file holds
+	d[HeaderLength+TocLength+4] = 100
+	err = Stream(d, func(e Entry) error { return nil })
+	if serr, ok := err.(*SectionError); !ok || serr.Section != TocValues {
+		t.Errorf("expected a *SectionError for the values, got %v", err)
+	}
+}
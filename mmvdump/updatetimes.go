@@ -0,0 +1,82 @@
+package mmvdump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// UpdateTimesSuffix is appended to the name of the file speed clients record the times the
+// values of a mapping were last updated at in, which is stored next to the mapping with
+// a leading dot in its name, so PCP skips it.
+//
+// The file starts with a 16 byte header holding the magic "MMVU", the version 1 as an
+// int32 and the generation of the mapping the times are of, as an int64, followed by
+// an int64 for every value in the values section of the mapping, in the order they are
+// laid out, holding the time of the last update to the value in nanoseconds since the
+// epoch, or 0 if it was not updated since the mapping was written. All numbers are
+// little endian.
+const UpdateTimesSuffix = ".lastupdate"
+
+// UpdateTimesHeaderLength is the length of the header of an update times file
+const UpdateTimesHeaderLength = 16
+
+// UpdateTimesLocation returns the location of the update times file of the mapping at path
+func UpdateTimesLocation(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+UpdateTimesSuffix)
+}
+
+// UpdateTimes reads the times the values of a mapping were last updated at from the
+// contents of its update times file, by the offsets of the values. Values that were
+// not updated since the mapping was written get the zero time.
+//
+// It fails if the times are of another generation of the mapping, as they are when
+// the mapping was rewritten after they were read.
+func UpdateTimes(data, times []byte) (map[uint64]time.Time, error) {
+	h, err := readHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	tocs, err := readTocs(data, h.Toc)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(times) < UpdateTimesHeaderLength || !bytes.Equal(times[:4], []byte("MMVU")) {
+		return nil, errors.New("not an update times file")
+	}
+
+	if v := int32(binary.LittleEndian.Uint32(times[4:])); v != 1 {
+		return nil, fmt.Errorf("unsupported update times version %v", v)
+	}
+
+	if gen := int64(binary.LittleEndian.Uint64(times[8:])); gen != int64(h.G1) {
+		return nil, fmt.Errorf("update times are of generation %v, but the mapping is of generation %v", gen, h.G1)
+	}
+
+	ans := make(map[uint64]time.Time)
+	for _, toc := range tocs {
+		if toc.Type != TocValues {
+			continue
+		}
+
+		for i := uint64(0); i < uint64(toc.Count); i++ {
+			pos := UpdateTimesHeaderLength + 8*i
+			if pos+8 > uint64(len(times)) {
+				return nil, fmt.Errorf("update times end before the time of value %v", i)
+			}
+
+			var t time.Time
+			if ns := int64(binary.LittleEndian.Uint64(times[pos:])); ns != 0 {
+				t = time.Unix(0, ns)
+			}
+			ans[toc.Offset+i*ValueLength] = t
+		}
+	}
+
+	return ans, nil
+}
@@ -0,0 +1,52 @@
+package mmvdump
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUpdateTimes(t *testing.T) {
+	d := data("testdata/test1.mmv")
+
+	h, tocs, _, _, _, _, _, err := Dump(d)
+	if err != nil {
+		t.Fatalf("cannot dump test1, error: %v", err)
+	}
+
+	var values *Toc
+	for _, toc := range tocs {
+		if toc.Type == TocValues {
+			values = toc
+		}
+	}
+
+	times := make([]byte, UpdateTimesHeaderLength+8*int(values.Count))
+	copy(times, "MMVU")
+	binary.LittleEndian.PutUint32(times[4:], 1)
+	binary.LittleEndian.PutUint64(times[8:], h.G1)
+	binary.LittleEndian.PutUint64(times[UpdateTimesHeaderLength:], uint64(time.Unix(5, 0).UnixNano()))
+
+	updated, err := UpdateTimes(d, times)
+	if err != nil {
+		t.Fatalf("cannot read update times, error: %v", err)
+	}
+
+	if len(updated) != int(values.Count) || !updated[values.Offset].Equal(time.Unix(5, 0)) {
+		t.Errorf("expected the first value to be updated at 5, got %v", updated)
+	}
+
+	binary.LittleEndian.PutUint64(times[8:], h.G1+1)
+	if _, err = UpdateTimes(d, times); err == nil {
+		t.Errorf("expected update times of another generation to fail")
+	}
+
+	if _, err = UpdateTimes(d, []byte("MMV")); err == nil {
+		t.Errorf("expected a short file to fail")
+	}
+
+	if l := UpdateTimesLocation(filepath.Join("mmv", "app")); l != filepath.Join("mmv", ".app.lastupdate") {
+		t.Errorf("unexpected location %v", l)
+	}
+}
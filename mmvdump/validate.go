@@ -0,0 +1,153 @@
+package mmvdump
+
+import "fmt"
+
+// region is a byte range [start, end) in a mapping holding a single kind of component
+type region struct {
+	name       string
+	start, end uint64
+}
+
+func (r region) overlaps(o region) bool {
+	return r.start < o.end && o.start < r.end
+}
+
+// itemLength returns the length of a single item described by a toc of the passed type
+func itemLength(t TocType, version int32) uint64 {
+	switch t {
+	case TocIndoms:
+		return InstanceDomainLength
+	case TocInstances:
+		if version == 2 {
+			return Instance2Length
+		}
+		return Instance1Length
+	case TocMetrics:
+		if version == 2 {
+			return Metric2Length
+		}
+		return Metric1Length
+	case TocValues:
+		return ValueLength
+	case TocStrings:
+		return StringLength
+	}
+
+	return 0
+}
+
+// Validate checks the structure of a mapping beyond what is needed to read it, returning
+// all problems found. It detects TOC regions that overlap each other or the header,
+// negative TOC counts, values pointing at offsets that are not metrics or instances,
+// values of instance metrics without an instance, and string offsets pointing outside
+// the strings region.
+//
+// If the data cannot be read using Dump, the error returned by Dump is the only problem reported.
+func Validate(data []byte) []error {
+	h, tocs, metrics, values, instances, indoms, strings, err := Dump(data)
+	if err != nil {
+		return []error{err}
+	}
+
+	var problems []error
+
+	regions := []region{{"header", 0, HeaderLength + uint64(len(tocs))*TocLength}}
+	for i, toc := range tocs {
+		// Dump already fails on negative counts, but the conversion below must
+		// not wrap one into a huge region if that ever changes
+		if toc.Count < 0 {
+			problems = append(problems, fmt.Errorf("TOC[%v] has a negative count %v", i, toc.Count))
+			continue
+		}
+
+		if toc.Count == 0 {
+			continue
+		}
+
+		l := itemLength(toc.Type, h.Version)
+		if l == 0 {
+			problems = append(problems, fmt.Errorf("TOC[%v] has an unknown type %d", i, int32(toc.Type)))
+			continue
+		}
+
+		regions = append(regions, region{
+			fmt.Sprintf("TOC[%v] (%v)", i, toc.Type),
+			toc.Offset,
+			toc.Offset + uint64(toc.Count)*l,
+		})
+	}
+
+	for i := range regions {
+		for j := i + 1; j < len(regions); j++ {
+			if regions[i].overlaps(regions[j]) {
+				problems = append(problems, fmt.Errorf(
+					"%v region [%v, %v) overlaps %v region [%v, %v)",
+					regions[j].name, regions[j].start, regions[j].end,
+					regions[i].name, regions[i].start, regions[i].end,
+				))
+			}
+		}
+	}
+
+	checkString := func(off uint64, what string) {
+		if off == 0 {
+			return
+		}
+
+		if _, ok := strings[off]; !ok {
+			problems = append(problems, fmt.Errorf("%v points at offset %v, which is not a string", what, off))
+		}
+	}
+
+	checkValue := func(off uint64) {
+		v := values[off]
+
+		m, ok := metrics[v.Metric]
+		if !ok {
+			problems = append(problems, fmt.Errorf("value at %v points at offset %v, which is not a metric", off, v.Metric))
+			return
+		}
+
+		if m.Typ() == StringType {
+			checkString(uint64(v.Extra), fmt.Sprintf("string value at %v", off))
+		}
+
+		if m.Indom() != NoIndom {
+			if v.Instance == 0 {
+				problems = append(problems, fmt.Errorf("value at %v of an instance metric has no instance", off))
+			} else if _, ok := instances[v.Instance]; !ok {
+				problems = append(problems, fmt.Errorf("value at %v points at offset %v, which is not an instance", off, v.Instance))
+			}
+		}
+	}
+
+	// components are checked in the order they are laid out in the tocs,
+	// so problems are reported in a deterministic order
+	for _, toc := range tocs {
+		l := itemLength(toc.Type, h.Version)
+
+		for i, off := int32(0), toc.Offset; i < toc.Count; i, off = i+1, off+l {
+			switch toc.Type {
+			case TocIndoms:
+				indom := indoms[off]
+				checkString(indom.Shorttext, fmt.Sprintf("shorttext of indom at %v", off))
+				checkString(indom.Longtext, fmt.Sprintf("longtext of indom at %v", off))
+			case TocInstances:
+				if ins, ok := instances[off].(*Instance2); ok {
+					checkString(ins.External, fmt.Sprintf("name of instance at %v", off))
+				}
+			case TocMetrics:
+				m := metrics[off]
+				if m2, ok := m.(*Metric2); ok {
+					checkString(m2.Name, fmt.Sprintf("name of metric at %v", off))
+				}
+				checkString(m.ShortText(), fmt.Sprintf("shorttext of metric at %v", off))
+				checkString(m.LongText(), fmt.Sprintf("longtext of metric at %v", off))
+			case TocValues:
+				checkValue(off)
+			}
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,60 @@
+package mmvdump
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	for _, f := range []string{"test1", "test2", "test3", "test4"} {
+		if problems := Validate(data("testdata/" + f + ".mmv")); len(problems) != 0 {
+			t.Errorf("expected %v to be valid, got %v", f, problems)
+		}
+	}
+
+	cases := []struct {
+		file     string
+		offset   int
+		val      uint64
+		expected string
+	}{
+		// metrics toc offset moved into the header
+		{"test1", 48, 40, "overlaps header region"},
+		// metric of the value moved to the value itself
+		{"test1", 208, 192, "value at 192 points at offset 192, which is not a metric"},
+		// shorttext of the metric moved into the metric
+		{"test1", 176, 96, "shorttext of metric at 88 points at offset 96, which is not a string"},
+		// instance of a value moved into another instance
+		{"test2", 504, 140, "value at 480 points at offset 140, which is not an instance"},
+		// instance of a value cleared
+		{"test2", 504, 0, "value at 480 of an instance metric has no instance"},
+	}
+
+	for _, c := range cases {
+		d := data("testdata/" + c.file + ".mmv")
+		binary.LittleEndian.PutUint64(d[c.offset:], c.val)
+
+		problems, found := Validate(d), false
+		for _, p := range problems {
+			if strings.Contains(p.Error(), c.expected) {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("expected problem %q in %v, got %v", c.expected, c.file, problems)
+		}
+	}
+
+	// count of the first toc made negative
+	d := data("testdata/test1.mmv")
+	binary.LittleEndian.PutUint32(d[44:], 0xffffffff)
+	if problems := Validate(d); len(problems) == 0 || !strings.Contains(problems[0].Error(), "negative") {
+		t.Errorf("expected a negative count to be reported, got %v", problems)
+	}
+
+	if problems := Validate([]byte("MMV")); len(problems) != 1 {
+		t.Errorf("expected a single problem for unreadable data, got %v", problems)
+	}
+}
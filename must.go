@@ -0,0 +1,89 @@
+package speed
+
+import "fmt"
+
+// OpError is returned by operations on metrics and instance domains, and by
+// registering them with a client, naming the failed operation, what it was done on
+// and the client it was done with, so failures can be attributed in applications
+// publishing many metrics.
+//
+// Errors that are meant to be compared, like ErrClientStarted, and errors
+// of their own type, like *MappingError and *CardinalityError, are not wrapped.
+type OpError struct {
+	Op     string // the failed operation, like "set" or "register"
+	Name   string // name of the metric, instance or instance domain the operation was done on
+	Client string // name of the client the metric is registered with, if it is
+	Err    error  // the underlying error
+}
+
+func (e *OpError) Error() string {
+	return describeFailure(e.Op, e.Name, e.Client, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *OpError) Unwrap() error { return e.Err }
+
+// PanicError is the value the Must functions and methods panic with,
+// naming the failed operation, what it was done on and the client it was done with.
+type PanicError struct {
+	Op     string // the failed operation, like "set" or "register"
+	Name   string // name of the metric, instance or instance domain the operation was done on
+	Client string // name of the client, if known
+	Err    error  // the error returned by the operation
+}
+
+func (e *PanicError) Error() string {
+	switch e.Err.(type) {
+	case *CardinalityError, *ConversionError:
+		// the error already names the operation and the metric
+		return fmt.Sprintf("speed: %v", e.Err)
+	}
+
+	if e.Op == "" {
+		return fmt.Sprintf("speed: %v", e.Err)
+	}
+	return "speed: " + describeFailure(e.Op, e.Name, e.Client, e.Err)
+}
+
+// Unwrap returns the error returned by the operation
+func (e *PanicError) Unwrap() error { return e.Err }
+
+// describeFailure returns the message of a failed operation
+func describeFailure(op, name, client string, err error) string {
+	target := name
+	switch {
+	case client == "":
+	case name == "":
+		target = "client " + client
+	default:
+		target += " of client " + client
+	}
+	if target == "" {
+		return fmt.Sprintf("cannot %v: %v", op, err)
+	}
+	return fmt.Sprintf("cannot %v %v: %v", op, target, err)
+}
+
+// must panics with a PanicError if err is not nil. If err is an *OpError,
+// the panic names the operation and the target of the error instead.
+func must(op, name, client string, err error) {
+	if err == nil {
+		return
+	}
+
+	switch e := err.(type) {
+	case *OpError:
+		panic(&PanicError{e.Op, e.Name, e.Client, e.Err})
+	case *CardinalityError:
+		panic(&PanicError{"add instance", instanceName(e.Metric, e.Instance), e.Client, e})
+	case *ConversionError:
+		panic(&PanicError{"set", e.Metric, e.Client, e})
+	}
+
+	panic(&PanicError{op, name, client, err})
+}
+
+// instanceName returns the name of an instance of a metric used in errors
+func instanceName(metric, instance string) string {
+	return metric + "[" + instance + "]"
+}
@@ -0,0 +1,26 @@
+//go:build go1.18
+// +build go1.18
+
+package speed
+
+// Must returns v, and panics with a *PanicError if err is not nil.
+// It replaces the Must variants of constructors and methods, like
+//
+//	counter := speed.Must(speed.NewPCPCounter(0, "requests"))
+func Must[T any](v T, err error) T {
+	if err != nil {
+		if _, ok := err.(*PanicError); ok {
+			panic(err)
+		}
+		must("", "", "", err)
+	}
+	return v
+}
+
+// MustDo panics with a *PanicError if err is not nil, for operations that only return
+// an error, like
+//
+//	speed.MustDo(client.Register(counter))
+func MustDo(err error) {
+	Must(struct{}{}, err)
+}
@@ -0,0 +1,28 @@
+//go:build go1.18
+// +build go1.18
+
+package speed
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMust(t *testing.T) {
+	c := Must(NewPCPCounter(0, "requests"))
+	if c.Name() != "requests" {
+		t.Errorf("expected the counter to be returned, got %v", c.Name())
+	}
+
+	perr := recoverPanicError(t, func() { Must(NewPCPCounter(0, "")) })
+	if perr.Err == nil {
+		t.Errorf("expected the error to be wrapped")
+	}
+
+	cause := &PanicError{"set", "m", "", errors.New("cause")}
+	if perr = recoverPanicError(t, func() { MustDo(cause) }); perr != cause {
+		t.Errorf("expected a *PanicError to be passed through, got %v", perr)
+	}
+
+	MustDo(nil)
+}
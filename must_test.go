@@ -0,0 +1,144 @@
+package speed
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// recoverPanicError runs f and returns the *PanicError it panics with
+func recoverPanicError(t *testing.T, f func()) (perr *PanicError) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatalf("expected a panic")
+		}
+
+		var ok bool
+		if perr, ok = r.(*PanicError); !ok {
+			t.Fatalf("expected a *PanicError, got %T: %v", r, r)
+		}
+	}()
+
+	f()
+	return nil
+}
+
+func TestMustPanics(t *testing.T) {
+	c, err := NewPCPCounter(0, "requests")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	perr := recoverPanicError(t, func() { c.MustInc(-1) })
+	if perr.Op != "increment" || perr.Name != "requests" || perr.Err == nil {
+		t.Errorf("expected a failed increment of requests, got %+v", perr)
+	}
+
+	cv, err := NewPCPCounterVector(map[string]int64{"a": 1}, "conns")
+	if err != nil {
+		t.Fatalf("cannot create CounterVector, error: %v", err)
+	}
+
+	perr = recoverPanicError(t, func() { cv.MustInc(1, "b") })
+	if perr.Name != "conns[b]" {
+		t.Errorf("expected the instance to be named in the panic, got %v", perr.Name)
+	}
+
+	client, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	client.MustRegister(c)
+
+	perr = recoverPanicError(t, func() { client.MustRegister(c) })
+	if perr.Op != "register" || perr.Name != "requests" {
+		t.Errorf("expected a failed register of requests, got %+v", perr)
+	}
+
+	if perr.Client != "test" {
+		t.Errorf("expected the client to be named in the panic, got %v", perr.Client)
+	}
+
+	if msg := perr.Error(); msg != "speed: cannot register requests of client test: "+perr.Err.Error() {
+		t.Errorf("unexpected message %v", msg)
+	}
+
+	perr = recoverPanicError(t, func() { c.MustInc(-1) })
+	if perr.Client != "test" {
+		t.Errorf("expected a registered metric to name its client in the panic, got %+v", perr)
+	}
+}
+
+func TestErrorsNameOperation(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	g, err := NewPCPGauge(0, "temperature")
+	if err != nil {
+		t.Fatalf("cannot create gauge, error: %v", err)
+	}
+
+	cv, err := NewPCPCounterVector(map[string]int64{"a": 1}, "conns")
+	if err != nil {
+		t.Fatalf("cannot create CounterVector, error: %v", err)
+	}
+
+	c.MustRegister(g)
+	c.MustRegister(cv)
+
+	_, err = NewPCPSingletonMetric("hot", "weather", Int32Type, InstantSemantics, OneUnit)
+	cases := []struct {
+		op        string
+		err       error
+		opname    string
+		name      string
+		client    string
+		substring string
+	}{
+		{"create", err, "create metric", "weather", "", "incompatible with type"},
+		{"register", c.Register(g), "register", "temperature", "test", "already registered"},
+		{"set", g.pcpSingletonMetric.set("hot"), "set", "temperature", "test", "incompatible with type"},
+		{"set vector", cv.Set(0, "a"), "set", "conns[a]", "test", "cannot go backwards"},
+		{"increment vector", cv.Inc(1, "b"), "increment", "conns[b]", "test", "not an instance"},
+		{"increment vector", cv.Inc(-1, "a"), "increment", "conns[a]", "test", "negative"},
+	}
+
+	for _, cs := range cases {
+		oe, ok := cs.err.(*OpError)
+		if !ok {
+			t.Errorf("%v: expected an *OpError, got %T: %v", cs.op, cs.err, cs.err)
+			continue
+		}
+
+		if oe.Op != cs.opname || oe.Name != cs.name || oe.Client != cs.client {
+			t.Errorf("%v: expected %v of %v by client %q, got %+v", cs.op, cs.opname, cs.name, cs.client, oe)
+		}
+
+		if msg := oe.Error(); !strings.Contains(msg, cs.name) || !strings.Contains(msg, cs.substring) {
+			t.Errorf("%v: expected the message to name %v and contain %q, got %v", cs.op, cs.name, cs.substring, msg)
+		}
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if err = c.SetFlag(NoPrefixFlag); err != ErrClientStarted {
+		t.Errorf("expected ErrClientStarted to not be wrapped, got %v", err)
+	}
+}
+
+func TestPanicErrorUnwrap(t *testing.T) {
+	cause := errors.New("cause")
+	perr := &PanicError{"set", "m", "", cause}
+
+	if perr.Unwrap() != cause {
+		t.Errorf("expected Unwrap to return the cause")
+	}
+
+	if msg := (&PanicError{Err: cause}).Error(); msg != "speed: cause" {
+		t.Errorf("expected speed: cause, got %v", msg)
+	}
+}
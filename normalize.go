@@ -0,0 +1,120 @@
+package speed
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// InstanceNormalizer maps an instance name to the name it is published under.
+// Normalizers should be idempotent, so normalizing a normalized name does not change it.
+type InstanceNormalizer func(string) string
+
+// LowercaseInstance is an InstanceNormalizer lowercasing instance names.
+func LowercaseInstance(name string) string { return strings.ToLower(name) }
+
+// TruncateInstance returns an InstanceNormalizer truncating instance names to n bytes,
+// without splitting a multi-byte character.
+func TruncateInstance(n int) InstanceNormalizer {
+	return func(name string) string {
+		if len(name) <= n {
+			return name
+		}
+
+		for n > 0 && !utf8.RuneStart(name[n]) {
+			n--
+		}
+
+		return name[:n]
+	}
+}
+
+// ReplaceInstanceChars returns an InstanceNormalizer replacing all characters
+// of instance names found in chars by with.
+func ReplaceInstanceChars(chars string, with rune) InstanceNormalizer {
+	return func(name string) string {
+		return strings.Map(func(r rune) rune {
+			if strings.ContainsRune(chars, r) {
+				return with
+			}
+			return r
+		}, name)
+	}
+}
+
+// ChainInstanceNormalizers returns an InstanceNormalizer applying all passed normalizers in order.
+func ChainInstanceNormalizers(normalizers ...InstanceNormalizer) InstanceNormalizer {
+	return func(name string) string {
+		for _, f := range normalizers {
+			name = f(name)
+		}
+		return name
+	}
+}
+
+// DefaultInstanceNormalizer truncates instance names to the longest name a mapping can hold.
+var DefaultInstanceNormalizer = TruncateInstance(StringLength - 1)
+
+// normalized returns the name an instance is published under
+func (m *pcpInstanceMetric) normalized(instance string) string {
+	if m.normalize == nil {
+		return instance
+	}
+	return m.normalize(instance)
+}
+
+// setNormalizer normalizes the names of all instances of the metric using f, and the
+// instances passed to all later updates. It fails if two instances have the same name
+// after normalization, or the metric is bound to a mapping.
+func (m *pcpInstanceMetric) setNormalizer(f InstanceNormalizer) error {
+	if f == nil {
+		return m.errorf("set instance normalizer of", "", "instance normalizer cannot be nil")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.indom.mutex.Lock()
+	defer m.indom.mutex.Unlock()
+
+	vals := make(map[string]*instanceValue, len(m.vals))
+	instances := make(map[string]*pcpInstance, len(m.vals))
+
+	for name, v := range m.vals {
+		if v.update != nil {
+			return m.errorf("set instance normalizer of", "", "cannot rename the instances while the client is started")
+		}
+
+		n := f(name)
+		if n == "" || len(n) > StringLength {
+			return m.errorf("set instance normalizer of", "", "instance %v is normalized to the invalid name %q", name, n)
+		}
+
+		if _, ok := vals[n]; ok {
+			return m.errorf("set instance normalizer of", "", "more than one instance is normalized to %v", n)
+		}
+
+		vals[n] = v
+		if m.indom.hasInstance(name) {
+			instances[n] = newpcpInstance(n)
+		}
+	}
+
+	m.vals, m.indom.instances, m.normalize = vals, instances, f
+	return nil
+}
+
+// SetInstanceNormalizer normalizes the names of all instances of the vector, and of the
+// instances passed to all later calls, using f. It must be called before the client
+// the vector is registered with is started, and fails if two instances have the same
+// name after normalization.
+func (c *PCPCounterVector) SetInstanceNormalizer(f InstanceNormalizer) error {
+	return c.setNormalizer(f)
+}
+
+// SetInstanceNormalizer normalizes the names of all instances of the vector, and of the
+// instances passed to all later calls, using f. It must be called before the client
+// the vector is registered with is started, and fails if two instances have the same
+// name after normalization.
+func (g *PCPGaugeVector) SetInstanceNormalizer(f InstanceNormalizer) error {
+	return g.setNormalizer(f)
+}
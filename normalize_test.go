@@ -0,0 +1,90 @@
+package speed
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestInstanceNormalizers(t *testing.T) {
+	cases := []struct {
+		f        InstanceNormalizer
+		in, want string
+	}{
+		{LowercaseInstance, "Tenant-A", "tenant-a"},
+		{TruncateInstance(4), "tenant", "tena"},
+		{TruncateInstance(4), "ten", "ten"},
+		{TruncateInstance(4), "aéé", "aé"},
+		{ReplaceInstanceChars(" /", '_'), "a b/c", "a_b_c"},
+		{ChainInstanceNormalizers(LowercaseInstance, TruncateInstance(3)), "ABCD", "abc"},
+		{DefaultInstanceNormalizer, strings.Repeat("x", 300), strings.Repeat("x", StringLength-1)},
+	}
+
+	for _, c := range cases {
+		if got := c.f(c.in); got != c.want {
+			t.Errorf("expected %q to be normalized to %q, got %q", c.in, c.want, got)
+		}
+	}
+}
+
+func TestVectorInstanceNormalizer(t *testing.T) {
+	cv, err := NewPCPCounterVector(map[string]int64{"Host A": 1, "host-b": 2}, "hosts.requests")
+	if err != nil {
+		t.Fatalf("cannot create CounterVector, error: %v", err)
+	}
+
+	if err = cv.SetInstanceNormalizer(nil); err == nil {
+		t.Errorf("expected an error setting a nil normalizer")
+	}
+
+	lower := ChainInstanceNormalizers(LowercaseInstance, ReplaceInstanceChars(" ", '-'))
+	if err = cv.SetInstanceNormalizer(lower); err != nil {
+		t.Fatalf("cannot set normalizer, error: %v", err)
+	}
+
+	if v, err := cv.Val("HOST A"); err != nil || v != 1 {
+		t.Errorf("expected HOST A to be normalized to host-a with 1, got %v, error: %v", v, err)
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	c.MustRegister(cv, WithMaxInstances(10, RejectInstances))
+
+	cv.MustInc(1, "Host A")
+	cv.MustInc(1, "Host C")
+
+	c.MustStart()
+	defer c.MustStop()
+
+	for ins, expected := range map[string]int64{"host-a": 2, "host-b": 2, "host-c": 1} {
+		if v, err := mmvdump.Lookup(c.writer.Bytes(), "hosts.requests["+ins+"]"); err != nil || v != expected {
+			t.Errorf("expected hosts.requests[%v] to be %v, got %v, error: %v", ins, expected, v, err)
+		}
+	}
+
+	if err = cv.SetInstanceNormalizer(LowercaseInstance); err == nil {
+		t.Errorf("expected an error setting a normalizer while the client is started")
+	}
+
+	if err = cv.Delete("HOST-B"); err != nil {
+		t.Errorf("expected HOST-B to be normalized when deleting, got %v", err)
+	}
+}
+
+func TestVectorInstanceNormalizerDuplicates(t *testing.T) {
+	g, err := NewPCPGaugeVector(map[string]float64{"a": 1, "A": 2}, "dup")
+	if err != nil {
+		t.Fatalf("cannot create GaugeVector, error: %v", err)
+	}
+
+	if err = g.SetInstanceNormalizer(LowercaseInstance); err == nil {
+		t.Errorf("expected an error normalizing two instances to the same name")
+	}
+
+	if v, err := g.Val("A"); err != nil || v != 2 {
+		t.Errorf("expected the instances to be unchanged after a failure, got %v, error: %v", v, err)
+	}
+}
@@ -0,0 +1,111 @@
+package speed
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// No value
+//
+// A numeric singleton metric can be marked as having no value, using Clear or
+// by registering it using WithNoValue, to tell a metric that was never reported
+// apart from one that was reported as zero. While it has no value, the mapping holds
+// a sentinel value of its type, which PCP reports as no value if the mapping has
+// SentinelFlag set. The next update gives the metric a value again.
+//
+// The sentinels are the smallest value of signed integer types, the largest value
+// of unsigned integer types, and NaN for floating point types.
+
+// sentinel returns the value written to a mapping for a metric of the type without a value.
+func (m MetricType) sentinel() interface{} {
+	switch m {
+	case Int32Type:
+		return int32(math.MinInt32)
+	case Uint32Type:
+		return uint32(math.MaxUint32)
+	case Int64Type:
+		return int64(math.MinInt64)
+	case Uint64Type:
+		return uint64(math.MaxUint64)
+	case FloatType:
+		return float32(math.NaN())
+	case DoubleType:
+		return math.NaN()
+	}
+	return ""
+}
+
+// published returns the value written to a mapping for the metric,
+// the mutex must be held at least for reading.
+func (m *pcpSingletonMetric) published() interface{} {
+	if m.cleared {
+		return m.t.sentinel()
+	}
+	return m.value()
+}
+
+// Clear marks the metric as having no value, until its value is next set or updated.
+// Until then, its value is the zero value of its type, and the mapping holds a sentinel.
+// It fails for string metrics, which have no sentinel.
+func (m *pcpSingletonMetric) Clear() error {
+	if !m.t.isNumeric() {
+		return m.errorf("clear", "", "only numeric metrics can have no value, not %v", m.t)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.enabled() {
+		return nil
+	}
+
+	s := m.t.sentinel()
+
+	if m.word != nil {
+		atomic.StoreUint64(m.word, m.t.bits(s))
+		m.wrote()
+	} else if m.update != nil {
+		if err := m.update(s); err != nil {
+			return m.opError("write", "", err)
+		}
+	}
+
+	m.val, m.cleared = m.t.zero(), true
+	return nil
+}
+
+// MustClear is Clear that panics on failure.
+func (m *pcpSingletonMetric) MustClear() {
+	must("clear", m.name, m.client, m.Clear())
+}
+
+// HasValue returns false if the metric has no value, see Clear.
+func (m *pcpSingletonMetric) HasValue() bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return !m.cleared
+}
+
+// WithNoValue registers a numeric singleton metric without a value, which it has
+// until its value is first set or updated, and sets SentinelFlag on the client,
+// so PCP reports no value for it instead of its initial value.
+//
+// It has no effect on other metrics.
+func WithNoValue() RegisterOption {
+	return func(c *PCPClient, m Metric) error {
+		sm, ok := m.(singletonMetric)
+		if !ok || !sm.singleton().t.isNumeric() {
+			return nil
+		}
+
+		if err := sm.singleton().Clear(); err != nil {
+			return err
+		}
+
+		c.mutex.Lock()
+		c.flag |= SentinelFlag
+		c.mutex.Unlock()
+		return nil
+	}
+}
@@ -0,0 +1,86 @@
+package speed
+
+import (
+	"math"
+	"testing"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestClear(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	counter, err := NewPCPCounter(5, "novalue.counter")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+	c.MustRegister(counter)
+
+	gauge, err := NewPCPGauge(1.5, "novalue.gauge")
+	if err != nil {
+		t.Fatalf("cannot create gauge, error: %v", err)
+	}
+	c.MustRegister(gauge, WithNoValue())
+
+	if c.flag&SentinelFlag == 0 {
+		t.Errorf("expected WithNoValue to set the sentinel flag")
+	}
+
+	if gauge.HasValue() || gauge.Val() != 0 {
+		t.Errorf("expected the gauge to have no value, got %v", gauge.Val())
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "novalue.gauge"); err != nil || !math.IsNaN(v.(float64)) {
+		t.Errorf("expected the gauge to be written as NaN, got %v, error: %v", v, err)
+	}
+
+	counter.MustClear()
+	if counter.HasValue() {
+		t.Errorf("expected the counter to have no value after Clear")
+	}
+
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "novalue.counter"); err != nil || v != int64(math.MinInt64) {
+		t.Errorf("expected the counter to be written as the sentinel, got %v, error: %v", v, err)
+	}
+
+	counter.Up()
+	gauge.MustInc(2)
+
+	if !counter.HasValue() || counter.Val() != 1 || !gauge.HasValue() || gauge.Val() != 2 {
+		t.Errorf("expected updates to start from zero after Clear, got %v and %v", counter.Val(), gauge.Val())
+	}
+
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "novalue.counter"); err != nil || v != int64(1) {
+		t.Errorf("expected the counter to be written as 1, got %v, error: %v", v, err)
+	}
+
+	s, err := NewPCPSingletonMetric("a", "novalue.string", StringType, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	if err = s.Clear(); err == nil {
+		t.Errorf("expected clearing a string metric to fail")
+	}
+}
+
+func TestClearUnbound(t *testing.T) {
+	m, err := NewPCPSingletonMetric(int32(3), "novalue.metric", Int32Type, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	m.MustClear()
+
+	// setting the zero value it holds without a value still gives the metric a value
+	m.MustSet(int32(0))
+	if !m.HasValue() || m.Val() != int32(0) {
+		t.Errorf("expected the metric to have the value 0, got %v", m.Val())
+	}
+}
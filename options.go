@@ -0,0 +1,115 @@
+package speed
+
+import "fmt"
+
+// MetricOption configures a metric created by NewSingletonMetric or NewInstanceMetric,
+// replacing the positional type, semantics, unit and descriptions of the older constructors.
+//
+// Their constructors are named after what they set, like Unit, unlike the RegisterOptions
+// configuring how a client publishes a metric, like WithRate.
+type MetricOption func(*metricOptions)
+
+// metricOptions are the properties of a metric set by MetricOptions
+type metricOptions struct {
+	t    MetricType
+	s    MetricSemantics
+	u    MetricUnit
+	desc []string
+
+	typed bool // whether the type was set, instead of inferred from the values
+}
+
+// OfType sets the type of a metric, which is otherwise inferred from its initial
+// value, with int and uint values inferred as 64 bit types.
+func OfType(t MetricType) MetricOption {
+	return func(o *metricOptions) { o.t, o.typed = t, true }
+}
+
+// Semantics sets the semantics of a metric, which are InstantSemantics by default.
+func Semantics(s MetricSemantics) MetricOption {
+	return func(o *metricOptions) { o.s = s }
+}
+
+// Unit sets the unit of a metric, which is OneUnit by default.
+func Unit(u MetricUnit) MetricOption {
+	return func(o *metricOptions) { o.u = u }
+}
+
+// Description sets the short and, optionally, the long description of a metric.
+func Description(short string, long ...string) MetricOption {
+	return func(o *metricOptions) { o.desc = append([]string{short}, long...) }
+}
+
+// inferType returns the type of metrics holding values like val
+func inferType(val interface{}) (MetricType, error) {
+	switch val.(type) {
+	case int32:
+		return Int32Type, nil
+	case int, int64:
+		return Int64Type, nil
+	case uint32:
+		return Uint32Type, nil
+	case uint, uint64:
+		return Uint64Type, nil
+	case float32:
+		return FloatType, nil
+	case float64:
+		return DoubleType, nil
+	case string:
+		return StringType, nil
+	}
+	return 0, fmt.Errorf("cannot infer the type of a metric from a %T value", val)
+}
+
+// newMetricOptions applies opts to the default options of a metric holding values like val
+func newMetricOptions(name string, val interface{}, opts []MetricOption) (*metricOptions, error) {
+	o := &metricOptions{s: InstantSemantics, u: OneUnit}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if !o.typed {
+		t, err := inferType(val)
+		if err != nil {
+			return nil, &OpError{"create metric", name, "", err}
+		}
+		o.t = t
+	}
+
+	return o, nil
+}
+
+// NewSingletonMetric creates a new PCPSingletonMetric holding val, configured by opts.
+//
+//	m, err := speed.NewSingletonMetric("requests.size", int64(0),
+//		speed.Semantics(speed.CounterSemantics),
+//		speed.Unit(speed.ByteUnit),
+//		speed.Description("bytes received in requests"),
+//	)
+func NewSingletonMetric(name string, val interface{}, opts ...MetricOption) (*PCPSingletonMetric, error) {
+	o, err := newMetricOptions(name, val, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPCPSingletonMetric(val, name, o.t, o.s, o.u, o.desc...)
+}
+
+// NewInstanceMetric creates a new PCPInstanceMetric over indom holding vals, which must
+// have a value for every instance of indom, configured by opts.
+func NewInstanceMetric(name string, indom *PCPInstanceDomain, vals Instances, opts ...MetricOption) (*PCPInstanceMetric, error) {
+	var val interface{}
+	for _, instance := range indom.Instances() {
+		if v, ok := vals[instance]; ok {
+			val = v
+			break
+		}
+	}
+
+	o, err := newMetricOptions(name, val, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPCPInstanceMetric(vals, name, indom, o.t, o.s, o.u, o.desc...)
+}
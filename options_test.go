@@ -0,0 +1,106 @@
+package speed
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewSingletonMetric(t *testing.T) {
+	cases := []struct {
+		val interface{}
+		t   MetricType
+	}{
+		{int32(1), Int32Type},
+		{1, Int64Type},
+		{int64(1), Int64Type},
+		{uint32(1), Uint32Type},
+		{uint(1), Uint64Type},
+		{uint64(1), Uint64Type},
+		{float32(1), FloatType},
+		{1.0, DoubleType},
+		{"one", StringType},
+	}
+
+	for _, c := range cases {
+		m, err := NewSingletonMetric("inferred", c.val)
+		if err != nil {
+			t.Errorf("cannot create a metric holding %v(%T), error: %v", c.val, c.val, err)
+			continue
+		}
+
+		if m.Type() != c.t || m.Semantics() != InstantSemantics || m.Unit() != OneUnit {
+			t.Errorf("expected a metric holding a %T to be an instant %v of OneUnit, got %v %v %v", c.val, c.t, m.Semantics(), m.Type(), m.Unit())
+		}
+	}
+
+	if _, err := NewSingletonMetric("inferred", true); err == nil {
+		t.Errorf("expected inferring the type of a bool metric to fail")
+	}
+
+	m, err := NewSingletonMetric("requests.size", 0,
+		OfType(Uint32Type),
+		Semantics(CounterSemantics),
+		Unit(ByteUnit),
+		Description("request bytes", "bytes received in requests"),
+	)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	if m.Type() != Uint32Type || m.Semantics() != CounterSemantics || m.Unit() != ByteUnit {
+		t.Errorf("expected a counter of Uint32Type and ByteUnit, got %v %v %v", m.Semantics(), m.Type(), m.Unit())
+	}
+
+	if m.ShortDescription() != "request bytes" || m.LongDescription() != "bytes received in requests" {
+		t.Errorf("unexpected descriptions %q and %q", m.ShortDescription(), m.LongDescription())
+	}
+
+	if v := m.Val(); v != uint32(0) {
+		t.Errorf("expected the value to be resolved to the type, got %v(%T)", v, v)
+	}
+
+	if _, err = NewSingletonMetric("negative", -1, OfType(Uint64Type)); err == nil {
+		t.Errorf("expected a value incompatible with the type to fail")
+	}
+
+	// the split interfaces are implemented by the metrics
+	var r SingletonMetricReader = m
+	var w SingletonMetricWriter = m
+	w.MustSet(5)
+	if v := r.Val(); v != uint32(5) {
+		t.Errorf("expected 5, got %v", v)
+	}
+}
+
+func TestNewInstanceMetric(t *testing.T) {
+	indom, err := NewPCPInstanceDomain("options.indom", []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("cannot create instance domain, error: %v", err)
+	}
+
+	m, err := NewInstanceMetric("options.metric", indom, Instances{"a": 1.5, "b": 2.5}, Unit(SecondUnit))
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+
+	if m.Type() != DoubleType || m.Unit() != SecondUnit {
+		t.Errorf("expected a metric of DoubleType and SecondUnit, got %v %v", m.Type(), m.Unit())
+	}
+
+	var r InstanceMetricReader = m
+	if i := r.Instances(); len(i) != 2 {
+		t.Errorf("expected 2 instances, got %v", i)
+	}
+
+	if v, _ := r.ValInstance("b"); !reflect.DeepEqual(v, 2.5) {
+		t.Errorf("expected b to be 2.5, got %v", v)
+	}
+
+	if _, err = NewInstanceMetric("options.metric", indom, Instances{"a": 1.5}); err == nil {
+		t.Errorf("expected creating a metric without values for all instances to fail")
+	}
+
+	if _, err = NewInstanceMetric("options.metric", indom, nil); err == nil {
+		t.Errorf("expected creating a metric without values to fail")
+	}
+}
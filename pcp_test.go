@@ -0,0 +1,162 @@
+//go:build pcp
+// +build pcp
+
+package speed
+
+// Integration tests checking mappings written by speed against the PCP installed on
+// the system, which catch incompatibilities with libpcp_mmv the tests reading mappings
+// using mmvdump cannot. They are only built with the pcp tag, and run using
+//
+//	make integration
+//
+// Tests whose tools are missing are skipped.
+
+import (
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// pcpTool returns the path of a PCP tool, looking in PATH and the directories
+// PCP installs its tools in, skipping the test if it is not installed
+func pcpTool(t *testing.T, name string) string {
+	if path, err := exec.LookPath(name); err == nil {
+		return path
+	}
+
+	for _, dir := range []string{"PCP_BIN_DIR", "PCP_BINADM_DIR"} {
+		if d, ok := config[dir]; ok {
+			path := filepath.Join(rootPath, d, name)
+			if _, err := exec.LookPath(path); err == nil {
+				return path
+			}
+		}
+	}
+
+	t.Skipf("%v is not installed", name)
+	return ""
+}
+
+// runPCPTool runs the PCP tool at path, failing the test if it fails, and returns its output
+func runPCPTool(t *testing.T, path string, args ...string) string {
+	out, err := exec.Command(path, args...).CombinedOutput()
+	if err != nil {
+		t.Errorf("%v %v failed, error: %v, output:\n%s", filepath.Base(path), strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+// newPCPTestClient creates and starts a client with metrics of all types, singleton and
+// over instances, with and without descriptions, and with names needing MMV version 2
+// if long is true
+func newPCPTestClient(t *testing.T, name string, long bool) *PCPClient {
+	c, err := NewPCPClient(name)
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	prefix := "metrics"
+	if long {
+		prefix = strings.Repeat("long", MaxV1NameLength/4) + ".metrics"
+	}
+
+	c.MustRegisterString(prefix+".int32", int32(-32), Int32Type, InstantSemantics, OneUnit)
+	c.MustRegisterString(prefix+".uint32", uint32(32), Uint32Type, InstantSemantics, OneUnit)
+	c.MustRegisterString(prefix+".int64", int64(-64), Int64Type, CounterSemantics, OneUnit)
+	c.MustRegisterString(prefix+".uint64", uint64(64), Uint64Type, CounterSemantics, ByteUnit)
+	c.MustRegisterString(prefix+".float", float32(2.5), FloatType, InstantSemantics, SecondUnit)
+	c.MustRegisterString(prefix+".double", float64(-4.25), DoubleType, DiscreteSemantics, OneUnit)
+	c.MustRegisterString(prefix+".string", "hello", StringType, DiscreteSemantics, OneUnit)
+
+	counter, err := NewPCPCounter(42, prefix+".counter", "a counter", "a counter, with a long description")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+	c.MustRegister(counter)
+
+	vector, err := NewPCPGaugeVector(map[string]float64{"a": 1, "b": 2}, prefix+".vector", "a vector")
+	if err != nil {
+		t.Fatalf("cannot create vector, error: %v", err)
+	}
+	c.MustRegister(vector)
+
+	c.MustStart()
+	return c
+}
+
+// pcpTestValues are the values of the metrics of newPCPTestClient, as printed by pminfo -f
+var pcpTestValues = map[string][]string{
+	"int32":   {`value -32`},
+	"uint32":  {`value 32`},
+	"int64":   {`value -64`},
+	"uint64":  {`value 64`},
+	"float":   {`value 2.5`},
+	"double":  {`value -4.25`},
+	"string":  {`value "hello"`},
+	"counter": {`value 42`},
+	"vector":  {`inst \[\d+ or "a"\] value 1`, `inst \[\d+ or "b"\] value 2`},
+}
+
+func TestPCPMmvdump(t *testing.T) {
+	mmvdump := pcpTool(t, "mmvdump")
+
+	for _, long := range []bool{false, true} {
+		c := newPCPTestClient(t, "speed_integration", long)
+
+		out := runPCPTool(t, mmvdump, c.Location())
+
+		for name := range pcpTestValues {
+			if !strings.Contains(out, ".metrics."+name) {
+				t.Errorf("expected mmvdump to list metric %v, got\n%v", name, out)
+			}
+		}
+
+		c.MustStop()
+	}
+}
+
+func TestPCPValues(t *testing.T) {
+	pminfo := pcpTool(t, "pminfo")
+
+	pmdas, ok := config["PCP_PMDAS_DIR"]
+	if !ok {
+		t.Skip("PCP_PMDAS_DIR is not configured")
+	}
+
+	dsos, _ := filepath.Glob(filepath.Join(rootPath, pmdas, "mmv", "pmda_mmv.*"))
+	if len(dsos) == 0 {
+		t.Skip("the mmv PMDA is not installed")
+	}
+
+	for _, long := range []bool{false, true} {
+		c := newPCPTestClient(t, "speed_integration", long)
+
+		// read the metrics in a local context, loading the mmv PMDA in the process,
+		// so pmcd need not be running
+		args := []string{"-L", "-K", "clear", "-K", "add,70," + dsos[0] + ",mmv_init"}
+
+		metrics := "mmv.speed_integration.metrics"
+		if long {
+			metrics = "mmv.speed_integration." + strings.Repeat("long", MaxV1NameLength/4) + ".metrics"
+		}
+
+		out := runPCPTool(t, pminfo, append(args, "-f", metrics)...)
+		for name, values := range pcpTestValues {
+			for _, v := range values {
+				re := regexp.MustCompile(regexp.QuoteMeta(metrics+"."+name) + `\n( +.*\n)*? +` + v + `\n`)
+				if !re.MatchString(out) {
+					t.Errorf("expected pminfo to report %v for %v, got\n%v", v, name, out)
+				}
+			}
+		}
+
+		out = runPCPTool(t, pminfo, append(args, "-t", metrics+".counter", metrics+".vector")...)
+		if !strings.Contains(out, "[a counter]") || !strings.Contains(out, "[a vector]") {
+			t.Errorf("expected pminfo to report the descriptions, got\n%v", out)
+		}
+
+		c.MustStop()
+	}
+}
@@ -0,0 +1,129 @@
+package speed
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Threshold is an alarm condition on the value of a metric, configured using WithThreshold
+// and exported as a pmie rule by WritePmieRules, so host side alerting can be
+// bootstrapped from the instrumentation.
+//
+// pmie converts the values of metrics with counter semantics to rates,
+// so thresholds on counters are thresholds on their rates.
+type Threshold struct {
+	Op      string  // comparison of the value against Value, one of >, >=, <, <=, == and !=
+	Value   float64 // the value compared against
+	Message string  // printed when the condition holds, defaults to a description of the condition
+}
+
+// thresholdOps are the comparisons a Threshold can use
+var thresholdOps = map[string]bool{">": true, ">=": true, "<": true, "<=": true, "==": true, "!=": true}
+
+// WithThreshold configures an alarm condition on a numeric metric,
+// which is exported as a pmie rule by WritePmieRules.
+// It can be passed multiple times to configure multiple conditions.
+func WithThreshold(th Threshold) RegisterOption {
+	return func(c *PCPClient, m Metric) error {
+		if !thresholdOps[th.Op] {
+			return fmt.Errorf("invalid comparison %q in a threshold", th.Op)
+		}
+
+		if !m.Type().isNumeric() {
+			return errors.New("thresholds can only be set on numeric metrics")
+		}
+
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		if c.thresholds == nil {
+			c.thresholds = make(map[string][]Threshold)
+		}
+
+		c.thresholds[m.Name()] = append(c.thresholds[m.Name()], th)
+
+		c.undoOnFailure(m, func() {
+			ths := c.thresholds[m.Name()]
+			for i := len(ths) - 1; i >= 0; i-- {
+				if ths[i] == th {
+					ths = append(ths[:i], ths[i+1:]...)
+					break
+				}
+			}
+
+			if len(ths) == 0 {
+				delete(c.thresholds, m.Name())
+			} else {
+				c.thresholds[m.Name()] = ths
+			}
+		})
+
+		return nil
+	}
+}
+
+// WritePmieRules writes a pmie(1) rule for every threshold configured using WithThreshold,
+// which prints a message when the condition holds. For metrics with instances,
+// the rule holds if the condition holds for any instance, and the message names it.
+//
+// The rules can be loaded using pmie -c, or installed under $PCP_SYSCONF_DIR/pmie.
+func (c *PCPClient) WritePmieRules(w io.Writer) error {
+	prefix := c.MetricPrefix()
+
+	c.mutex.Lock()
+	thresholds := make(map[string][]Threshold, len(c.thresholds))
+	for name, ths := range c.thresholds {
+		thresholds[name] = ths
+	}
+	c.mutex.Unlock()
+
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "// pmie rules for %v, generated by speed\n", strings.TrimSuffix(prefix, "."))
+
+	for _, m := range c.r.sortedMetrics() {
+		for i, th := range thresholds[m.Name()] {
+			if !thresholdOps[th.Op] {
+				return c.opError("write pmie rules of", "", fmt.Errorf("invalid comparison %q in a threshold of %v", th.Op, m.Name()))
+			}
+
+			if !m.Type().isNumeric() {
+				return c.opError("write pmie rules of", "", fmt.Errorf("%v is not a numeric metric", m.Name()))
+			}
+
+			name := prefix + m.Name()
+			value := strconv.FormatFloat(th.Value, 'g', -1, 64)
+
+			message := th.Message
+			if message == "" {
+				message = name + " " + th.Op + " " + value
+			}
+
+			cond := name + " " + th.Op + " " + value
+			if m.Indom() != nil {
+				cond = "some_inst (" + cond + ")"
+				message += " for %i"
+			}
+
+			fmt.Fprintf(b, "\n%v =\n    %v\n    -> print %v;\n", ruleName(name, i), cond, strconv.Quote(message))
+		}
+	}
+
+	return b.Flush()
+}
+
+// ruleName returns the name of the i-th rule on a metric, which can only
+// contain letters, digits and underscores
+func ruleName(metric string, i int) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, metric)
+
+	return name + "_" + strconv.Itoa(i)
+}
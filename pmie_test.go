@@ -0,0 +1,61 @@
+package speed
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWritePmieRules(t *testing.T) {
+	c, err := NewPCPClient("app")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	failures, err := NewPCPCounter(0, "errors")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	latency, err := NewPCPGaugeVector(map[string]float64{"a": 0}, "latency")
+	if err != nil {
+		t.Fatalf("cannot create gauge vector, error: %v", err)
+	}
+
+	c.MustRegister(failures, WithThreshold(Threshold{">", 10, "too many errors"}))
+	c.MustRegister(latency, WithThreshold(Threshold{Op: ">=", Value: 0.5}))
+
+	var b bytes.Buffer
+	if err = c.WritePmieRules(&b); err != nil {
+		t.Fatalf("cannot write pmie rules, error: %v", err)
+	}
+
+	expected := `// pmie rules for mmv.app, generated by speed
+
+mmv_app_errors_0 =
+    mmv.app.errors > 10
+    -> print "too many errors";
+
+mmv_app_latency_0 =
+    some_inst (mmv.app.latency >= 0.5)
+    -> print "mmv.app.latency >= 0.5 for %i";
+`
+	if b.String() != expected {
+		t.Errorf("expected\n%v\ngot\n%v", expected, b.String())
+	}
+
+	s, err := NewPCPSingletonMetric("a", "state", StringType, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+	if err = c.Register(s, WithThreshold(Threshold{Op: "~", Value: 1})); err == nil {
+		t.Errorf("expected an invalid threshold to fail registering")
+	}
+
+	if err = c.Register(s, WithThreshold(Threshold{Op: ">", Value: 1})); err == nil {
+		t.Errorf("expected a threshold on a string metric to fail registering")
+	}
+
+	if c.r.HasMetric("state") {
+		t.Errorf("expected a metric failing an option to not be registered")
+	}
+}
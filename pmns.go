@@ -0,0 +1,126 @@
+package speed
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// PMNS holds the names of a PCP namespace, as read by ReadPMNS,
+// which registered metrics can be checked against, see ValidateNamespace.
+type PMNS struct {
+	names map[string]bool // all names, of metrics as well as of their parents
+}
+
+// pmnsComment matches C style comments and preprocessor directives in a PMNS file
+var pmnsComment = regexp.MustCompile(`(?s:/\*.*?\*/)|(?m:^#.*$)`)
+
+// ReadPMNS reads a namespace in the ASCII format of pmns(5), where every non-leaf
+// name is followed by its children in braces, with leaves having their PMID, as in
+//
+//	root {
+//		kernel
+//	}
+//
+//	kernel {
+//		uname	60:12:0
+//	}
+//
+// Comments and preprocessor directives are ignored, without including other files.
+func ReadPMNS(r io.Reader) (*PMNS, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ns := &PMNS{make(map[string]bool)}
+
+	tokens := strings.Fields(pmnsComment.ReplaceAllString(string(data), ""))
+	parent := "" // name of the current non-leaf, empty outside braces
+
+	for i := 0; i < len(tokens); i++ {
+		switch t := tokens[i]; {
+		case parent == "":
+			if i+1 >= len(tokens) || tokens[i+1] != "{" {
+				return nil, fmt.Errorf("expected { after %v", t)
+			}
+
+			parent, i = t, i+1
+			if parent != "root" {
+				ns.names[parent] = true
+			}
+		case t == "}":
+			parent = ""
+		case t == "{":
+			return nil, fmt.Errorf("unexpected { in %v", parent)
+		default:
+			name := t
+			if parent != "root" {
+				name = parent + "." + t
+			}
+			ns.names[name] = true
+
+			// skip the PMID of a leaf
+			if i+1 < len(tokens) && strings.ContainsRune(tokens[i+1], ':') {
+				i++
+			}
+		}
+	}
+
+	if parent != "" {
+		return nil, fmt.Errorf("missing } after the children of %v", parent)
+	}
+
+	return ns, nil
+}
+
+// LoadPMNS reads the namespace of the local PCP installation, from the file named by
+// the PMNS_DEFAULT environment variable, or the root file in the pmns directory under
+// PCP_VAR_DIR otherwise.
+func LoadPMNS() (*PMNS, error) {
+	path, ok := os.LookupEnv("PMNS_DEFAULT")
+	if !ok {
+		dir, present := config["PCP_VAR_DIR"]
+		if !present {
+			return nil, errors.New("cannot find the PCP namespace, PCP_VAR_DIR is not configured")
+		}
+		path = filepath.Join(rootPath, dir, "pmns", "root")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadPMNS(f)
+}
+
+// Has returns true if name is in the namespace, as a metric or as the parent of metrics.
+func (ns *PMNS) Has(name string) bool {
+	return ns.names[name]
+}
+
+// ValidateNamespace checks the names of all registered metrics against the names of a
+// namespace outside mmv, and returns an error naming the metrics that are named the same
+// as another metric or a parent of metrics of the namespace, like kernel.all.load, as the
+// same name appearing under mmv confuses users of the host.
+func (c *PCPClient) ValidateNamespace(ns *PMNS) error {
+	var taken []string
+	for _, m := range c.r.sortedMetrics() {
+		if name := m.Name(); ns.Has(name) && name != "mmv" && !strings.HasPrefix(name, "mmv.") {
+			taken = append(taken, name)
+		}
+	}
+
+	if len(taken) > 0 {
+		return c.opError("validate names of", "", fmt.Errorf("metric names %v are also in the PCP namespace", strings.Join(taken, ", ")))
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package speed
+
+import (
+	"strings"
+	"testing"
+)
+
+const testPMNS = `/*
+ * generated by pmnsmerge
+ */
+#define KERNEL 60
+
+root {
+	kernel
+	mmv	70:*:*
+}
+
+kernel {
+	all
+	uname	60:12:0	/* the uname */
+}
+
+kernel.all {
+	load	KERNEL:2:0
+}
+`
+
+func TestReadPMNS(t *testing.T) {
+	ns, err := ReadPMNS(strings.NewReader(testPMNS))
+	if err != nil {
+		t.Fatalf("cannot read namespace, error: %v", err)
+	}
+
+	for _, name := range []string{"kernel", "kernel.all", "kernel.all.load", "kernel.uname", "mmv"} {
+		if !ns.Has(name) {
+			t.Errorf("expected %v to be in the namespace", name)
+		}
+	}
+
+	for _, name := range []string{"root", "all", "load", "60:12:0", "kernel.uname.sysname"} {
+		if ns.Has(name) {
+			t.Errorf("expected %v not to be in the namespace", name)
+		}
+	}
+
+	for _, bad := range []string{"root kernel }", "root { kernel", "root { { }"} {
+		if _, err = ReadPMNS(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected reading %q to fail", bad)
+		}
+	}
+}
+
+func TestValidateNamespace(t *testing.T) {
+	ns, err := ReadPMNS(strings.NewReader(testPMNS))
+	if err != nil {
+		t.Fatalf("cannot read namespace, error: %v", err)
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	c.MustRegisterString("requests", 0, Int64Type, CounterSemantics, OneUnit)
+	if err = c.ValidateNamespace(ns); err != nil {
+		t.Errorf("expected no collisions, got %v", err)
+	}
+
+	c.MustRegisterString("kernel.all.load", 0, Int64Type, InstantSemantics, OneUnit)
+	c.MustRegisterString("kernel.uname", 0, Int64Type, InstantSemantics, OneUnit)
+
+	err = c.ValidateNamespace(ns)
+	if err == nil || !strings.Contains(err.Error(), "kernel.all.load, kernel.uname") {
+		t.Errorf("expected an error naming kernel.all.load and kernel.uname, got %v", err)
+	}
+}
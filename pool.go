@@ -0,0 +1,106 @@
+package speed
+
+import "time"
+
+// PoolHooks maintain the metrics of a pool of resources, like connections, instrumented
+// using InstrumentPool, and are called by the pool as resources are acquired and
+// released. They are safe for concurrent use.
+type PoolHooks struct {
+	client   *PCPClient
+	clock    Clock
+	acquired *PCPCounter
+	failed   *PCPCounter
+	wait     *PCPHistogram
+	inuse    *PCPGauge
+}
+
+// InstrumentPool registers metrics for a pool with the client, all named under name,
+// and returns the hooks maintaining them. The registered metrics are
+//
+// name.acquired, counting the resources acquired from the pool
+//
+// name.acquire.failed, counting the attempts to acquire a resource that failed,
+// like ones that timed out
+//
+// name.acquire.wait, a histogram of the time taken to acquire resources, in microseconds
+//
+// name.inuse, the number of resources acquired and not yet released
+//
+// name.idle, the number of idle resources returned by idle, which is only registered
+// if idle is not nil, and is called by the client at every publish interval, see
+// NewPCPLazyMetric. For a *sql.DB, it can return int64(db.Stats().Idle).
+//
+// The hooks read the time from the clock the client has when the pool is instrumented.
+func InstrumentPool(c *PCPClient, name string, idle func() int64) (*PoolHooks, error) {
+	p := &PoolHooks{client: c, clock: c.getClock()}
+
+	var err error
+	if p.acquired, err = NewPCPCounter(0, name+".acquired", "resources acquired"); err != nil {
+		return nil, err
+	}
+
+	if p.failed, err = NewPCPCounter(0, name+".acquire.failed", "failed attempts to acquire a resource"); err != nil {
+		return nil, err
+	}
+
+	if p.wait, err = NewPCPHistogram(name+".acquire.wait", 0, HistogramMax, 3, MicrosecondUnit, "time taken to acquire resources"); err != nil {
+		return nil, err
+	}
+
+	if p.inuse, err = NewPCPGauge(0, name+".inuse", "resources acquired and not released"); err != nil {
+		return nil, err
+	}
+
+	metrics := []Metric{p.acquired, p.failed, p.wait, p.inuse}
+
+	if idle != nil {
+		f := func() (interface{}, error) { return idle(), nil }
+
+		m, err := NewPCPLazyMetric(f, name+".idle", Int64Type, InstantSemantics, OneUnit, "idle resources")
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+
+	for _, m := range metrics {
+		if err = c.Register(m); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// MustInstrumentPool panics if InstrumentPool fails.
+func MustInstrumentPool(c *PCPClient, name string, idle func() int64) *PoolHooks {
+	p, err := InstrumentPool(c, name, idle)
+	must("instrument pool", name, c.name(), err)
+	return p
+}
+
+// OnAcquireStart is called when the pool starts acquiring a resource, and returns the
+// time it started at, to be passed to OnAcquired or OnAcquireFailed.
+func (p *PoolHooks) OnAcquireStart() time.Time {
+	return p.clock.Now()
+}
+
+// OnAcquired is called when a resource the pool started acquiring at the passed time
+// is acquired.
+func (p *PoolHooks) OnAcquired(start time.Time) {
+	p.client.reportError(p.acquired.Inc(1))
+	p.client.reportError(p.inuse.Inc(1))
+	p.client.reportError(p.wait.Record(microseconds(p.clock.Now().Sub(start))))
+}
+
+// OnAcquireFailed is called when acquiring a resource the pool started acquiring at
+// the passed time fails.
+func (p *PoolHooks) OnAcquireFailed(start time.Time) {
+	p.client.reportError(p.failed.Inc(1))
+	p.client.reportError(p.wait.Record(microseconds(p.clock.Now().Sub(start))))
+}
+
+// OnRelease is called when an acquired resource is released back to the pool.
+func (p *PoolHooks) OnRelease() {
+	p.client.reportError(p.inuse.Dec(1))
+}
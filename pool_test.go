@@ -0,0 +1,83 @@
+package speed
+
+import (
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestInstrumentPool(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	clock := NewManualClock(time.Unix(1000, 0))
+	if err = c.SetClock(clock); err != nil {
+		t.Fatalf("cannot set clock, error: %v", err)
+	}
+
+	idle := int64(4)
+	p := MustInstrumentPool(c, "conns", func() int64 { return atomic.LoadInt64(&idle) })
+
+	c.MustStart()
+	defer c.MustStop()
+
+	start := p.OnAcquireStart()
+	clock.Advance(2 * time.Millisecond)
+	p.OnAcquired(start)
+
+	start = p.OnAcquireStart()
+	p.OnAcquired(start)
+
+	start = p.OnAcquireStart()
+	clock.Advance(5 * time.Millisecond)
+	p.OnAcquireFailed(start)
+
+	p.OnRelease()
+
+	expected := map[string]interface{}{
+		"conns.acquired":          int64(2),
+		"conns.acquire.failed":    int64(1),
+		"conns.inuse":             float64(1),
+		"conns.idle":              int64(4),
+		"conns.acquire.wait[min]": float64(0),
+	}
+
+	for name, val := range expected {
+		if v, err := mmvdump.Lookup(c.writer.Bytes(), name); err != nil || v != val {
+			t.Errorf("expected %v to be %v, got %v, error: %v", name, val, v, err)
+		}
+	}
+
+	// histograms keep 3 significant figures
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "conns.acquire.wait[max]"); err != nil || math.Abs(v.(float64)-5000) > 5 {
+		t.Errorf("expected the longest wait to be about 5000us, got %v, error: %v", v, err)
+	}
+
+	// the idle resources are gathered at every publish interval
+	atomic.StoreInt64(&idle, 3)
+	if err = c.Tick(); err != nil {
+		t.Fatalf("cannot tick the client, error: %v", err)
+	}
+
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "conns.idle"); err != nil || v != int64(3) {
+		t.Errorf("expected 3 idle resources after a tick, got %v, error: %v", v, err)
+	}
+}
+
+func TestInstrumentPoolWithoutIdle(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	MustInstrumentPool(c, "conns", nil)
+
+	if _, ok := c.r.metrics["conns.idle"]; ok {
+		t.Errorf("expected no idle metric to be registered without a function")
+	}
+}
@@ -0,0 +1,112 @@
+package speed
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MaxFullNameLength is the maximum length of the full name of a metric as PCP
+// exposes it, including the prefix, which is the length of an mmv string
+// less its terminator.
+const MaxFullNameLength = StringLength - 1
+
+// pmnsComponent matches a component of a name in the PCP namespace
+var pmnsComponent = regexp.MustCompile(`\A[A-Za-z][A-Za-z0-9_]*\z`)
+
+// SetNoPrefix sets whether the metrics of the client are published directly under
+// the mmv namespace, as mmv.<metric>, instead of under the name of the client,
+// as mmv.<client>.<metric>, which is the default. It sets or clears NoPrefixFlag,
+// and fails if a registered metric would have a full name longer than MaxFullNameLength.
+func (c *PCPClient) SetNoPrefix(noprefix bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.r.mapped {
+		return ErrClientStarted
+	}
+
+	flag := c.flag &^ NoPrefixFlag
+	if noprefix {
+		flag |= NoPrefixFlag
+	}
+
+	if err := c.checkFullNames(flag); err != nil {
+		return c.opError("set the prefix of", "", err)
+	}
+
+	c.flag = flag
+	return nil
+}
+
+// MetricPrefix returns the prefix PCP adds to the names of the metrics of the client,
+// "mmv.<client>." by default and "mmv." if NoPrefixFlag is set.
+func (c *PCPClient) MetricPrefix() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.prefixFor(c.flag)
+}
+
+// FullName returns the name PCP exposes a metric of the client under.
+func (c *PCPClient) FullName(name string) string {
+	return c.MetricPrefix() + name
+}
+
+// prefixFor returns the prefix of the metrics of the client written with flag
+func (c *PCPClient) prefixFor(flag MMVFlag) string {
+	if flag&NoPrefixFlag != 0 {
+		return "mmv."
+	}
+	return "mmv." + c.name() + "."
+}
+
+// checkFullNames returns an error if a registered metric would have a full name
+// that is too long if the client was written with flag, the mutex must be held.
+func (c *PCPClient) checkFullNames(flag MMVFlag) error {
+	prefix := c.prefixFor(flag)
+	for _, m := range c.r.sortedMetrics() {
+		if err := checkFullName(prefix, m.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFullName returns an error if the full name of a metric is too long
+func checkFullName(prefix, name string) error {
+	if len(prefix)+len(name) > MaxFullNameLength {
+		return fmt.Errorf("full name %v%v is longer than %v bytes", prefix, name, MaxFullNameLength)
+	}
+	return nil
+}
+
+// ValidateNames checks the full names of all registered metrics against the rules of
+// the PCP namespace, where every component of a name starts with a letter followed by
+// letters, digits and underscores, and returns an error naming the invalid names.
+//
+// PCP does not expose metrics with invalid names, but they are still written
+// to the mapping, so the check is not done when metrics are registered.
+func (c *PCPClient) ValidateNames() error {
+	prefix := c.MetricPrefix()
+
+	var invalid []string
+	for _, m := range c.r.sortedMetrics() {
+		name := prefix + m.Name()
+
+		valid := checkFullName(prefix, m.Name()) == nil
+		for _, component := range strings.Split(name, ".") {
+			valid = valid && pmnsComponent.MatchString(component)
+		}
+
+		if !valid {
+			invalid = append(invalid, name)
+		}
+	}
+
+	if len(invalid) > 0 {
+		return c.opError("validate names of", "", fmt.Errorf("invalid metric names %v", strings.Join(invalid, ", ")))
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package speed
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMetricPrefix(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	if p := c.MetricPrefix(); p != "mmv.test." {
+		t.Errorf("expected the prefix mmv.test., got %v", p)
+	}
+
+	if err = c.SetNoPrefix(true); err != nil {
+		t.Fatalf("cannot remove the prefix, error: %v", err)
+	}
+
+	if c.flag&NoPrefixFlag == 0 || c.FullName("requests") != "mmv.requests" {
+		t.Errorf("expected the metrics to be published directly under mmv, got %v", c.FullName("requests"))
+	}
+
+	// a name that only fits without the client name in the prefix
+	long := strings.Repeat("a", MaxFullNameLength-len("mmv."))
+	c.MustRegisterString(long, 1, Int32Type, InstantSemantics, OneUnit)
+
+	if err = c.SetNoPrefix(false); err == nil {
+		t.Errorf("expected adding the prefix to fail when a full name gets too long")
+	}
+
+	if c.flag&NoPrefixFlag == 0 {
+		t.Errorf("expected the flag to be unchanged after a failure")
+	}
+
+	if err = c.SetFlag(ProcessFlag); err == nil {
+		t.Errorf("expected clearing NoPrefixFlag to fail when a full name gets too long")
+	}
+
+	c2, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	m, err := NewPCPCounter(0, long)
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	if err = c2.Register(m); err == nil || !strings.Contains(err.Error(), "longer than") {
+		t.Errorf("expected registering a metric with a too long full name to fail, got %v", err)
+	}
+}
+
+func TestValidateNames(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	c.MustRegisterString("valid.name_1", 1, Int32Type, InstantSemantics, OneUnit)
+	if err = c.ValidateNames(); err != nil {
+		t.Errorf("expected valid names, got %v", err)
+	}
+
+	c.MustRegisterString("invalid.1name", 1, Int32Type, InstantSemantics, OneUnit)
+	if err = c.ValidateNames(); err == nil || !strings.Contains(err.Error(), "mmv.test.invalid.1name") {
+		t.Errorf("expected mmv.test.invalid.1name to be invalid, got %v", err)
+	}
+}
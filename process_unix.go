@@ -0,0 +1,12 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package speed
+
+import "syscall"
+
+// processAlive returns false if no process has the passed identifier,
+// which is the case for processes that exited or crashed.
+func processAlive(pid int) bool {
+	return syscall.Kill(pid, 0) != syscall.ESRCH
+}
@@ -0,0 +1,29 @@
+//go:build go1.9
+// +build go1.9
+
+package speed
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+// ProfilingLabel is the pprof label under which metric names are recorded
+const ProfilingLabel = "speed.metric"
+
+// WithProfiling returns a copy of the passed context carrying a pprof label
+// with the name of the passed metric.
+//
+// The labels only show up in CPU profiles once they are applied to a goroutine,
+// either using pprof.SetGoroutineLabels or by passing the context to pprof.Do.
+// DoProfiled does the latter.
+func WithProfiling(ctx context.Context, m Metric) context.Context {
+	return pprof.WithLabels(ctx, pprof.Labels(ProfilingLabel, m.Name()))
+}
+
+// DoProfiled calls f with a context carrying a pprof label for the passed metric,
+// with the label applied to the current goroutine for the duration of the call,
+// so CPU profile samples taken inside f can be correlated with the metric.
+func DoProfiled(ctx context.Context, m Metric, f func(context.Context)) {
+	pprof.Do(ctx, pprof.Labels(ProfilingLabel, m.Name()), f)
+}
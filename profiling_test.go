@@ -0,0 +1,34 @@
+//go:build go1.9
+// +build go1.9
+
+package speed
+
+import (
+	"context"
+	"runtime/pprof"
+	"testing"
+)
+
+func TestProfiling(t *testing.T) {
+	m, err := NewPCPCounter(0, "profiled.counter")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	ctx := WithProfiling(context.Background(), m)
+	if v, ok := pprof.Label(ctx, ProfilingLabel); !ok || v != m.Name() {
+		t.Errorf("expected label %v to be %v, got %v", ProfilingLabel, m.Name(), v)
+	}
+
+	called := false
+	DoProfiled(context.Background(), m, func(ctx context.Context) {
+		called = true
+		if v, ok := pprof.Label(ctx, ProfilingLabel); !ok || v != m.Name() {
+			t.Errorf("expected label %v to be %v, got %v", ProfilingLabel, m.Name(), v)
+		}
+	})
+
+	if !called {
+		t.Error("expected DoProfiled to call the passed function")
+	}
+}
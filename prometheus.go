@@ -0,0 +1,214 @@
+package speed
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// promFamily is the metadata of a metric family of the Prometheus text exposition format
+type promFamily struct {
+	name, help, typ string
+}
+
+// promSample is a sample of the Prometheus text exposition format
+type promSample struct {
+	name   string
+	labels string // labels ordered by name, formatted as k1=v1,k2=v2, empty if the sample has no labels
+	value  float64
+}
+
+// family returns the name of the family a sample belongs to, which for histograms
+// and summaries is the name of the sample without its _sum, _count or _bucket suffix
+func (s promSample) family(families map[string]*promFamily) *promFamily {
+	if f, ok := families[s.name]; ok {
+		return f
+	}
+
+	for _, suffix := range []string{"_sum", "_count", "_bucket"} {
+		if f, ok := families[strings.TrimSuffix(s.name, suffix)]; ok && strings.HasSuffix(s.name, suffix) {
+			if f.typ == "histogram" || f.typ == "summary" {
+				return f
+			}
+		}
+	}
+
+	return nil
+}
+
+// parsePrometheus parses metrics in the Prometheus text exposition format, version 0.0.4,
+// returning all samples in the order they appear, and the families declared by HELP and TYPE
+// comments by name. Timestamps of samples are ignored.
+func parsePrometheus(r io.Reader) ([]promSample, map[string]*promFamily, error) {
+	var samples []promSample
+	families := make(map[string]*promFamily)
+
+	family := func(name string) *promFamily {
+		f, ok := families[name]
+		if !ok {
+			f = &promFamily{name: name, typ: "untyped"}
+			families[name] = f
+		}
+		return f
+	}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" {
+			continue
+		}
+
+		if line[0] == '#' {
+			fields := strings.Fields(line[1:])
+			if len(fields) < 3 {
+				continue
+			}
+
+			switch fields[0] {
+			case "HELP":
+				// the help text is the rest of the line after the name, with whitespace preserved
+				help := strings.TrimSpace(line[1:])
+				help = strings.TrimSpace(strings.TrimPrefix(help, "HELP"))
+				help = strings.TrimSpace(strings.TrimPrefix(help, fields[1]))
+				family(fields[1]).help = unescapeProm(help, false)
+			case "TYPE":
+				family(fields[1]).typ = fields[2]
+			}
+
+			continue
+		}
+
+		s, err := parsePromSample(line)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %v", n, err)
+		}
+
+		samples = append(samples, s)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return samples, families, nil
+}
+
+// parsePromSample parses a line holding a sample
+func parsePromSample(line string) (promSample, error) {
+	var s promSample
+
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return s, fmt.Errorf("invalid sample %q", line)
+	}
+
+	s.name, line = line[:end], line[end:]
+
+	if line[0] == '{' {
+		labels, rest, err := parsePromLabels(line[1:])
+		if err != nil {
+			return s, err
+		}
+		s.labels, line = labels, rest
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 || len(fields) > 2 {
+		return s, fmt.Errorf("invalid value of %v", s.name)
+	}
+
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return s, fmt.Errorf("invalid value of %v: %v", s.name, err)
+	}
+
+	s.value = v
+	return s, nil
+}
+
+// parsePromLabels parses the labels of a sample following the opening brace,
+// returning them ordered by name and the rest of the line after the closing brace
+func parsePromLabels(line string) (string, string, error) {
+	var labels []string
+
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return "", "", fmt.Errorf("unterminated labels")
+		}
+
+		if line[0] == '}' {
+			sort.Strings(labels)
+			return strings.Join(labels, ","), line[1:], nil
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			return "", "", fmt.Errorf("invalid label in %q", line)
+		}
+
+		name := strings.TrimSpace(line[:eq])
+		line = strings.TrimLeft(line[eq+1:], " \t")
+
+		if line == "" || line[0] != '"' {
+			return "", "", fmt.Errorf("unquoted value of label %v", name)
+		}
+
+		// find the closing quote, skipping escaped characters
+		i := 1
+		for ; i < len(line) && line[i] != '"'; i++ {
+			if line[i] == '\\' {
+				i++
+			}
+		}
+
+		if i >= len(line) {
+			return "", "", fmt.Errorf("unterminated value of label %v", name)
+		}
+
+		labels = append(labels, name+"="+unescapeProm(line[1:i], true))
+
+		line = strings.TrimLeft(line[i+1:], " \t")
+		if line != "" && line[0] == ',' {
+			line = line[1:]
+		}
+	}
+}
+
+// unescapeProm unescapes backslashes and line feeds in help texts,
+// and double quotes as well in label values
+func unescapeProm(s string, quotes bool) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b = append(b, s[i])
+			continue
+		}
+
+		switch s[i+1] {
+		case '\\':
+			b = append(b, '\\')
+		case 'n':
+			b = append(b, '\n')
+		case '"':
+			if !quotes {
+				b = append(b, '\\')
+			}
+			b = append(b, '"')
+		default:
+			b = append(b, '\\', s[i+1])
+		}
+		i++
+	}
+
+	return string(b)
+}
@@ -0,0 +1,161 @@
+package speed
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// publishSchedule spaces the periodic work of a client, like running collectors
+// and flushing updates held back by a write rate limit.
+//
+// With a jitter, every interval is randomly lengthened or shortened by up to that
+// fraction of it, so many processes on a host started at the same time do not
+// keep writing their mappings at the same instants.
+type publishSchedule struct {
+	interval time.Duration
+	jitter   float64
+	rnd      *rand.Rand
+	clock    Clock
+}
+
+func newpublishSchedule(interval time.Duration, jitter float64) *publishSchedule {
+	// the global source is seeded identically in every process, defeating the jitter
+	seed := time.Now().UnixNano() ^ int64(os.Getpid())<<32
+	return &publishSchedule{interval, jitter, rand.New(rand.NewSource(seed)), SystemClock}
+}
+
+// next returns the time to wait until the next tick.
+// It is only called by start, one tick at a time.
+func (s *publishSchedule) next() time.Duration {
+	if s.jitter == 0 {
+		return s.interval
+	}
+
+	return time.Duration(float64(s.interval) * (1 + s.jitter*(2*s.rnd.Float64()-1)))
+}
+
+// start calls f after every interval of the clock of the schedule until the returned
+// function is called, which waits for a running call to return.
+func (s *publishSchedule) start(f func()) (stop func()) {
+	var (
+		mutex   sync.Mutex
+		stopped bool
+		timer   ClockTimer
+		tick    func()
+	)
+
+	tick = func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if stopped {
+			return
+		}
+
+		f()
+		timer = s.clock.AfterFunc(s.next(), tick)
+	}
+
+	mutex.Lock()
+	timer = s.clock.AfterFunc(s.next(), tick)
+	mutex.Unlock()
+
+	return func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		stopped = true
+		timer.Stop()
+	}
+}
+
+// SetPublishInterval sets the interval at which the client runs its collectors,
+// including lazy metrics and metric histories, and flushes updates held back by
+// a write rate limit, overriding DefaultCollectInterval and WriteLimiterFlushInterval.
+func (c *PCPClient) SetPublishInterval(interval time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.r.mapped {
+		return ErrClientStarted
+	}
+
+	if interval <= 0 {
+		return errors.New("publish interval must be positive")
+	}
+
+	c.publishInterval = interval
+	return nil
+}
+
+// SetPublishJitter randomly varies every publish interval by up to the passed
+// fraction of it, between 0 and 1, to avoid many processes on one host
+// writing their mappings at the same time.
+func (c *PCPClient) SetPublishJitter(jitter float64) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.r.mapped {
+		return ErrClientStarted
+	}
+
+	if jitter < 0 || jitter >= 1 {
+		return errors.New("publish jitter must be at least 0 and less than 1")
+	}
+
+	c.publishJitter = jitter
+	return nil
+}
+
+// schedule returns the schedule for periodic work defaulting to interval
+// if a publish interval is not set.
+func (c *PCPClient) schedule(interval time.Duration) *publishSchedule {
+	if c.publishInterval != 0 {
+		interval = c.publishInterval
+	}
+
+	s := newpublishSchedule(interval, c.publishJitter)
+	s.clock = c.clock
+	return s
+}
+
+// SetManualTick stops the client from doing its periodic work on a schedule, so
+// collectors, lazy metrics and metric histories are only collected, and updates held
+// back by a write rate limit are only flushed, when Tick is called. It is meant for
+// testing collectors and lazy metrics one exact step at a time.
+func (c *PCPClient) SetManualTick(manual bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.r.mapped {
+		return ErrClientStarted
+	}
+
+	c.manualTick = manual
+	return nil
+}
+
+// Tick does one cycle of the periodic work of a started client right away,
+// running all enabled collectors once and flushing updates held back by a write
+// rate limit, and returns when it is done. It can be called whether or not
+// SetManualTick is set.
+func (c *PCPClient) Tick() error {
+	c.mutex.Lock()
+	if !c.r.mapped {
+		c.mutex.Unlock()
+		return errors.New("cannot tick a client that is not started")
+	}
+	limiter := c.limiter
+	c.mutex.Unlock()
+
+	c.collectors.collect()
+
+	if limiter != nil {
+		limiter.flush()
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package speed
+
+import (
+	"testing"
+	"time"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestPublishSchedule(t *testing.T) {
+	s := newpublishSchedule(time.Second, 0)
+	if d := s.next(); d != time.Second {
+		t.Errorf("expected an interval of 1s without jitter, got %v", d)
+	}
+
+	s = newpublishSchedule(time.Second, 0.2)
+
+	varied := false
+	for i := 0; i < 100; i++ {
+		d := s.next()
+		if d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("expected an interval within 20%% of 1s, got %v", d)
+		}
+
+		if d != time.Second {
+			varied = true
+		}
+	}
+
+	if !varied {
+		t.Errorf("expected intervals to vary with jitter")
+	}
+}
+
+func TestSetPublishInterval(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	if err = c.SetPublishInterval(0); err == nil {
+		t.Errorf("expected an error setting a publish interval of 0")
+	}
+
+	for _, j := range []float64{-0.1, 1} {
+		if err = c.SetPublishJitter(j); err == nil {
+			t.Errorf("expected an error setting a publish jitter of %v", j)
+		}
+	}
+
+	if s := c.schedule(time.Minute); s.interval != time.Minute {
+		t.Errorf("expected the default interval without a publish interval, got %v", s.interval)
+	}
+
+	if err = c.SetPublishInterval(5 * time.Millisecond); err != nil {
+		t.Fatalf("cannot set publish interval, error: %v", err)
+	}
+
+	if err = c.SetPublishJitter(0.5); err != nil {
+		t.Fatalf("cannot set publish jitter, error: %v", err)
+	}
+
+	m, err := NewPCPCounter(0, "collected")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	col := &countingCollector{m: m}
+	c.MustRegisterCollector(col)
+
+	clock := NewManualClock(time.Unix(0, 0))
+	if err = c.SetClock(clock); err != nil {
+		t.Fatalf("cannot set clock, error: %v", err)
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if err = c.SetPublishInterval(time.Second); err != ErrClientStarted {
+		t.Errorf("expected ErrClientStarted setting the publish interval after start, got %v", err)
+	}
+
+	if err = c.SetPublishJitter(0.1); err != ErrClientStarted {
+		t.Errorf("expected ErrClientStarted setting the publish jitter after start, got %v", err)
+	}
+
+	clock.Advance(100 * time.Millisecond)
+
+	// intervals are at most 7.5ms with a jitter of 0.5, and the collector also runs on Start
+	if n := col.count(); n < 14 {
+		t.Errorf("expected the collector to run at the publish interval, ran %v times", n)
+	}
+}
+
+func TestTick(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	m, err := NewPCPCounter(0, "collected")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	col := &countingCollector{m: m}
+	c.MustRegisterCollector(col)
+
+	limited := newTestCounter(t, c, "limited")
+
+	if err = c.SetWriteRateLimit(1); err != nil {
+		t.Fatalf("cannot set write rate limit, error: %v", err)
+	}
+
+	if err = c.SetManualTick(true); err != nil {
+		t.Fatalf("cannot set manual tick, error: %v", err)
+	}
+
+	if err = c.Tick(); err == nil {
+		t.Errorf("expected ticking a client that is not started to fail")
+	}
+
+	clock := NewManualClock(time.Unix(0, 0))
+	if err = c.SetClock(clock); err != nil {
+		t.Fatalf("cannot set clock, error: %v", err)
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if err = c.SetManualTick(false); err != ErrClientStarted {
+		t.Errorf("expected ErrClientStarted setting manual tick after start, got %v", err)
+	}
+
+	clock.Advance(time.Hour)
+	if n := col.count(); n != 0 {
+		t.Errorf("expected the collector to only run on Tick, ran %v times", n)
+	}
+
+	limited.Inc(1)
+	limited.Inc(1)
+
+	lookup := func(name string) int64 {
+		v, err := mmvdump.Lookup(c.writer.Bytes(), name)
+		if err != nil {
+			t.Fatalf("cannot lookup %v, error: %v", name, err)
+		}
+		return v.(int64)
+	}
+
+	if v := lookup("limited"); v != 1 {
+		t.Errorf("expected the second update to be held back, got %v written", v)
+	}
+
+	for i := 1; i <= 3; i++ {
+		if err = c.Tick(); err != nil {
+			t.Fatalf("cannot tick, error: %v", err)
+		}
+
+		if n := col.count(); n != i {
+			t.Errorf("expected the collector to run %v times, ran %v times", i, n)
+		}
+	}
+
+	if v := lookup("collected"); v != 3 {
+		t.Errorf("expected collected to be written as 3, got %v", v)
+	}
+
+	if v := lookup("limited"); v != 2 {
+		t.Errorf("expected the held back update to be flushed by Tick, got %v written", v)
+	}
+}
@@ -0,0 +1,147 @@
+package speed
+
+import (
+	"fmt"
+
+	histogram "github.com/codahale/hdrhistogram"
+)
+
+// PCPQuantileHistogram records values, like latencies, in a high dynamic range histogram,
+// publishing their 50th, 90th and 99th percentiles, minimum, maximum and mean as the
+// instances p50, p90, p99, min, max and mean of a single metric.
+//
+// Unlike PCPHistogram, recording a value does not touch the mapping, as computing the
+// percentiles takes a walk over the histogram. They are computed every time the client
+// runs its collectors instead, once on Start and then at every publish interval, see
+// SetPublishInterval, so a histogram that is not registered publishes nothing.
+type PCPQuantileHistogram struct {
+	*pcpInstanceMetric
+	h *histogram.Histogram
+}
+
+// the quantiles published by a PCPQuantileHistogram, by instance
+var quantileInstances = []struct {
+	name     string
+	quantile float64
+}{
+	{"p50", 50},
+	{"p90", 90},
+	{"p99", 99},
+}
+
+// NewPCPQuantileHistogram creates a new PCPQuantileHistogram recording values in unit
+// between low and high, with sigfigures significant figures, which are limited like the
+// ones of NewPCPHistogram. Optionally, a couple of description strings may be passed as
+// the short and long descriptions of the metric.
+//
+// Internally it creates a PCP InstanceMetric with DoubleType, InstantSemantics and unit.
+func NewPCPQuantileHistogram(name string, low, high int64, sigfigures int, unit MetricUnit, desc ...string) (*PCPQuantileHistogram, error) {
+	if low > high {
+		return nil, &OpError{"create metric", name, "", fmt.Errorf("low %v cannot be larger than high %v", low, high)}
+	}
+
+	low, high, sigfigures = normalize(low, high, sigfigures)
+
+	instances := []string{"min", "max", "mean"}
+	for _, q := range quantileInstances {
+		instances = append(instances, q.name)
+	}
+
+	vals := make(Instances)
+	for _, s := range instances {
+		vals[s] = float64(0)
+	}
+
+	m, err := generateInstanceMetric(vals, name, instances, DoubleType, InstantSemantics, unit, desc...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PCPQuantileHistogram{m, histogram.New(low, high, sigfigures)}, nil
+}
+
+// Record records a new value.
+func (h *PCPQuantileHistogram) Record(val int64) error {
+	return h.RecordN(val, 1)
+}
+
+// MustRecord panics if Record fails.
+func (h *PCPQuantileHistogram) MustRecord(val int64) {
+	must("record", h.name, h.client, h.Record(val))
+}
+
+// RecordN records the same value n times.
+func (h *PCPQuantileHistogram) RecordN(val, n int64) error {
+	if !h.enabled() {
+		return nil
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if err := h.h.RecordValues(val, n); err != nil {
+		return h.opError("record", "", err)
+	}
+
+	return nil
+}
+
+// MustRecordN panics if RecordN fails.
+func (h *PCPQuantileHistogram) MustRecordN(val, n int64) {
+	must("record", h.name, h.client, h.RecordN(val, n))
+}
+
+// Percentile returns the value at the passed percentile of all values recorded so far,
+// which need not be published yet.
+func (h *PCPQuantileHistogram) Percentile(p float64) int64 {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.h.ValueAtQuantile(p)
+}
+
+// Count returns the number of values recorded so far.
+func (h *PCPQuantileHistogram) Count() int64 {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.h.TotalCount()
+}
+
+// collect publishes the quantiles of the values recorded so far.
+func (h *PCPQuantileHistogram) collect() error {
+	if !h.enabled() {
+		return nil
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	vals := map[string]float64{
+		"min":  float64(h.h.Min()),
+		"max":  float64(h.h.Max()),
+		"mean": h.h.Mean(),
+	}
+	for _, q := range quantileInstances {
+		vals[q.name] = float64(h.h.ValueAtQuantile(q.quantile))
+	}
+
+	for instance, val := range vals {
+		if h.vals[instance].val == val {
+			continue
+		}
+
+		if err := h.setInstance(val, instance); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reset clears all recorded values.
+func (h *PCPQuantileHistogram) reset() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.h.Reset()
+	return h.resetInstances()
+}
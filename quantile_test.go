@@ -0,0 +1,74 @@
+package speed
+
+import (
+	"math"
+	"testing"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestPCPQuantileHistogram(t *testing.T) {
+	if _, err := NewPCPQuantileHistogram("latency", 100, 10, 3, MicrosecondUnit); err == nil {
+		t.Errorf("expected creating a histogram with low larger than high to fail")
+	}
+
+	h, err := NewPCPQuantileHistogram("latency", 0, 1000000, 3, MicrosecondUnit)
+	if err != nil {
+		t.Fatalf("cannot create histogram, error: %v", err)
+	}
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	if err = c.SetManualTick(true); err != nil {
+		t.Fatal(err)
+	}
+	c.MustRegister(h)
+	c.MustStart()
+	defer c.MustStop()
+
+	for i := int64(1); i <= 100; i++ {
+		h.MustRecord(i * 10)
+	}
+	h.MustRecordN(5000, 0)
+
+	if err = h.Record(1 << 40); err == nil {
+		t.Errorf("expected recording a value out of range to fail")
+	}
+
+	// nothing is published until the client collects
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "latency[p50]"); err != nil || v != float64(0) {
+		t.Errorf("expected p50 to be 0 before a tick, got %v, error: %v", v, err)
+	}
+
+	if err = c.Tick(); err != nil {
+		t.Fatalf("cannot tick, error: %v", err)
+	}
+
+	expected := map[string]float64{"min": 10, "max": 1000, "mean": 505, "p50": 500, "p90": 900, "p99": 990}
+	for instance, val := range expected {
+		v, err := mmvdump.Lookup(c.writer.Bytes(), "latency["+instance+"]")
+		if err != nil {
+			t.Errorf("cannot look up %v, error: %v", instance, err)
+		} else if math.Abs(v.(float64)-val) > val/100 {
+			t.Errorf("expected %v to be about %v, got %v", instance, val, v)
+		}
+	}
+
+	if h.Count() != 100 {
+		t.Errorf("expected 100 values to be recorded, got %v", h.Count())
+	}
+
+	if p := h.Percentile(50); p != 500 {
+		t.Errorf("expected the 50th percentile to be 500, got %v", p)
+	}
+
+	if err = h.reset(); err != nil {
+		t.Fatalf("cannot reset histogram, error: %v", err)
+	}
+
+	if h.Count() != 0 {
+		t.Errorf("expected no values after a reset, got %v", h.Count())
+	}
+}
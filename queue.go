@@ -0,0 +1,117 @@
+package speed
+
+import "time"
+
+// QueueHooks maintain the metrics of a queue instrumented using InstrumentQueue,
+// and are called by the queue as items move through it. They are safe for concurrent use.
+type QueueHooks struct {
+	client     *PCPClient
+	clock      Clock
+	depth      *PCPGauge
+	enqueued   *PCPCounter
+	dequeued   *PCPCounter
+	completed  *PCPCounter
+	wait       *PCPHistogram
+	processing *PCPHistogram
+}
+
+// InstrumentQueue registers metrics for a queue with the client, all named under name,
+// and returns the hooks maintaining them. The registered metrics are
+//
+// name.depth, the number of items enqueued and not yet dequeued
+//
+// name.enqueued, name.dequeued and name.completed, counting the items enqueued,
+// dequeued and completed, whose rates PCP reports as the throughput of the queue
+//
+// name.wait, a histogram of the time items waited in the queue, in microseconds
+//
+// name.processing, a histogram of the time from dequeueing items to completing them,
+// in microseconds
+//
+// The hooks read the time from the clock the client has when the queue is instrumented.
+func InstrumentQueue(c *PCPClient, name string) (*QueueHooks, error) {
+	q := &QueueHooks{client: c, clock: c.getClock()}
+
+	var err error
+	if q.depth, err = NewPCPGauge(0, name+".depth", "items in the queue"); err != nil {
+		return nil, err
+	}
+
+	counters := []struct {
+		counter **PCPCounter
+		name    string
+		desc    string
+	}{
+		{&q.enqueued, "enqueued", "items enqueued"},
+		{&q.dequeued, "dequeued", "items dequeued"},
+		{&q.completed, "completed", "items completed after being dequeued"},
+	}
+
+	for _, ct := range counters {
+		if *ct.counter, err = NewPCPCounter(0, name+"."+ct.name, ct.desc); err != nil {
+			return nil, err
+		}
+	}
+
+	if q.wait, err = NewPCPHistogram(name+".wait", 0, HistogramMax, 3, MicrosecondUnit, "time items waited in the queue"); err != nil {
+		return nil, err
+	}
+
+	if q.processing, err = NewPCPHistogram(name+".processing", 0, HistogramMax, 3, MicrosecondUnit, "time from dequeueing items to completing them"); err != nil {
+		return nil, err
+	}
+
+	for _, m := range []Metric{q.depth, q.enqueued, q.dequeued, q.completed, q.wait, q.processing} {
+		if err = c.Register(m); err != nil {
+			return nil, err
+		}
+	}
+
+	return q, nil
+}
+
+// MustInstrumentQueue panics if InstrumentQueue fails.
+func MustInstrumentQueue(c *PCPClient, name string) *QueueHooks {
+	q, err := InstrumentQueue(c, name)
+	must("instrument queue", name, c.name(), err)
+	return q
+}
+
+// OnEnqueue is called when an item is enqueued, and returns the time it was enqueued at,
+// to be kept with the item and passed to OnDequeue.
+func (q *QueueHooks) OnEnqueue() time.Time {
+	q.client.reportError(q.depth.Inc(1))
+	q.client.reportError(q.enqueued.Inc(1))
+	return q.clock.Now()
+}
+
+// OnDequeue is called when an item enqueued at the passed time is dequeued, and returns
+// the time it was dequeued at, to be passed to OnComplete once the item is processed.
+func (q *QueueHooks) OnDequeue(enqueued time.Time) time.Time {
+	now := q.clock.Now()
+
+	q.client.reportError(q.depth.Dec(1))
+	q.client.reportError(q.dequeued.Inc(1))
+	q.client.reportError(q.wait.Record(microseconds(now.Sub(enqueued))))
+	return now
+}
+
+// OnComplete is called when processing an item dequeued at the passed time is complete.
+func (q *QueueHooks) OnComplete(dequeued time.Time) {
+	q.client.reportError(q.completed.Inc(1))
+	q.client.reportError(q.processing.Record(microseconds(q.clock.Now().Sub(dequeued))))
+}
+
+// microseconds returns a duration in microseconds, clamped to the range of a histogram
+func microseconds(d time.Duration) int64 {
+	us := int64(d / time.Microsecond)
+	if us < HistogramMin {
+		return HistogramMin
+	}
+
+	if us > HistogramMax {
+		return HistogramMax
+	}
+
+	return us
+}
@@ -0,0 +1,60 @@
+package speed
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestInstrumentQueue(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	clock := NewManualClock(time.Unix(1000, 0))
+	if err = c.SetClock(clock); err != nil {
+		t.Fatalf("cannot set clock, error: %v", err)
+	}
+
+	q := MustInstrumentQueue(c, "jobs")
+
+	if _, err = InstrumentQueue(c, "jobs"); err == nil {
+		t.Errorf("expected instrumenting a queue twice under the same name to fail")
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	a, b := q.OnEnqueue(), q.OnEnqueue()
+	clock.Advance(3 * time.Millisecond)
+	da := q.OnDequeue(a)
+	clock.Advance(2 * time.Millisecond)
+	q.OnComplete(da)
+	db := q.OnDequeue(b)
+	clock.Advance(4 * time.Millisecond)
+	q.OnComplete(db)
+	q.OnEnqueue()
+
+	expected := map[string]interface{}{
+		"jobs.depth":           float64(1),
+		"jobs.enqueued":        int64(3),
+		"jobs.dequeued":        int64(2),
+		"jobs.completed":       int64(2),
+		"jobs.wait[min]":       float64(3000),
+		"jobs.processing[min]": float64(2000),
+	}
+
+	for name, val := range expected {
+		if v, err := mmvdump.Lookup(c.writer.Bytes(), name); err != nil || v != val {
+			t.Errorf("expected %v to be %v, got %v, error: %v", name, val, v, err)
+		}
+	}
+
+	// histograms keep 3 significant figures
+	if v, err := mmvdump.Lookup(c.writer.Bytes(), "jobs.wait[max]"); err != nil || math.Abs(v.(float64)-5000) > 5 {
+		t.Errorf("expected the longest wait to be about 5000us, got %v, error: %v", v, err)
+	}
+}
@@ -0,0 +1,174 @@
+package speed
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateSuffix is appended to the name of a counter registered using WithRate
+// to name the metric its rate is published as.
+const RateSuffix = "_rate"
+
+// counterRate is a lazy metric publishing the rate per second of a counter,
+// computed every time the client runs its collectors.
+type counterRate struct {
+	*pcpSingletonMetric
+	source   PCPMetric
+	factor   float64       // converts increases of the source to the unit of the rate
+	halfLife time.Duration // of the weight of a rate in the average, 0 to not average
+
+	state sync.Mutex // guards the fields below
+	clock Clock
+	last  float64   // value of the source at the last collection
+	at    time.Time // time of the last collection
+	seen  bool      // whether the source was collected
+	rated bool      // whether a rate was computed
+}
+
+// pmapiUnit is a MetricUnit given by its PMAPI representation, for units combining
+// dimensions, like bytes per second
+type pmapiUnit uint32
+
+// PMAPI returns the PMAPI representation of the unit.
+func (u pmapiUnit) PMAPI() uint32 { return uint32(u) }
+
+// secondsPerTimeUnit are the seconds in every TimeUnit, by scale
+var secondsPerTimeUnit = []float64{1e-9, 1e-6, 1e-3, 1, 60, 3600}
+
+// rateUnit returns the unit of the rate per second of a counter in unit u, and the factor
+// converting increases of the counter to that unit. The rate of a counter of time is the
+// fraction of time it counts, with no unit.
+func rateUnit(u MetricUnit) (MetricUnit, float64) {
+	if t, ok := u.(TimeUnit); ok {
+		if s := int(t >> 12 & 0xf); s < len(secondsPerTimeUnit) {
+			return pmapiUnit(0), secondsPerTimeUnit[s]
+		}
+	}
+
+	// a time dimension of -1 in 4 bits, and a time scale of seconds
+	return pmapiUnit(u.PMAPI() | 0xf<<24 | uint32(SecondUnit)&0xf000), 1
+}
+
+// floatValue returns a numeric value as a float64
+func floatValue(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case int32:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// newcounterRate creates the metric publishing the rate of a counter
+func newcounterRate(source PCPMetric, halfLife time.Duration) (*counterRate, error) {
+	unit, factor := rateUnit(source.Unit())
+
+	d, err := newpcpMetricDesc(source.Name()+RateSuffix, DoubleType, InstantSemantics, unit, "rate per second of "+source.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	sm, err := newpcpSingletonMetric(float64(0), d)
+	if err != nil {
+		return nil, err
+	}
+
+	return &counterRate{pcpSingletonMetric: sm, source: source, factor: factor, halfLife: halfLife, clock: SystemClock}, nil
+}
+
+// setClock sets the clock the rate is computed with.
+func (r *counterRate) setClock(clock Clock) {
+	r.state.Lock()
+	defer r.state.Unlock()
+
+	r.clock = clock
+}
+
+// collect publishes the rate of the source since the last collection, averaged with
+// the previous rates if a half life is set. A source that went backwards was reset,
+// and the rate is only computed again at the next collection.
+func (r *counterRate) collect() error {
+	if !r.enabled() {
+		return nil
+	}
+
+	val, ok := floatValue(metricValue(r.source))
+	if !ok {
+		return nil
+	}
+
+	r.state.Lock()
+	defer r.state.Unlock()
+
+	now := r.clock.Now()
+	last, at, seen := r.last, r.at, r.seen
+	r.last, r.at, r.seen = val, now, true
+
+	dt := now.Sub(at)
+	if !seen || val < last || dt <= 0 {
+		return nil
+	}
+
+	rate := (val - last) * r.factor / dt.Seconds()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.rated && r.halfLife > 0 {
+		// the weight of the previous average halves every half life
+		w := math.Exp2(-float64(dt) / float64(r.halfLife))
+		rate = w*r.value().(float64) + (1-w)*rate
+	}
+
+	r.rated = true
+	return r.set(rate)
+}
+
+// WithRate publishes the rate per second of a counter as another metric, named after the
+// counter followed by RateSuffix, for readers of the mapping that do not compute rates,
+// unlike PCP, like exporters reading it directly. The rate is computed every publish
+// interval, see SetPublishInterval, as an exponentially weighted moving average of the
+// rates over every interval, where the weight of a rate halves every halfLife, or as the
+// rate over the last interval if halfLife is 0.
+//
+// The rate of a counter of bytes is in bytes per second, and the rate of a counter of
+// time is the fraction of time it counts. It has no effect on metrics with instances
+// and metrics that are not numeric counters.
+func WithRate(halfLife time.Duration) RegisterOption {
+	return func(c *PCPClient, m Metric) error {
+		pm, ok := m.(PCPMetric)
+		if !ok || pm.Indom() != nil || pm.Semantics() != CounterSemantics || pm.Type() == StringType {
+			return nil
+		}
+
+		r, err := newcounterRate(pm, halfLife)
+		if err != nil {
+			return err
+		}
+
+		if err = c.checkMetric(r); err != nil {
+			return err
+		}
+
+		// the rate is registered along with the counter, so neither is without the other
+		c.mutex.Lock()
+		attached := c.attachMetric(m, r)
+		c.mutex.Unlock()
+
+		if !attached {
+			return c.Register(r)
+		}
+
+		return nil
+	}
+}
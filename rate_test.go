@@ -0,0 +1,102 @@
+package speed
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestRateUnit(t *testing.T) {
+	if u, f := rateUnit(ByteUnit); u.PMAPI() != 0x1f003000 || f != 1 {
+		t.Errorf("expected bytes per second, got %x and factor %v", u.PMAPI(), f)
+	}
+
+	if u, f := rateUnit(MillisecondUnit); u.PMAPI() != 0 || f != 1e-3 {
+		t.Errorf("expected no unit and a factor of 1e-3 for milliseconds, got %x and %v", u.PMAPI(), f)
+	}
+}
+
+func TestWithRate(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	clock := NewManualClock(time.Unix(1000, 0))
+	if err = c.SetClock(clock); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SetManualTick(true); err != nil {
+		t.Fatal(err)
+	}
+
+	instant, err := NewPCPCounter(0, "requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	averaged, err := NewPCPCounter(0, "bytes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gauge, err := NewPCPGauge(0, "gauge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.MustRegister(instant, WithRate(0))
+	c.MustRegister(averaged, WithRate(10*time.Second))
+	c.MustRegister(gauge, WithRate(0))
+
+	if c.r.HasMetric("gauge" + RateSuffix) {
+		t.Errorf("expected no rate for a gauge")
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	rate := func(name string) float64 {
+		v, err := mmvdump.Lookup(c.writer.Bytes(), name+RateSuffix)
+		if err != nil {
+			t.Fatalf("cannot look up the rate of %v, error: %v", name, err)
+		}
+		return v.(float64)
+	}
+
+	tick := func(d time.Duration, inc int64) {
+		instant.MustInc(inc)
+		averaged.MustInc(inc)
+		clock.Advance(d)
+		if err := c.Tick(); err != nil {
+			t.Fatalf("cannot tick, error: %v", err)
+		}
+	}
+
+	// with manual ticks, the first tick only samples the counters
+	tick(time.Second, 50)
+	if r := rate("requests"); r != 0 {
+		t.Errorf("expected no rate before the second tick, got %v", r)
+	}
+
+	tick(10*time.Second, 100)
+	if r := rate("requests"); r != 10 {
+		t.Errorf("expected a rate of 10, got %v", r)
+	}
+
+	if r := rate("bytes"); r != 10 {
+		t.Errorf("expected the first average to be the first rate, got %v", r)
+	}
+
+	tick(10*time.Second, 300)
+	if r := rate("requests"); r != 30 {
+		t.Errorf("expected a rate of 30, got %v", r)
+	}
+
+	// the previous average has half the weight after a half life
+	if r := rate("bytes"); math.Abs(r-20) > 1e-9 {
+		t.Errorf("expected an average of 20, got %v", r)
+	}
+}
@@ -0,0 +1,149 @@
+package speed
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/performancecopilot/speed/bytewriter"
+)
+
+// WriteLimiterFlushInterval is the interval at which updates held back by a
+// write rate limit are written to the mapping, unless a publish interval is set
+// using SetPublishInterval.
+var WriteLimiterFlushInterval = 100 * time.Millisecond
+
+// CoalescedWritesMetricName is the name of the counter registered by SetWriteRateLimit,
+// counting the updates that were held back instead of being written immediately.
+const CoalescedWritesMetricName = "speed.writes.coalesced"
+
+// pendingKey identifies a value in a mapping
+type pendingKey struct {
+	writer bytewriter.Writer
+	offset int
+}
+
+// pendingWrite is the latest update to a value that was held back
+type pendingWrite struct {
+	update updateClosure
+	val    interface{}
+}
+
+// writeLimiter limits the rate of writes to a mapping using a token bucket
+// that allows bursts of up to a second worth of writes.
+//
+// Updates made over the limit are not written immediately. Only the latest
+// update to a value is kept, and written the next time pending updates are flushed,
+// so a value in a mapping is never more than one flush interval behind.
+type writeLimiter struct {
+	mutex sync.Mutex
+
+	limit  float64 // writes per second
+	tokens float64
+	last   time.Time
+
+	pending   map[pendingKey]pendingWrite
+	coalesced int64
+
+	metric *PCPCounter // counts coalesced updates
+	stats  *Stats      // stats of the client, counting flushes
+	clock  Clock       // refills the bucket
+
+	cancel func() // stops the schedule, nil if pending updates are not flushed
+}
+
+func newwriteLimiter(limit int, metric *PCPCounter, stats *Stats, clock Clock) *writeLimiter {
+	return &writeLimiter{
+		limit:   float64(limit),
+		tokens:  float64(limit),
+		last:    clock.Now(),
+		pending: make(map[pendingKey]pendingWrite),
+		metric:  metric,
+		stats:   stats,
+		clock:   clock,
+	}
+}
+
+// allow takes a token from the bucket if one is available
+func (l *writeLimiter) allow() bool {
+	now := l.clock.Now()
+
+	l.tokens += now.Sub(l.last).Seconds() * l.limit
+	if l.tokens > l.limit {
+		l.tokens = l.limit
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+
+	l.tokens--
+	return true
+}
+
+// wrap returns an update closure that writes through the limiter
+func (l *writeLimiter) wrap(update updateClosure, writer bytewriter.Writer, offset int) updateClosure {
+	key := pendingKey{writer, offset}
+
+	return func(val interface{}) error {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+
+		if !l.allow() {
+			l.pending[key] = pendingWrite{update, val}
+			l.coalesced++
+			return nil
+		}
+
+		delete(l.pending, key)
+		return update(val)
+	}
+}
+
+// flush writes all pending updates, and updates the count of coalesced updates
+func (l *writeLimiter) flush() {
+	l.mutex.Lock()
+
+	for key, w := range l.pending {
+		if err := w.update(w.val); err != nil && logging {
+			clientlogger.WithField("error", err).Error("cannot write a held back update")
+		}
+		delete(l.pending, key)
+	}
+
+	coalesced := l.coalesced
+	l.mutex.Unlock()
+
+	atomic.AddUint64(&l.stats.Flushes, 1)
+
+	// the counter is not written through the limiter, so it can be set outside the lock
+	_ = l.metric.Set(coalesced)
+}
+
+// forget drops all pending updates to a mapping that is about to be removed
+func (l *writeLimiter) forget(writer bytewriter.Writer) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for key := range l.pending {
+		if key.writer == writer {
+			delete(l.pending, key)
+		}
+	}
+}
+
+// start flushes pending updates on the schedule until stop is called.
+func (l *writeLimiter) start(s *publishSchedule) {
+	l.cancel = s.start(l.flush)
+}
+
+// stop stops flushing and waits for a running flush to finish.
+func (l *writeLimiter) stop() {
+	if l.cancel == nil {
+		return
+	}
+
+	l.cancel()
+	l.cancel = nil
+}
@@ -0,0 +1,68 @@
+package speed
+
+import (
+	"testing"
+	"time"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestWriteRateLimit(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	if err = c.SetWriteRateLimit(0); err == nil {
+		t.Errorf("expected a write rate limit of 0 to fail")
+	}
+
+	if err = c.SetWriteRateLimit(10); err != nil {
+		t.Fatalf("cannot set write rate limit, error: %v", err)
+	}
+
+	m, err := NewPCPCounter(0, "limited.counter")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+	c.MustRegister(m)
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if err = c.SetWriteRateLimit(20); err != ErrClientStarted {
+		t.Errorf("expected setting the write rate limit after start to fail with ErrClientStarted, got %v", err)
+	}
+
+	for i := int64(1); i <= 100; i++ {
+		if err = m.Set(i); err != nil {
+			t.Fatalf("cannot set counter, error: %v", err)
+		}
+	}
+
+	lookup := func(name string) int64 {
+		v, err := mmvdump.Lookup(c.writer.Bytes(), name)
+		if err != nil {
+			t.Fatalf("cannot lookup %v, error: %v", name, err)
+		}
+		return v.(int64)
+	}
+
+	if v := lookup("limited.counter"); v >= 100 {
+		t.Errorf("expected updates over the limit to be held back, got %v written", v)
+	}
+
+	if m.Val() != 100 {
+		t.Errorf("expected held back updates to be stored in the metric, got %v", m.Val())
+	}
+
+	time.Sleep(3 * WriteLimiterFlushInterval)
+
+	if v := lookup("limited.counter"); v != 100 {
+		t.Errorf("expected held back updates to be flushed, got %v written", v)
+	}
+
+	if v := lookup(CoalescedWritesMetricName); v < 80 {
+		t.Errorf("expected at least 80 coalesced writes, got %v", v)
+	}
+}
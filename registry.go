@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"sort"
 	"sync"
 
 	"github.com/Sirupsen/logrus"
@@ -43,6 +44,10 @@ type Registry interface {
 
 	// adds a Metric object after parsing the passed string for Instances and InstanceDomains
 	AddMetricByString(name string, val interface{}, t MetricType, s MetricSemantics, u MetricUnit) (Metric, error)
+
+	// calls the passed function for every metric in the registry ordered by name,
+	// stopping at and returning the first error
+	ForEach(func(Metric) error) error
 }
 
 // PCPRegistry implements a registry for PCP as the client
@@ -54,13 +59,6 @@ type PCPRegistry struct {
 	indomlock   sync.RWMutex
 	metricslock sync.RWMutex
 
-	// offsets
-	instanceoffset int
-	indomoffset    int
-	metricsoffset  int
-	valuesoffset   int
-	stringsoffset  int
-
 	// counts
 	instanceCount int
 	valueCount    int
@@ -68,6 +66,10 @@ type PCPRegistry struct {
 
 	mapped   bool
 	version2 bool // a flag that maintains whether we need to write mmv version 2
+
+	// changes the instances of a registered instance domain, set by the client using
+	// the registry to also write the change to its mapping
+	instanceChanger func(indom *PCPInstanceDomain, instance string, add bool) error
 }
 
 // NewPCPRegistry creates a new PCPRegistry object
@@ -114,6 +116,183 @@ func (r *PCPRegistry) StringCount() int {
 	return r.stringcount
 }
 
+// sortedInstanceDomains returns all instance domains in the registry ordered by name
+func (r *PCPRegistry) sortedInstanceDomains() []*PCPInstanceDomain {
+	r.indomlock.RLock()
+	defer r.indomlock.RUnlock()
+
+	names := make([]string, 0, len(r.instanceDomains))
+	for name := range r.instanceDomains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	indoms := make([]*PCPInstanceDomain, len(names))
+	for i, name := range names {
+		indoms[i] = r.instanceDomains[name]
+	}
+
+	return indoms
+}
+
+// sortedMetrics returns all metrics in the registry ordered by name
+func (r *PCPRegistry) sortedMetrics() []PCPMetric {
+	r.metricslock.RLock()
+	defer r.metricslock.RUnlock()
+
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metrics := make([]PCPMetric, len(names))
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+
+	return metrics
+}
+
+// ForEach calls f for every metric in the registry in ascending order of names,
+// stopping at and returning the first error returned by f.
+// The registry can be modified from f, but the changes are not visible to the current walk.
+func (r *PCPRegistry) ForEach(f func(Metric) error) error {
+	for _, m := range r.sortedMetrics() {
+		if err := f(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RegisteredInstanceDomain describes an instance domain in a registry
+// along with the metrics defined over it
+type RegisteredInstanceDomain struct {
+	InstanceDomain
+	Metrics []Metric // metrics referencing the instance domain, ordered by name
+}
+
+// registeredInstanceDomains returns all instance domains in the registry ordered by name,
+// with the metrics referencing each
+func (r *PCPRegistry) registeredInstanceDomains() []RegisteredInstanceDomain {
+	indoms := r.sortedInstanceDomains()
+
+	pos := make(map[string]int, len(indoms))
+	ans := make([]RegisteredInstanceDomain, len(indoms))
+	for i, indom := range indoms {
+		pos[indom.Name()] = i
+		ans[i].InstanceDomain = indom
+	}
+
+	for _, m := range r.sortedMetrics() {
+		if m.Indom() == nil {
+			continue
+		}
+
+		if i, ok := pos[m.Indom().Name()]; ok {
+			ans[i].Metrics = append(ans[i].Metrics, m)
+		}
+	}
+
+	return ans
+}
+
+// SetInstanceDomainDescription updates the short and long description
+// of a registered instance domain
+func (r *PCPRegistry) SetInstanceDomainDescription(name string, desc ...string) error {
+	if r.mapped {
+		return ErrClientStarted
+	}
+
+	return r.setInstanceDomainDescription(name, desc...)
+}
+
+func (r *PCPRegistry) setInstanceDomainDescription(name string, desc ...string) error {
+	shortDescription, longDescription, err := indomDescription(desc)
+	if err != nil {
+		return err
+	}
+
+	r.indomlock.Lock()
+	defer r.indomlock.Unlock()
+
+	indom, present := r.instanceDomains[name]
+	if !present {
+		return &OpError{"set description of", name, "", fmt.Errorf("%v is not an instance domain of the registry", name)}
+	}
+
+	// update the count of non null strings
+	for _, s := range []string{indom.shortDescription, indom.longDescription} {
+		if s != "" {
+			r.stringcount--
+		}
+	}
+
+	for _, s := range []string{shortDescription, longDescription} {
+		if s != "" {
+			r.stringcount++
+		}
+	}
+
+	indom.shortDescription, indom.longDescription = shortDescription, longDescription
+
+	return nil
+}
+
+func (r *PCPRegistry) setMetricDescription(name string, desc ...string) error {
+	if len(desc) > 2 {
+		return &OpError{"set description of", name, "", errors.New("only 2 optional strings allowed, short and long descriptions")}
+	}
+
+	shortDescription, longDescription := "", ""
+	if len(desc) > 0 {
+		shortDescription = desc[0]
+	}
+	if len(desc) > 1 {
+		longDescription = desc[1]
+	}
+
+	r.metricslock.Lock()
+	defer r.metricslock.Unlock()
+
+	m, present := r.metrics[name]
+	if !present {
+		return &OpError{"set description of", name, "", fmt.Errorf("%v is not a metric of the registry", name)}
+	}
+
+	md := metricDesc(m)
+	if md == nil {
+		return &OpError{"set description of", name, "", fmt.Errorf("the description of %v cannot be changed", name)}
+	}
+
+	// update the count of non null strings
+	for _, s := range []string{md.shortDescription, md.longDescription} {
+		if s != "" {
+			r.stringcount--
+		}
+	}
+
+	for _, s := range []string{shortDescription, longDescription} {
+		if s != "" {
+			r.stringcount++
+		}
+	}
+
+	md.shortDescription, md.longDescription = shortDescription, longDescription
+
+	return nil
+}
+
+// metricDescription returns the short and long description of a registered metric
+func (r *PCPRegistry) metricDescription(m PCPMetric) (string, string) {
+	r.metricslock.RLock()
+	defer r.metricslock.RUnlock()
+
+	return m.ShortDescription(), m.LongDescription()
+}
+
 // HasInstanceDomain returns true if the registry already has an indom of the specified name
 func (r *PCPRegistry) HasInstanceDomain(name string) bool {
 	r.indomlock.RLock()
@@ -134,18 +313,34 @@ func (r *PCPRegistry) HasMetric(name string) bool {
 
 // AddInstanceDomain will add a new instance domain to the current registry
 func (r *PCPRegistry) AddInstanceDomain(indom InstanceDomain) error {
+	if r.mapped {
+		return ErrClientStarted
+	}
+
+	return r.addInstanceDomain(indom)
+}
+
+// addInstanceDomain adds an instance domain, even if the registry is mapped
+func (r *PCPRegistry) addInstanceDomain(indom InstanceDomain) error {
 	if r.HasInstanceDomain(indom.Name()) {
-		return errors.New("InstanceDomain is already defined for the current registry")
+		return &OpError{"register", indom.Name(), "", errors.New("instance domain is already registered")}
 	}
 
 	r.indomlock.Lock()
 	defer r.indomlock.Unlock()
 
-	if r.mapped {
-		return errors.New("Cannot add an indom when a mapping is active")
+	for _, other := range r.instanceDomains {
+		if other.ID() == indom.ID() {
+			return &OpError{"register", indom.Name(), "", fmt.Errorf("instance domain has the same id %v as %v", indom.ID(), other.Name())}
+		}
 	}
 
-	r.instanceDomains[indom.Name()] = indom.(*PCPInstanceDomain)
+	pcpindom := indom.(*PCPInstanceDomain)
+	pcpindom.mutex.Lock()
+	pcpindom.registry = r
+	pcpindom.mutex.Unlock()
+
+	r.instanceDomains[indom.Name()] = pcpindom
 	r.instanceCount += indom.InstanceCount()
 
 	if !r.version2 {
@@ -201,21 +396,303 @@ func (r *PCPRegistry) addMetric(m PCPMetric) {
 	}
 }
 
+// reconcile brings instance domains in line with the metrics over them. Instances
+// added to a metric are added to its instance domain, with the other metrics over it
+// getting zero values for them, and instances deleted from all metrics over their
+// instance domain are removed from the metrics and the instance domain.
+// It returns the number of instances changed.
+func (r *PCPRegistry) reconcile() int {
+	r.metricslock.Lock()
+	defer r.metricslock.Unlock()
+
+	r.indomlock.Lock()
+	defer r.indomlock.Unlock()
+
+	metrics := make(map[*PCPInstanceDomain][]*pcpInstanceMetric)
+	for _, m := range r.metrics {
+		if im, ok := m.(instanceMetric); ok {
+			metric := im.instance()
+			metrics[metric.indom] = append(metrics[metric.indom], metric)
+		}
+	}
+
+	changed := 0
+	for indom, ms := range metrics {
+		for _, m := range ms {
+			m.mutex.Lock()
+		}
+
+		changed += r.addInstances(indom, ms) + r.removeInstances(indom, ms)
+
+		// instances deleted and added again before a reconcile are not bound to a mapping
+		if r.mapped {
+			for _, m := range ms {
+				for _, v := range m.vals {
+					if !v.deleted && v.update == nil {
+						changed++
+					}
+				}
+			}
+		}
+
+		for _, m := range ms {
+			m.mutex.Unlock()
+		}
+	}
+
+	return changed
+}
+
+// addInstances adds the instances added to any of the metrics to their instance domain
+func (r *PCPRegistry) addInstances(indom *PCPInstanceDomain, metrics []*pcpInstanceMetric) int {
+	indom.mutex.Lock()
+	defer indom.mutex.Unlock()
+
+	added := 0
+	for _, m := range metrics {
+		for name := range m.vals {
+			if indom.hasInstance(name) {
+				continue
+			}
+
+			indom.instances[name] = newpcpInstance(name)
+			r.instanceCount++
+			added++
+
+			if len(name) > MaxV1NameLength {
+				r.version2 = true
+			}
+		}
+	}
+
+	if added == 0 {
+		return 0
+	}
+
+	for _, m := range metrics {
+		for name := range indom.instances {
+			if _, ok := m.vals[name]; !ok {
+				m.vals[name] = newinstanceValue(m.t.zero())
+			}
+		}
+	}
+
+	r.valueCount += added * len(metrics)
+	for _, m := range metrics {
+		if m.t == StringType {
+			r.stringcount += added
+		}
+	}
+
+	return added
+}
+
+// removeInstances removes the instances deleted from all metrics from the metrics
+// and their instance domain
+func (r *PCPRegistry) removeInstances(indom *PCPInstanceDomain, metrics []*pcpInstanceMetric) int {
+	indom.mutex.Lock()
+	defer indom.mutex.Unlock()
+
+	removed := 0
+	for name := range indom.instances {
+		deleted := true
+		for _, m := range metrics {
+			deleted = deleted && m.vals[name].deleted
+		}
+
+		if !deleted {
+			continue
+		}
+
+		delete(indom.instances, name)
+		r.instanceCount--
+		removed++
+
+		for _, m := range metrics {
+			delete(m.vals, name)
+			r.valueCount--
+
+			if m.t == StringType {
+				r.stringcount--
+			}
+		}
+	}
+
+	return removed
+}
+
+// adoptInstances brings the values of a metric in line with its instance domain, whose
+// instances could have changed since the metric was created, see AddInstance.
+func adoptInstances(m *pcpInstanceMetric) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.indom.mutex.RLock()
+	defer m.indom.mutex.RUnlock()
+
+	for name := range m.indom.instances {
+		if _, ok := m.vals[name]; !ok {
+			m.vals[name] = newinstanceValue(m.t.zero())
+		}
+	}
+
+	for name, v := range m.vals {
+		if !m.indom.hasInstance(name) {
+			v.deleted, v.update = true, nil
+			delete(m.vals, name)
+		}
+	}
+}
+
+// changeInstance adds or removes an instance of a registered instance domain
+func (r *PCPRegistry) changeInstance(indom *PCPInstanceDomain, instance string, add bool) error {
+	if r.instanceChanger != nil {
+		return r.instanceChanger(indom, instance, add)
+	}
+
+	return r.applyInstanceChange(indom, instance, add)
+}
+
+// applyInstanceChange adds an instance to a registered instance domain and a zero value
+// for it to the metrics over it, or removes an instance and its values.
+func (r *PCPRegistry) applyInstanceChange(indom *PCPInstanceDomain, instance string, add bool) error {
+	r.metricslock.Lock()
+	defer r.metricslock.Unlock()
+
+	r.indomlock.Lock()
+	defer r.indomlock.Unlock()
+
+	var metrics []*pcpInstanceMetric
+	for _, m := range r.metrics {
+		if im, ok := m.(instanceMetric); ok && im.instance().indom == indom {
+			metrics = append(metrics, im.instance())
+		}
+	}
+
+	// metrics are locked before the instance domain, like when they read its instances
+	for _, m := range metrics {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+	}
+
+	indom.mutex.Lock()
+	defer indom.mutex.Unlock()
+
+	if err := checkChange(indom, instance, add); err != nil {
+		return err
+	}
+
+	if add {
+		indom.instances[instance] = newpcpInstance(instance)
+		r.instanceCount++
+
+		if len(instance) > MaxV1NameLength {
+			r.version2 = true
+		}
+	} else {
+		delete(indom.instances, instance)
+		r.instanceCount--
+	}
+
+	for _, m := range metrics {
+		n := 1
+		if add {
+			m.vals[instance] = newinstanceValue(m.t.zero())
+		} else {
+			// handles to the instance see it deleted
+			m.vals[instance].deleted, m.vals[instance].update = true, nil
+			delete(m.vals, instance)
+			n = -1
+		}
+
+		r.valueCount += n
+		if m.t == StringType {
+			r.stringcount += n
+		}
+	}
+
+	return nil
+}
+
+// registrySnapshot holds the parts of a registry changed by adding metrics and
+// instance domains, so an addition that cannot be mapped can be undone
+type registrySnapshot struct {
+	instanceDomains map[string]*PCPInstanceDomain
+	metrics         map[string]PCPMetric
+
+	instanceCount, valueCount, stringcount int
+	version2                               bool
+}
+
+// snapshot returns the current metrics and instance domains of the registry
+func (r *PCPRegistry) snapshot() *registrySnapshot {
+	r.indomlock.RLock()
+	defer r.indomlock.RUnlock()
+
+	r.metricslock.RLock()
+	defer r.metricslock.RUnlock()
+
+	s := &registrySnapshot{
+		instanceDomains: make(map[string]*PCPInstanceDomain, len(r.instanceDomains)),
+		metrics:         make(map[string]PCPMetric, len(r.metrics)),
+		instanceCount:   r.instanceCount,
+		valueCount:      r.valueCount,
+		stringcount:     r.stringcount,
+		version2:        r.version2,
+	}
+
+	for name, indom := range r.instanceDomains {
+		s.instanceDomains[name] = indom
+	}
+
+	for name, m := range r.metrics {
+		s.metrics[name] = m
+	}
+
+	return s
+}
+
+// restore removes the metrics and instance domains added since the snapshot was taken
+func (r *PCPRegistry) restore(s *registrySnapshot) {
+	r.indomlock.Lock()
+	defer r.indomlock.Unlock()
+
+	r.metricslock.Lock()
+	defer r.metricslock.Unlock()
+
+	for name, indom := range r.instanceDomains {
+		if _, ok := s.instanceDomains[name]; !ok {
+			indom.mutex.Lock()
+			indom.registry = nil
+			indom.mutex.Unlock()
+		}
+	}
+
+	r.instanceDomains, r.metrics = s.instanceDomains, s.metrics
+	r.instanceCount, r.valueCount, r.stringcount = s.instanceCount, s.valueCount, s.stringcount
+	r.version2 = s.version2
+}
+
 // AddMetric will add a new metric to the current registry
 func (r *PCPRegistry) AddMetric(m Metric) error {
 	if r.mapped {
-		return errors.New("cannot add a metric when a mapping is active")
+		return ErrClientStarted
 	}
 
+	return r.add(m)
+}
+
+// add adds a metric, even if the registry is mapped, see PCPClient.Register
+func (r *PCPRegistry) add(m Metric) error {
 	if r.HasMetric(m.Name()) {
-		return errors.New("metric is already defined for the current registry")
+		return &OpError{"register", m.Name(), "", errors.New("metric is already registered")}
 	}
 
 	pcpm := m.(PCPMetric)
 
 	// if it is an indom metric
 	if pcpm.Indom() != nil && !r.HasInstanceDomain(pcpm.Indom().Name()) {
-		err := r.AddInstanceDomain(pcpm.Indom())
+		err := r.addInstanceDomain(pcpm.Indom())
 		if err != nil {
 			return err
 		}
@@ -224,6 +701,10 @@ func (r *PCPRegistry) AddMetric(m Metric) error {
 	r.metricslock.Lock()
 	defer r.metricslock.Unlock()
 
+	if im, ok := m.(instanceMetric); ok {
+		adoptInstances(im.instance())
+	}
+
 	r.addMetric(pcpm)
 
 	if logging {
@@ -241,8 +722,17 @@ func (r *PCPRegistry) AddMetric(m Metric) error {
 
 // AddInstanceDomainByName adds an instance domain using passed parameters
 func (r *PCPRegistry) AddInstanceDomainByName(name string, instances []string) (InstanceDomain, error) {
+	if r.mapped {
+		return nil, ErrClientStarted
+	}
+
+	return r.addInstanceDomainByName(name, instances)
+}
+
+// addInstanceDomainByName adds an instance domain, even if the registry is mapped
+func (r *PCPRegistry) addInstanceDomainByName(name string, instances []string) (InstanceDomain, error) {
 	if r.HasInstanceDomain(name) {
-		return nil, errors.New("The InstanceDomain already exists for this registry")
+		return nil, &OpError{"register", name, "", errors.New("instance domain is already registered")}
 	}
 
 	indom, err := NewPCPInstanceDomain(name, instances)
@@ -250,7 +740,7 @@ func (r *PCPRegistry) AddInstanceDomainByName(name string, instances []string) (
 		return nil, err
 	}
 
-	err = r.AddInstanceDomain(indom)
+	err = r.addInstanceDomain(indom)
 	if err != nil {
 		return nil, err
 	}
@@ -268,7 +758,7 @@ var reg, _ = regexp.Compile(pattern)
 
 func parseString(s string) (metric string, indom string, instances []string, err error) {
 	if !reg.MatchString(s) {
-		return "", "", nil, errors.New("Invalid String")
+		return "", "", nil, &OpError{"register", s, "", errors.New("expected a metric name of the form metric or indom[instances].metric")}
 	}
 
 	matches := reg.FindStringSubmatch(s)
@@ -294,7 +784,7 @@ func (r *PCPRegistry) addSingletonMetricByString(name string, val interface{}, t
 		return nil, err
 	}
 
-	err = r.AddMetric(m)
+	err = r.add(m)
 	if err != nil {
 		return nil, err
 	}
@@ -306,7 +796,7 @@ func (r *PCPRegistry) addInstanceMetricByString(name string, val interface{}, in
 	// instance metric
 	mp, ok := val.(Instances)
 	if !ok {
-		return nil, errors.New("to define an instance metric, a Instances type is required")
+		return nil, &OpError{"register", name, "", fmt.Errorf("to define an instance metric, a Instances type is required, got %T", val)}
 	}
 
 	var (
@@ -315,14 +805,14 @@ func (r *PCPRegistry) addInstanceMetricByString(name string, val interface{}, in
 	)
 
 	if !r.HasInstanceDomain(indom) {
-		id, err = r.AddInstanceDomainByName(indom, instances)
+		id, err = r.addInstanceDomainByName(indom, instances)
 		if err != nil {
 			return nil, err
 		}
 	} else if r.instanceDomains[indom].MatchInstances(instances) {
 		id = r.instanceDomains[indom]
 	} else {
-		return nil, fmt.Errorf("a different instance domain under the name %v already exists in the registry", indom)
+		return nil, &OpError{"register", name, "", fmt.Errorf("a different instance domain under the name %v already exists in the registry", indom)}
 	}
 
 	m, err := NewPCPInstanceMetric(mp, name, id.(*PCPInstanceDomain), t, s, u)
@@ -330,7 +820,7 @@ func (r *PCPRegistry) addInstanceMetricByString(name string, val interface{}, in
 		return nil, err
 	}
 
-	err = r.AddMetric(m)
+	err = r.add(m)
 	if err != nil {
 		return nil, err
 	}
@@ -340,6 +830,15 @@ func (r *PCPRegistry) addInstanceMetricByString(name string, val interface{}, in
 
 // AddMetricByString dynamically creates a PCPMetric
 func (r *PCPRegistry) AddMetricByString(str string, val interface{}, t MetricType, s MetricSemantics, u MetricUnit) (Metric, error) {
+	if r.mapped {
+		return nil, ErrClientStarted
+	}
+
+	return r.addMetricByString(str, val, t, s, u)
+}
+
+// addMetricByString adds a metric, even if the registry is mapped, see PCPClient.RegisterString
+func (r *PCPRegistry) addMetricByString(str string, val interface{}, t MetricType, s MetricSemantics, u MetricUnit) (Metric, error) {
 	metric, indom, instances, err := parseString(str)
 	if err != nil {
 		return nil, err
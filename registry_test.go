@@ -1,6 +1,9 @@
 package speed
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func TestIdentifierRegex(t *testing.T) {
 	cases := []struct {
@@ -152,3 +155,45 @@ func TestMMV2MetricRegistration(t *testing.T) {
 		t.Errorf("expected the metric name to be registered in the strings section")
 	}
 }
+
+func TestForEach(t *testing.T) {
+	r := NewPCPRegistry()
+
+	names := []string{"c.metric", "a.metric", "b.metric"}
+	for _, n := range names {
+		if _, err := r.AddMetricByString(n, 1, Int32Type, InstantSemantics, OneUnit); err != nil {
+			t.Fatalf("cannot add metric %v, error: %v", n, err)
+		}
+	}
+
+	var visited []string
+	err := r.ForEach(func(m Metric) error {
+		visited = append(visited, m.Name())
+		return nil
+	})
+	if err != nil {
+		t.Errorf("expected ForEach to not fail, got %v", err)
+	}
+
+	expected := []string{"a.metric", "b.metric", "c.metric"}
+	if len(visited) != len(expected) {
+		t.Fatalf("expected to visit %v, visited %v", expected, visited)
+	}
+
+	for i := range expected {
+		if visited[i] != expected[i] {
+			t.Errorf("expected metric %v to be %v, got %v", i, expected[i], visited[i])
+		}
+	}
+
+	stop := errors.New("stop")
+	count := 0
+	err = r.ForEach(func(m Metric) error {
+		count++
+		return stop
+	})
+
+	if err != stop || count != 1 {
+		t.Errorf("expected ForEach to stop at the first error, called %v times, got %v", count, err)
+	}
+}
@@ -0,0 +1,279 @@
+package speed
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ResetsMetricName is the name of the counter registered by WithResetSchedule,
+// counting the metrics reset on their schedule.
+const ResetsMetricName = "speed.resets"
+
+// ResetSchedule decides when the metrics registered using WithResetSchedule are reset.
+type ResetSchedule interface {
+	// returns the first time after t the metrics are reset at
+	Next(t time.Time) time.Time
+}
+
+// resetEvery resets metrics at every multiple of an interval
+type resetEvery time.Duration
+
+// ResetEvery returns a schedule resetting metrics every d, at the multiples of d since
+// the zero time, so with d of 15 minutes metrics are reset on the hour and at 15, 30 and
+// 45 minutes past the hour, whenever the client is started.
+func ResetEvery(d time.Duration) ResetSchedule { return resetEvery(d) }
+
+func (r resetEvery) Next(t time.Time) time.Time {
+	d := time.Duration(r)
+	if d <= 0 {
+		return time.Time{}
+	}
+
+	return t.Truncate(d).Add(d)
+}
+
+// resetDaily resets metrics at midnight
+type resetDaily struct {
+	loc *time.Location
+}
+
+// ResetDaily returns a schedule resetting metrics every day at midnight in loc,
+// like time.Local, following changes to daylight saving time.
+func ResetDaily(loc *time.Location) ResetSchedule { return resetDaily{loc} }
+
+func (r resetDaily) Next(t time.Time) time.Time {
+	y, m, d := t.In(r.loc).Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, r.loc)
+}
+
+// resetScheduler resets metrics on their schedules while the client is started
+type resetScheduler struct {
+	mutex   sync.Mutex
+	clock   Clock
+	started bool
+	resets  *PCPCounter // counts the metrics reset
+	report  func(error) // passes errors resetting metrics to the error handler
+	groups  map[ResetSchedule]*resetGroup
+}
+
+// resetGroup holds the metrics reset on the same schedule
+type resetGroup struct {
+	metrics []Metric
+	timer   ClockTimer
+	gen     uint64 // incremented every time the timer is set, so stale timers do nothing
+}
+
+func newresetScheduler(resets *PCPCounter, report func(error)) *resetScheduler {
+	return &resetScheduler{resets: resets, report: report, groups: make(map[ResetSchedule]*resetGroup)}
+}
+
+// add resets m on schedule s, starting right away if the client is started
+func (s *resetScheduler) add(schedule ResetSchedule, m Metric) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	g, ok := s.groups[schedule]
+	if !ok {
+		g = &resetGroup{}
+		s.groups[schedule] = g
+
+		if s.started {
+			s.arm(schedule, g)
+		}
+	}
+
+	g.metrics = append(g.metrics, m)
+}
+
+// remove stops resetting m on schedule s
+func (s *resetScheduler) remove(schedule ResetSchedule, m Metric) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	g, ok := s.groups[schedule]
+	if !ok {
+		return
+	}
+
+	for i := len(g.metrics) - 1; i >= 0; i-- {
+		if g.metrics[i] == m {
+			g.metrics = append(g.metrics[:i], g.metrics[i+1:]...)
+			break
+		}
+	}
+
+	if len(g.metrics) == 0 {
+		g.gen++
+		if g.timer != nil {
+			g.timer.Stop()
+			g.timer = nil
+		}
+
+		delete(s.groups, schedule)
+	}
+}
+
+// start resets the metrics on their schedules as read from clock
+func (s *resetScheduler) start(clock Clock) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.clock, s.started = clock, true
+	for schedule, g := range s.groups {
+		s.arm(schedule, g)
+	}
+}
+
+// stop stops resetting metrics
+func (s *resetScheduler) stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.started = false
+	for _, g := range s.groups {
+		g.gen++
+		if g.timer != nil {
+			g.timer.Stop()
+			g.timer = nil
+		}
+	}
+}
+
+// arm sets the timer of a group for the next reset of its schedule, the mutex must be held.
+func (s *resetScheduler) arm(schedule ResetSchedule, g *resetGroup) {
+	now := s.clock.Now()
+
+	next := schedule.Next(now)
+	if !next.After(now) {
+		go s.report(fmt.Errorf("reset schedule %v has no reset after %v", schedule, now))
+		return
+	}
+
+	g.gen++
+	gen := g.gen
+	g.timer = s.clock.AfterFunc(next.Sub(now), func() { s.fire(schedule, g, gen) })
+}
+
+// fire resets the metrics of a group and sets its timer for the next reset
+func (s *resetScheduler) fire(schedule ResetSchedule, g *resetGroup, gen uint64) {
+	var errs []error
+
+	s.mutex.Lock()
+	if !s.started || g.gen != gen {
+		s.mutex.Unlock()
+		return
+	}
+
+	for _, m := range g.metrics {
+		if err := resetMetric(m); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if err := s.resets.Inc(1); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	s.arm(schedule, g)
+	s.mutex.Unlock()
+
+	for _, err := range errs {
+		s.report(err)
+	}
+}
+
+// resetMetric resets a metric that is enabled, like ResetTagged
+func resetMetric(m Metric) error {
+	r, ok := m.(resetter)
+	if !ok {
+		return fmt.Errorf("metric %v cannot be reset", m.Name())
+	}
+
+	if pm, ok := m.(PCPMetric); ok && !metricDesc(pm).enabled() {
+		return nil
+	}
+
+	if err := r.reset(); err != nil {
+		return fmt.Errorf("cannot reset metric %v: %v", m.Name(), err)
+	}
+
+	return nil
+}
+
+// WithResetSchedule resets a metric on a schedule, like ResetEvery(time.Hour) or
+// ResetDaily(time.Local), for metrics reported per window of time, like the orders taken
+// today. Metrics are reset like by ResetTagged, and only while the client is started,
+// with every reset counted by a counter registered under ResetsMetricName.
+//
+// Metrics passed equal schedules are reset together, so schedules have to be comparable,
+// like the ones returned by ResetEvery and ResetDaily, and registering a metric with a
+// schedule that is not fails. Failing to reset a metric is reported to the error handler,
+// see SetErrorHandler.
+func WithResetSchedule(schedule ResetSchedule) RegisterOption {
+	return func(c *PCPClient, m Metric) error {
+		if _, ok := m.(resetter); !ok {
+			return errors.New("metric cannot be reset")
+		}
+
+		if schedule == nil {
+			return errors.New("reset schedule cannot be nil")
+		}
+
+		// schedules are map keys, see resetScheduler.add
+		if !reflect.TypeOf(schedule).Comparable() {
+			return fmt.Errorf("reset schedule of type %T is not comparable", schedule)
+		}
+
+		s, err := c.resetScheduler()
+		if err != nil {
+			return err
+		}
+
+		s.add(schedule, m)
+
+		c.mutex.Lock()
+		c.undoOnFailure(m, func() { s.remove(schedule, m) })
+		c.mutex.Unlock()
+
+		return nil
+	}
+}
+
+// resetScheduler returns the scheduler of resets, registering its counter if needed
+func (c *PCPClient) resetScheduler() (*resetScheduler, error) {
+	c.mutex.Lock()
+	if s := c.resets; s != nil {
+		c.mutex.Unlock()
+		return s, nil
+	}
+
+	m, err := NewPCPCounter(0, ResetsMetricName, "number of metrics reset on their schedule")
+	if err != nil {
+		c.mutex.Unlock()
+		return nil, err
+	}
+
+	// the counter is added while holding the lock, so concurrent first calls
+	// register it once, and all get the same scheduler
+	err = c.addToRegistryLocked(func() error { return c.r.add(m) })
+	if err == nil {
+		c.resets = newresetScheduler(m, c.handleError)
+		if c.r.mapped {
+			c.resets.start(c.clock)
+		}
+	}
+
+	s := c.resets
+	c.unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.registered(m)
+	return s, nil
+}
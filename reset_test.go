@@ -0,0 +1,229 @@
+package speed
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestResetSchedules(t *testing.T) {
+	now := time.Date(2017, 3, 25, 23, 50, 30, 0, time.UTC)
+
+	cases := []struct {
+		schedule ResetSchedule
+		next     time.Time
+	}{
+		{ResetEvery(15 * time.Minute), time.Date(2017, 3, 26, 0, 0, 0, 0, time.UTC)},
+		{ResetEvery(time.Minute), time.Date(2017, 3, 25, 23, 51, 0, 0, time.UTC)},
+		{ResetDaily(time.UTC), time.Date(2017, 3, 26, 0, 0, 0, 0, time.UTC)},
+		{ResetDaily(time.FixedZone("east", 2*3600)), time.Date(2017, 3, 26, 22, 0, 0, 0, time.UTC)},
+	}
+
+	for _, cs := range cases {
+		if next := cs.schedule.Next(now); !next.Equal(cs.next) {
+			t.Errorf("expected the reset after %v on %v to be at %v, got %v", now, cs.schedule, cs.next, next)
+		}
+	}
+
+	if next := ResetEvery(0).Next(now); next.After(now) {
+		t.Errorf("expected an empty interval to have no next reset, got %v", next)
+	}
+}
+
+func TestWithResetSchedule(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	clock := NewManualClock(time.Date(2017, 3, 25, 23, 50, 0, 0, time.UTC))
+	if err = c.SetClock(clock); err != nil {
+		t.Fatalf("cannot set clock, error: %v", err)
+	}
+
+	var errs []error
+	c.SetErrorHandler(func(err error) { errs = append(errs, err) })
+
+	orders, err := NewPCPCounter(0, "orders.today")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	window, err := NewPCPGauge(0, "orders.window")
+	if err != nil {
+		t.Fatalf("cannot create gauge, error: %v", err)
+	}
+
+	c.MustRegister(orders, WithResetSchedule(ResetDaily(time.UTC)))
+	c.MustRegister(window, WithResetSchedule(ResetEvery(15*time.Minute)))
+
+	c.MustStart()
+	defer c.MustStop()
+
+	orders.MustInc(10)
+	window.MustSet(5)
+
+	// midnight resets both
+	clock.Advance(10 * time.Minute)
+
+	if orders.Val() != 0 || window.Val() != 0 {
+		t.Errorf("expected both metrics to be reset at midnight, got %v and %v", orders.Val(), window.Val())
+	}
+
+	orders.MustInc(3)
+	window.MustSet(2)
+
+	clock.Advance(15 * time.Minute)
+
+	if orders.Val() != 3 || window.Val() != 0 {
+		t.Errorf("expected only the window to be reset, got %v and %v", orders.Val(), window.Val())
+	}
+
+	if val, err := mmvdump.Lookup(c.writer.Bytes(), ResetsMetricName); err != nil || val != int64(3) {
+		t.Errorf("expected %v to count 3 resets, got %v, error: %v", ResetsMetricName, val, err)
+	}
+
+	// metrics registered after Start are reset as well
+	late, err := NewPCPCounter(0, "orders.late")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	c.MustRegister(late, WithResetSchedule(ResetEvery(time.Minute)))
+	late.MustInc(1)
+
+	clock.Advance(time.Minute)
+
+	if late.Val() != 0 {
+		t.Errorf("expected a metric registered after Start to be reset, got %v", late.Val())
+	}
+
+	if len(errs) != 0 {
+		t.Errorf("expected no errors, got %v", errs)
+	}
+}
+
+func TestResetScheduleStopsWithClient(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	clock := NewManualClock(time.Date(2017, 3, 25, 12, 0, 0, 0, time.UTC))
+	if err = c.SetClock(clock); err != nil {
+		t.Fatalf("cannot set clock, error: %v", err)
+	}
+
+	counter, err := NewPCPCounter(0, "orders.hourly")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	c.MustRegister(counter, WithResetSchedule(ResetEvery(time.Hour)))
+
+	c.MustStart()
+	counter.MustInc(4)
+	c.MustStop()
+
+	clock.Advance(2 * time.Hour)
+
+	if counter.Val() != 4 {
+		t.Errorf("expected a stopped client to not reset metrics, got %v", counter.Val())
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	clock.Advance(time.Hour)
+
+	if counter.Val() != 0 {
+		t.Errorf("expected a restarted client to reset metrics again, got %v", counter.Val())
+	}
+}
+
+func TestWithResetScheduleErrors(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	counter, err := NewPCPCounter(0, "orders.hourly")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	if err = c.Register(counter, WithResetSchedule(nil)); err == nil {
+		t.Errorf("expected a nil reset schedule to fail registering")
+	}
+
+	if c.r.HasMetric("orders.hourly") {
+		t.Errorf("expected a metric failing an option to not be registered")
+	}
+
+	if err = c.Register(counter, WithResetSchedule(ResetEvery(time.Hour))); err != nil {
+		t.Errorf("expected the metric to register once the option is fixed, got %v", err)
+	}
+}
+
+// scheduleList is a reset schedule that is not comparable
+type scheduleList []time.Duration
+
+func (l scheduleList) Next(t time.Time) time.Time { return t.Add(l[0]) }
+
+func TestWithResetScheduleNotComparable(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	counter, err := NewPCPCounter(0, "orders.hourly")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	if err = c.Register(counter, WithResetSchedule(scheduleList{time.Hour})); err == nil {
+		t.Errorf("expected a schedule that is not comparable to fail registering")
+	}
+}
+
+func TestWithResetScheduleConcurrent(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+
+	// the first registrations using a schedule race to register the counter of resets
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			counter, err := NewPCPCounter(0, fmt.Sprintf("orders.%v", i))
+			if err == nil {
+				err = c.Register(counter, WithResetSchedule(ResetEvery(time.Hour)))
+			}
+			errs[i] = err
+		}(i)
+	}
+
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("expected registration %v to succeed, got %v", i, err)
+		}
+	}
+
+	if !c.r.HasMetric(ResetsMetricName) {
+		t.Errorf("expected %v to be registered", ResetsMetricName)
+	}
+}
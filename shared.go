@@ -0,0 +1,318 @@
+package speed
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/performancecopilot/speed/bytewriter"
+)
+
+// Shared mappings
+//
+// EXPERIMENTAL: cooperating processes, like the workers of a pre-fork server, can
+// publish their metrics through a single mapping instead of one mapping per process.
+// Every process registers the same metrics and instance domains with a client of the
+// same name. One of them is the leader, which creates the mapping and writes all metadata
+// and singleton values. The others are workers, which attach to the mapping of the leader
+// once it is written, and only ever write the values of the instances they claimed.
+//
+// Instances are claimed using ClaimInstances, and are owned by a single process at a time.
+// Ownership is coordinated through a small lock region, a file next to the mapping holding
+// the process identifier of the owner of every instance, which is claimed and released atomically.
+// Instances owned by a process that is gone, like a crashed worker, are free to claim.
+//
+// The layout of a shared mapping cannot change after it is started, so workers must
+// be started after the leader, and restarted along with it.
+
+// SharedMappingRole is the role of a client in a mapping shared by multiple processes.
+type SharedMappingRole int
+
+// Possible values for a SharedMappingRole
+const (
+	NotShared    SharedMappingRole = iota // the client creates a mapping of its own, the default
+	SharedLeader                          // the client creates the shared mapping
+	SharedWorker                          // the client attaches to the mapping of the leader
+)
+
+// ownersTag is the tag at the start of a lock region, followed by the number of owner slots
+const ownersTag = "SPO"
+
+// ownersHeaderLength is the length of the header of a lock region,
+// owner slots are 4 byte process identifiers following it
+const ownersHeaderLength = 8
+
+// sharedMapping is the state of a client sharing its mapping with other processes
+type sharedMapping struct {
+	leader bool
+	claims map[string][]string // instances claimed by the client for every instance domain
+
+	owners  *bytewriter.MemoryMappedWriter // lock region, mapped while the client is started
+	claimed map[string]map[string]int      // offsets of the owned instances in the lock region
+}
+
+// ownersLocation returns the location of the lock region of a shared mapping
+func ownersLocation(loc string) string { return loc + ".owners" }
+
+// SetSharedMapping sets the role of the client in a mapping shared with other processes.
+// Shared mappings are experimental, and cannot be combined with SetMemfd.
+func (c *PCPClient) SetSharedMapping(role SharedMappingRole) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.r.mapped {
+		return ErrClientStarted
+	}
+
+	switch role {
+	case NotShared:
+		c.shared = nil
+	case SharedLeader, SharedWorker:
+		var claims map[string][]string
+		if c.shared != nil {
+			claims = c.shared.claims
+		}
+
+		c.shared = &sharedMapping{leader: role == SharedLeader, claims: claims}
+	default:
+		return c.opError("share the mapping of", "", fmt.Errorf("invalid role %v", role))
+	}
+
+	return nil
+}
+
+// ClaimInstances claims instances of an instance domain for the client, which owns them
+// in the shared mapping once started and is the only process writing their values.
+// Starting the client fails if another process owns any of them.
+//
+// Values of instances a client has not claimed are not written to a shared mapping.
+func (c *PCPClient) ClaimInstances(indom string, instances ...string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.r.mapped {
+		return ErrClientStarted
+	}
+
+	if c.shared == nil {
+		return c.opError("claim instances of", "", errors.New("the client does not share its mapping"))
+	}
+
+	if c.shared.claims == nil {
+		c.shared.claims = make(map[string][]string)
+	}
+
+	c.shared.claims[indom] = append(c.shared.claims[indom], instances...)
+	return nil
+}
+
+// owns returns true if the client writes the values of an instance to its mapping
+func (c *PCPClient) owns(indom InstanceDomain, instance string) bool {
+	if c.shared == nil {
+		return true
+	}
+
+	_, ok := c.shared.claimed[indom.Name()][instance]
+	return ok
+}
+
+// mapShared creates or attaches to a shared mapping and claims the instances of the client
+func (c *PCPClient) mapShared() error {
+	if c.memfd {
+		return errors.New("anonymous mappings cannot be shared")
+	}
+
+	loc, n := ownersLocation(c.loc), c.r.InstanceCount()
+
+	var (
+		owners *bytewriter.MemoryMappedWriter
+		err    error
+	)
+
+	// the lock region of the leader is created before the mapping,
+	// so it exists when workers find the mapping
+	if c.shared.leader {
+		owners, err = bytewriter.NewMemoryMappedWriter(loc, ownersHeaderLength+4*n)
+		if err == nil {
+			owners.MustWriteString(ownersTag, 0)
+			owners.MustWriteUint32(uint32(n), 4)
+		}
+	} else {
+		owners, err = bytewriter.OpenMemoryMappedWriter(loc, ownersHeaderLength+4*n)
+	}
+
+	if err != nil {
+		return &MappingError{loc, err}
+	}
+
+	c.shared.owners = owners
+
+	if c.shared.leader {
+		err = c.claim()
+		if err == nil {
+			var writer bytewriter.Writer
+			writer, err = c.newWriter()
+			if err == nil {
+				c.writer = writer
+				c.writes.reset()
+				c.start()
+				c.publishWriter(writer)
+			}
+		}
+	} else {
+		err = c.attach()
+	}
+
+	if err != nil {
+		_ = c.release(c.shared.leader && EraseFileOnStop)
+	}
+
+	return err
+}
+
+// attach maps the shared mapping written by the leader, after checking that it has
+// the layout of the registry of the client, and binds the values of the claimed instances to it
+func (c *PCPClient) attach() error {
+	l := newmmvLayout(c.r, c.encoder(), c.tocCount(), c.valueAlignment(), c.hot, c.headroom)
+
+	writer, err := bytewriter.OpenMemoryMappedWriter(c.loc, c.Length())
+	if err != nil {
+		return &MappingError{c.loc, err}
+	}
+
+	b := writer.Bytes()
+	gen := atomic.LoadInt64((*int64)(unsafe.Pointer(&b[8])))
+	if gen == 0 || gen != atomic.LoadInt64((*int64)(unsafe.Pointer(&b[16]))) {
+		_ = writer.Unmap(false)
+		return &MappingError{c.loc, errors.New("the mapping is not written yet")}
+	}
+
+	// render the mapping of the client without binding it, to compare the metadata
+	// with the shared mapping, from the toc count in the header up to the values
+	expected := bytewriter.NewByteWriter(len(b))
+	c.writer = expected
+	c.write(l, 0, 0, false)
+	e := expected.Bytes()
+
+	if !bytes.Equal(e[24:32], b[24:32]) || !bytes.Equal(e[36:l.valuesoffset], b[36:l.valuesoffset]) {
+		c.writer = nil
+		_ = writer.Unmap(false)
+		return &MappingError{c.loc, errors.New("the mapping does not have the layout of the registry of the client")}
+	}
+
+	if err = c.claim(); err != nil {
+		c.writer = nil
+		_ = writer.Unmap(false)
+		return err
+	}
+
+	c.writer = writer
+	c.writes.reset()
+	c.layout = l
+	atomic.AddUint64(&c.stats.Bytes, uint64(len(b)))
+
+	for i, m := range l.metrics {
+		if im, ok := m.(instanceMetric); ok {
+			c.bindClaimed(im.instance(), l.metric(i)[metricSlots:])
+		}
+	}
+
+	return nil
+}
+
+// bindClaimed binds the values of the claimed instances of a metric to
+// the shared mapping, without writing the rest of the metric
+func (c *PCPClient) bindClaimed(m *pcpInstanceMetric, v []int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for j, i := range m.indom.sortedInstances() {
+		val := m.vals[i.name]
+		if !val.deleted && c.owns(m.indom, i.name) {
+			val.update = c.writeValue(m.pcpMetricDesc, val.val, v[j*valueSlots], v[j*valueSlots+1], c.limiter != nil)
+		}
+	}
+}
+
+// claim claims the instances of the client in the lock region, failing
+// if another process owns any of them or they are not registered
+func (c *PCPClient) claim() error {
+	pid := uint32(os.Getpid())
+	c.shared.claimed = make(map[string]map[string]int)
+
+	slots := make(map[string]map[string]int)
+	off := ownersHeaderLength
+	for _, indom := range c.r.sortedInstanceDomains() {
+		slots[indom.name] = make(map[string]int)
+		for _, i := range indom.sortedInstances() {
+			slots[indom.name][i.name] = off
+			off += 4
+		}
+	}
+
+	for indom, instances := range c.shared.claims {
+		for _, instance := range instances {
+			off, ok := slots[indom][instance]
+			if !ok {
+				c.unclaim()
+				return c.opError("claim instances of", "", fmt.Errorf("%v is not a registered instance of %v", instance, indom))
+			}
+
+			owner := claimSlot((*uint32)(unsafe.Pointer(&c.shared.owners.Bytes()[off])), pid)
+			if owner != pid {
+				c.unclaim()
+				return c.opError("claim instances of", "", fmt.Errorf("instance %v of %v is owned by process %v", instance, indom, owner))
+			}
+
+			if c.shared.claimed[indom] == nil {
+				c.shared.claimed[indom] = make(map[string]int)
+			}
+			c.shared.claimed[indom][instance] = off
+		}
+	}
+
+	return nil
+}
+
+// claimSlot claims an owner slot of the lock region for pid, taking it over from
+// an owner that is gone, and returns the owner of the slot
+func claimSlot(slot *uint32, pid uint32) uint32 {
+	for {
+		owner := atomic.LoadUint32(slot)
+		if owner == pid || (owner != 0 && processAlive(int(owner))) {
+			return owner
+		}
+
+		if atomic.CompareAndSwapUint32(slot, owner, pid) {
+			return pid
+		}
+	}
+}
+
+// unclaim releases the instances claimed by the client in the lock region
+func (c *PCPClient) unclaim() {
+	pid := uint32(os.Getpid())
+	for _, instances := range c.shared.claimed {
+		for _, off := range instances {
+			atomic.CompareAndSwapUint32((*uint32)(unsafe.Pointer(&c.shared.owners.Bytes()[off])), pid, 0)
+		}
+	}
+
+	c.shared.claimed = nil
+}
+
+// release releases the claimed instances and unmaps the lock region
+func (c *PCPClient) release(erase bool) error {
+	if c.shared.owners == nil {
+		return nil
+	}
+
+	c.unclaim()
+
+	err := c.shared.owners.Unmap(erase)
+	c.shared.owners = nil
+	return err
+}
@@ -0,0 +1,114 @@
+package speed
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+	"unsafe"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+// newSharedClient creates a client of a shared mapping with a counter
+// and a metric over an instance domain of two workers
+func newSharedClient(t *testing.T, role SharedMappingRole, claims ...string) (*PCPClient, *PCPInstanceMetric) {
+	c, err := NewPCPClient("sharedtest")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	if err = c.SetSharedMapping(role); err != nil {
+		t.Fatalf("cannot share the mapping, error: %v", err)
+	}
+
+	indom, err := NewPCPInstanceDomain("shared.workers", []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("cannot create instance domain, error: %v", err)
+	}
+
+	m, err := NewPCPInstanceMetric(Instances{"a": int64(0), "b": int64(0)}, "shared.requests", indom, Int64Type, CounterSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+	c.MustRegister(m)
+
+	counter, err := NewPCPCounter(1, "shared.restarts")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+	c.MustRegister(counter)
+
+	if err = c.ClaimInstances("shared.workers", claims...); err != nil {
+		t.Fatalf("cannot claim instances, error: %v", err)
+	}
+
+	return c, m
+}
+
+func TestSharedMapping(t *testing.T) {
+	leader, lm := newSharedClient(t, SharedLeader, "a")
+	leader.MustStart()
+	defer leader.MustStop()
+
+	worker, wm := newSharedClient(t, SharedWorker, "b")
+	worker.MustStart()
+	defer worker.MustStop()
+
+	lm.MustSetInstance(int64(3), "a")
+	wm.MustSetInstance(int64(7), "b")
+
+	// values of instances claimed by other clients are not written
+	lm.MustSetInstance(int64(100), "b")
+	wm.MustSetInstance(int64(100), "a")
+
+	if v, err := mmvdump.Lookup(leader.writer.Bytes(), "shared.requests[a]"); err != nil || v != int64(3) {
+		t.Errorf("expected the leader to write 3, got %v, error: %v", v, err)
+	}
+
+	if v, err := mmvdump.Lookup(leader.writer.Bytes(), "shared.requests[b]"); err != nil || v != int64(7) {
+		t.Errorf("expected the worker to write 7, got %v, error: %v", v, err)
+	}
+
+	if v, err := mmvdump.Lookup(leader.writer.Bytes(), "shared.restarts"); err != nil || v != int64(1) {
+		t.Errorf("expected the leader to write the counter, got %v, error: %v", v, err)
+	}
+
+	if err := worker.SetInstanceDomainDescription("shared.workers", "workers"); err == nil {
+		t.Errorf("expected changing the layout of a shared mapping to fail")
+	}
+}
+
+func TestSharedMappingClaims(t *testing.T) {
+	leader, _ := newSharedClient(t, SharedLeader)
+	leader.MustStart()
+	defer leader.MustStop()
+
+	// pretend another process owns instance b, which follows a in the lock region,
+	// using the parent process which is alive as long as the test is
+	owner := (*uint32)(unsafe.Pointer(&leader.shared.owners.Bytes()[ownersHeaderLength+4]))
+	atomic.StoreUint32(owner, uint32(os.Getppid()))
+
+	worker, _ := newSharedClient(t, SharedWorker, "a", "b")
+	if err := worker.Start(); err == nil {
+		worker.MustStop()
+		t.Fatalf("expected claiming an instance owned by another process to fail")
+	}
+
+	// the instances claimed before the failure are released
+	if v := atomic.LoadUint32((*uint32)(unsafe.Pointer(&leader.shared.owners.Bytes()[ownersHeaderLength]))); v != 0 {
+		t.Errorf("expected instance a to be released, owned by %v", v)
+	}
+
+	worker, _ = newSharedClient(t, SharedWorker, "c")
+	if err := worker.Start(); err == nil {
+		worker.MustStop()
+		t.Errorf("expected claiming an unregistered instance to fail")
+	}
+
+	worker, _ = newSharedClient(t, SharedWorker)
+	worker.MustRegisterString("shared.extra", int32(1), Int32Type, InstantSemantics, OneUnit)
+	if err := worker.Start(); err == nil {
+		worker.MustStop()
+		t.Errorf("expected attaching to a mapping with a different layout to fail")
+	}
+}
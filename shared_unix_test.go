@@ -0,0 +1,44 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package speed
+
+import (
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"testing"
+	"unsafe"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestSharedMappingCrashedOwner(t *testing.T) {
+	leader, _ := newSharedClient(t, SharedLeader)
+	leader.MustStart()
+	defer leader.MustStop()
+
+	// a process that exited without releasing its instances, like a crashed worker
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("cannot run a process, error: %v", err)
+	}
+
+	owner := (*uint32)(unsafe.Pointer(&leader.shared.owners.Bytes()[ownersHeaderLength+4]))
+	atomic.StoreUint32(owner, uint32(cmd.Process.Pid))
+
+	worker, wm := newSharedClient(t, SharedWorker, "b")
+	if err := worker.Start(); err != nil {
+		t.Fatalf("expected an instance of a process that is gone to be free to claim, got %v", err)
+	}
+	defer worker.MustStop()
+
+	if v := atomic.LoadUint32(owner); v != uint32(os.Getpid()) {
+		t.Errorf("expected instance b to be owned by the worker, owned by %v", v)
+	}
+
+	wm.MustSetInstance(int64(7), "b")
+	if v, err := mmvdump.Lookup(leader.writer.Bytes(), "shared.requests[b]"); err != nil || v != int64(7) {
+		t.Errorf("expected the worker to write 7, got %v, error: %v", v, err)
+	}
+}
@@ -0,0 +1,91 @@
+package speed
+
+import "errors"
+
+// ValueSlot is the position of a value of a registered metric in the mapping of a
+// started client, for writing the value without going through the metric, like from
+// C code called using cgo, or another language runtime in the same process.
+//
+// A value is stored at Offset from the start of the mapping in little endian byte order,
+// taking Size bytes. A string value is a null terminated string of at most
+// StringLength-1 bytes.
+//
+// Slots are valid until the client is stopped or its registry is written to a new mapping,
+// which Stats().Remaps counts, and have to be looked up again after.
+//
+// Singleton metrics of Int64Type, Uint64Type, FloatType and DoubleType are updated in place
+// in the mapping, unless a write rate limit is set, so they read the values written through
+// their slots, which should then be written atomically. Other metrics are not told about
+// them, and write the values they hold when they are updated or a new mapping is written,
+// so they should only be written through their slots.
+type ValueSlot struct {
+	Metric   string
+	Instance string // empty for singleton metrics
+	Type     MetricType
+	Offset   int
+	Size     int
+}
+
+// valueSlot returns the slot of a value written at the passed offsets by writeValue
+func valueSlot(m PCPMetric, instance string, offset, stringoffset int) ValueSlot {
+	t := m.Type()
+	if t == StringType {
+		offset = stringoffset
+	}
+
+	return ValueSlot{m.Name(), instance, t, offset, valueSize(t)}
+}
+
+// ValueSlots returns the slots of the values of a registered metric in the mapping,
+// one for singleton metrics, and one for every instance of instance metrics, ordered
+// by instance name. The client must be started.
+func (c *PCPClient) ValueSlots(name string) ([]ValueSlot, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.r.mapped {
+		return nil, c.opError("get value slots of", name, errors.New("client is not started"))
+	}
+
+	for i, m := range c.layout.metrics {
+		if m.Name() != name {
+			continue
+		}
+
+		v := c.layout.metric(i)[metricSlots:]
+
+		im, ok := m.(instanceMetric)
+		if !ok {
+			return []ValueSlot{valueSlot(m, "", v[0], v[1])}, nil
+		}
+
+		metric := im.instance()
+		metric.mutex.RLock()
+		defer metric.mutex.RUnlock()
+
+		var slots []ValueSlot
+		for j, ins := range metric.indom.sortedInstances() {
+			if !metric.vals[ins.name].deleted {
+				slots = append(slots, valueSlot(m, ins.name, v[j*valueSlots], v[j*valueSlots+1]))
+			}
+		}
+
+		return slots, nil
+	}
+
+	return nil, c.opError("get value slots of", name, errors.New("metric is not registered"))
+}
+
+// MappingBytes returns the memory of the active mapping of the client, which the offsets
+// of ValueSlots are relative to, or nil if the client is not started. The memory is only
+// valid as long as the slots are.
+func (c *PCPClient) MappingBytes() []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.r.mapped {
+		return nil
+	}
+
+	return c.writer.Bytes()
+}
@@ -0,0 +1,75 @@
+package speed
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestValueSlots(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	counter := newTestCounter(t, c, "slot.counter")
+	c.MustRegisterString("slot.string", "a", StringType, InstantSemantics, OneUnit)
+
+	vector, err := NewPCPGaugeVector(map[string]float64{"x": 1, "y": 2}, "slot.vector")
+	if err != nil {
+		t.Fatalf("cannot create vector, error: %v", err)
+	}
+	c.MustRegister(vector)
+
+	if _, err = c.ValueSlots("slot.counter"); err == nil {
+		t.Errorf("expected getting the slots of a client that is not started to fail")
+	}
+
+	if c.MappingBytes() != nil {
+		t.Errorf("expected no mapping before the client is started")
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if _, err = c.ValueSlots("slot.missing"); err == nil {
+		t.Errorf("expected getting the slots of a missing metric to fail")
+	}
+
+	b := c.MappingBytes()
+
+	slots, err := c.ValueSlots("slot.counter")
+	if err != nil || len(slots) != 1 || slots[0].Type != Int64Type || slots[0].Size != 8 {
+		t.Fatalf("unexpected slots %+v, error: %v", slots, err)
+	}
+	binary.LittleEndian.PutUint64(b[slots[0].Offset:], 42)
+
+	if v, err := mmvdump.Lookup(b, "slot.counter"); err != nil || v != int64(42) {
+		t.Errorf("expected the counter written through its slot to be 42, got %v, error: %v", v, err)
+	}
+	if counter.Val() != 42 {
+		t.Errorf("expected the counter updated in place to read the write, got %v", counter.Val())
+	}
+
+	slots, err = c.ValueSlots("slot.string")
+	if err != nil || len(slots) != 1 || slots[0].Size != StringLength {
+		t.Fatalf("unexpected slots %+v, error: %v", slots, err)
+	}
+	copy(b[slots[0].Offset:], "bc\x00")
+
+	if v, err := mmvdump.Lookup(b, "slot.string"); err != nil || v != "bc" {
+		t.Errorf("expected the string written through its slot to be bc, got %v, error: %v", v, err)
+	}
+
+	slots, err = c.ValueSlots("slot.vector")
+	if err != nil || len(slots) != 2 || slots[0].Instance != "x" || slots[1].Instance != "y" {
+		t.Fatalf("unexpected slots %+v, error: %v", slots, err)
+	}
+	binary.LittleEndian.PutUint64(b[slots[1].Offset:], math.Float64bits(-3.5))
+
+	if v, err := mmvdump.Lookup(b, "slot.vector[y]"); err != nil || v != float64(-3.5) {
+		t.Errorf("expected the instance written through its slot to be -3.5, got %v, error: %v", v, err)
+	}
+}
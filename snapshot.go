@@ -0,0 +1,190 @@
+package speed
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// CSVTimeFormat is the format of the timestamps written by a CSVSnapshotter,
+// the default format used by pmrep.
+const CSVTimeFormat = "2006-01-02 15:04:05"
+
+var snapshotlogger = log.WithField("prefix", "snapshot")
+
+// CSVSnapshotter periodically appends the values of all metrics of a client to a file
+// in the CSV format written by `pmrep -o csv`, so an instrumented application keeps
+// a short local history of its metrics even when pmlogger is not recording them.
+//
+// The first row of a file holds the column names, "Time" followed by the names of all
+// values as PCP exposes them, like "mmv.app.requests" for a singleton metric and
+// "mmv.app.latency-max" for an instance. Every other row holds a timestamp followed by
+// the values at that time.
+//
+// Once a file holds MaxRows snapshots, or when metrics are added or removed,
+// it is moved to the same path with a ".1" suffix, replacing any previous file there,
+// and a new file is started.
+type CSVSnapshotter struct {
+	c    *PCPClient
+	path string
+
+	// the maximum number of snapshots in a file, 0 is unlimited
+	MaxRows int
+
+	mutex   sync.Mutex
+	columns []string // the columns of the current file
+	rows    int      // the number of snapshots in the current file
+
+	cancel func() // stops the schedule, nil if snapshots are not taken
+}
+
+// NewCSVSnapshotter creates a CSVSnapshotter writing the metrics of c to the file at path.
+func NewCSVSnapshotter(c *PCPClient, path string) *CSVSnapshotter {
+	return &CSVSnapshotter{c: c, path: path}
+}
+
+// metricPrefix returns the prefix PCP adds to the names of the metrics of the client
+func (s *CSVSnapshotter) metricPrefix() string {
+	return s.c.MetricPrefix()
+}
+
+// read returns the names and the values of all values of all metrics
+func (s *CSVSnapshotter) read() ([]string, []string) {
+	prefix := s.metricPrefix()
+
+	var columns, vals []string
+	for _, m := range s.c.r.sortedMetrics() {
+		switch metric := m.(type) {
+		case singletonMetric:
+			sm := metric.singleton()
+
+			sm.mutex.RLock()
+			val := sm.value()
+			sm.mutex.RUnlock()
+
+			columns = append(columns, prefix+sm.name)
+			vals = append(vals, fmt.Sprint(val))
+		case instanceMetric:
+			im := metric.instance()
+
+			im.mutex.RLock()
+			for _, i := range im.indom.sortedInstances() {
+				if im.vals[i.name].deleted {
+					continue
+				}
+
+				columns = append(columns, prefix+im.name+"-"+i.name)
+				vals = append(vals, fmt.Sprint(im.vals[i.name].val))
+			}
+			im.mutex.RUnlock()
+		}
+	}
+
+	return columns, vals
+}
+
+// sameColumns returns true if a and b hold the same names in the same order
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Snapshot appends the current values of all metrics to the file.
+func (s *CSVSnapshotter) Snapshot() error {
+	columns, vals := s.read()
+	now := s.c.getClock().Now().Format(CSVTimeFormat)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rotate := s.columns != nil && (!sameColumns(columns, s.columns) || (s.MaxRows > 0 && s.rows >= s.MaxRows))
+	if rotate {
+		if err := os.Rename(s.path, s.path+".1"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		s.columns = nil
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if s.columns == nil {
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(s.path, flags, 0644)
+	if err != nil {
+		return err
+	}
+
+	b := bufio.NewWriter(f)
+	w := csv.NewWriter(b)
+
+	if s.columns == nil {
+		if err = w.Write(append([]string{"Time"}, columns...)); err != nil {
+			_ = f.Close()
+			return err
+		}
+		s.columns, s.rows = columns, 0
+	}
+
+	if err = w.Write(append([]string{now}, vals...)); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	w.Flush()
+	if err = w.Error(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err = b.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	s.rows++
+	return f.Close()
+}
+
+// Start takes a snapshot at every interval of the clock of the client until Stop is called.
+func (s *CSVSnapshotter) Start(interval time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.cancel != nil {
+		return
+	}
+
+	schedule := newpublishSchedule(interval, 0)
+	schedule.clock = s.c.getClock()
+
+	s.cancel = schedule.start(func() {
+		if err := s.Snapshot(); err != nil && logging {
+			snapshotlogger.WithField("error", err).Error("cannot write snapshot")
+		}
+	})
+}
+
+// Stop stops taking snapshots and waits for a running snapshot to be written.
+func (s *CSVSnapshotter) Stop() {
+	s.mutex.Lock()
+	cancel := s.cancel
+	s.cancel = nil
+	s.mutex.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+}
@@ -0,0 +1,139 @@
+package speed
+
+import (
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func readCSV(path string, t *testing.T) [][]string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("cannot open %v, error: %v", path, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("cannot read %v, error: %v", path, err)
+	}
+
+	return records
+}
+
+func TestCSVSnapshotter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "speed")
+	if err != nil {
+		t.Fatalf("cannot create directory, error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	c, err := NewPCPClient("snap")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	counter := newTestCounter(t, c, "requests")
+
+	_, err = c.RegisterString("status[a, b]", Instances{"a": "up", "b": "down, degraded"}, StringType, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot register metric, error: %v", err)
+	}
+
+	path := filepath.Join(dir, "snap.csv")
+	s := NewCSVSnapshotter(c, path)
+	s.MaxRows = 2
+
+	counter.MustInc(1)
+	if err = s.Snapshot(); err != nil {
+		t.Fatalf("cannot take snapshot, error: %v", err)
+	}
+
+	counter.MustInc(1)
+	if err = s.Snapshot(); err != nil {
+		t.Fatalf("cannot take snapshot, error: %v", err)
+	}
+
+	records := readCSV(path, t)
+	if len(records) != 3 {
+		t.Fatalf("expected a header and 2 snapshots, got %v rows", len(records))
+	}
+
+	header := []string{"Time", "mmv.snap.requests", "mmv.snap.status-a", "mmv.snap.status-b"}
+	if !reflect.DeepEqual(records[0], header) {
+		t.Errorf("expected header %v, got %v", header, records[0])
+	}
+
+	if _, err = time.Parse(CSVTimeFormat, records[1][0]); err != nil {
+		t.Errorf("expected a timestamp in the first column, got %v", records[1][0])
+	}
+
+	if expected := []string{"1", "up", "down, degraded"}; !reflect.DeepEqual(records[1][1:], expected) {
+		t.Errorf("expected values %v, got %v", expected, records[1][1:])
+	}
+
+	if records[2][1] != "2" {
+		t.Errorf("expected the second snapshot to hold 2, got %v", records[2][1])
+	}
+
+	// the file is full, so it is rotated
+	counter.MustInc(1)
+	if err = s.Snapshot(); err != nil {
+		t.Fatalf("cannot take snapshot, error: %v", err)
+	}
+
+	if records = readCSV(path+".1", t); len(records) != 3 {
+		t.Errorf("expected the rotated file to hold a header and 2 snapshots, got %v rows", len(records))
+	}
+
+	records = readCSV(path, t)
+	if len(records) != 2 || records[1][1] != "3" {
+		t.Errorf("expected a new file with a header and the third snapshot, got %v", records)
+	}
+
+	// a new metric changes the columns, so the file is rotated
+	newTestCounter(t, c, "errors")
+	if err = s.Snapshot(); err != nil {
+		t.Fatalf("cannot take snapshot, error: %v", err)
+	}
+
+	records = readCSV(path, t)
+	if len(records) != 2 || records[0][1] != "mmv.snap.errors" {
+		t.Errorf("expected a new file with the new metric, got %v", records)
+	}
+}
+
+func TestCSVSnapshotterStartStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "speed")
+	if err != nil {
+		t.Fatalf("cannot create directory, error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	c, err := NewPCPClient("snap")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	newTestCounter(t, c, "requests")
+
+	path := filepath.Join(dir, "snap.csv")
+	s := NewCSVSnapshotter(c, path)
+
+	s.Start(10 * time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	s.Stop()
+
+	rows := len(readCSV(path, t))
+	if rows < 3 {
+		t.Errorf("expected at least 2 snapshots, got %v rows", rows)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if after := len(readCSV(path, t)); after != rows {
+		t.Errorf("expected no snapshots after Stop, got %v more rows", after-rows)
+	}
+}
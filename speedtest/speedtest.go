@@ -0,0 +1,247 @@
+// Package speedtest provides helpers for testing applications instrumented using speed.
+package speedtest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+
+	"github.com/performancecopilot/speed"
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+// TestingT is the part of *testing.T used by the assertions, also implemented by *testing.B.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+}
+
+// metric is a metric read from a mapping
+type metric struct {
+	Item        uint32
+	Type        mmvdump.Type
+	Semantics   mmvdump.Semantics
+	Unit        mmvdump.Unit
+	Indom       int32
+	Short, Long string
+}
+
+// indom is an instance domain read from a mapping, with its instances by name
+type indom struct {
+	Count       uint32
+	Short, Long string
+	Instances   map[string]int32
+}
+
+// mapping is the contents of a mapping, keyed by names instead of offsets,
+// so mappings written by different processes at different times can be compared
+type mapping struct {
+	header  mmvdump.Header
+	metrics map[string]metric
+	indoms  map[uint32]indom
+	values  map[string]interface{} // by metric, or metric[instance]
+}
+
+// readMapping reads a mapping from its contents
+func readMapping(data []byte) (*mapping, error) {
+	h, _, metrics, values, instances, indoms, strs, err := mmvdump.Dump(data)
+	if err != nil {
+		return nil, err
+	}
+
+	str := func(offset uint64) string {
+		if s, ok := strs[offset]; ok && offset != 0 {
+			return cstring(s.Payload[:])
+		}
+		return ""
+	}
+
+	instanceName := func(offset uint64) string {
+		switch i := instances[offset].(type) {
+		case *mmvdump.Instance1:
+			return cstring(i.External[:])
+		case *mmvdump.Instance2:
+			return str(i.External)
+		}
+		return ""
+	}
+
+	m := &mapping{
+		header:  *h,
+		metrics: make(map[string]metric, len(metrics)),
+		indoms:  make(map[uint32]indom, len(indoms)),
+		values:  make(map[string]interface{}, len(values)),
+	}
+
+	// generations and the process differ between every mapping of a registry
+	m.header.G1, m.header.G2, m.header.Process = 0, 0, 0
+
+	for off, d := range indoms {
+		i := indom{d.Count, str(d.Shorttext), str(d.Longtext), make(map[string]int32)}
+		for ioff, instance := range instances {
+			if instance.Indom() == off {
+				i.Instances[instanceName(ioff)] = instance.Internal()
+			}
+		}
+		m.indoms[d.Serial] = i
+	}
+
+	names := make(map[uint64]string, len(metrics))
+	for off, d := range metrics {
+		var name string
+		switch mt := d.(type) {
+		case *mmvdump.Metric1:
+			name = cstring(mt.Name[:])
+		case *mmvdump.Metric2:
+			name = str(mt.Name)
+		}
+
+		names[off] = name
+		m.metrics[name] = metric{d.Item(), d.Typ(), d.Sem(), d.Unit(), d.Indom(), str(d.ShortText()), str(d.LongText())}
+	}
+
+	for _, v := range values {
+		d, ok := metrics[v.Metric]
+		if !ok {
+			return nil, fmt.Errorf("a value points at offset %v, which is not a metric", v.Metric)
+		}
+
+		name := names[v.Metric]
+		if d.Indom() != mmvdump.NoIndom {
+			name += "[" + instanceName(v.Instance) + "]"
+		}
+
+		if d.Typ() == mmvdump.StringType {
+			m.values[name] = str(uint64(v.Extra))
+		} else if m.values[name], err = mmvdump.FixedVal(v.Val, d.Typ()); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// cstring returns the contents of a null terminated string stored in b
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// diff returns the differences of a mapping from the expected one
+func diff(expected, actual *mapping) []string {
+	var diffs []string
+
+	if expected.header != actual.header {
+		diffs = append(diffs, fmt.Sprintf("header is %+v, expected %+v", actual.header, expected.header))
+	}
+
+	for serial, e := range expected.indoms {
+		if a, ok := actual.indoms[serial]; !ok {
+			diffs = append(diffs, fmt.Sprintf("instance domain %v is missing", serial))
+		} else if !reflect.DeepEqual(a, e) {
+			diffs = append(diffs, fmt.Sprintf("instance domain %v is %+v, expected %+v", serial, a, e))
+		}
+	}
+
+	for serial := range actual.indoms {
+		if _, ok := expected.indoms[serial]; !ok {
+			diffs = append(diffs, fmt.Sprintf("instance domain %v is not registered", serial))
+		}
+	}
+
+	for name, e := range expected.metrics {
+		if a, ok := actual.metrics[name]; !ok {
+			diffs = append(diffs, fmt.Sprintf("metric %v is missing", name))
+		} else if a != e {
+			diffs = append(diffs, fmt.Sprintf("metric %v is %+v, expected %+v", name, a, e))
+		}
+	}
+
+	for name := range actual.metrics {
+		if _, ok := expected.metrics[name]; !ok {
+			diffs = append(diffs, fmt.Sprintf("metric %v is not registered", name))
+		}
+	}
+
+	for name, e := range expected.values {
+		if a, ok := actual.values[name]; !ok {
+			diffs = append(diffs, fmt.Sprintf("value of %v is missing", name))
+		} else if a != e {
+			diffs = append(diffs, fmt.Sprintf("value of %v is %v, expected %v", name, a, e))
+		}
+	}
+
+	for name := range actual.values {
+		if _, ok := expected.values[name]; !ok {
+			diffs = append(diffs, fmt.Sprintf("value of %v is not registered", name))
+		}
+	}
+
+	sort.Strings(diffs)
+	return diffs
+}
+
+// CompareMapping reads the mapping of a started client from where PCP reads it, and
+// compares it to the mapping the client writes for its registry, see EncodeTo, returning
+// a description of every difference, ordered alphabetically.
+//
+// The header, except for the generation and the process, the instance domains and their
+// instances, and the metrics and their values are compared by name, so the order of the
+// components in the mapping does not matter. Values updated while the mapping is compared
+// can differ.
+func CompareMapping(c *speed.PCPClient) ([]string, error) {
+	if s := c.Status(); s.State != speed.ClientStarted || s.InMemory {
+		return nil, errors.New("the client has no mapping, it is not started or is writing to memory")
+	}
+
+	data, err := ioutil.ReadFile(c.Location())
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	if err = c.EncodeTo(&b); err != nil {
+		return nil, err
+	}
+
+	expected, err := readMapping(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the expected mapping: %v", err)
+	}
+
+	actual, err := readMapping(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the mapping at %v: %v", c.Location(), err)
+	}
+
+	return diff(expected, actual), nil
+}
+
+// AssertMappingMatches fails the test if the mapping of a started client, as PCP reads it,
+// differs from the registry of the client, reporting every difference, as in
+//
+//	func TestInstrumentation(t *testing.T) {
+//		c := newInstrumentedClient()
+//		c.MustStart()
+//		defer c.MustStop()
+//
+//		speedtest.AssertMappingMatches(t, c)
+//	}
+//
+// See CompareMapping for what is compared.
+func AssertMappingMatches(t TestingT, c *speed.PCPClient) {
+	diffs, err := CompareMapping(c)
+	if err != nil {
+		t.Fatalf("cannot compare the mapping of the client: %v", err)
+		return
+	}
+
+	for _, d := range diffs {
+		t.Errorf("the mapping differs from the registry: %v", d)
+	}
+}
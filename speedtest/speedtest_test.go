@@ -0,0 +1,78 @@
+package speedtest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/performancecopilot/speed"
+)
+
+// recorder is a TestingT recording the failures of assertions
+type recorder struct {
+	errors []string
+	fatal  bool
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.Errorf(format, args...)
+	r.fatal = true
+}
+
+func TestAssertMappingMatches(t *testing.T) {
+	c, err := speed.NewPCPClient("speedtest")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	counter, err := speed.NewPCPCounter(1, "requests", "number of requests")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+	c.MustRegister(counter)
+
+	vector, err := speed.NewPCPGaugeVector(map[string]float64{"a": 1, "b": 2}, "sizes")
+	if err != nil {
+		t.Fatalf("cannot create vector, error: %v", err)
+	}
+	c.MustRegister(vector)
+
+	c.MustRegisterString("version", "1.0", speed.StringType, speed.DiscreteSemantics, speed.OneUnit)
+
+	r := &recorder{}
+	AssertMappingMatches(r, c)
+	if !r.fatal {
+		t.Errorf("expected comparing the mapping of a client that is not started to fail")
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	counter.Inc(4)
+	vector.MustSet(7, "b")
+
+	r = &recorder{}
+	AssertMappingMatches(r, c)
+	if len(r.errors) != 0 {
+		t.Errorf("expected the mapping to match, got %v", r.errors)
+	}
+
+	// a value changed behind the back of the metric
+	slots, err := c.ValueSlots("sizes")
+	if err != nil {
+		t.Fatalf("cannot get value slots, error: %v", err)
+	}
+	c.MappingBytes()[slots[0].Offset] ^= 0xff
+
+	diffs, err := CompareMapping(c)
+	if err != nil {
+		t.Fatalf("cannot compare the mapping, error: %v", err)
+	}
+	if len(diffs) != 1 || !strings.Contains(diffs[0], "value of sizes[a]") {
+		t.Errorf("expected a single difference in the value of sizes[a], got %v", diffs)
+	}
+}
@@ -0,0 +1,82 @@
+package speed
+
+import "sync/atomic"
+
+// Stats counts the work a client has done to publish its metrics since it was created,
+// for embedding into application specific health reports.
+type Stats struct {
+	Writes       uint64 // updates of values written to a mapping after it was created
+	FailedWrites uint64 // updates of values that could not be written
+	Bytes        uint64 // bytes written, counting every new mapping in full
+	Remaps       uint64 // times the registry was written to a new mapping while the client was started
+	Extensions   uint64 // times instances were added to the active mapping in place, see SetInstanceHeadroom
+	Flushes      uint64 // times updates held back by a write rate limit were flushed
+}
+
+// writeCounts counts the writes of the values of a metric. Every metric has its own,
+// so updates of different metrics do not contend on a counter shared by all of them,
+// and they are summed up by PCPClient.Stats.
+type writeCounts struct {
+	writes, failed uint64 // updated atomically, the struct is allocated separately so they are 64 bit aligned
+}
+
+// track returns an update closure counting every write.
+func (w *writeCounts) track(update updateClosure) updateClosure {
+	return func(val interface{}) error {
+		err := update(val)
+
+		if err != nil {
+			atomic.AddUint64(&w.failed, 1)
+		} else {
+			atomic.AddUint64(&w.writes, 1)
+		}
+
+		return err
+	}
+}
+
+// load returns a copy of the stats read atomically.
+func (s *Stats) load() Stats {
+	return Stats{
+		Writes:       atomic.LoadUint64(&s.Writes),
+		FailedWrites: atomic.LoadUint64(&s.FailedWrites),
+		Bytes:        atomic.LoadUint64(&s.Bytes),
+		Remaps:       atomic.LoadUint64(&s.Remaps),
+		Extensions:   atomic.LoadUint64(&s.Extensions),
+		Flushes:      atomic.LoadUint64(&s.Flushes),
+	}
+}
+
+// valueSize returns the number of bytes a value of the type occupies in a mapping.
+func valueSize(t MetricType) int {
+	switch t {
+	case Int32Type, Uint32Type, FloatType:
+		return 4
+	case StringType:
+		return StringLength
+	}
+	return 8
+}
+
+// Stats returns the counts of the work the client has done to publish its metrics.
+// It does not take the client's lock, and can be called at any time.
+func (c *PCPClient) Stats() Stats {
+	s := c.stats.load()
+
+	c.r.metricslock.RLock()
+	defer c.r.metricslock.RUnlock()
+
+	for _, m := range c.r.metrics {
+		md := metricDesc(m)
+		if md == nil {
+			continue
+		}
+
+		writes := atomic.LoadUint64(&md.counts.writes)
+		s.Writes += writes
+		s.FailedWrites += atomic.LoadUint64(&md.counts.failed)
+		s.Bytes += writes * uint64(valueSize(md.t))
+	}
+
+	return s
+}
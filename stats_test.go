@@ -0,0 +1,127 @@
+package speed
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	m, err := NewPCPSingletonMetric(int32(0), "stats.metric", Int32Type, InstantSemantics, OneUnit)
+	if err != nil {
+		t.Fatalf("cannot create metric, error: %v", err)
+	}
+	c.MustRegister(m)
+
+	if s := c.Stats(); s != (Stats{}) {
+		t.Errorf("expected no stats before start, got %+v", s)
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	s := c.Stats()
+	if s.Bytes != uint64(c.Length()) || s.Writes != 0 {
+		t.Errorf("expected only the mapping of %v bytes to be counted on start, got %+v", c.Length(), s)
+	}
+
+	for i := 1; i <= 10; i++ {
+		m.MustSet(int32(i))
+	}
+
+	s = c.Stats()
+	if s.Writes != 10 || s.Bytes != uint64(c.Length())+40 {
+		t.Errorf("expected 10 writes of 4 bytes, got %+v", s)
+	}
+}
+
+func TestStatsAtomicWrites(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	counter, err := NewPCPCounter(0, "stats.counter")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	gauge, err := NewPCPGauge(0, "stats.gauge")
+	if err != nil {
+		t.Fatalf("cannot create gauge, error: %v", err)
+	}
+
+	c.MustRegister(counter)
+	c.MustRegister(gauge)
+
+	// updates before start are not written to a mapping
+	counter.Up()
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if counter.word == nil || !counter.bound {
+		t.Fatalf("expected the counter to be on the atomic fast path")
+	}
+
+	length := uint64(c.Length())
+
+	for i := 0; i < 10; i++ {
+		counter.Up()
+		gauge.MustInc(1)
+	}
+	gauge.MustSet(5)
+
+	if s := c.Stats(); s.Writes != 21 || s.Bytes != length+21*8 {
+		t.Errorf("expected 21 writes of 8 bytes on the atomic fast path, got %+v", s)
+	}
+
+	c.MustStop()
+	counter.Up()
+
+	if s := c.Stats(); s.Writes != 21 {
+		t.Errorf("expected updates of a stopped client to not be counted, got %+v", s)
+	}
+
+	c.MustStart()
+}
+
+func TestStatsRemapsAndFlushes(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	c.MustRegisterString("stats.indom[a, b].metric", Instances{"a": 1, "b": 2}, Int32Type, InstantSemantics, OneUnit)
+
+	if err = c.SetWriteRateLimit(1000); err != nil {
+		t.Fatalf("cannot set write rate limit, error: %v", err)
+	}
+
+	if err = c.SetPublishInterval(10 * time.Millisecond); err != nil {
+		t.Fatalf("cannot set publish interval, error: %v", err)
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if err = c.SetInstanceDomainDescription("stats.indom", "remapped"); err != nil {
+		t.Fatalf("cannot remap, error: %v", err)
+	}
+
+	if s := c.Stats(); s.Remaps != 1 {
+		t.Errorf("expected 1 remap, got %+v", s)
+	}
+
+	for deadline := time.Now().Add(time.Second); c.Stats().Flushes == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if s := c.Stats(); s.Flushes == 0 {
+		t.Errorf("expected held back updates to be flushed, got %+v", s)
+	}
+}
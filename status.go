@@ -0,0 +1,45 @@
+package speed
+
+// ClientState is the state of a client in its lifecycle.
+type ClientState int
+
+// values for ClientState
+const (
+	ClientCreated ClientState = iota // the client was never started
+	ClientStarted                    // the client is publishing metrics
+	ClientStopped                    // the client was stopped and its mapping removed
+	ClientFailed                     // the last Start or Stop of the client failed
+)
+
+//go:generate stringer -type=ClientState
+
+// ClientStatus describes the state of a client and of its mapping.
+type ClientStatus struct {
+	State    ClientState
+	Location string // location of the mapping, or where it will be created
+	Size     int    // size of the mapping in bytes, 0 if the client is not started
+	InMemory bool   // whether metrics are written to the in-memory fallback instead of a mapping
+	Err      error  // the error that caused the failure when State is ClientFailed
+}
+
+// Status returns the current state of the client, so supervisory code and
+// health endpoints can report the health of the instrumentation.
+func (c *PCPClient) Status() ClientStatus {
+	loc := c.Location()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	s := ClientStatus{
+		State:    c.state,
+		Location: loc,
+		InMemory: c.writer != nil && c.inMemory,
+		Err:      c.err,
+	}
+
+	if c.writer != nil {
+		s.Size = len(c.writer.Bytes())
+	}
+
+	return s
+}
@@ -0,0 +1,85 @@
+package speed
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	newTestCounter(t, c, "requests")
+
+	if s := c.Status(); s.State != ClientCreated || s.Size != 0 || s.Location != c.loc {
+		t.Errorf("expected a created client without a mapping at %v, got %+v", c.loc, s)
+	}
+
+	c.MustStart()
+
+	s := c.Status()
+	if s.State != ClientStarted || s.Err != nil {
+		t.Errorf("expected a started client, got %+v", s)
+	}
+
+	if s.Size != c.Length() {
+		t.Errorf("expected a mapping of %v bytes, got %v", c.Length(), s.Size)
+	}
+
+	if fi, err := os.Stat(s.Location); err != nil || fi.Size() != int64(s.Size) {
+		t.Errorf("expected a mapping of %v bytes at %v, error: %v", s.Size, s.Location, err)
+	}
+
+	c.MustStop()
+
+	if s := c.Status(); s.State != ClientStopped || s.Size != 0 {
+		t.Errorf("expected a stopped client without a mapping, got %+v", s)
+	}
+}
+
+func TestStatusFailed(t *testing.T) {
+	f, err := ioutil.TempFile("", "speed")
+	if err != nil {
+		t.Fatalf("cannot create file, error: %v", err)
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	_ = f.Close()
+
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+	newTestCounter(t, c, "requests")
+
+	// a mapping cannot be created under a regular file
+	c.loc = f.Name() + "/mmv/test"
+
+	err = c.Start()
+	if err == nil {
+		t.Fatalf("expected start to fail")
+	}
+
+	if s := c.Status(); s.State != ClientFailed || s.Err != err {
+		t.Errorf("expected a failed client with error %v, got %+v", err, s)
+	}
+
+	c.SetInMemoryFallback(true)
+	c.MustStart()
+	defer c.MustStop()
+
+	if s := c.Status(); s.State != ClientStarted || !s.InMemory || s.Err != nil {
+		t.Errorf("expected a started client writing to memory, got %+v", s)
+	}
+}
+
+func TestClientStateString(t *testing.T) {
+	if s := ClientStopped.String(); s != "ClientStopped" {
+		t.Errorf("expected ClientStopped, got %v", s)
+	}
+
+	if s := ClientState(10).String(); s != "ClientState(10)" {
+		t.Errorf("expected ClientState(10), got %v", s)
+	}
+}
@@ -0,0 +1,170 @@
+package speed
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// RegisterOption configures a metric as it is registered with a client.
+//
+// Options are applied in order before the metric is added to the registry, and the
+// first one failing fails Register, leaving the metric unregistered. If Register fails,
+// the changes the options made to the client, like tags and thresholds, are undone.
+type RegisterOption func(c *PCPClient, m Metric) error
+
+// WithTags tags a metric with arbitrary strings as it is registered, so it can be
+// managed along with other metrics of the same category, as in
+//
+//	c.MustRegister(m, speed.WithTags("db", "critical"))
+//	...
+//	c.DisableTagged("db")
+//
+// Tags are only known to the client, and are not written to the mapping.
+func WithTags(tags ...string) RegisterOption {
+	return func(c *PCPClient, m Metric) error {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		if c.tags == nil {
+			c.tags = make(map[string][]string)
+		}
+
+		for _, tag := range tags {
+			c.tags[tag] = append(c.tags[tag], m.Name())
+		}
+
+		c.undoOnFailure(m, func() {
+			for _, tag := range tags {
+				c.tags[tag] = removeLast(c.tags[tag], m.Name())
+				if len(c.tags[tag]) == 0 {
+					delete(c.tags, tag)
+				}
+			}
+		})
+
+		return nil
+	}
+}
+
+// removeLast removes the last occurrence of name from names
+func removeLast(names []string, name string) []string {
+	for i := len(names) - 1; i >= 0; i-- {
+		if names[i] == name {
+			return append(names[:i], names[i+1:]...)
+		}
+	}
+
+	return names
+}
+
+// taggedNames returns the names of the metrics tagged with any of the passed tags
+func (c *PCPClient) taggedNames(tags []string) []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, tag := range tags {
+		for _, name := range c.tags[tag] {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Tagged returns the metrics tagged with any of the passed tags, in ascending order of names.
+func (c *PCPClient) Tagged(tags ...string) []Metric {
+	names := c.taggedNames(tags)
+
+	c.r.metricslock.RLock()
+	defer c.r.metricslock.RUnlock()
+
+	metrics := make([]Metric, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, c.r.metrics[name])
+	}
+
+	return metrics
+}
+
+// Tags returns the tags of a registered metric in ascending order.
+func (c *PCPClient) Tags(name string) []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var tags []string
+	for tag, names := range c.tags {
+		for _, n := range names {
+			if n == name {
+				tags = append(tags, tag)
+				break
+			}
+		}
+	}
+
+	sort.Strings(tags)
+	return tags
+}
+
+// resetter is implemented by metrics that can be reset to their initial state
+type resetter interface {
+	reset() error
+}
+
+// errNoTaggedMetrics is returned by group operations on tags with no metrics
+var errNoTaggedMetrics = errors.New("no metrics are tagged with the passed tags")
+
+// ResetTagged resets the metrics tagged with any of the passed tags, setting all
+// their values to the zero value of their type and clearing recorded histograms.
+// Counters that are reset go back to 0, which PCP treats like a counter wrap.
+// Disabled metrics are not reset.
+func (c *PCPClient) ResetTagged(tags ...string) error {
+	metrics := c.Tagged(tags...)
+	if len(metrics) == 0 {
+		return errNoTaggedMetrics
+	}
+
+	for _, m := range metrics {
+		r, ok := m.(resetter)
+		if !ok {
+			return fmt.Errorf("metric %v cannot be reset", m.Name())
+		}
+
+		if err := r.reset(); err != nil {
+			return fmt.Errorf("cannot reset metric %v: %v", m.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+// setTaggedEnabled enables or disables the metrics tagged with any of the passed tags
+func (c *PCPClient) setTaggedEnabled(tags []string, enabled bool) error {
+	metrics := c.Tagged(tags...)
+	if len(metrics) == 0 {
+		return errNoTaggedMetrics
+	}
+
+	for _, m := range metrics {
+		metricDesc(m.(PCPMetric)).setEnabled(enabled)
+	}
+
+	return nil
+}
+
+// DisableTagged disables the metrics tagged with any of the passed tags.
+// Unlike DisableMetrics, it does not disable other metrics under their names.
+func (c *PCPClient) DisableTagged(tags ...string) error {
+	return c.setTaggedEnabled(tags, false)
+}
+
+// EnableTagged enables the metrics tagged with any of the passed tags.
+func (c *PCPClient) EnableTagged(tags ...string) error {
+	return c.setTaggedEnabled(tags, true)
+}
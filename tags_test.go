@@ -0,0 +1,155 @@
+package speed
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTags(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	queries, err := NewPCPCounter(0, "db.queries")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+	c.MustRegister(queries, WithTags("db", "critical"))
+
+	latency, err := NewPCPHistogram("db.latency", 0, 1000, 3, NanosecondUnit)
+	if err != nil {
+		t.Fatalf("cannot create histogram, error: %v", err)
+	}
+	c.MustRegister(latency, WithTags("db"))
+
+	// not tagged, but under the name of a tagged metric
+	rows := newTestCounter(t, c, "db.queries.rows")
+
+	requests := newTestCounter(t, c, "http.requests")
+	if err = c.Register(requests, WithTags("http", "critical")); err == nil {
+		t.Errorf("expected registering a metric twice to fail")
+	}
+
+	if tags := c.Tags("db.queries"); !reflect.DeepEqual(tags, []string{"critical", "db"}) {
+		t.Errorf("expected db.queries to be tagged [critical db], got %v", tags)
+	}
+
+	if tags := c.Tags("http.requests"); len(tags) != 0 {
+		t.Errorf("expected a failed registration not to tag the metric, got %v", tags)
+	}
+
+	var names []string
+	for _, m := range c.Tagged("db", "critical") {
+		names = append(names, m.Name())
+	}
+	if expected := []string{"db.latency", "db.queries"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v to be tagged, got %v", expected, names)
+	}
+
+	c.MustStart()
+	defer c.MustStop()
+
+	queries.MustInc(10)
+	rows.MustInc(10)
+	latency.MustRecord(100)
+
+	if err = c.ResetTagged("db"); err != nil {
+		t.Fatalf("cannot reset tagged metrics, error: %v", err)
+	}
+
+	if queries.Val() != 0 {
+		t.Errorf("expected a reset counter to be 0, got %v", queries.Val())
+	}
+
+	if latency.Max() != 0 || latency.Mean() != 0 {
+		t.Errorf("expected a reset histogram to be empty, got max %v and mean %v", latency.Max(), latency.Mean())
+	}
+
+	if rows.Val() != 10 {
+		t.Errorf("expected an untagged counter not to be reset, got %v", rows.Val())
+	}
+
+	latency.MustRecord(200)
+	if latency.Max() != 200 {
+		t.Errorf("expected a reset histogram to record again, got max %v", latency.Max())
+	}
+
+	if err = c.DisableTagged("critical"); err != nil {
+		t.Fatalf("cannot disable tagged metrics, error: %v", err)
+	}
+
+	if names := c.DisabledMetrics(); !reflect.DeepEqual(names, []string{"db.queries"}) {
+		t.Errorf("expected only db.queries to be disabled, got %v", names)
+	}
+
+	if err = c.EnableTagged("critical"); err != nil {
+		t.Fatalf("cannot enable tagged metrics, error: %v", err)
+	}
+
+	if names := c.DisabledMetrics(); len(names) != 0 {
+		t.Errorf("expected no metrics to be disabled, got %v", names)
+	}
+
+	if err = c.ResetTagged("missing"); err == nil {
+		t.Errorf("expected resetting a tag with no metrics to fail")
+	}
+}
+
+func TestRegisterOptionsUndone(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	// the rate of the counter cannot be added, so neither can the counter
+	c.MustRegisterString("orders"+RateSuffix, 0.0, DoubleType, InstantSemantics, OneUnit)
+
+	orders, err := NewPCPCounter(0, "orders")
+	if err != nil {
+		t.Fatalf("cannot create counter, error: %v", err)
+	}
+
+	err = c.Register(orders,
+		WithTags("shop"),
+		WithThreshold(Threshold{Op: ">", Value: 10}),
+		Hot(),
+		WithHistory(4),
+		WithResetSchedule(ResetEvery(time.Hour)),
+		WithRate(0),
+	)
+	if err == nil {
+		t.Fatalf("expected registering a counter whose rate cannot be added to fail")
+	}
+
+	if c.r.HasMetric("orders") {
+		t.Errorf("expected the counter to not be registered")
+	}
+
+	if len(c.tags) != 0 || len(c.thresholds) != 0 || len(c.hot) != 0 {
+		t.Errorf("expected the options to be undone, got tags %v, thresholds %v and hot metrics %v", c.tags, c.thresholds, c.hot)
+	}
+
+	if _, ok := c.history.list("orders"); ok {
+		t.Errorf("expected the history of the counter to not be kept")
+	}
+
+	if n := len(c.resets.groups); n != 0 {
+		t.Errorf("expected the counter to not be reset, got %v schedules", n)
+	}
+
+	// once the rate can be added, the counter registers with all of its options
+	c2, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	if err = c2.Register(orders, WithTags("shop"), WithThreshold(Threshold{Op: ">", Value: 10}), WithRate(0)); err != nil {
+		t.Fatalf("cannot register counter, error: %v", err)
+	}
+
+	if !c2.r.HasMetric("orders"+RateSuffix) || !reflect.DeepEqual(c2.Tags("orders"), []string{"shop"}) || len(c2.thresholds["orders"]) != 1 {
+		t.Errorf("expected the options of the counter to apply")
+	}
+}
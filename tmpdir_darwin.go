@@ -0,0 +1,22 @@
+package speed
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// defaultTmpDir returns the directory mmv files are written under when PCP is not installed.
+//
+// On macOS, TMPDIR points to a per user directory under /var/folders, which keeps the
+// mappings of an instrumented binary private to the user running it, and is where
+// mmvdump can find them. Processes started by launchd may not have TMPDIR set, in which
+// case a per user directory under /tmp is used, as the mmv directory is created with
+// 0700 permissions and one created by another user under /tmp cannot be written to.
+func defaultTmpDir() string {
+	if dir := os.Getenv("TMPDIR"); dir != "" {
+		return dir
+	}
+
+	return filepath.Join("/tmp", "speed-"+strconv.Itoa(os.Getuid()))
+}
@@ -0,0 +1,66 @@
+package speed
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestDefaultTmpDir(t *testing.T) {
+	tmpdir, present := os.LookupEnv("TMPDIR")
+	defer func() {
+		if present {
+			_ = os.Setenv("TMPDIR", tmpdir)
+		}
+	}()
+
+	if err := os.Setenv("TMPDIR", "/var/folders/xy/T"); err != nil {
+		t.Fatal(err)
+	}
+
+	if dir := defaultTmpDir(); dir != "/var/folders/xy/T" {
+		t.Errorf("expected the per user TMPDIR to be used, got %v", dir)
+	}
+
+	if err := os.Unsetenv("TMPDIR"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := filepath.Join("/tmp", "speed-"+strconv.Itoa(os.Getuid()))
+	if dir := defaultTmpDir(); dir != expected {
+		t.Errorf("expected %v without TMPDIR, got %v", expected, dir)
+	}
+}
+
+func TestDarwinMapping(t *testing.T) {
+	c, err := NewPCPClient("darwin")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	counter := newTestCounter(t, c, "requests")
+
+	c.MustStart()
+	defer c.MustStop()
+
+	counter.MustInc(42)
+
+	if _, present := config["PCP_TMP_DIR"]; !present {
+		if dir := filepath.Join(defaultTmpDir(), "mmv", "darwin"); c.loc != dir {
+			t.Errorf("expected the mapping to be written to %v, got %v", dir, c.loc)
+		}
+	}
+
+	data, err := ioutil.ReadFile(c.loc)
+	if err != nil {
+		t.Fatalf("cannot read the mapping, error: %v", err)
+	}
+
+	if v, err := mmvdump.Lookup(data, "requests"); err != nil || v != int64(42) {
+		t.Errorf("expected the mapping read from disk to hold 42, got %v, error: %v", v, err)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !darwin
+// +build !darwin
+
+package speed
+
+import "os"
+
+// defaultTmpDir returns the directory mmv files are written under when PCP is not installed.
+func defaultTmpDir() string {
+	return os.TempDir()
+}
@@ -0,0 +1,115 @@
+package speed
+
+import (
+	"path/filepath"
+
+	"github.com/performancecopilot/speed/bytewriter"
+)
+
+// Update times
+//
+// MMV has no room for the time a value was last updated at, so a client recording them,
+// see SetUpdateTimes, writes them to a companion file next to its mapping, named like
+// the mapping with a leading dot, which PCP skips, and UpdateTimesSuffix appended.
+//
+// The file starts with a 16 byte header holding the magic "MMVU", the version 1 as an
+// int32 and the generation of the mapping the times are of, as an int64, followed by
+// an int64 for every value in the values section of the mapping, in the order they are
+// laid out, holding the time of the last update to the value in nanoseconds since the
+// epoch, or 0 if it was not updated since the mapping was written. All numbers are
+// little endian. mmvdump shows the times if the file exists.
+
+// UpdateTimesSuffix is appended to the name of the companion file of a mapping,
+// holding the times its values were last updated at, see SetUpdateTimes.
+const UpdateTimesSuffix = ".lastupdate"
+
+// updateTimesHeaderLength is the length of the header of an update times file
+const updateTimesHeaderLength = 16
+
+// updateTimesLocation returns the location of the update times file of a mapping at loc
+func updateTimesLocation(loc string) string {
+	return filepath.Join(filepath.Dir(loc), "."+filepath.Base(loc)+UpdateTimesSuffix)
+}
+
+// updateTimes records the times the values of a mapping are updated at
+type updateTimes struct {
+	writer *bytewriter.MemoryMappedWriter
+	base   int // offset of the values section of the mapping
+	clock  Clock
+}
+
+// newUpdateTimes creates the update times file of a mapping with layout l. If it cannot
+// be created, the error is passed to the error handler, and no times are recorded.
+func (c *PCPClient) newUpdateTimes(l *mmvLayout) *updateTimes {
+	n := (l.stringsoffset - l.valuesoffset) / ValueLength
+
+	w, err := bytewriter.NewMemoryMappedWriter(updateTimesLocation(c.loc), updateTimesHeaderLength+8*n)
+	if err != nil {
+		err = &MappingError{updateTimesLocation(c.loc), err}
+		if logging {
+			clientlogger.WithField("error", err).Error("cannot create the update times file")
+		}
+
+		c.deferError(err)
+		return nil
+	}
+
+	_ = w.MustWrite([]byte("MMVU"), 0)
+	_ = w.MustWriteInt32(1, 4)
+
+	u := &updateTimes{w, l.valuesoffset, c.clock}
+	u.setGeneration(l.gen)
+	return u
+}
+
+// setGeneration sets the generation of the mapping the times are of
+func (u *updateTimes) setGeneration(gen int64) {
+	if u != nil {
+		_ = u.writer.MustWriteInt64(gen, 8)
+	}
+}
+
+// stamp returns an update closure recording the time of every successful update
+// made by update to the value at offset
+func (u *updateTimes) stamp(update updateClosure, offset int) updateClosure {
+	if u == nil || update == nil {
+		return update
+	}
+
+	pos := updateTimesHeaderLength + 8*((offset-u.base)/ValueLength)
+	return func(val interface{}) error {
+		if err := update(val); err != nil {
+			return err
+		}
+
+		_ = u.writer.MustWriteInt64(u.clock.Now().UnixNano(), pos)
+		return nil
+	}
+}
+
+// close unmaps the update times file, removing it if erase is true
+func (u *updateTimes) close(erase bool) error {
+	if u == nil {
+		return nil
+	}
+	return u.writer.Unmap(erase)
+}
+
+// SetUpdateTimes sets whether the client records the time every value in its mapping
+// was last updated at, in a file next to the mapping that mmvdump reads, to tell stale
+// values apart. Singleton metrics of 64 bit and floating point types are then updated
+// through their mutex instead of atomically in the mapping, and every update reads the
+// clock, so it is meant for debugging.
+//
+// Times are not recorded for anonymous, shared and in-memory mappings.
+func (c *PCPClient) SetUpdateTimes(record bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.r.mapped {
+		return ErrClientStarted
+	}
+
+	c.recordUpdates = record
+	return nil
+}
@@ -0,0 +1,91 @@
+package speed
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/performancecopilot/speed/mmvdump"
+)
+
+func TestUpdateTimes(t *testing.T) {
+	c, err := NewPCPClient("test")
+	if err != nil {
+		t.Fatalf("cannot create client, error: %v", err)
+	}
+
+	clock := NewManualClock(time.Unix(1000, 0))
+	if err = c.SetClock(clock); err != nil {
+		t.Fatalf("cannot set clock, error: %v", err)
+	}
+
+	if err = c.SetUpdateTimes(true); err != nil {
+		t.Fatalf("cannot record update times, error: %v", err)
+	}
+
+	counter := newTestCounter(t, c, "updated.counter")
+
+	vector, err := NewPCPGaugeVector(map[string]float64{"a": 1, "b": 2}, "updated.vector")
+	if err != nil {
+		t.Fatalf("cannot create vector, error: %v", err)
+	}
+	c.MustRegister(vector)
+
+	c.MustStart()
+	defer c.MustStop()
+
+	if err = c.SetUpdateTimes(false); err != ErrClientStarted {
+		t.Errorf("expected ErrClientStarted setting update times of a started client, got %v", err)
+	}
+
+	read := func() map[uint64]time.Time {
+		b, err := ioutil.ReadFile(updateTimesLocation(c.Location()))
+		if err != nil {
+			t.Fatalf("cannot read update times, error: %v", err)
+		}
+
+		times, err := mmvdump.UpdateTimes(c.writer.Bytes(), b)
+		if err != nil {
+			t.Fatalf("cannot parse update times, error: %v", err)
+		}
+		return times
+	}
+
+	slot := func(name string, i int) uint64 {
+		slots, err := c.ValueSlots(name)
+		if err != nil {
+			t.Fatalf("cannot get slots of %v, error: %v", name, err)
+		}
+		return uint64(slots[i].Offset)
+	}
+
+	times := read()
+	if len(times) != 3 {
+		t.Fatalf("expected the times of 3 values, got %v", times)
+	}
+	for off, tm := range times {
+		if !tm.IsZero() {
+			t.Errorf("expected the value at %v not to be updated, got %v", off, tm)
+		}
+	}
+
+	clock.Advance(time.Second)
+	counter.Up()
+	clock.Advance(time.Second)
+	vector.MustSet(5, "b")
+
+	times = read()
+	if tm := times[slot("updated.counter", 0)]; !tm.Equal(time.Unix(1001, 0)) {
+		t.Errorf("expected the counter to be updated at 1001, got %v", tm)
+	}
+	if tm := times[slot("updated.vector", 1)]; !tm.Equal(time.Unix(1002, 0)) {
+		t.Errorf("expected instance b to be updated at 1002, got %v", tm)
+	}
+	if tm := times[slot("updated.vector", 0)]; !tm.IsZero() {
+		t.Errorf("expected instance a not to be updated, got %v", tm)
+	}
+
+	if counter.Val() != 1 {
+		t.Errorf("expected the counter to be 1, got %v", counter.Val())
+	}
+}